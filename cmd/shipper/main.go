@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"shipper-deployment/internal/config"
 	"shipper-deployment/internal/database"
@@ -17,6 +21,10 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(validate(os.Args[2:]))
+	}
+
 	log.Println("Starting shipper Deployment Service")
 
 	// Load configuration
@@ -39,7 +47,21 @@ func main() {
 	// Create and start server
 	srv := server.NewServer(cfg, db, nrApp)
 	log.Printf("Server starting on port %s", cfg.Port)
-	if err := srv.Start(); err != nil {
-		log.Fatal("Server failed to start:", err)
+	go func() {
+		if err := srv.Start(); err != nil {
+			log.Fatal("Server failed to start:", err)
+		}
+	}()
+
+	// Wait for a termination signal and shut down gracefully
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Server shutdown failed: %v", err)
 	}
+	log.Println("Server stopped")
 }
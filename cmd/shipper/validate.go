@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"shipper-deployment/internal/jobspec"
+	"shipper-deployment/internal/nomad"
+)
+
+const validateUsage = `Usage: shipper validate [-var name=value] [-var-file file] [-json] <job file>...
+
+Checks Nomad job files without deploying them. Files are parsed locally; files using
+features the local parser does not support are sent to Nomad's parse API when NOMAD_URL
+(and NOMAD_TOKEN if ACLs are enabled) is set.
+
+`
+
+// stringList collects the values of a repeated flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// validate runs the validate command and returns the process exit code
+func validate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	var assignments, varFiles stringList
+	flags.Var(&assignments, "var", "set a job variable as name=value, may be repeated")
+	flags.Var(&varFiles, "var-file", "read job variables from an HCL file, or JSON when named .json, may be repeated")
+	printJSON := flags.Bool("json", false, "print the parsed jobs as JSON")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), validateUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	var nomadClient *nomad.Client
+	if nomadURL := os.Getenv("NOMAD_URL"); nomadURL != "" {
+		skipTLSVerify, _ := strconv.ParseBool(os.Getenv("SKIP_TLS_VERIFY"))
+		nomadClient = nomad.NewClient(nomadURL, skipTLSVerify, os.Getenv("NOMAD_TOKEN"))
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		jobJSON, err := validateFile(path, assignments, varFiles, nomadClient)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			status = 1
+			continue
+		}
+
+		if *printJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(jobJSON); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+				status = 1
+			}
+			continue
+		}
+		job, _ := jobJSON["Job"].(map[string]interface{})
+		fmt.Printf("%s: job %q is valid\n", path, job["ID"])
+	}
+	return status
+}
+
+// validateFile parses and validates one job file with the given variables
+func validateFile(path string, assignments, varFiles []string, nomadClient *nomad.Client) (map[string]interface{}, error) {
+	content, err := os.ReadFile(path) // #nosec G304 - path is given on the command line
+	if err != nil {
+		return nil, err
+	}
+
+	variables := jobspec.NewVariables(string(content))
+	for _, varFile := range varFiles {
+		data, err := os.ReadFile(varFile) // #nosec G304 - path is given on the command line
+		if err != nil {
+			return nil, err
+		}
+		if err := variables.AddVarFile(jobspec.VarFile{Name: varFile, Content: data}); err != nil {
+			return nil, err
+		}
+	}
+	for _, assignment := range assignments {
+		if err := variables.AddAssignment(assignment); err != nil {
+			return nil, err
+		}
+	}
+
+	jobJSON, err := jobspec.Parse(string(content), variables)
+	if errors.Is(err, jobspec.ErrUnsupported) {
+		if nomadClient == nil {
+			return nil, fmt.Errorf("%v, set NOMAD_URL to validate it with Nomad", err)
+		}
+		jobJSON, err = nomadClient.ParseJob(string(content), variables.HCL(), "*")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return jobJSON, jobspec.Validate(jobJSON)
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/logger"
+	"shipper-deployment/internal/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// keyPrefix starts every API key so they are easy to recognise in secret scanners
+const keyPrefix = "shk"
+
+var (
+	// ErrInvalidKey is returned for keys that are malformed or unknown
+	ErrInvalidKey = errors.New("invalid api key")
+	// ErrRevokedKey is returned for keys that were revoked
+	ErrRevokedKey = errors.New("api key revoked")
+	// ErrExpiredKey is returned for keys past their expiry
+	ErrExpiredKey = errors.New("api key expired")
+	// ErrInvalidKeyRequest is returned when a key cannot be created as requested
+	ErrInvalidKeyRequest = errors.New("invalid api key request")
+)
+
+// KeyStore creates and checks API keys stored in the api_keys table
+type KeyStore struct {
+	db     *sql.DB
+	logger *logrus.Entry
+}
+
+// NewKeyStore creates a key store backed by db
+func NewKeyStore(db *sql.DB) *KeyStore {
+	return &KeyStore{
+		db:     db,
+		logger: logger.WithModule("auth"),
+	}
+}
+
+// Create stores a new API key and returns it together with the key itself,
+// which is not stored and cannot be recovered later
+func (s *KeyStore) Create(req models.CreateAPIKeyRequest) (*models.APIKey, string, error) {
+	if req.Name == "" {
+		return nil, "", fmt.Errorf("%w: name is required", ErrInvalidKeyRequest)
+	}
+	if len(req.Scopes) == 0 {
+		return nil, "", fmt.Errorf("%w: at least one scope is required", ErrInvalidKeyRequest)
+	}
+	for _, scope := range req.Scopes {
+		if !models.IsValidScope(scope) {
+			return nil, "", fmt.Errorf("%w: unknown scope %q", ErrInvalidKeyRequest, scope)
+		}
+	}
+	for _, service := range req.Services {
+		if service == "" || strings.Contains(service, ",") {
+			return nil, "", fmt.Errorf("%w: invalid service %q", ErrInvalidKeyRequest, service)
+		}
+	}
+
+	expiresAt := req.ExpiresAt
+	if req.ExpiresIn != "" {
+		ttl, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			return nil, "", fmt.Errorf("%w: invalid expires_in %q", ErrInvalidKeyRequest, req.ExpiresIn)
+		}
+		expiry := time.Now().UTC().Add(ttl)
+		expiresAt = &expiry
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+	key := fmt.Sprintf("%s_%s_%s", keyPrefix, id, secret)
+
+	apiKey := &models.APIKey{
+		ID:        id,
+		Name:      req.Name,
+		Owner:     req.Owner,
+		KeyHash:   HashKey(key),
+		Scopes:    req.Scopes,
+		Services:  req.Services,
+		ExpiresAt: expiresAt,
+	}
+	if err := database.InsertAPIKey(s.db, apiKey); err != nil {
+		return nil, "", err
+	}
+
+	stored, err := database.GetAPIKey(s.db, id)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read back api key: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"key_id":   id,
+		"name":     req.Name,
+		"owner":    req.Owner,
+		"scopes":   req.Scopes,
+		"services": req.Services,
+	}).Info("API key created")
+
+	return stored, key, nil
+}
+
+// Authenticate checks an API key and returns the identity it grants
+func (s *KeyStore) Authenticate(key string) (*Identity, error) {
+	id, ok := parseKeyID(key)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+
+	apiKey, err := database.GetAPIKey(s.db, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up api key: %w", err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(HashKey(key)), []byte(apiKey.KeyHash)) != 1 {
+		return nil, ErrInvalidKey
+	}
+	if apiKey.RevokedAt != nil {
+		return nil, ErrRevokedKey
+	}
+	now := time.Now()
+	if apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt) {
+		return nil, ErrExpiredKey
+	}
+
+	if err := database.TouchAPIKey(s.db, id, now.UTC()); err != nil {
+		s.logger.WithError(err).WithField("key_id", id).Warn("Failed to record api key use")
+	}
+
+	return &Identity{
+		Method:   MethodAPIKey,
+		Subject:  apiKey.Name,
+		KeyID:    apiKey.ID,
+		Scopes:   apiKey.Scopes,
+		Services: apiKey.Services,
+	}, nil
+}
+
+// IsAPIKey reports whether a credential has the API key format
+func IsAPIKey(key string) bool {
+	_, ok := parseKeyID(key)
+	return ok
+}
+
+// HashKey returns the stored form of an API key. Keys are random, so a plain SHA-256 suffices.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseKeyID extracts the key ID from a key of the form shk_<id>_<secret>
+func parseKeyID(key string) (string, bool) {
+	parts := strings.Split(key, "_")
+	if len(parts) != 3 || parts[0] != keyPrefix || parts[1] == "" || parts[2] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate random key: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
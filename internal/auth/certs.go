@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"crypto/x509"
+	"path"
+
+	"shipper-deployment/internal/config"
+)
+
+// MethodClientCert identifies callers authenticated with a verified TLS client certificate
+const MethodClientCert = "client_cert"
+
+// ClientCertIdentity returns the identity granted to a verified client certificate by the
+// first rule it matches
+func ClientCertIdentity(cert *x509.Certificate, rules []config.ClientCertRule) (*Identity, bool) {
+	for _, rule := range rules {
+		if !matchesCert(rule, cert) {
+			continue
+		}
+		return &Identity{
+			Method:   MethodClientCert,
+			Subject:  certSubject(cert),
+			Scopes:   rule.Scopes,
+			Services: rule.Services,
+		}, true
+	}
+	return nil, false
+}
+
+func matchesCert(rule config.ClientCertRule, cert *x509.Certificate) bool {
+	if rule.CommonName != "" && !globMatch(rule.CommonName, cert.Subject.CommonName) {
+		return false
+	}
+	if rule.DNSName != "" && !anyMatch(rule.DNSName, cert.DNSNames) {
+		return false
+	}
+	if rule.Email != "" && !anyMatch(rule.Email, cert.EmailAddresses) {
+		return false
+	}
+	if rule.URI != "" {
+		uris := make([]string, len(cert.URIs))
+		for i, uri := range cert.URIs {
+			uris[i] = uri.String()
+		}
+		if !anyMatch(rule.URI, uris) {
+			return false
+		}
+	}
+	return true
+}
+
+// certSubject names the certificate in deployment history: its common name, else its first SAN
+func certSubject(cert *x509.Certificate) string {
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	default:
+		return cert.SerialNumber.String()
+	}
+}
+
+func anyMatch(pattern string, values []string) bool {
+	for _, value := range values {
+		if globMatch(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func globMatch(pattern, value string) bool {
+	matched, err := path.Match(pattern, value)
+	return err == nil && matched
+}
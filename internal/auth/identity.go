@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"shipper-deployment/internal/models"
+)
+
+// Authentication methods an Identity can come from
+const (
+	MethodSharedSecret = "shared_secret"
+	MethodAPIKey       = "api_key"
+)
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	Method string
+	// Subject names the caller, such as the API key name
+	Subject string
+	// KeyID is the ID of the API key used, if any
+	KeyID  string
+	Scopes []string
+	// Services limits the caller to these service names or glob patterns, empty allows every service
+	Services []string
+	// Claims are the OIDC token claims recorded on deployments the caller starts
+	Claims map[string]string
+}
+
+// HasScope reports whether the identity holds scope. The admin scope grants every scope.
+func (i *Identity) HasScope(scope string) bool {
+	for _, s := range i.Scopes {
+		if s == scope || s == models.ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsService reports whether the identity may act on the named service
+func (i *Identity) AllowsService(service string) bool {
+	if len(i.Services) == 0 {
+		return true
+	}
+	for _, pattern := range i.Services {
+		if matched, err := path.Match(pattern, service); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// String identifies the caller in deployment history, for example "api_key:ci-payments/3f9c2a1b"
+func (i *Identity) String() string {
+	if i.KeyID != "" {
+		return fmt.Sprintf("%s:%s/%s", i.Method, i.Subject, i.KeyID)
+	}
+	return fmt.Sprintf("%s:%s", i.Method, i.Subject)
+}
+
+type contextKey struct{}
+
+// WithIdentity returns a context carrying the authenticated identity
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, identity)
+}
+
+// FromContext returns the identity authenticated for a request, if any
+func FromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(contextKey{}).(*Identity)
+	return identity, ok && identity != nil
+}
+
+// TriggeredBy returns the identity to record on rows created by a request, or "" when unauthenticated
+func TriggeredBy(ctx context.Context) string {
+	if identity, ok := FromContext(ctx); ok {
+		return identity.String()
+	}
+	return ""
+}
+
+// Claims returns the OIDC claims to record on rows created by a request, nil for other callers
+func Claims(ctx context.Context) map[string]string {
+	if identity, ok := FromContext(ctx); ok && len(identity.Claims) > 0 {
+		return identity.Claims
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// jwksRefreshInterval is the shortest time between two loads of a key set, so tokens with
+// unknown key IDs cannot make Shipper hammer the provider
+const jwksRefreshInterval = time.Minute
+
+// jwk is a single key of a JSON Web Key Set
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// keySet holds the signing keys of an OIDC provider, loaded from a URL or a local file
+type keySet struct {
+	url    string
+	file   string
+	client *http.Client
+
+	mu       sync.Mutex
+	keys     map[string]crypto.PublicKey
+	loadedAt time.Time
+}
+
+func newKeySet(url, file string) *keySet {
+	return &keySet{
+		url:    url,
+		file:   file,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// key returns the public key with the given ID, reloading the set when the ID is unknown
+// since providers rotate their keys
+func (k *keySet) key(kid string) (crypto.PublicKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if key, ok := k.keys[kid]; ok {
+		return key, nil
+	}
+	if k.keys != nil && time.Since(k.loadedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	keys, err := k.load()
+	if err != nil {
+		return nil, err
+	}
+	k.keys = keys
+	k.loadedAt = time.Now()
+
+	if key, ok := k.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (k *keySet) load() (map[string]crypto.PublicKey, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if k.file != "" {
+		data, err = os.ReadFile(k.file) // #nosec G304 - path comes from operator configuration
+		if err != nil {
+			return nil, fmt.Errorf("failed to read jwks file: %v", err)
+		}
+	} else {
+		data, err = k.fetch()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse jwks: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		publicKey, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwks key %q: %v", key.Kid, err)
+		}
+		keys[key.Kid] = publicKey
+	}
+	return keys, nil
+}
+
+func (k *keySet) fetch() ([]byte, error) {
+	resp, err := k.client.Get(k.url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch jwks: status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jwks: %v", err)
+	}
+	return data, nil
+}
+
+// publicKey decodes an RSA or EC key
+func (j jwk) publicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := decodeBigInt(j.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %v", err)
+		}
+		e, err := decodeBigInt(j.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := decodeBigInt(j.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %v", err)
+		}
+		y, err := decodeBigInt(j.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %v", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
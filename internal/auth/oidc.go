@@ -0,0 +1,325 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"path"
+	"strings"
+	"time"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/logger"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MethodOIDC identifies callers authenticated with a CI OIDC ID token
+const MethodOIDC = "oidc"
+
+// oidcLeeway allows for clock skew between Shipper and the token issuer
+const oidcLeeway = time.Minute
+
+var (
+	// ErrInvalidToken is returned for ID tokens that are malformed, badly signed, expired or
+	// meant for another issuer or audience
+	ErrInvalidToken = errors.New("invalid oidc token")
+	// ErrNoOIDCRule is returned when a valid token matches none of the configured rules
+	ErrNoOIDCRule = errors.New("no oidc rule matches token")
+)
+
+// OIDCVerifier checks CI OIDC ID tokens, such as those of GitHub Actions and GitLab CI, and
+// maps their claims to an identity through the configured rules
+type OIDCVerifier struct {
+	providers    []*oidcProvider
+	rules        []config.OIDCRule
+	recordClaims []string
+	logger       *logrus.Entry
+	now          func() time.Time
+}
+
+type oidcProvider struct {
+	config.OIDCProvider
+	keys *keySet
+}
+
+// NewOIDCVerifier creates a verifier for the providers and rules of cfg. Signing keys are
+// loaded on first use.
+func NewOIDCVerifier(cfg *config.OIDCConfig) *OIDCVerifier {
+	verifier := &OIDCVerifier{
+		rules:        cfg.Rules,
+		recordClaims: cfg.RecordClaims,
+		logger:       logger.WithModule("auth"),
+		now:          time.Now,
+	}
+	for _, provider := range cfg.Providers {
+		verifier.providers = append(verifier.providers, &oidcProvider{
+			OIDCProvider: provider,
+			keys:         newKeySet(provider.JWKSURL, provider.JWKSFile),
+		})
+	}
+	return verifier
+}
+
+// IsJWT reports whether credential looks like a compact JWS rather than an API key or secret
+func IsJWT(credential string) bool {
+	return strings.HasPrefix(credential, "eyJ") && strings.Count(credential, ".") == 2
+}
+
+// Verify checks the token's signature, issuer, audience and lifetime and returns the identity
+// granted by the first rule its claims match
+func (v *OIDCVerifier) Verify(token string) (*Identity, error) {
+	header, claims, signingInput, signature, err := splitJWT(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	issuer, _ := claims["iss"].(string)
+	provider := v.provider(issuer)
+	if provider == nil {
+		return nil, fmt.Errorf("%w: untrusted issuer %q", ErrInvalidToken, issuer)
+	}
+
+	key, err := provider.keys.key(header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err := verifySignature(header.Alg, key, signingInput, signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if !hasAudience(claims["aud"], provider.Audience) {
+		return nil, fmt.Errorf("%w: audience does not include %q", ErrInvalidToken, provider.Audience)
+	}
+	if err := v.checkLifetime(claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	subject := claimString(claims["sub"])
+	for _, rule := range v.rules {
+		if rule.Provider != provider.Name || !matchesClaims(rule.Claims, claims) {
+			continue
+		}
+
+		v.logger.WithFields(logrus.Fields{
+			"provider": provider.Name,
+			"subject":  subject,
+			"services": rule.Services,
+			"scopes":   rule.Scopes,
+		}).Debug("OIDC token matched rule")
+
+		return &Identity{
+			Method:   MethodOIDC,
+			Subject:  provider.Name + ":" + subject,
+			Scopes:   rule.Scopes,
+			Services: rule.Services,
+			Claims:   v.recordedClaims(claims),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: provider %s, subject %q", ErrNoOIDCRule, provider.Name, subject)
+}
+
+func (v *OIDCVerifier) provider(issuer string) *oidcProvider {
+	for _, provider := range v.providers {
+		if provider.Issuer == issuer {
+			return provider
+		}
+	}
+	return nil
+}
+
+// checkLifetime requires exp and, when present, enforces nbf and iat
+func (v *OIDCVerifier) checkLifetime(claims map[string]interface{}) error {
+	now := v.now()
+
+	exp, ok := claimTime(claims["exp"])
+	if !ok {
+		return errors.New("token has no expiry")
+	}
+	if now.After(exp.Add(oidcLeeway)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claimTime(claims["nbf"]); ok && now.Add(oidcLeeway).Before(nbf) {
+		return errors.New("token not yet valid")
+	}
+	if iat, ok := claimTime(claims["iat"]); ok && now.Add(oidcLeeway).Before(iat) {
+		return errors.New("token issued in the future")
+	}
+	return nil
+}
+
+// recordedClaims picks the claims stored with deployments the token starts
+func (v *OIDCVerifier) recordedClaims(claims map[string]interface{}) map[string]string {
+	recorded := make(map[string]string)
+	for _, name := range v.recordClaims {
+		if value := claimString(claims[name]); value != "" {
+			recorded[name] = value
+		}
+	}
+	return recorded
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func splitJWT(token string) (jwtHeader, map[string]interface{}, []byte, []byte, error) {
+	var header jwtHeader
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return header, nil, nil, nil, errors.New("token is not a JWT")
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return header, nil, nil, nil, fmt.Errorf("invalid header encoding: %v", err)
+	}
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return header, nil, nil, nil, fmt.Errorf("invalid header: %v", err)
+	}
+
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return header, nil, nil, nil, fmt.Errorf("invalid claims encoding: %v", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(rawClaims))
+	decoder.UseNumber()
+	var claims map[string]interface{}
+	if err := decoder.Decode(&claims); err != nil {
+		return header, nil, nil, nil, fmt.Errorf("invalid claims: %v", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return header, nil, nil, nil, fmt.Errorf("invalid signature encoding: %v", err)
+	}
+
+	return header, claims, []byte(parts[0] + "." + parts[1]), signature, nil
+}
+
+// verifySignature checks an RS256/384/512 or ES256/384 signature. Other algorithms, notably
+// "none" and the HMAC family, are rejected.
+func verifySignature(alg string, key crypto.PublicKey, signingInput, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+
+	var digest []byte
+	switch hash {
+	case crypto.SHA256:
+		sum := sha256.Sum256(signingInput)
+		digest = sum[:]
+	case crypto.SHA384:
+		sum := sha512.Sum384(signingInput)
+		digest = sum[:]
+	default:
+		sum := sha512.Sum512(signingInput)
+		digest = sum[:]
+	}
+
+	switch publicKey := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("algorithm %s does not match rsa key", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(publicKey, hash, digest, signature); err != nil {
+			return errors.New("signature verification failed")
+		}
+		return nil
+
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return fmt.Errorf("algorithm %s does not match ec key", alg)
+		}
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("signature verification failed")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(publicKey, digest, r, s) {
+			return errors.New("signature verification failed")
+		}
+		return nil
+
+	default:
+		return errors.New("unsupported key type")
+	}
+}
+
+// hasAudience accepts aud as a single string or a list, as the JWT spec allows
+func hasAudience(aud interface{}, audience string) bool {
+	switch value := aud.(type) {
+	case string:
+		return value == audience
+	case []interface{}:
+		for _, item := range value {
+			if s, ok := item.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesClaims reports whether every pattern matches its claim. Patterns are globs as in
+// path.Match, so "*" does not cross a "/".
+func matchesClaims(patterns map[string]string, claims map[string]interface{}) bool {
+	for name, pattern := range patterns {
+		value, ok := claims[name]
+		if !ok {
+			return false
+		}
+		if matched, err := path.Match(pattern, claimString(value)); err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func claimString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	default:
+		return ""
+	}
+}
+
+func claimTime(value interface{}) (time.Time, bool) {
+	number, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"shipper-deployment/pkg/signing"
+)
+
+// MethodSignedRequest identifies callers that signed the request with the shared secret
+const MethodSignedRequest = "signed_request"
+
+// DefaultSignatureMaxSkew is how far a signed request's timestamp may be from the server clock
+const DefaultSignatureMaxSkew = 5 * time.Minute
+
+// maxSignedBodySize bounds the body read into memory to check a signature
+const maxSignedBodySize = 16 * 1024 * 1024
+
+// maxNonceLength bounds the nonces kept in the replay cache
+const maxNonceLength = 128
+
+var (
+	// ErrInvalidSignature is returned for signed requests that are malformed or do not match
+	ErrInvalidSignature = errors.New("invalid request signature")
+	// ErrStaleRequest is returned for signed requests outside the clock-skew window
+	ErrStaleRequest = errors.New("request timestamp outside allowed window")
+	// ErrReplayedRequest is returned when a nonce is used a second time
+	ErrReplayedRequest = errors.New("request nonce already used")
+)
+
+// IsSigned reports whether the request carries a signature
+func IsSigned(r *http.Request) bool {
+	return r.Header.Get(signing.HeaderSignature) != ""
+}
+
+// RequestVerifier checks HMAC request signatures made with the shared secret
+type RequestVerifier struct {
+	secret  string
+	maxSkew time.Duration
+	nonces  *NonceCache
+	now     func() time.Time
+}
+
+// NewRequestVerifier creates a verifier for signatures made with secret. Timestamps may be
+// maxSkew away from the server clock, DefaultSignatureMaxSkew when not positive.
+func NewRequestVerifier(secret string, maxSkew time.Duration) *RequestVerifier {
+	if maxSkew <= 0 {
+		maxSkew = DefaultSignatureMaxSkew
+	}
+	return &RequestVerifier{
+		secret:  secret,
+		maxSkew: maxSkew,
+		// A nonce only has to be remembered while its timestamp is still accepted
+		nonces: NewNonceCache(2 * maxSkew),
+		now:    time.Now,
+	}
+}
+
+// Verify checks the signature of r. The body is read and replaced so handlers can still read it.
+func (v *RequestVerifier) Verify(r *http.Request) error {
+	timestamp := r.Header.Get(signing.HeaderTimestamp)
+	nonce := r.Header.Get(signing.HeaderNonce)
+	signature, ok := strings.CutPrefix(r.Header.Get(signing.HeaderSignature), signing.Version+"=")
+	if !ok || timestamp == "" || nonce == "" || len(nonce) > maxNonceLength {
+		return fmt.Errorf("%w: missing or malformed signature headers", ErrInvalidSignature)
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: malformed timestamp", ErrInvalidSignature)
+	}
+	now := v.now()
+	skew := now.Sub(time.Unix(seconds, 0))
+	if skew > v.maxSkew || skew < -v.maxSkew {
+		return fmt.Errorf("%w: skew %s", ErrStaleRequest, skew.Round(time.Second))
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	expected := signing.Compute(v.secret, signing.StringToSign(r.Method, r.URL.RequestURI(), timestamp, nonce, signing.BodyHash(body)))
+	if subtle.ConstantTimeCompare([]byte(signature), []byte(expected)) != 1 {
+		return ErrInvalidSignature
+	}
+
+	// Only remember nonces of valid signatures, so unsigned traffic cannot fill the cache
+	if !v.nonces.Add(nonce, now) {
+		return ErrReplayedRequest
+	}
+	return nil
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %v", err)
+	}
+	if len(body) > maxSignedBodySize {
+		return nil, fmt.Errorf("body larger than %d bytes", maxSignedBodySize)
+	}
+	_ = r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// NonceCache remembers nonces for a fixed time to reject replayed requests
+type NonceCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+// NewNonceCache creates a cache remembering each nonce for ttl
+func NewNonceCache(ttl time.Duration) *NonceCache {
+	return &NonceCache{
+		ttl:  ttl,
+		seen: make(map[string]time.Time),
+	}
+}
+
+// Add records nonce and reports whether it was unused
+func (c *NonceCache) Add(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastPrune) > c.ttl/2 {
+		for seen, expires := range c.seen {
+			if now.After(expires) {
+				delete(c.seen, seen)
+			}
+		}
+		c.lastPrune = now
+	}
+
+	if expires, ok := c.seen[nonce]; ok && !now.After(expires) {
+		return false
+	}
+	c.seen[nonce] = now.Add(c.ttl)
+	return true
+}
+
+// Len returns the number of nonces remembered
+func (c *NonceCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.seen)
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// ClusterConfig is a named Nomad cluster Shipper can deploy to besides NOMAD_URL
+type ClusterConfig struct {
+	URL   string `json:"url"`
+	Token string `json:"token,omitempty"`
+	// TokenEnv names an environment variable holding the token, so it stays out of the file
+	TokenEnv      string `json:"token_env,omitempty"`
+	SkipTLSVerify bool   `json:"skip_tls_verify,omitempty"`
+	// CAFile is a PEM bundle the cluster's certificate is verified against
+	CAFile string `json:"ca_file,omitempty"`
+	// ClientCertFile and ClientKeyFile are presented to clusters that require mutual TLS
+	ClientCertFile string `json:"client_cert_file,omitempty"`
+	ClientKeyFile  string `json:"client_key_file,omitempty"`
+	// Namespace and Region are used for jobs when neither the service's config nor the request sets them
+	Namespace string `json:"namespace,omitempty"`
+	Region    string `json:"region,omitempty"`
+	// Promotion limits which deployments /promote may bring to the cluster
+	Promotion *PromotionPolicy `json:"promotion,omitempty"`
+}
+
+// PromotionPolicy gates promotions into a cluster
+type PromotionPolicy struct {
+	// From lists the clusters a build may be promoted from, "" being NOMAD_URL. Any cluster when empty.
+	From []string `json:"from,omitempty"`
+	// SoakTime is how long the source deployment must have been successful before it is promoted
+	SoakTime Duration `json:"soak_time,omitempty"`
+}
+
+// AllowsSource reports whether builds may be promoted from a cluster
+func (p *PromotionPolicy) AllowsSource(cluster string) bool {
+	if p == nil || len(p.From) == 0 {
+		return true
+	}
+	for _, from := range p.From {
+		if from == cluster {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadClusters reads named Nomad clusters from a JSON file keyed by cluster name
+func LoadClusters(file string) (map[string]ClusterConfig, error) {
+	data, err := os.ReadFile(file) // #nosec G304 - path comes from operator configuration
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clusters config: %v", err)
+	}
+
+	var clusters map[string]ClusterConfig
+	if err := json.Unmarshal(data, &clusters); err != nil {
+		return nil, fmt.Errorf("failed to parse clusters config %s: %v", file, err)
+	}
+
+	for name, cluster := range clusters {
+		if name == "" {
+			return nil, fmt.Errorf("clusters config %s: cluster names must not be empty", file)
+		}
+		if cluster.URL == "" {
+			return nil, fmt.Errorf("cluster %s: url is required", name)
+		}
+		if (cluster.ClientCertFile == "") != (cluster.ClientKeyFile == "") {
+			return nil, fmt.Errorf("cluster %s: client_cert_file and client_key_file must be set together", name)
+		}
+		if cluster.Promotion != nil {
+			for _, from := range cluster.Promotion.From {
+				if _, known := clusters[from]; from == name || (from != "" && !known) {
+					return nil, fmt.Errorf("cluster %s: cannot promote from cluster %q", name, from)
+				}
+			}
+		}
+		if cluster.TokenEnv != "" {
+			if cluster.Token != "" {
+				return nil, fmt.Errorf("cluster %s: only one of token and token_env may be set", name)
+			}
+			cluster.Token = os.Getenv(cluster.TokenEnv)
+			clusters[name] = cluster
+		}
+	}
+
+	return clusters, nil
+}
@@ -1,19 +1,97 @@
 package config
 
 import (
+	"log"
 	"os"
 	"strconv"
+	"time"
 )
 
+// Job file parsers, selected with JOB_PARSER
+const (
+	// JobParserNomad parses every job file with Nomad's parse API
+	JobParserNomad = "nomad"
+	// JobParserLocal parses job files in process, using Nomad only for features it does not support
+	JobParserLocal = "local"
+)
+
+// Deploy lock policies, selected with DEPLOY_LOCK_POLICY or a service's "lock" entry. They decide
+// what happens to a deploy while an earlier deployment of the same service is still rolling out.
+const (
+	// LockNone submits every deploy straight away, the newest submission wins in Nomad
+	LockNone = "none"
+	// LockQueue records the deploy and submits it once the earlier deployment has finished
+	LockQueue = "queue"
+	// LockReject refuses the deploy with 409 Conflict
+	LockReject = "reject"
+	// LockSupersede submits the deploy and marks the earlier unfinished deployments superseded
+	LockSupersede = "supersede"
+)
+
+// ValidLockPolicy reports whether policy names a deploy lock policy
+func ValidLockPolicy(policy string) bool {
+	switch policy {
+	case LockNone, LockQueue, LockReject, LockSupersede:
+		return true
+	}
+	return false
+}
+
 type Config struct {
-	NomadURL        string
-	ValidSecret     string
-	Port            string
-	SkipTLSVerify   bool
-	NomadToken      string
-	NewRelicLicense string
-	NewRelicAppName string
-	NewRelicEnabled bool
+	NomadURL    string
+	ValidSecret string
+	// SharedSecretEnabled accepts ValidSecret as a credential with every scope, alongside API keys
+	SharedSecretEnabled bool
+	// RequireSignedRequests only accepts ValidSecret in an HMAC request signature, never sent as is
+	RequireSignedRequests bool
+	// SignatureMaxSkew is how far a signed request's timestamp may be from the server clock
+	SignatureMaxSkew time.Duration
+	Port             string
+	SkipTLSVerify    bool
+	NomadToken       string
+	NewRelicLicense  string
+	NewRelicAppName  string
+	NewRelicEnabled  bool
+
+	// Background status reconciliation
+	ReconcileInterval    time.Duration
+	ReconcileConcurrency int
+
+	// AsyncDeploys answers every deploy with 202 Accepted and submits it from the deploy queue
+	AsyncDeploys bool
+	// DeployWorkers and DeployQueueSize size the deploy queue
+	DeployWorkers   int
+	DeployQueueSize int
+
+	// DeployLockPolicy applies to services whose config sets no lock policy, LockNone when empty
+	DeployLockPolicy string
+
+	// NomadEventStream follows /v1/event/stream to update deployments as soon as Nomad changes
+	NomadEventStream bool
+
+	// MetaKeyPrefix is prepended to the Meta keys Shipper sets on jobs (tag_id, timestamp, updated_by)
+	MetaKeyPrefix string
+
+	// JobParser selects how uploaded job files are parsed, JobParserNomad or JobParserLocal
+	JobParser string
+
+	// TLS serves HTTPS, nil when TLS_CERT_FILE and TLS_KEY_FILE are not set
+	TLS *TLSConfig
+
+	// OIDC enables keyless authentication with CI OIDC tokens, nil when OIDC_CONFIG is not set
+	OIDC *OIDCConfig
+
+	// Policy holds the rules uploaded job files are checked against, nil when POLICY_CONFIG is not set
+	Policy *PolicyConfig
+
+	// Services holds per-service policy loaded from SERVICES_CONFIG, keyed by service name or glob
+	Services map[string]ServiceConfig
+	// EnforceServiceAllowlist refuses deploys of services without an entry in Services
+	EnforceServiceAllowlist bool
+
+	// Clusters are named Nomad clusters loaded from CLUSTERS_CONFIG, requests without a
+	// cluster go to NomadURL
+	Clusters map[string]ClusterConfig
 }
 
 func Load() *Config {
@@ -29,15 +107,146 @@ func Load() *Config {
 		newRelicEnabled = false
 	}
 
+	reconcileInterval, err := time.ParseDuration(getEnv("RECONCILE_INTERVAL", "30s"))
+	if err != nil {
+		reconcileInterval = 30 * time.Second
+	}
+
+	reconcileConcurrency, err := strconv.Atoi(getEnv("RECONCILE_CONCURRENCY", "4"))
+	if err != nil || reconcileConcurrency < 1 {
+		reconcileConcurrency = 4
+	}
+
+	asyncDeploys, err := strconv.ParseBool(getEnv("ASYNC_DEPLOYS", "false"))
+	if err != nil {
+		asyncDeploys = false
+	}
+
+	deployWorkers, err := strconv.Atoi(getEnv("DEPLOY_WORKERS", "4"))
+	if err != nil || deployWorkers < 1 {
+		deployWorkers = 4
+	}
+
+	deployQueueSize, err := strconv.Atoi(getEnv("DEPLOY_QUEUE_SIZE", "100"))
+	if err != nil || deployQueueSize < 1 {
+		deployQueueSize = 100
+	}
+
+	nomadEventStream, err := strconv.ParseBool(getEnv("NOMAD_EVENT_STREAM", "false"))
+	if err != nil {
+		nomadEventStream = false
+	}
+
+	sharedSecretEnabled, err := strconv.ParseBool(getEnv("SHARED_SECRET_ENABLED", "true"))
+	if err != nil {
+		sharedSecretEnabled = true
+	}
+
+	requireSignedRequests, err := strconv.ParseBool(getEnv("REQUIRE_SIGNED_REQUESTS", "false"))
+	if err != nil {
+		requireSignedRequests = false
+	}
+
+	signatureMaxSkew, err := time.ParseDuration(getEnv("SIGNATURE_MAX_SKEW", "5m"))
+	if err != nil || signatureMaxSkew <= 0 {
+		signatureMaxSkew = 5 * time.Minute
+	}
+
+	enforceServiceAllowlist, err := strconv.ParseBool(getEnv("ENFORCE_SERVICE_ALLOWLIST", "false"))
+	if err != nil {
+		enforceServiceAllowlist = false
+	}
+
+	jobParser := getEnv("JOB_PARSER", JobParserNomad)
+	if jobParser != JobParserNomad && jobParser != JobParserLocal {
+		log.Fatalf("JOB_PARSER must be %s or %s, got %q", JobParserNomad, JobParserLocal, jobParser)
+	}
+
+	deployLockPolicy := getEnv("DEPLOY_LOCK_POLICY", LockNone)
+	if !ValidLockPolicy(deployLockPolicy) {
+		log.Fatalf("DEPLOY_LOCK_POLICY must be %s, %s, %s or %s, got %q", LockNone, LockQueue, LockReject, LockSupersede, deployLockPolicy)
+	}
+
+	var tlsConfig *TLSConfig
+	if certFile, keyFile := getEnv("TLS_CERT_FILE", ""), getEnv("TLS_KEY_FILE", ""); certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			log.Fatal("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+		}
+		tlsConfig = &TLSConfig{
+			CertFile:     certFile,
+			KeyFile:      keyFile,
+			ClientCAFile: getEnv("TLS_CLIENT_CA_FILE", ""),
+		}
+		if tlsConfig.RequireClientCert, err = strconv.ParseBool(getEnv("TLS_REQUIRE_CLIENT_CERT", "false")); err != nil {
+			tlsConfig.RequireClientCert = false
+		}
+		if tlsConfig.ReloadInterval, err = time.ParseDuration(getEnv("TLS_RELOAD_INTERVAL", "30s")); err != nil || tlsConfig.ReloadInterval <= 0 {
+			tlsConfig.ReloadInterval = 30 * time.Second
+		}
+		if identitiesPath := getEnv("TLS_CLIENT_IDENTITIES", ""); identitiesPath != "" {
+			if tlsConfig.ClientIdentities, err = LoadClientIdentities(identitiesPath); err != nil {
+				log.Fatal("Failed to load TLS client identities:", err)
+			}
+		}
+	}
+
+	var oidc *OIDCConfig
+	if oidcPath := getEnv("OIDC_CONFIG", ""); oidcPath != "" {
+		if oidc, err = LoadOIDC(oidcPath); err != nil {
+			log.Fatal("Failed to load OIDC config:", err)
+		}
+	}
+
+	var policy *PolicyConfig
+	if policyPath := getEnv("POLICY_CONFIG", ""); policyPath != "" {
+		if policy, err = LoadPolicy(policyPath); err != nil {
+			log.Fatal("Failed to load policy config:", err)
+		}
+	}
+
+	services := map[string]ServiceConfig{}
+	if servicesPath := getEnv("SERVICES_CONFIG", ""); servicesPath != "" {
+		if services, err = LoadServices(servicesPath); err != nil {
+			log.Fatal("Failed to load services config:", err)
+		}
+	}
+
+	clusters := map[string]ClusterConfig{}
+	if clustersPath := getEnv("CLUSTERS_CONFIG", ""); clustersPath != "" {
+		if clusters, err = LoadClusters(clustersPath); err != nil {
+			log.Fatal("Failed to load clusters config:", err)
+		}
+	}
+
 	return &Config{
-		NomadURL:        getEnv("NOMAD_URL", "http://10.10.85.1:4646"),
-		ValidSecret:     getEnv("RPC_SECRET", "your-64-character-secret-key-here-please-change-this-in-production"),
-		Port:            getEnv("PORT", "16166"),
-		SkipTLSVerify:   skipTLSVerify,
-		NomadToken:      getEnv("NOMAD_TOKEN", ""),
-		NewRelicLicense: getEnv("NEW_RELIC_LICENSE_KEY", ""),
-		NewRelicAppName: getEnv("NEW_RELIC_APP_NAME", "shipper-deployment"),
-		NewRelicEnabled: newRelicEnabled,
+		NomadURL:              getEnv("NOMAD_URL", "http://10.10.85.1:4646"),
+		ValidSecret:           getEnv("RPC_SECRET", "your-64-character-secret-key-here-please-change-this-in-production"),
+		SharedSecretEnabled:   sharedSecretEnabled,
+		RequireSignedRequests: requireSignedRequests,
+		SignatureMaxSkew:      signatureMaxSkew,
+		Port:                  getEnv("PORT", "16166"),
+		SkipTLSVerify:         skipTLSVerify,
+		NomadToken:            getEnv("NOMAD_TOKEN", ""),
+		NewRelicLicense:       getEnv("NEW_RELIC_LICENSE_KEY", ""),
+		NewRelicAppName:       getEnv("NEW_RELIC_APP_NAME", "shipper-deployment"),
+		NewRelicEnabled:       newRelicEnabled,
+
+		ReconcileInterval:    reconcileInterval,
+		ReconcileConcurrency: reconcileConcurrency,
+		AsyncDeploys:         asyncDeploys,
+		DeployWorkers:        deployWorkers,
+		DeployQueueSize:      deployQueueSize,
+		DeployLockPolicy:     deployLockPolicy,
+		NomadEventStream:     nomadEventStream,
+		MetaKeyPrefix:        getEnv("META_KEY_PREFIX", ""),
+		JobParser:            jobParser,
+		TLS:                  tlsConfig,
+		OIDC:                 oidc,
+		Policy:               policy,
+		Services:             services,
+		Clusters:             clusters,
+
+		EnforceServiceAllowlist: enforceServiceAllowlist,
 	}
 }
 
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// OIDCConfig configures keyless authentication with CI OIDC ID tokens
+type OIDCConfig struct {
+	Providers []OIDCProvider `json:"providers"`
+	// Rules map token claims to the scopes and services a caller gets. The first matching rule wins.
+	Rules []OIDCRule `json:"rules"`
+	// RecordClaims are the claims stored on deployments the token triggers
+	RecordClaims []string `json:"record_claims,omitempty"`
+}
+
+// OIDCProvider is a token issuer Shipper trusts, such as GitHub Actions or GitLab CI
+type OIDCProvider struct {
+	Name     string `json:"name"`
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+	// JWKSURL is fetched for the signing keys; JWKSFile reads them from disk instead
+	JWKSURL  string `json:"jwks_url,omitempty"`
+	JWKSFile string `json:"jwks_file,omitempty"`
+}
+
+// OIDCRule grants scopes on services to tokens whose claims all match. Claim values are
+// glob patterns, so "refs/heads/*" matches any branch.
+type OIDCRule struct {
+	Provider string            `json:"provider"`
+	Claims   map[string]string `json:"claims"`
+	Scopes   []string          `json:"scopes"`
+	Services []string          `json:"services"`
+}
+
+// DefaultOIDCRecordClaims are recorded when record_claims is not set. They cover the
+// GitHub Actions and GitLab CI claims that identify a pipeline run.
+var DefaultOIDCRecordClaims = []string{
+	"sub", "repository", "ref", "sha", "environment", "actor", "workflow", "run_id",
+	"project_path", "pipeline_id", "job_id", "user_login",
+}
+
+// OIDCIdentityClaims name the repository or project a CI token was issued to. Every rule must
+// pin one of them, otherwise any repository on the provider could match it.
+var OIDCIdentityClaims = []string{"repository", "repository_owner", "project_path"}
+
+// LoadOIDC reads OIDC providers and rules from a JSON file
+func LoadOIDC(path string) (*OIDCConfig, error) {
+	data, err := os.ReadFile(path) // #nosec G304 - path comes from operator configuration
+	if err != nil {
+		return nil, fmt.Errorf("failed to read oidc config: %v", err)
+	}
+
+	var oidc OIDCConfig
+	if err := json.Unmarshal(data, &oidc); err != nil {
+		return nil, fmt.Errorf("failed to parse oidc config %s: %v", path, err)
+	}
+
+	providers := make(map[string]bool, len(oidc.Providers))
+	for _, provider := range oidc.Providers {
+		if provider.Name == "" || provider.Issuer == "" || provider.Audience == "" {
+			return nil, fmt.Errorf("oidc provider %q: name, issuer and audience are required", provider.Name)
+		}
+		if (provider.JWKSURL == "") == (provider.JWKSFile == "") {
+			return nil, fmt.Errorf("oidc provider %s: exactly one of jwks_url and jwks_file is required", provider.Name)
+		}
+		providers[provider.Name] = true
+	}
+
+	for i, rule := range oidc.Rules {
+		if !providers[rule.Provider] {
+			return nil, fmt.Errorf("oidc rule %d: unknown provider %q", i, rule.Provider)
+		}
+		if !pinsIdentity(rule.Claims) {
+			return nil, fmt.Errorf("oidc rule %d: claims must match at least one of %s", i, strings.Join(OIDCIdentityClaims, ", "))
+		}
+		if len(rule.Scopes) == 0 || len(rule.Services) == 0 {
+			return nil, fmt.Errorf("oidc rule %d: scopes and services are required", i)
+		}
+	}
+
+	if len(oidc.RecordClaims) == 0 {
+		oidc.RecordClaims = DefaultOIDCRecordClaims
+	}
+
+	return &oidc, nil
+}
+
+// pinsIdentity reports whether claims match one of OIDCIdentityClaims with a pattern that is
+// more than wildcards
+func pinsIdentity(claims map[string]string) bool {
+	for _, claim := range OIDCIdentityClaims {
+		if strings.Trim(claims[claim], "*?/") != "" {
+			return true
+		}
+	}
+	return false
+}
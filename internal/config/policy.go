@@ -0,0 +1,100 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Policy rule types
+const (
+	PolicyDeniedDrivers      = "denied_drivers"
+	PolicyRequiredResources  = "required_resources"
+	PolicyMaxCount           = "max_count"
+	PolicyNoLatestTag        = "no_latest_tag"
+	PolicyRequireUpdate      = "require_update"
+	PolicyAllowedDatacenters = "allowed_datacenters"
+	PolicyNoPrivileged       = "no_privileged"
+	PolicyNoHostVolumes      = "no_host_volumes"
+)
+
+// PolicyRuleTypes lists every supported rule type
+var PolicyRuleTypes = []string{
+	PolicyDeniedDrivers,
+	PolicyRequiredResources,
+	PolicyMaxCount,
+	PolicyNoLatestTag,
+	PolicyRequireUpdate,
+	PolicyAllowedDatacenters,
+	PolicyNoPrivileged,
+	PolicyNoHostVolumes,
+}
+
+// PolicyConfig holds the organisational rules uploaded job files are checked against
+type PolicyConfig struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+// PolicyRule is a single check. Which fields apply depends on Type.
+type PolicyRule struct {
+	// Name identifies the rule in violations, Type when empty
+	Name string `json:"name,omitempty"`
+	Type string `json:"type"`
+	// Values are the drivers of denied_drivers or the datacenters of allowed_datacenters
+	Values []string `json:"values,omitempty"`
+	// Max is the highest task group count max_count allows
+	Max int `json:"max,omitempty"`
+	// MaxCPU and MaxMemoryMB cap task resources for required_resources, unlimited when zero
+	MaxCPU      int `json:"max_cpu,omitempty"`
+	MaxMemoryMB int `json:"max_memory_mb,omitempty"`
+	// WarnOnly reports violations without refusing the deployment
+	WarnOnly bool `json:"warn_only,omitempty"`
+}
+
+// RuleName returns the name violations of the rule are reported under
+func (r PolicyRule) RuleName() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.Type
+}
+
+// LoadPolicy reads job file policy rules from a JSON file
+func LoadPolicy(path string) (*PolicyConfig, error) {
+	data, err := os.ReadFile(path) // #nosec G304 - path comes from operator configuration
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy config: %v", err)
+	}
+
+	var policy PolicyConfig
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy config %s: %v", path, err)
+	}
+
+	for i, rule := range policy.Rules {
+		if !isPolicyRuleType(rule.Type) {
+			return nil, fmt.Errorf("policy rule %d: unknown type %q", i, rule.Type)
+		}
+		switch rule.Type {
+		case PolicyDeniedDrivers, PolicyAllowedDatacenters:
+			if len(rule.Values) == 0 {
+				return nil, fmt.Errorf("policy rule %s: values are required", rule.RuleName())
+			}
+		case PolicyMaxCount:
+			if rule.Max < 1 {
+				return nil, fmt.Errorf("policy rule %s: max must be at least 1", rule.RuleName())
+			}
+		}
+	}
+
+	return &policy, nil
+}
+
+func isPolicyRuleType(ruleType string) bool {
+	for _, known := range PolicyRuleTypes {
+		if ruleType == known {
+			return true
+		}
+	}
+	return false
+}
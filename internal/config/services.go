@@ -0,0 +1,145 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+// ServiceConfig holds the deployment policy for a single service
+type ServiceConfig struct {
+	AutoRollback *AutoRollbackPolicy `json:"auto_rollback,omitempty"`
+	// Namespace and Region are where the service's Nomad job lives, the token's defaults when empty
+	Namespace string `json:"namespace,omitempty"`
+	Region    string `json:"region,omitempty"`
+	// AllowJobFile permits /deploy/job uploads for the service when the allowlist is enforced
+	AllowJobFile bool `json:"allow_job_file,omitempty"`
+	// JobIDs are the job IDs, or glob patterns, an uploaded job file may declare. Only the
+	// service name itself is allowed when empty.
+	JobIDs []string `json:"job_ids,omitempty"`
+	// Lock is the deploy lock policy for the service, DEPLOY_LOCK_POLICY when empty
+	Lock string `json:"lock,omitempty"`
+}
+
+// AllowsJobID reports whether a job file deployed as this service may declare jobID
+func (s ServiceConfig) AllowsJobID(service, jobID string) bool {
+	if len(s.JobIDs) == 0 {
+		return jobID == service
+	}
+	for _, pattern := range s.JobIDs {
+		if matched, err := path.Match(pattern, jobID); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// AutoRollbackPolicy makes Shipper revert a deployment it triggered when the rollout fails
+// or is not healthy enough once the health deadline has passed
+type AutoRollbackPolicy struct {
+	Enabled bool `json:"enabled"`
+	// HealthDeadline is how long after submission the deployment must be successful
+	HealthDeadline Duration `json:"health_deadline,omitempty"`
+	// MinHealthyPercent of desired allocations that must be healthy at the deadline
+	// for a still running deployment to be left alone
+	MinHealthyPercent int `json:"min_healthy_percent,omitempty"`
+}
+
+// Duration is a time.Duration that reads from JSON strings such as "10m"
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string such as \"5m\": %v", err)
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Service returns the policy for a service, falling back to the "*" entry
+func (c *Config) Service(name string) ServiceConfig {
+	service, _ := c.LookupService(name)
+	return service
+}
+
+// LockPolicy returns the deploy lock policy of a service
+func (c *Config) LockPolicy(name string) string {
+	if policy := c.Service(name).Lock; policy != "" {
+		return policy
+	}
+	if c.DeployLockPolicy != "" {
+		return c.DeployLockPolicy
+	}
+	return LockNone
+}
+
+// LookupService returns the policy for a service and whether the services config has an entry
+// for it. An exact entry wins over glob entries such as "payments-*", of which the longest
+// matching pattern is used; "*" matches any service.
+func (c *Config) LookupService(name string) (ServiceConfig, bool) {
+	if service, ok := c.Services[name]; ok {
+		return service, true
+	}
+
+	best := ""
+	for pattern := range c.Services {
+		if len(pattern) < len(best) || (len(pattern) == len(best) && pattern > best) {
+			continue
+		}
+		if matched, err := path.Match(pattern, name); err == nil && matched {
+			best = pattern
+		}
+	}
+	if best == "" {
+		return ServiceConfig{}, false
+	}
+	return c.Services[best], true
+}
+
+// LoadServices reads per-service policy from a JSON file keyed by service name
+func LoadServices(file string) (map[string]ServiceConfig, error) {
+	data, err := os.ReadFile(file) // #nosec G304 - path comes from operator configuration
+	if err != nil {
+		return nil, fmt.Errorf("failed to read services config: %v", err)
+	}
+
+	var services map[string]ServiceConfig
+	if err := json.Unmarshal(data, &services); err != nil {
+		return nil, fmt.Errorf("failed to parse services config %s: %v", file, err)
+	}
+
+	for name, service := range services {
+		if _, err := path.Match(name, ""); err != nil {
+			return nil, fmt.Errorf("service %s: invalid pattern: %v", name, err)
+		}
+		for _, pattern := range service.JobIDs {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("service %s: invalid job_ids pattern %q: %v", name, pattern, err)
+			}
+		}
+		if service.Lock != "" && !ValidLockPolicy(service.Lock) {
+			return nil, fmt.Errorf("service %s: lock must be %s, %s, %s or %s, got %q", name, LockNone, LockQueue, LockReject, LockSupersede, service.Lock)
+		}
+		if policy := service.AutoRollback; policy != nil {
+			if policy.MinHealthyPercent < 0 || policy.MinHealthyPercent > 100 {
+				return nil, fmt.Errorf("service %s: min_healthy_percent must be between 0 and 100", name)
+			}
+		}
+	}
+
+	return services, nil
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// TLSConfig enables HTTPS on the Shipper server and, with a client CA, mutual TLS
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is a PEM bundle client certificates are verified against
+	ClientCAFile string
+	// RequireClientCert rejects connections without a valid client certificate, otherwise one is
+	// verified only when presented
+	RequireClientCert bool
+	// ReloadInterval is how often the certificate files are checked for changes
+	ReloadInterval time.Duration
+	// ClientIdentities map verified client certificates to scopes and services
+	ClientIdentities []ClientCertRule
+}
+
+// Enabled reports whether the server should serve HTTPS
+func (t *TLSConfig) Enabled() bool {
+	return t != nil && t.CertFile != "" && t.KeyFile != ""
+}
+
+// ClientCertRule grants scopes on services to client certificates it matches. Every field set
+// must match; values are glob patterns. DNSName, URI and Email match any SAN of that kind.
+type ClientCertRule struct {
+	CommonName string   `json:"common_name,omitempty"`
+	DNSName    string   `json:"dns_name,omitempty"`
+	URI        string   `json:"uri,omitempty"`
+	Email      string   `json:"email,omitempty"`
+	Scopes     []string `json:"scopes"`
+	Services   []string `json:"services"`
+}
+
+// LoadClientIdentities reads client certificate rules from a JSON file
+func LoadClientIdentities(path string) ([]ClientCertRule, error) {
+	data, err := os.ReadFile(path) // #nosec G304 - path comes from operator configuration
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client identities: %v", err)
+	}
+
+	var file struct {
+		Identities []ClientCertRule `json:"identities"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse client identities %s: %v", path, err)
+	}
+
+	for i, rule := range file.Identities {
+		if rule.CommonName == "" && rule.DNSName == "" && rule.URI == "" && rule.Email == "" {
+			return nil, fmt.Errorf("client identity %d: at least one of common_name, dns_name, uri and email is required", i)
+		}
+		if len(rule.Scopes) == 0 || len(rule.Services) == 0 {
+			return nil, fmt.Errorf("client identity %d: scopes and services are required", i)
+		}
+	}
+
+	return file.Identities, nil
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"shipper-deployment/internal/models"
+)
+
+// migrateAPIKeys creates the api_keys table
+func migrateAPIKeys(db *sql.DB) error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		owner TEXT NOT NULL DEFAULT '',
+		key_hash TEXT UNIQUE NOT NULL,
+		scopes TEXT NOT NULL DEFAULT '',
+		services TEXT NOT NULL DEFAULT '',
+		expires_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		revoked_at DATETIME,
+		last_used_at DATETIME
+	);`
+
+	if _, err := db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create api_keys table: %w", err)
+	}
+	return nil
+}
+
+// InsertAPIKey stores a new API key
+func InsertAPIKey(db *sql.DB, key *models.APIKey) error {
+	_, err := db.Exec(`INSERT INTO api_keys (id, name, owner, key_hash, scopes, services, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		key.ID, key.Name, key.Owner, key.KeyHash, strings.Join(key.Scopes, ","), strings.Join(key.Services, ","), key.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert api key: %w", err)
+	}
+	return nil
+}
+
+// GetAPIKey returns an API key by ID, or sql.ErrNoRows
+func GetAPIKey(db *sql.DB, id string) (*models.APIKey, error) {
+	row := db.QueryRow("SELECT "+apiKeySelectColumns+" FROM api_keys WHERE id = ?", id)
+	return scanAPIKey(row)
+}
+
+// ListAPIKeys returns every API key, revoked ones included, oldest first
+func ListAPIKeys(db *sql.DB) ([]models.APIKey, error) {
+	rows, err := db.Query("SELECT " + apiKeySelectColumns + " FROM api_keys ORDER BY created_at, id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes an API key. It returns sql.ErrNoRows when no active key has the ID.
+func RevokeAPIKey(db *sql.DB, id string) error {
+	result, err := db.Exec("UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL", id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TouchAPIKey records that an API key was used
+func TouchAPIKey(db *sql.DB, id string, usedAt time.Time) error {
+	_, err := db.Exec("UPDATE api_keys SET last_used_at = ? WHERE id = ?", usedAt, id)
+	return err
+}
+
+const apiKeySelectColumns = "id, name, owner, key_hash, scopes, services, expires_at, created_at, revoked_at, last_used_at"
+
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	var (
+		key      models.APIKey
+		scopes   string
+		services string
+	)
+	err := row.Scan(
+		&key.ID,
+		&key.Name,
+		&key.Owner,
+		&key.KeyHash,
+		&scopes,
+		&services,
+		&key.ExpiresAt,
+		&key.CreatedAt,
+		&key.RevokedAt,
+		&key.LastUsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	key.Scopes = splitList(scopes)
+	key.Services = splitList(services)
+	return &key, nil
+}
+
+// splitList splits a comma separated column, returning nil for an empty one
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
@@ -2,10 +2,14 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"shipper-deployment/internal/models"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -32,7 +36,45 @@ func InitDB() *sql.DB {
 	}
 	log.Printf("Successfully connected to database at %s", dbPath)
 
-	// Create deployments table
+	if err := Migrate(db); err != nil {
+		log.Fatal("Failed to migrate database:", err)
+	}
+	log.Println("Database tables initialized")
+
+	return db
+}
+
+// deploymentColumns lists columns added to the deployments table after the initial schema.
+// Migrate adds any that are missing so existing databases keep working after an upgrade.
+var deploymentColumns = []struct {
+	name       string
+	definition string
+}{
+	{"deployment_id", "TEXT NOT NULL DEFAULT ''"},
+	{"nomad_job_id", "TEXT NOT NULL DEFAULT ''"},
+	{"job_version", "INTEGER NOT NULL DEFAULT 0"},
+	{"action", "TEXT NOT NULL DEFAULT 'deploy'"},
+	{"rollback_of", "TEXT NOT NULL DEFAULT ''"},
+	{"restored_version", "INTEGER"},
+	{"variables", "TEXT NOT NULL DEFAULT ''"},
+	{"triggered_by", "TEXT NOT NULL DEFAULT ''"},
+	{"auth_claims", "TEXT NOT NULL DEFAULT ''"},
+	{"reason", "TEXT NOT NULL DEFAULT ''"},
+	{"task_states", "TEXT NOT NULL DEFAULT ''"},
+	{"namespace", "TEXT NOT NULL DEFAULT ''"},
+	{"region", "TEXT NOT NULL DEFAULT ''"},
+	{"cluster", "TEXT NOT NULL DEFAULT ''"},
+	{"cluster_deployments", "TEXT NOT NULL DEFAULT ''"},
+	{"image", "TEXT NOT NULL DEFAULT ''"},
+	{"images", "TEXT NOT NULL DEFAULT ''"},
+	{"job_file", "INTEGER NOT NULL DEFAULT 0"},
+	{"promoted_from", "TEXT NOT NULL DEFAULT ''"},
+	{"pipeline", "TEXT NOT NULL DEFAULT ''"},
+	{"superseded_by", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// Migrate creates the deployments table and brings older databases up to the current schema
+func Migrate(db *sql.DB) error {
 	createTable := `
 	CREATE TABLE IF NOT EXISTS deployments (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -44,13 +86,60 @@ func InitDB() *sql.DB {
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);`
 
-	_, err = db.Exec(createTable)
+	if _, err := db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create deployments table: %w", err)
+	}
+
+	existing, err := tableColumns(db, "deployments")
 	if err != nil {
-		log.Fatal("Failed to create table:", err)
+		return err
 	}
-	log.Println("Database tables initialized")
 
-	return db
+	for _, column := range deploymentColumns {
+		if existing[column.name] {
+			continue
+		}
+		// #nosec G202 - column names and definitions come from the static list above
+		alter := fmt.Sprintf("ALTER TABLE deployments ADD COLUMN %s %s", column.name, column.definition)
+		if _, err := db.Exec(alter); err != nil {
+			return fmt.Errorf("failed to add column %s: %w", column.name, err)
+		}
+		log.Printf("Added column %s to deployments table", column.name)
+	}
+
+	if err := migrateAPIKeys(db); err != nil {
+		return err
+	}
+	if err := migratePipelines(db); err != nil {
+		return err
+	}
+	return migrateOperations(db)
+}
+
+// tableColumns returns the set of column names defined on a table
+func tableColumns(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s schema: %w", table, err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			columnType string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return nil, fmt.Errorf("failed to scan %s schema: %w", table, err)
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
 }
 
 func InsertDeployment(db *sql.DB, tagID, serviceName, jobID, status string) error {
@@ -71,6 +160,55 @@ func InsertDeployment(db *sql.DB, tagID, serviceName, jobID, status string) erro
 	return nil
 }
 
+// InsertDeploymentRecord stores a deployment row including the tracking and rollback columns
+func InsertDeploymentRecord(db *sql.DB, deployment *models.Deployment) error {
+	log.Printf("Inserting %s: tag_id=%s, service=%s, status=%s", deployment.Action, deployment.TagID, deployment.ServiceName, deployment.Status)
+
+	action := deployment.Action
+	if action == "" {
+		action = models.ActionDeploy
+	}
+
+	var authClaims string
+	if len(deployment.AuthClaims) > 0 {
+		encoded, err := json.Marshal(deployment.AuthClaims)
+		if err != nil {
+			return fmt.Errorf("failed to encode auth claims: %w", err)
+		}
+		authClaims = string(encoded)
+	}
+
+	clusters, err := encodeClusterDeployments(deployment.Clusters)
+	if err != nil {
+		return err
+	}
+
+	var images string
+	if len(deployment.Images) > 0 {
+		encoded, err := json.Marshal(deployment.Images)
+		if err != nil {
+			return fmt.Errorf("failed to encode image overrides: %w", err)
+		}
+		images = string(encoded)
+	}
+
+	_, err = db.Exec(`INSERT INTO deployments
+		(tag_id, service_name, job_id, status, deployment_id, nomad_job_id, namespace, region, cluster, job_version,
+		action, rollback_of, restored_version, triggered_by, auth_claims, reason, cluster_deployments,
+		image, images, job_file, promoted_from, pipeline)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		deployment.TagID, deployment.ServiceName, deployment.JobID, deployment.Status, deployment.DeploymentID,
+		deployment.NomadJobID, deployment.Namespace, deployment.Region, deployment.Cluster, deployment.JobVersion,
+		action, deployment.RollbackOf, deployment.RestoredVersion, deployment.TriggeredBy, authClaims, deployment.Reason,
+		clusters, deployment.Image, images, deployment.JobFile, deployment.PromotedFrom, deployment.Pipeline,
+	)
+	if err != nil {
+		log.Printf("ERROR inserting %s: %v", action, err)
+		return fmt.Errorf("failed to insert deployment: %w", err)
+	}
+	return nil
+}
+
 func UpdateDeploymentStatus(db *sql.DB, tagID, status string) error {
 	stmt, err := db.Prepare("UPDATE deployments SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE tag_id = ?")
 	if err != nil {
@@ -99,3 +237,217 @@ func GetDeployment(db *sql.DB, tagID string) (string, string, string, error) {
 		Scan(&serviceName, &jobID, &status)
 	return serviceName, jobID, status, err
 }
+
+// UpdateDeploymentHealth records the rollout status and the Nomad objects backing it
+func UpdateDeploymentHealth(db *sql.DB, tagID string, health *models.DeploymentHealth) error {
+	var taskStates string
+	if len(health.Tasks) > 0 {
+		encoded, err := json.Marshal(health.Tasks)
+		if err != nil {
+			return fmt.Errorf("failed to encode task states: %w", err)
+		}
+		taskStates = string(encoded)
+	}
+
+	stmt, err := db.Prepare(`UPDATE deployments SET status = ?, deployment_id = ?,
+		nomad_job_id = CASE WHEN ? != '' THEN ? ELSE nomad_job_id END,
+		job_version = CASE WHEN ? != '' THEN ? ELSE job_version END,
+		task_states = CASE WHEN ? != '' THEN ? ELSE task_states END,
+		updated_at = CURRENT_TIMESTAMP WHERE tag_id = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	// The job version is only meaningful once a Nomad deployment exists
+	_, err = stmt.Exec(health.Status, health.DeploymentID, health.NomadJobID, health.NomadJobID,
+		health.DeploymentID, health.JobVersion, taskStates, taskStates, tagID)
+	return err
+}
+
+// UpdateClusterDeployments records the combined status and the per-cluster state of a fan-out deploy
+func UpdateClusterDeployments(db *sql.DB, tagID, status string, clusters []models.ClusterDeployment) error {
+	encoded, err := encodeClusterDeployments(clusters)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("UPDATE deployments SET status = ?, cluster_deployments = ?, updated_at = CURRENT_TIMESTAMP WHERE tag_id = ?",
+		status, encoded, tagID)
+	return err
+}
+
+func encodeClusterDeployments(clusters []models.ClusterDeployment) (string, error) {
+	if len(clusters) == 0 {
+		return "", nil
+	}
+	encoded, err := json.Marshal(clusters)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cluster deployments: %w", err)
+	}
+	return string(encoded), nil
+}
+
+// GetDeploymentRecord returns the full deployment row for a tag
+func GetDeploymentRecord(db *sql.DB, tagID string) (*models.Deployment, error) {
+	row := db.QueryRow("SELECT "+deploymentSelectColumns+" FROM deployments WHERE tag_id = ?", tagID)
+	return scanDeployment(row)
+}
+
+// ListActiveDeployments returns submitted deployments, including fan-out deploys, that have not
+// reached a terminal status
+func ListActiveDeployments(db *sql.DB) ([]models.Deployment, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(models.TerminalStatuses)), ", ")
+	args := make([]interface{}, len(models.TerminalStatuses))
+	for i, status := range models.TerminalStatuses {
+		args[i] = status
+	}
+
+	// #nosec G202 - only placeholders are concatenated into the query
+	return queryDeployments(db,
+		"SELECT "+deploymentSelectColumns+" FROM deployments WHERE status NOT IN ("+placeholders+
+			") AND ((job_id IS NOT NULL AND job_id != '') OR cluster_deployments != '') ORDER BY created_at",
+		args...,
+	)
+}
+
+// GetLastSuccessfulDeployment returns the newest successful deployment of a Nomad job in a
+// cluster and namespace, ignoring the given tag. Rows without a namespace match any namespace.
+// It returns sql.ErrNoRows when the job never succeeded.
+func GetLastSuccessfulDeployment(db *sql.DB, nomadJobID, cluster, namespace, excludeTagID string) (*models.Deployment, error) {
+	row := db.QueryRow("SELECT "+deploymentSelectColumns+` FROM deployments
+		WHERE (nomad_job_id = ? OR (nomad_job_id = '' AND service_name = ?))
+		AND cluster = ? AND (namespace = ? OR namespace = '' OR ? = '')
+		AND status = ? AND deployment_id != '' AND tag_id != ?
+		ORDER BY updated_at DESC, id DESC LIMIT 1`,
+		nomadJobID, nomadJobID, cluster, namespace, namespace, models.StatusSuccessful, excludeTagID)
+	return scanDeployment(row)
+}
+
+// ListPromotions returns the tags of the deployments promoted from a deployment, oldest first
+func ListPromotions(db *sql.DB, tagID string) ([]string, error) {
+	rows, err := db.Query("SELECT tag_id FROM deployments WHERE promoted_from = ? ORDER BY created_at, id", tagID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query promotions: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []string
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, fmt.Errorf("failed to scan promotion: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// UpdateDeploymentVariables records the job variables a deployment was submitted with
+func UpdateDeploymentVariables(db *sql.DB, tagID string, variables map[string]json.RawMessage) error {
+	encoded, err := json.Marshal(variables)
+	if err != nil {
+		return fmt.Errorf("failed to encode variables: %w", err)
+	}
+
+	_, err = db.Exec("UPDATE deployments SET variables = ?, updated_at = CURRENT_TIMESTAMP WHERE tag_id = ?", string(encoded), tagID)
+	return err
+}
+
+// MarkDeploymentRolledBack records that a deployment was reverted to the given job version
+func MarkDeploymentRolledBack(db *sql.DB, tagID string, restoredVersion uint64) error {
+	_, err := db.Exec("UPDATE deployments SET status = ?, restored_version = ?, updated_at = CURRENT_TIMESTAMP WHERE tag_id = ?",
+		models.StatusRolledBack, restoredVersion, tagID)
+	return err
+}
+
+func queryDeployments(db *sql.DB, query string, args ...interface{}) ([]models.Deployment, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query deployments: %w", err)
+	}
+	defer rows.Close()
+
+	var deployments []models.Deployment
+	for rows.Next() {
+		deployment, err := scanDeployment(rows)
+		if err != nil {
+			return nil, err
+		}
+		deployments = append(deployments, *deployment)
+	}
+	return deployments, rows.Err()
+}
+
+const deploymentSelectColumns = "id, tag_id, service_name, COALESCE(job_id, ''), status, deployment_id, nomad_job_id, namespace, region, cluster, " +
+	"job_version, action, rollback_of, restored_version, variables, triggered_by, auth_claims, reason, task_states, " +
+	"cluster_deployments, image, images, job_file, promoted_from, pipeline, superseded_by, created_at, updated_at"
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDeployment(row rowScanner) (*models.Deployment, error) {
+	var deployment models.Deployment
+	var variables, authClaims, taskStates, clusters, images string
+	err := row.Scan(
+		&deployment.ID,
+		&deployment.TagID,
+		&deployment.ServiceName,
+		&deployment.JobID,
+		&deployment.Status,
+		&deployment.DeploymentID,
+		&deployment.NomadJobID,
+		&deployment.Namespace,
+		&deployment.Region,
+		&deployment.Cluster,
+		&deployment.JobVersion,
+		&deployment.Action,
+		&deployment.RollbackOf,
+		&deployment.RestoredVersion,
+		&variables,
+		&deployment.TriggeredBy,
+		&authClaims,
+		&deployment.Reason,
+		&taskStates,
+		&clusters,
+		&deployment.Image,
+		&images,
+		&deployment.JobFile,
+		&deployment.PromotedFrom,
+		&deployment.Pipeline,
+		&deployment.SupersededBy,
+		&deployment.CreatedAt,
+		&deployment.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if variables != "" {
+		if err := json.Unmarshal([]byte(variables), &deployment.Variables); err != nil {
+			return nil, fmt.Errorf("failed to decode variables of deployment %s: %w", deployment.TagID, err)
+		}
+	}
+	if authClaims != "" {
+		if err := json.Unmarshal([]byte(authClaims), &deployment.AuthClaims); err != nil {
+			return nil, fmt.Errorf("failed to decode auth claims of deployment %s: %w", deployment.TagID, err)
+		}
+	}
+	if taskStates != "" {
+		if err := json.Unmarshal([]byte(taskStates), &deployment.Tasks); err != nil {
+			return nil, fmt.Errorf("failed to decode task states of deployment %s: %w", deployment.TagID, err)
+		}
+	}
+	if clusters != "" {
+		if err := json.Unmarshal([]byte(clusters), &deployment.Clusters); err != nil {
+			return nil, fmt.Errorf("failed to decode clusters of deployment %s: %w", deployment.TagID, err)
+		}
+	}
+	if images != "" {
+		if err := json.Unmarshal([]byte(images), &deployment.Images); err != nil {
+			return nil, fmt.Errorf("failed to decode image overrides of deployment %s: %w", deployment.TagID, err)
+		}
+	}
+	return &deployment, nil
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+
+	"shipper-deployment/internal/models"
+)
+
+// ListServiceDeployments returns the unfinished deployments of a service in a cluster and
+// namespace, oldest first: the submitted ones still rolling out and the queued ones. Rows
+// without a namespace match any namespace. Dispatched and periodic runs are left out, they do
+// not change the job.
+func ListServiceDeployments(db *sql.DB, service, cluster, namespace, excludeTagID string) ([]models.Deployment, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(models.TerminalStatuses)), ", ")
+	args := []interface{}{service, service, cluster, namespace, namespace, excludeTagID,
+		models.ActionDispatch, models.ActionPeriodicForce, models.StatusQueued}
+	for _, status := range models.TerminalStatuses {
+		args = append(args, status)
+	}
+
+	// #nosec G202 - only placeholders are concatenated into the query
+	return queryDeployments(db,
+		"SELECT "+deploymentSelectColumns+` FROM deployments
+		WHERE (service_name = ? OR (service_name = '' AND nomad_job_id = ?))
+		AND cluster = ? AND (namespace = ? OR namespace = '' OR ? = '')
+		AND tag_id != ? AND action NOT IN (?, ?)
+		AND (status = ? OR (status NOT IN (`+placeholders+`) AND job_id IS NOT NULL AND job_id != ''))
+		ORDER BY created_at, id`,
+		args...,
+	)
+}
+
+// ListQueuedDeployments returns every deployment waiting for a deploy lock, oldest first
+func ListQueuedDeployments(db *sql.DB) ([]models.Deployment, error) {
+	return queryDeployments(db,
+		"SELECT "+deploymentSelectColumns+" FROM deployments WHERE status = ? ORDER BY created_at, id",
+		models.StatusQueued,
+	)
+}
+
+// MarkDeploymentSuperseded records that a newer deploy replaced a deployment before it finished
+func MarkDeploymentSuperseded(db *sql.DB, tagID, supersededBy string) error {
+	_, err := db.Exec("UPDATE deployments SET status = ?, superseded_by = ?, updated_at = CURRENT_TIMESTAMP WHERE tag_id = ?",
+		models.StatusSuperseded, supersededBy, tagID)
+	return err
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"shipper-deployment/internal/models"
+)
+
+// migrateOperations creates the operations table
+func migrateOperations(db *sql.DB) error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS operations (
+		id TEXT PRIMARY KEY,
+		kind TEXT NOT NULL,
+		status TEXT NOT NULL,
+		tag_id TEXT NOT NULL DEFAULT '',
+		http_status INTEGER NOT NULL DEFAULT 0,
+		result TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		triggered_by TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		started_at DATETIME,
+		finished_at DATETIME
+	);`
+
+	if _, err := db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create operations table: %w", err)
+	}
+	return nil
+}
+
+// InsertOperation stores a newly queued operation
+func InsertOperation(db *sql.DB, operation *models.Operation) error {
+	_, err := db.Exec("INSERT INTO operations (id, kind, status, tag_id, triggered_by) VALUES (?, ?, ?, ?, ?)",
+		operation.ID, operation.Kind, operation.Status, operation.TagID, operation.TriggeredBy)
+	if err != nil {
+		return fmt.Errorf("failed to insert operation: %w", err)
+	}
+	return nil
+}
+
+// StartOperation records that a worker picked up an operation
+func StartOperation(db *sql.DB, id string) error {
+	_, err := db.Exec("UPDATE operations SET status = ?, started_at = CURRENT_TIMESTAMP WHERE id = ?", models.OperationRunning, id)
+	return err
+}
+
+// FinishOperation records the outcome of an operation. A waiting operation has no finish time yet.
+func FinishOperation(db *sql.DB, operation *models.Operation) error {
+	_, err := db.Exec(`UPDATE operations SET status = ?, tag_id = CASE WHEN ? != '' THEN ? ELSE tag_id END,
+		http_status = ?, result = ?, error = ?, finished_at = CASE WHEN ? = ? THEN NULL ELSE CURRENT_TIMESTAMP END WHERE id = ?`,
+		operation.Status, operation.TagID, operation.TagID, operation.HTTPStatus, string(operation.Result), operation.Error,
+		operation.Status, models.OperationWaiting, operation.ID)
+	return err
+}
+
+// FinishWaitingOperations records the outcome of the operations waiting for a deployment the
+// deploy lock queued
+func FinishWaitingOperations(db *sql.DB, tagID, status, message string) error {
+	_, err := db.Exec("UPDATE operations SET status = ?, error = ?, finished_at = CURRENT_TIMESTAMP WHERE tag_id = ? AND status = ?",
+		status, message, tagID, models.OperationWaiting)
+	return err
+}
+
+// FailUnfinishedOperations fails the operations a previous run queued but never finished,
+// returning how many there were
+func FailUnfinishedOperations(db *sql.DB, message string) (int64, error) {
+	result, err := db.Exec("UPDATE operations SET status = ?, error = ?, finished_at = CURRENT_TIMESTAMP WHERE status IN (?, ?)",
+		models.OperationFailed, message, models.OperationQueued, models.OperationRunning)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fail unfinished operations: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// GetOperation returns an operation by ID, or sql.ErrNoRows
+func GetOperation(db *sql.DB, id string) (*models.Operation, error) {
+	row := db.QueryRow("SELECT "+operationSelectColumns+" FROM operations WHERE id = ?", id)
+	return scanOperation(row)
+}
+
+// ListOperations returns the newest operations, only those with the given status when it is set
+func ListOperations(db *sql.DB, status string, limit int) ([]models.Operation, error) {
+	rows, err := db.Query("SELECT "+operationSelectColumns+" FROM operations WHERE ? = '' OR status = ? ORDER BY created_at DESC, rowid DESC LIMIT ?",
+		status, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query operations: %w", err)
+	}
+	defer rows.Close()
+
+	operations := []models.Operation{}
+	for rows.Next() {
+		operation, err := scanOperation(rows)
+		if err != nil {
+			return nil, err
+		}
+		operations = append(operations, *operation)
+	}
+	return operations, rows.Err()
+}
+
+const operationSelectColumns = "id, kind, status, tag_id, http_status, result, error, triggered_by, created_at, started_at, finished_at"
+
+func scanOperation(row rowScanner) (*models.Operation, error) {
+	var operation models.Operation
+	var result string
+	err := row.Scan(
+		&operation.ID,
+		&operation.Kind,
+		&operation.Status,
+		&operation.TagID,
+		&operation.HTTPStatus,
+		&result,
+		&operation.Error,
+		&operation.TriggeredBy,
+		&operation.CreatedAt,
+		&operation.StartedAt,
+		&operation.FinishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result != "" {
+		operation.Result = []byte(result)
+	}
+	return &operation, nil
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"shipper-deployment/internal/models"
+)
+
+// migratePipelines creates the pipelines table
+func migratePipelines(db *sql.DB) error {
+	createTable := `
+	CREATE TABLE IF NOT EXISTS pipelines (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		tag_id TEXT UNIQUE NOT NULL,
+		status TEXT NOT NULL,
+		stages TEXT NOT NULL,
+		current_stage INTEGER NOT NULL DEFAULT 0,
+		rollback_on_failure INTEGER NOT NULL DEFAULT 0,
+		cluster TEXT NOT NULL DEFAULT '',
+		message TEXT NOT NULL DEFAULT '',
+		triggered_by TEXT NOT NULL DEFAULT '',
+		auth_claims TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	if _, err := db.Exec(createTable); err != nil {
+		return fmt.Errorf("failed to create pipelines table: %w", err)
+	}
+	return nil
+}
+
+// InsertPipeline stores a new release pipeline
+func InsertPipeline(db *sql.DB, pipeline *models.Pipeline) error {
+	stages, err := json.Marshal(pipeline.Stages)
+	if err != nil {
+		return fmt.Errorf("failed to encode pipeline stages: %w", err)
+	}
+
+	var authClaims string
+	if len(pipeline.AuthClaims) > 0 {
+		encoded, err := json.Marshal(pipeline.AuthClaims)
+		if err != nil {
+			return fmt.Errorf("failed to encode auth claims: %w", err)
+		}
+		authClaims = string(encoded)
+	}
+
+	_, err = db.Exec(`INSERT INTO pipelines
+		(tag_id, status, stages, current_stage, rollback_on_failure, cluster, message, triggered_by, auth_claims)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		pipeline.TagID, pipeline.Status, string(stages), pipeline.CurrentStage, pipeline.RollbackOnFailure,
+		pipeline.Cluster, pipeline.Message, pipeline.TriggeredBy, authClaims,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert pipeline: %w", err)
+	}
+	return nil
+}
+
+// GetPipeline returns a pipeline by tag, or sql.ErrNoRows
+func GetPipeline(db *sql.DB, tagID string) (*models.Pipeline, error) {
+	row := db.QueryRow("SELECT "+pipelineSelectColumns+" FROM pipelines WHERE tag_id = ?", tagID)
+	return scanPipeline(row)
+}
+
+// ListActivePipelines returns the pipelines that are still running, oldest first
+func ListActivePipelines(db *sql.DB) ([]models.Pipeline, error) {
+	rows, err := db.Query("SELECT "+pipelineSelectColumns+" FROM pipelines WHERE status = ? ORDER BY created_at, id", models.StatusRunning)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pipelines: %w", err)
+	}
+	defer rows.Close()
+
+	var pipelines []models.Pipeline
+	for rows.Next() {
+		pipeline, err := scanPipeline(rows)
+		if err != nil {
+			return nil, err
+		}
+		pipelines = append(pipelines, *pipeline)
+	}
+	return pipelines, rows.Err()
+}
+
+// UpdatePipeline records the stage a pipeline is at, its status and message
+func UpdatePipeline(db *sql.DB, tagID, status string, currentStage int, message string) error {
+	_, err := db.Exec("UPDATE pipelines SET status = ?, current_stage = ?, message = ?, updated_at = CURRENT_TIMESTAMP WHERE tag_id = ?",
+		status, currentStage, message, tagID)
+	return err
+}
+
+// ListPipelineDeployments returns the deployments a pipeline started, oldest first
+func ListPipelineDeployments(db *sql.DB, pipelineTagID string) ([]models.Deployment, error) {
+	return queryDeployments(db,
+		"SELECT "+deploymentSelectColumns+" FROM deployments WHERE pipeline = ? ORDER BY created_at, id",
+		pipelineTagID,
+	)
+}
+
+const pipelineSelectColumns = "id, tag_id, status, stages, current_stage, rollback_on_failure, cluster, message, " +
+	"triggered_by, auth_claims, created_at, updated_at"
+
+func scanPipeline(row rowScanner) (*models.Pipeline, error) {
+	var pipeline models.Pipeline
+	var stages, authClaims string
+	err := row.Scan(
+		&pipeline.ID,
+		&pipeline.TagID,
+		&pipeline.Status,
+		&stages,
+		&pipeline.CurrentStage,
+		&pipeline.RollbackOnFailure,
+		&pipeline.Cluster,
+		&pipeline.Message,
+		&pipeline.TriggeredBy,
+		&authClaims,
+		&pipeline.CreatedAt,
+		&pipeline.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(stages), &pipeline.Stages); err != nil {
+		return nil, fmt.Errorf("failed to decode stages of pipeline %s: %w", pipeline.TagID, err)
+	}
+	if authClaims != "" {
+		if err := json.Unmarshal([]byte(authClaims), &pipeline.AuthClaims); err != nil {
+			return nil, fmt.Errorf("failed to decode auth claims of pipeline %s: %w", pipeline.TagID, err)
+		}
+	}
+	return &pipeline, nil
+}
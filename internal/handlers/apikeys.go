@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"shipper-deployment/internal/auth"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/models"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// CreateAPIKey creates an API key and returns it. The key is only shown in this response.
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to decode request JSON")
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	apiKey, key, err := h.keys.Create(req)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidKeyRequest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.logger.WithError(err).Error("Failed to create API key")
+		http.Error(w, fmt.Sprintf("Failed to create API key: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"key_id":     apiKey.ID,
+		"name":       apiKey.Name,
+		"created_by": auth.TriggeredBy(r.Context()),
+	}).Info("API key issued")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	h.writeJSONResponse(w, models.CreateAPIKeyResponse{APIKey: *apiKey, Key: key})
+}
+
+// ListAPIKeys lists every API key without the keys themselves
+func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := database.ListAPIKeys(h.db)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list API keys")
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if keys == nil {
+		keys = []models.APIKey{}
+	}
+
+	h.writeJSONResponse(w, keys)
+}
+
+// RevokeAPIKey revokes an API key so it can no longer authenticate
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID := mux.Vars(r)["key_id"]
+
+	if err := database.RevokeAPIKey(h.db, keyID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		h.logger.WithError(err).WithField("key_id", keyID).Error("Failed to revoke API key")
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"key_id":     keyID,
+		"revoked_by": auth.TriggeredBy(r.Context()),
+	}).Info("API key revoked")
+
+	apiKey, err := database.GetAPIKey(h.db, keyID)
+	if err != nil {
+		h.logger.WithError(err).WithField("key_id", keyID).Error("Failed to read revoked API key")
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	h.writeJSONResponse(w, apiKey)
+}
+
+// authorizeService writes a 403 and returns false when the caller may not act on the service.
+// Requests that did not pass through the auth middleware are not restricted.
+func (h *Handler) authorizeService(w http.ResponseWriter, r *http.Request, service string) bool {
+	identity, ok := auth.FromContext(r.Context())
+	if !ok || identity.AllowsService(service) {
+		return true
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"identity": identity.String(),
+		"service":  service,
+		"path":     r.URL.Path,
+	}).Warn("Caller is not allowed to act on service")
+	http.Error(w, fmt.Sprintf("Forbidden: not allowed to act on service %q", service), http.StatusForbidden)
+	return false
+}
+
+// authorizeJobID checks the caller may deploy the job a job file declares as service. Nomad runs
+// the declared job, so a caller limited to some services must be allowed to act on it as well,
+// unless the service's registry entry lets it deploy that job ID.
+func (h *Handler) authorizeJobID(w http.ResponseWriter, r *http.Request, service, jobID string) bool {
+	if jobID == service || h.config.Service(service).AllowsJobID(service, jobID) {
+		return true
+	}
+	return h.authorizeService(w, r, jobID)
+}
+
+// deploymentService returns the service a deployment row belongs to for authorization. Job
+// file deployments only record a service name when the request named one.
+func deploymentService(deployment *models.Deployment) string {
+	if deployment.ServiceName != "" {
+		return deployment.ServiceName
+	}
+	return deployment.NomadJobID
+}
+
+// authorizeRollback checks the caller may act on the service a rollback request targets.
+// Unknown tags are left for the rollback service to reject, other lookup errors answer 500.
+func (h *Handler) authorizeRollback(w http.ResponseWriter, r *http.Request, req models.RollbackRequest) bool {
+	if req.ServiceName != "" {
+		return h.authorizeService(w, r, req.ServiceName)
+	}
+	if req.TagID == "" {
+		return true
+	}
+
+	deployment, err := database.GetDeploymentRecord(h.db, req.TagID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true
+	}
+	if err != nil {
+		h.logger.WithError(err).WithField("tag_id", req.TagID).Error("Failed to get deployment")
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return false
+	}
+	return h.authorizeService(w, r, deploymentService(deployment))
+}
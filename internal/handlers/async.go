@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"shipper-deployment/internal/auth"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/queue"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// maxListedOperations caps GET /operations
+const maxListedOperations = 100
+
+// respondAsync reports whether a deploy request is queued rather than submitted before
+// answering: with ASYNC_DEPLOYS set or when the client sends "Prefer: respond-async".
+// Dry runs submit nothing and are always answered directly.
+func (h *Handler) respondAsync(r *http.Request) bool {
+	if dryRun, err := isDryRun(r); err != nil || dryRun {
+		return false
+	}
+	if h.config.AsyncDeploys {
+		return true
+	}
+	for _, prefer := range r.Header.Values("Prefer") {
+		for _, preference := range strings.Split(prefer, ",") {
+			if strings.EqualFold(strings.TrimSpace(preference), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// enqueue queues a deploy request for the deploy queue, which runs it through the synchronous
+// handler, and answers 202 Accepted with the operation's location
+func (h *Handler) enqueue(w http.ResponseWriter, r *http.Request, kind string, handle http.HandlerFunc) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 4*maxJobFileSize))
+	if err != nil {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	operation := &models.Operation{
+		Kind:        kind,
+		TagID:       requestTagID(r, body),
+		TriggeredBy: auth.TriggeredBy(r.Context()),
+	}
+
+	// The request outlives the connection, only the caller's identity is carried over
+	ctx := context.Background()
+	if identity, ok := auth.FromContext(r.Context()); ok {
+		ctx = auth.WithIdentity(ctx, identity)
+	}
+	queued := r.Clone(ctx)
+
+	task := func() (int, []byte) {
+		queued.Body = io.NopCloser(bytes.NewReader(body))
+		recorder := newResponseRecorder()
+		handle(recorder, queued)
+		return recorder.status, recorder.body.Bytes()
+	}
+
+	if err := h.queue.Enqueue(operation, task); err != nil {
+		if errors.Is(err, queue.ErrFull) {
+			h.logger.WithField("kind", kind).Warn("Deploy queue is full, refusing request")
+			w.Header().Set("Retry-After", "10")
+			http.Error(w, "Deploy queue is full, retry later", http.StatusServiceUnavailable)
+			return
+		}
+		h.logger.WithError(err).Error("Failed to queue operation")
+		http.Error(w, fmt.Sprintf("Failed to queue operation: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/operations/"+operation.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(operation); err != nil {
+		h.logger.WithError(err).Error("Failed to encode JSON response")
+	}
+}
+
+// requestTagID reads the tag_id of a JSON deploy request, multipart uploads report it once they ran
+func requestTagID(r *http.Request, body []byte) string {
+	if !isJSONRequest(r) && r.Header.Get("Content-Type") != "" {
+		return ""
+	}
+	var req struct {
+		TagID string `json:"tag_id"`
+	}
+	_ = json.Unmarshal(body, &req)
+	return req.TagID
+}
+
+// GetOperation reports a queued deploy and, once it ran, the response its endpoint returned
+func (h *Handler) GetOperation(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["operation_id"]
+
+	operation, err := database.GetOperation(h.db, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Operation %s not found", id), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.logger.WithError(err).WithField("operation", id).Error("Failed to get operation")
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	allowed, err := h.allowsOperation(r, operation)
+	if err != nil {
+		h.logger.WithError(err).WithField("operation", id).Error("Failed to get the operation's deployment")
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		h.logger.WithFields(logrus.Fields{
+			"operation": id,
+			"tag_id":    operation.TagID,
+			"path":      r.URL.Path,
+		}).Warn("Caller is not allowed to see operation")
+		http.Error(w, fmt.Sprintf("Forbidden: not allowed to see operation %s", id), http.StatusForbidden)
+		return
+	}
+
+	h.writeJSONResponse(w, operation)
+}
+
+// ListOperations reports the deploy queue and the newest operations, filtered by ?status=
+func (h *Handler) ListOperations(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", models.OperationQueued, models.OperationRunning, models.OperationWaiting, models.OperationSucceeded, models.OperationFailed:
+	default:
+		http.Error(w, fmt.Sprintf("unknown operation status %q", status), http.StatusBadRequest)
+		return
+	}
+
+	operations, err := database.ListOperations(h.db, status, maxListedOperations)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list operations")
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	visible := operations[:0]
+	for _, operation := range operations {
+		allowed, err := h.allowsOperation(r, &operation)
+		if err != nil {
+			h.logger.WithError(err).WithField("operation", operation.ID).Error("Failed to get the operation's deployment")
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if allowed {
+			visible = append(visible, operation)
+		}
+	}
+	operations = visible
+
+	h.logger.WithFields(logrus.Fields{
+		"status": status,
+		"count":  len(operations),
+	}).Debug("Listed operations")
+
+	h.writeJSONResponse(w, models.OperationsResponse{
+		Queue:      h.queue.Stats(),
+		Operations: operations,
+	})
+}
+
+// allowsOperation reports whether the caller may see an operation: the credential that queued it
+// may, others only when they may act on the service of the deployment it created
+func (h *Handler) allowsOperation(r *http.Request, operation *models.Operation) (bool, error) {
+	identity, ok := auth.FromContext(r.Context())
+	if !ok || len(identity.Services) == 0 || operation.TriggeredBy == identity.String() {
+		return true, nil
+	}
+	if operation.TagID == "" {
+		return false, nil
+	}
+
+	deployment, err := database.GetDeploymentRecord(h.db, operation.TagID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return identity.AllowsService(deploymentService(deployment)), nil
+}
+
+// responseRecorder captures the response a queued request's handler writes
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{header: make(http.Header)}
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(data)
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
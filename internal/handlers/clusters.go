@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"shipper-deployment/internal/auth"
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+
+	"github.com/sirupsen/logrus"
+)
+
+// nomadClient returns the client of a named cluster, the default cluster when name is empty.
+// It writes a 400 and returns false for clusters missing from CLUSTERS_CONFIG.
+func (h *Handler) nomadClient(w http.ResponseWriter, cluster string) (*nomad.Client, bool) {
+	client, err := h.clusters.Client(cluster)
+	if err != nil {
+		h.logger.WithField("cluster", cluster).Warn("Refusing request for an unknown cluster")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return client, true
+}
+
+// clusterScope returns the client of the scope's cluster and fills in the namespace and region
+// the service and request left open from the cluster's defaults
+func (h *Handler) clusterScope(w http.ResponseWriter, scope nomad.JobScope) (*nomad.Client, nomad.JobScope, bool) {
+	client, ok := h.nomadClient(w, scope.Cluster)
+	if !ok {
+		return nil, scope, false
+	}
+	defaults := h.config.Clusters[scope.Cluster]
+	if scope.Namespace == "" {
+		scope.Namespace = defaults.Namespace
+	}
+	if scope.Region == "" {
+		scope.Region = defaults.Region
+	}
+	return client, scope, true
+}
+
+// fanOutClusters checks the clusters a deploy request names. A single entry in clusters is a
+// plain deploy to that cluster. It writes a 400 and returns false when the request is invalid.
+func fanOutClusters(w http.ResponseWriter, req *models.DeploymentRequest) bool {
+	if len(req.Clusters) == 0 {
+		return true
+	}
+	if req.Cluster != "" {
+		http.Error(w, "cluster and clusters cannot be used together", http.StatusBadRequest)
+		return false
+	}
+	if len(req.Clusters) == 1 {
+		req.Cluster, req.Clusters = req.Clusters[0], nil
+		return true
+	}
+
+	seen := make(map[string]bool, len(req.Clusters))
+	for _, cluster := range req.Clusters {
+		if cluster == "" || seen[cluster] {
+			http.Error(w, fmt.Sprintf("clusters must name distinct clusters, got %q", req.Clusters), http.StatusBadRequest)
+			return false
+		}
+		seen[cluster] = true
+	}
+	return true
+}
+
+// deployFanOut submits the same tag to each cluster of the request and tracks them in one
+// deployment row. A cluster that refuses the job is recorded as failed while the others roll out.
+func (h *Handler) deployFanOut(w http.ResponseWriter, r *http.Request, req models.DeploymentRequest, scope nomad.JobScope, opts nomad.DeployOptions) {
+	clients := make([]*nomad.Client, len(req.Clusters))
+	clusters := make([]models.ClusterDeployment, len(req.Clusters))
+	for i, name := range req.Clusters {
+		scope.Cluster = name
+		client, clusterScope, ok := h.clusterScope(w, scope)
+		if !ok {
+			return
+		}
+		clients[i] = client
+		clusters[i] = models.ClusterDeployment{
+			Cluster:   name,
+			Status:    models.StatusPending,
+			Namespace: clusterScope.Namespace,
+			Region:    clusterScope.Region,
+		}
+	}
+
+	// A fan-out is one deployment for all its clusters, so it cannot be queued, refused or
+	// superseded in one of them: services with a lock policy deploy one cluster at a time
+	if policy := h.locks.Policy(&models.Deployment{ServiceName: req.ServiceName}); policy != config.LockNone {
+		h.logger.WithFields(logrus.Fields{"tag_id": req.TagID, "service": req.ServiceName, "policy": policy}).
+			Warn("Fan-out deploy refused by the deploy lock policy")
+		http.Error(w, fmt.Sprintf("Service %s has the %s deploy lock policy, deploy it to one cluster at a time", req.ServiceName, policy), http.StatusBadRequest)
+		return
+	}
+	unlock := h.lockClusters(req.ServiceName, clusters)
+	defer unlock()
+
+	if _, _, _, err := database.GetDeployment(h.db, req.TagID); err == nil {
+		h.logger.WithField("tag_id", req.TagID).Error("Deployment with this tag_id already exists")
+		http.Error(w, fmt.Sprintf("A deployment with tag_id %s already exists", req.TagID), http.StatusConflict)
+		return
+	}
+
+	if err := database.InsertDeploymentRecord(h.db, &models.Deployment{
+		TagID:       req.TagID,
+		ServiceName: req.ServiceName,
+		Status:      models.StatusPending,
+		Clusters:    clusters,
+		Image:       req.Image,
+		Images:      req.Images,
+		TriggeredBy: auth.TriggeredBy(r.Context()),
+		AuthClaims:  auth.Claims(r.Context()),
+	}); err != nil {
+		h.logger.WithError(err).Error("Database error inserting deployment")
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := models.DeploymentResponse{TagID: req.TagID}
+	submitted := false
+	for i := range clusters {
+		cluster := &clusters[i]
+		clusterOpts := opts
+		clusterOpts.Namespace, clusterOpts.Region = cluster.Namespace, cluster.Region
+
+		evalID, prepared, err := clients[i].TriggerDeployment(req.ServiceName, req.TagID, clusterOpts)
+		if err != nil {
+			h.logger.WithError(err).WithFields(logrus.Fields{
+				"service": req.ServiceName,
+				"tag_id":  req.TagID,
+				"cluster": cluster.Cluster,
+			}).Error("Nomad deployment failed")
+			cluster.Status = models.StatusFailed
+			cluster.Message = err.Error()
+			continue
+		}
+
+		cluster.Status = models.StatusRunning
+		cluster.JobID = evalID
+		if !submitted {
+			// Every cluster runs the same job, so the first one speaks for all
+			response.OverwrittenMeta = prepared.OverwrittenMeta
+			response.ImageChanges = prepared.ImageChanges
+			submitted = true
+		}
+	}
+
+	response.Status = models.FanOutStatus(clusters)
+	response.Clusters = clusters
+	if err := database.UpdateClusterDeployments(h.db, req.TagID, response.Status, clusters); err != nil {
+		h.logger.WithError(err).WithField("tag_id", req.TagID).Error("Failed to record cluster deployments")
+		// Continue with response even if database update fails
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"tag_id":   req.TagID,
+		"service":  req.ServiceName,
+		"clusters": req.Clusters,
+		"status":   response.Status,
+	}).Info("Fan-out deployment submitted")
+
+	h.writeJSONResponse(w, response)
+}
+
+// findCluster returns the part of a fan-out deploy submitted to a cluster, nil when there is none
+func findCluster(clusters []models.ClusterDeployment, name string) *models.ClusterDeployment {
+	for i := range clusters {
+		if clusters[i].Cluster == name {
+			return &clusters[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// PromoteDeployment promotes the canaries of a deployment that is awaiting promotion
+func (h *Handler) PromoteDeployment(w http.ResponseWriter, r *http.Request) {
+	var req models.PromoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		h.logger.WithError(err).Error("Failed to decode request JSON")
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	deployment, client, ok := h.activeNomadDeployment(w, r)
+	if !ok {
+		return
+	}
+
+	evalID, err := client.PromoteDeployment(deployment.DeploymentID, h.tracker.JobScope(*deployment), req.Groups)
+	if err != nil {
+		h.logger.WithError(err).WithField("tag_id", deployment.TagID).Error("Failed to promote deployment")
+		http.Error(w, fmt.Sprintf("Failed to promote deployment: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"tag_id":        deployment.TagID,
+		"deployment_id": deployment.DeploymentID,
+		"groups":        req.Groups,
+	}).Info("Deployment promoted")
+
+	h.writeDeploymentAction(w, deployment, evalID, "Canaries promoted")
+}
+
+// FailDeployment marks a deployment as failed in Nomad, stopping its rollout
+func (h *Handler) FailDeployment(w http.ResponseWriter, r *http.Request) {
+	deployment, client, ok := h.activeNomadDeployment(w, r)
+	if !ok {
+		return
+	}
+
+	evalID, err := client.FailDeployment(deployment.DeploymentID, h.tracker.JobScope(*deployment))
+	if err != nil {
+		h.logger.WithError(err).WithField("tag_id", deployment.TagID).Error("Failed to fail deployment")
+		http.Error(w, fmt.Sprintf("Failed to fail deployment: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"tag_id":        deployment.TagID,
+		"deployment_id": deployment.DeploymentID,
+	}).Info("Deployment marked as failed")
+
+	h.writeDeploymentAction(w, deployment, evalID, "Deployment marked as failed")
+}
+
+// activeNomadDeployment loads the deployment named in the URL and makes sure it is backed by a
+// Nomad deployment that is still in progress, returning the client of its cluster. A fan-out
+// deploy has a Nomad deployment in each cluster, the cluster query parameter picks one. It
+// writes the error response when there is no such deployment.
+func (h *Handler) activeNomadDeployment(w http.ResponseWriter, r *http.Request) (*models.Deployment, *nomad.Client, bool) {
+	tagID := mux.Vars(r)["tag_id"]
+
+	deployment, err := database.GetDeploymentRecord(h.db, tagID)
+	if err != nil {
+		h.logger.WithError(err).WithField("tag_id", tagID).Error("Failed to get deployment")
+		http.Error(w, fmt.Sprintf("Deployment not found: %v", err), http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	if !h.authorizeService(w, r, deploymentService(deployment)) {
+		return nil, nil, false
+	}
+
+	// Pick up the Nomad deployment ID if the rollout has not been refreshed yet
+	health, err := h.tracker.Refresh(*deployment)
+	if err != nil {
+		h.logger.WithError(err).WithField("tag_id", tagID).Error("Failed to refresh deployment status")
+		http.Error(w, fmt.Sprintf("Failed to get deployment status from Nomad: %v", err), http.StatusBadGateway)
+		return nil, nil, false
+	}
+	deployment.Status = health.Status
+	deployment.DeploymentID = health.DeploymentID
+
+	if len(health.Clusters) > 0 {
+		cluster := findCluster(health.Clusters, r.URL.Query().Get("cluster"))
+		if cluster == nil {
+			http.Error(w, fmt.Sprintf("Deployment %s was deployed to several clusters, cluster must name one of them", tagID), http.StatusBadRequest)
+			return nil, nil, false
+		}
+		deployment.Cluster = cluster.Cluster
+		deployment.Namespace = cluster.Namespace
+		deployment.Region = cluster.Region
+		deployment.Status = cluster.Status
+		deployment.DeploymentID = cluster.DeploymentID
+	}
+
+	if models.IsTerminalStatus(deployment.Status) {
+		http.Error(w, fmt.Sprintf("Deployment %s has already finished with status %s", tagID, deployment.Status), http.StatusConflict)
+		return nil, nil, false
+	}
+	if deployment.DeploymentID == "" {
+		http.Error(w, fmt.Sprintf("Deployment %s has no Nomad deployment yet", tagID), http.StatusConflict)
+		return nil, nil, false
+	}
+
+	client, err := h.clusters.Client(deployment.Cluster)
+	if err != nil {
+		h.logger.WithError(err).WithField("tag_id", tagID).Error("Deployment cluster is no longer configured")
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return nil, nil, false
+	}
+	return deployment, client, true
+}
+
+func (h *Handler) writeDeploymentAction(w http.ResponseWriter, deployment *models.Deployment, evalID, message string) {
+	// Record the effect right away rather than waiting for the next refresh
+	if health, err := h.tracker.Refresh(*deployment); err == nil {
+		deployment.Status = health.Status
+		if cluster := findCluster(health.Clusters, deployment.Cluster); cluster != nil {
+			deployment.Status = cluster.Status
+		}
+	}
+
+	h.writeJSONResponse(w, models.DeploymentActionResponse{
+		Status:       deployment.Status,
+		TagID:        deployment.TagID,
+		DeploymentID: deployment.DeploymentID,
+		EvalID:       evalID,
+		Message:      message,
+	})
+}
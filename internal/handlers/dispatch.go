@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"shipper-deployment/internal/auth"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// maxDispatchPayload is the largest dispatch payload Nomad accepts
+const maxDispatchPayload = 16 * 1024
+
+// DispatchJob dispatches a parameterized job and tracks the child job it starts
+func (h *Handler) DispatchJob(w http.ResponseWriter, r *http.Request) {
+	var req models.DispatchJobRequest
+	if !h.decodeJobRun(w, r, &req) {
+		return
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(req.Payload)
+	if err != nil {
+		http.Error(w, "payload must be base64 encoded", http.StatusBadRequest)
+		return
+	}
+	if len(payload) > maxDispatchPayload {
+		http.Error(w, fmt.Sprintf("payload exceeds the %d byte limit", maxDispatchPayload), http.StatusBadRequest)
+		return
+	}
+
+	job, client, scope, ok := h.operationTarget(w, r, mux.Vars(r)["job"])
+	if !ok || !h.checkRunTag(w, req.TagID) {
+		return
+	}
+
+	childJobID, evalID, err := client.DispatchJob(job, scope, req.Meta, payload)
+	if err != nil {
+		h.logger.WithError(err).WithField("job", job).Error("Failed to dispatch job")
+		http.Error(w, fmt.Sprintf("Failed to dispatch job: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	h.writeJobRun(w, r, req.TagID, scope, models.JobRunResponse{
+		Action:      models.ActionDispatch,
+		ServiceName: job,
+		ChildJobID:  childJobID,
+		EvalID:      evalID,
+		Message:     "Job dispatched",
+	})
+}
+
+// ForcePeriodicJob launches a periodic job now instead of at its next scheduled time and tracks
+// the child job it starts
+func (h *Handler) ForcePeriodicJob(w http.ResponseWriter, r *http.Request) {
+	var req models.ForcePeriodicRequest
+	if !h.decodeJobRun(w, r, &req) {
+		return
+	}
+
+	job, client, scope, ok := h.operationTarget(w, r, mux.Vars(r)["job"])
+	if !ok || !h.checkRunTag(w, req.TagID) {
+		return
+	}
+
+	childJobID, evalID, err := client.ForcePeriodicJob(job, scope)
+	if err != nil {
+		h.logger.WithError(err).WithField("job", job).Error("Failed to force periodic job")
+		http.Error(w, fmt.Sprintf("Failed to force periodic job: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	h.writeJobRun(w, r, req.TagID, scope, models.JobRunResponse{
+		Action:      models.ActionPeriodicForce,
+		ServiceName: job,
+		ChildJobID:  childJobID,
+		EvalID:      evalID,
+		Message:     "Periodic job launched",
+	})
+}
+
+// decodeJobRun reads the optional JSON body of a dispatch or periodic force. It writes a 400
+// and returns false when the body is invalid.
+func (h *Handler) decodeJobRun(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil && !errors.Is(err, io.EOF) {
+		h.logger.WithError(err).Error("Failed to decode request JSON")
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// checkRunTag refuses a tag_id that is already recorded, before the job is started
+func (h *Handler) checkRunTag(w http.ResponseWriter, tagID string) bool {
+	if tagID == "" {
+		return true
+	}
+	if _, _, _, err := database.GetDeployment(h.db, tagID); err == nil {
+		http.Error(w, fmt.Sprintf("A deployment with tag_id %s already exists", tagID), http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// writeJobRun records the child job a dispatch or periodic force started, so /status/{tag_id}
+// follows it to completion, and writes the response
+func (h *Handler) writeJobRun(w http.ResponseWriter, r *http.Request, tagID string, scope nomad.JobScope, response models.JobRunResponse) {
+	if tagID == "" {
+		tagID = fmt.Sprintf("%s-%s-%d", response.Action, response.ServiceName, time.Now().UnixNano())
+	}
+	response.TagID = tagID
+	response.Status = models.StatusRunning
+
+	if err := database.InsertDeploymentRecord(h.db, &models.Deployment{
+		TagID:       response.TagID,
+		ServiceName: response.ServiceName,
+		JobID:       response.EvalID,
+		Status:      response.Status,
+		NomadJobID:  response.ChildJobID,
+		Namespace:   scope.Namespace,
+		Region:      scope.Region,
+		Cluster:     scope.Cluster,
+		Action:      response.Action,
+		TriggeredBy: auth.TriggeredBy(r.Context()),
+		AuthClaims:  auth.Claims(r.Context()),
+	}); err != nil {
+		// The child job is already running, report it but say it is not tracked
+		h.logger.WithError(err).WithField("tag_id", response.TagID).Error("Failed to record job run")
+		response.TagID = ""
+		response.Message = fmt.Sprintf("%s, but it could not be recorded: %v", response.Message, err)
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"action":       response.Action,
+		"job":          response.ServiceName,
+		"child_job_id": response.ChildJobID,
+		"eval_id":      response.EvalID,
+		"tag_id":       response.TagID,
+		"triggered_by": auth.TriggeredBy(r.Context()),
+	}).Info("Job run started")
+
+	h.writeJSONResponse(w, response)
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PromoteEnvironment deploys the build of a successful deployment to another cluster, once the
+// target cluster's promotion policy allows it, and links the new deployment to its source. Targets
+// are named clusters, the only ones with a promotion policy; the NOMAD_URL cluster can only be a
+// source.
+func (h *Handler) PromoteEnvironment(w http.ResponseWriter, r *http.Request) {
+	var req models.EnvironmentPromotionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to decode request JSON")
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.TagID == "" || req.Cluster == "" {
+		http.Error(w, "tag_id and cluster are required, builds are promoted into named clusters and not the NOMAD_URL cluster", http.StatusBadRequest)
+		return
+	}
+
+	source, err := database.GetDeploymentRecord(h.db, req.TagID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Deployment %s not found", req.TagID), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.logger.WithError(err).WithField("tag_id", req.TagID).Error("Failed to get deployment")
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if !h.authorizeService(w, r, deploymentService(source)) {
+		return
+	}
+	if source.Action != models.ActionDeploy || source.JobFile || source.ServiceName == "" {
+		http.Error(w, fmt.Sprintf("Deployment %s was not made with /deploy, only service deploys can be promoted", req.TagID), http.StatusConflict)
+		return
+	}
+
+	service, ok := h.allowService(w, r, source.ServiceName)
+	if !ok {
+		return
+	}
+	scope, ok := h.serviceScope(w, source.ServiceName, service, nomad.JobScope{Cluster: req.Cluster, Namespace: req.Namespace, Region: req.Region})
+	if !ok {
+		return
+	}
+	client, scope, ok := h.clusterScope(w, scope)
+	if !ok || !h.promotable(w, source, req.Cluster) {
+		return
+	}
+
+	tagID := req.TargetTagID
+	if tagID == "" {
+		tagID = fmt.Sprintf("%s@%s", source.TagID, req.Cluster)
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"service": source.ServiceName,
+		"from":    source.TagID,
+		"tag_id":  tagID,
+		"cluster": req.Cluster,
+	}).Info("Promoting deployment")
+
+	// The same image overrides make the target run the build that succeeded in the source
+	h.submitDeployment(w, r, client, &models.Deployment{
+		TagID:        tagID,
+		ServiceName:  source.ServiceName,
+		Namespace:    scope.Namespace,
+		Region:       scope.Region,
+		Cluster:      scope.Cluster,
+		Image:        source.Image,
+		Images:       source.Images,
+		PromotedFrom: source.TagID,
+	}, nomad.DeployOptions{
+		Image:     source.Image,
+		Images:    source.Images,
+		Namespace: scope.Namespace,
+		Region:    scope.Region,
+	})
+}
+
+// promotable checks the source deployment succeeded in a cluster the target accepts builds from
+// and has soaked for the target's soak time. It writes the refusal and returns false otherwise.
+func (h *Handler) promotable(w http.ResponseWriter, source *models.Deployment, target string) bool {
+	sources := []string{source.Cluster}
+	if len(source.Clusters) > 0 {
+		sources = sources[:0]
+		for _, cluster := range source.Clusters {
+			sources = append(sources, cluster.Cluster)
+		}
+	}
+
+	policy := h.config.Clusters[target].Promotion
+	allowed := false
+	for _, cluster := range sources {
+		if cluster == target {
+			http.Error(w, fmt.Sprintf("Deployment %s already ran in cluster %s", source.TagID, target), http.StatusBadRequest)
+			return false
+		}
+		allowed = allowed || policy.AllowsSource(cluster)
+	}
+	if !allowed {
+		http.Error(w, fmt.Sprintf("Cluster %s does not accept promotions from %q", target, sources), http.StatusForbidden)
+		return false
+	}
+
+	if source.Status != models.StatusSuccessful && source.Status != models.StatusCompleted {
+		http.Error(w, fmt.Sprintf("Deployment %s is %s, only successful deployments can be promoted", source.TagID, source.Status), http.StatusConflict)
+		return false
+	}
+
+	// The row is last updated when the rollout is recorded as successful
+	if policy != nil && policy.SoakTime.Duration > 0 {
+		if readyAt := source.UpdatedAt.Add(policy.SoakTime.Duration); time.Now().Before(readyAt) {
+			http.Error(w, fmt.Sprintf("Deployment %s is soaking until %s before it can be promoted to %s",
+				source.TagID, readyAt.UTC().Format(time.RFC3339), target), http.StatusConflict)
+			return false
+		}
+	}
+	return true
+}
@@ -1,41 +1,99 @@
 package handlers
 
 import (
-	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
+	"shipper-deployment/internal/auth"
 	"shipper-deployment/internal/config"
 	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/jobspec"
+	"shipper-deployment/internal/locks"
 	"shipper-deployment/internal/models"
 	"shipper-deployment/internal/nomad"
+	"shipper-deployment/internal/pipeline"
+	"shipper-deployment/internal/policy"
+	"shipper-deployment/internal/queue"
+	"shipper-deployment/internal/rollback"
+	"shipper-deployment/internal/tracker"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	defaultStatusWait  = 30 * time.Second
+	maxStatusWait      = 5 * time.Minute
+	fallbackStatusWait = 10 * time.Second
+)
+
 type Handler struct {
-	db     *sql.DB
-	config *config.Config
-	nomad  *nomad.Client
-	logger *logrus.Entry
+	db        *sql.DB
+	config    *config.Config
+	clusters  *nomad.Clusters
+	tracker   *tracker.Tracker
+	rollback  *rollback.Service
+	pipelines *pipeline.Runner
+	queue     *queue.Queue
+	locks     *locks.Manager
+	keys      *auth.KeyStore
+	policy    *policy.Engine
+	logger    *logrus.Entry
 }
 
 func NewHandler(db *sql.DB, cfg *config.Config, nomadClient *nomad.Client) *Handler {
+	return NewHandlerWithClusters(db, cfg, nomad.NewClusters(nomadClient))
+}
+
+// NewHandlerWithClusters creates a handler deploying to the named clusters besides the default one
+func NewHandlerWithClusters(db *sql.DB, cfg *config.Config, clusters *nomad.Clusters) *Handler {
+	deployLocks := locks.New(db, clusters, cfg)
+	deploymentTracker := tracker.New(db, clusters, cfg, deployLocks)
+	rollbackService := rollback.NewService(db, clusters, deployLocks)
+
 	// Use the same logger as the nomad client for consistency
 	return &Handler{
-		db:     db,
-		config: cfg,
-		nomad:  nomadClient,
-		logger: nomadClient.GetLogger(),
+		db:        db,
+		config:    cfg,
+		clusters:  clusters,
+		tracker:   deploymentTracker,
+		rollback:  rollbackService,
+		pipelines: pipeline.New(db, clusters, cfg, deploymentTracker, rollbackService, deployLocks),
+		queue:     queue.New(db, cfg.DeployWorkers, cfg.DeployQueueSize),
+		locks:     deployLocks,
+		keys:      auth.NewKeyStore(db),
+		policy:    policy.New(cfg.Policy),
+		logger:    clusters.Default().GetLogger(),
 	}
 }
 
+// Tracker returns the deployment tracker shared with background workers
+func (h *Handler) Tracker() *tracker.Tracker {
+	return h.tracker
+}
+
+// Pipelines returns the release pipeline runner shared with the reconciler
+func (h *Handler) Pipelines() *pipeline.Runner {
+	return h.pipelines
+}
+
+// Locks returns the per-service deploy locks, whose queued deploys the reconciler submits
+func (h *Handler) Locks() *locks.Manager {
+	return h.locks
+}
+
+// Queue returns the deploy queue, which the server starts and stops
+func (h *Handler) Queue() *queue.Queue {
+	return h.queue
+}
+
 // writeJSONResponse is a helper function to write JSON responses with error handling
 func (h *Handler) writeJSONResponse(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -49,7 +107,21 @@ func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
 	h.writeJSONResponse(w, map[string]string{"status": "healthy", "time": time.Now().Format(time.RFC3339)})
 }
 
+// DeployJob deploys an uploaded job file, queued when the client asked for an asynchronous answer
 func (h *Handler) DeployJob(w http.ResponseWriter, r *http.Request) {
+	if h.respondAsync(r) {
+		h.enqueue(w, r, models.OperationDeployJob, h.deployJob)
+		return
+	}
+	h.deployJob(w, r)
+}
+
+func (h *Handler) deployJob(w http.ResponseWriter, r *http.Request) {
+	if isJSONRequest(r) {
+		h.deployJobJSON(w, r)
+		return
+	}
+
 	// Parse multipart form data (max 1MB)
 	err := r.ParseMultipartForm(1024 * 1024) // 1MB
 	if err != nil {
@@ -58,10 +130,9 @@ func (h *Handler) DeployJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Debug: Log all form values and files
+	// Debug: Log the form fields and files, values may hold sensitive job variables
 	h.logger.WithFields(logrus.Fields{
-		"form_values": r.Form,
-		"post_form":   r.PostForm,
+		"form_fields": formFieldNames(r.Form),
 		"multipart":   r.MultipartForm != nil,
 	}).Debug("Parsed multipart form")
 
@@ -78,7 +149,7 @@ func (h *Handler) DeployJob(w http.ResponseWriter, r *http.Request) {
 				}
 				return files
 			}(),
-			"values": r.MultipartForm.Value,
+			"values": formFieldNames(r.MultipartForm.Value),
 		}).Debug("Multipart form details")
 	}
 
@@ -133,15 +204,57 @@ func (h *Handler) DeployJob(w http.ResponseWriter, r *http.Request) {
 
 	h.logger.WithField("content_length", len(jobFileContent)).Info("Job file content read successfully")
 
-	// Check if deployment already exists
-	_, _, _, err = database.GetDeployment(h.db, tagID)
-	if err == nil {
-		// Deployment exists
-		h.logger.WithField("tag_id", tagID).Error("Deployment with this tag_id already exists")
-		http.Error(w, fmt.Sprintf("A deployment with tag_id %s already exists", tagID), http.StatusConflict)
+	// Merge var files in upload order, then var fields, as nomad job run does with -var-file and -var
+	variables := jobspec.NewVariables(string(jobFileContent))
+	for _, varFileHeader := range r.MultipartForm.File["var_file"] {
+		varFile, err := readVarFile(varFileHeader)
+		if err == nil {
+			err = variables.AddVarFile(varFile)
+		}
+		if err != nil {
+			h.logger.WithError(err).WithField("var_file", varFileHeader.Filename).Error("Failed to read var file")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, assignment := range r.MultipartForm.Value["var"] {
+		if err := variables.AddAssignment(assignment); err != nil {
+			h.logger.WithError(err).Error("Invalid var field in request")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	requested := nomad.JobScope{Cluster: r.FormValue("cluster"), Namespace: r.FormValue("namespace"), Region: r.FormValue("region")}
+	h.deployJobFile(w, r, tagID, r.FormValue("service_name"), requested, jobFileContent, variables)
+}
+
+// deployJobFile parses a job file with its variables and submits it, or plans it for a dry run.
+// serviceName is the registered service the file is deployed as, the job ID it declares when empty.
+// requested is the cluster, namespace and region the request asked for; the service's config may
+// override the namespace and region.
+func (h *Handler) deployJobFile(w http.ResponseWriter, r *http.Request, tagID, serviceName string, requested nomad.JobScope, jobFileContent []byte, variables *jobspec.Variables) {
+	dryRun, err := isDryRun(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	client, ok := h.nomadClient(w, requested.Cluster)
+	if !ok {
 		return
 	}
 
+	// Check if deployment already exists, a dry run records nothing so it may reuse a tag
+	if !dryRun {
+		_, _, _, err = database.GetDeployment(h.db, tagID)
+		if err == nil {
+			// Deployment exists
+			h.logger.WithField("tag_id", tagID).Error("Deployment with this tag_id already exists")
+			http.Error(w, fmt.Sprintf("A deployment with tag_id %s already exists", tagID), http.StatusConflict)
+			return
+		}
+	}
+
 	// Create temporary file in /tmp location
 	tmpFile := fmt.Sprintf("/tmp/nomad-job-%s.hcl", tagID)
 	if err := os.WriteFile(tmpFile, jobFileContent, 0600); err != nil {
@@ -153,25 +266,85 @@ func (h *Handler) DeployJob(w http.ResponseWriter, r *http.Request) {
 
 	h.logger.WithField("tmp_file", tmpFile).Info("Job file written to tmp location")
 
-	// Validate Nomad job file using Nomad's parse API
-	jobJSON, err := h.parseJobFileWithNomadAPI(string(jobFileContent), tagID)
+	// Nomad's parse API resolves the job in a namespace, the one it will be deployed to when known
+	parseNamespace := requested.Namespace
+	if parseNamespace == "" && serviceName != "" {
+		parseNamespace = h.config.Service(serviceName).Namespace
+	}
+
+	jobJSON, err := h.parseJobFile(client, string(jobFileContent), variables, parseNamespace, tagID)
 	if err != nil {
-		h.logger.WithError(err).Error("Failed to parse job file using Nomad API")
+		h.logger.WithError(err).Error("Failed to parse job file")
 		http.Error(w, fmt.Sprintf("Failed to parse job file: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	fmt.Println("Parsed job JSON:", jobJSON)
+	jobID := parsedJobID(jobJSON)
+	h.logger.WithFields(logrus.Fields{
+		"tag_id":    tagID,
+		"job_id":    jobID,
+		"variables": variables.Names(),
+	}).Debug("Parsed job file")
+
+	if serviceName == "" {
+		serviceName = jobID
+	}
+	if !h.authorizeService(w, r, serviceName) || !h.authorizeJobID(w, r, serviceName, jobID) {
+		return
+	}
+	if !h.allowJobFile(w, r, serviceName, requested, jobJSON) {
+		return
+	}
+	policyWarnings, ok := h.checkPolicy(w, tagID, jobID, jobJSON, string(jobFileContent))
+	if !ok {
+		return
+	}
+
+	if dryRun {
+		h.writePlan(w, client, client.PrepareJobFile(jobJSON, tagID), tagID, policyWarnings)
+		return
+	}
+
+	// Store initial deployment record, the service name is only set when the request named one
+	recordedService := ""
+	if serviceName != jobID {
+		recordedService = serviceName
+	}
+	scope := parsedJobScope(jobJSON)
+	deployment := &models.Deployment{
+		TagID:       tagID,
+		ServiceName: recordedService,
+		Status:      models.StatusPending,
+		NomadJobID:  jobID,
+		Namespace:   scope.Namespace,
+		Region:      scope.Region,
+		Cluster:     requested.Cluster,
+		JobFile:     true,
+		TriggeredBy: auth.TriggeredBy(r.Context()),
+		AuthClaims:  auth.Claims(r.Context()),
+	}
+	// A job file is parsed for this request only, it cannot wait for the lock
+	unlock, ok := h.lockService(w, deployment, false)
+	if !ok {
+		return
+	}
+	defer unlock()
 
-	// Store initial deployment record (without service name for job deployments)
-	if err := database.InsertDeployment(h.db, tagID, "", "", "pending"); err != nil {
+	if err := database.InsertDeploymentRecord(h.db, deployment); err != nil {
 		h.logger.WithError(err).Error("Database error inserting deployment")
 		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	// Keep the variable names so the deploy can be reproduced, sensitive values are redacted
+	if variables.Len() > 0 {
+		if err := database.UpdateDeploymentVariables(h.db, tagID, variables.Redacted()); err != nil {
+			h.logger.WithError(err).WithField("tag_id", tagID).Error("Failed to record deployment variables")
+		}
+	}
+
 	// Submit job to Nomad
-	jobID, err := h.nomad.SubmitJobFile(jobJSON, tagID)
+	jobID, prepared, err := client.SubmitJobFile(jobJSON, tagID)
 	if err != nil {
 		h.logger.WithError(err).WithField("tag_id", tagID).Error("Nomad job submission failed")
 		if updateErr := database.UpdateDeploymentStatus(h.db, tagID, "failed"); updateErr != nil {
@@ -194,17 +367,32 @@ func (h *Handler) DeployJob(w http.ResponseWriter, r *http.Request) {
 		}).Error("Failed to update job ID in database")
 		// Continue with response even if database update fails
 	}
+	h.supersedeOlder(deployment)
 
 	response := models.DeploymentResponse{
-		Status: "running",
-		TagID:  tagID,
-		JobID:  jobID,
+		Status:          "running",
+		TagID:           tagID,
+		JobID:           jobID,
+		Namespace:       scope.Namespace,
+		Region:          scope.Region,
+		Cluster:         requested.Cluster,
+		OverwrittenMeta: prepared.OverwrittenMeta,
+		PolicyWarnings:  policyWarnings,
 	}
 
 	h.writeJSONResponse(w, response)
 }
 
+// Deploy redeploys a service with a new tag, queued when the client asked for an asynchronous answer
 func (h *Handler) Deploy(w http.ResponseWriter, r *http.Request) {
+	if h.respondAsync(r) {
+		h.enqueue(w, r, models.OperationDeploy, h.deploy)
+		return
+	}
+	h.deploy(w, r)
+}
+
+func (h *Handler) deploy(w http.ResponseWriter, r *http.Request) {
 	var req models.DeploymentRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		h.logger.WithError(err).Error("Failed to decode request JSON")
@@ -221,36 +409,144 @@ func (h *Handler) Deploy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	dryRun, err := isDryRun(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !fanOutClusters(w, &req) {
+		return
+	}
+	if dryRun && len(req.Clusters) > 0 {
+		http.Error(w, "dry_run plans one cluster at a time, use cluster instead of clusters", http.StatusBadRequest)
+		return
+	}
+
+	if !h.authorizeService(w, r, req.ServiceName) {
+		return
+	}
+	service, ok := h.allowService(w, r, req.ServiceName)
+	if !ok {
+		return
+	}
+	scope, ok := h.serviceScope(w, req.ServiceName, service, nomad.JobScope{Cluster: req.Cluster, Namespace: req.Namespace, Region: req.Region})
+	if !ok {
+		return
+	}
+
+	deployOptions := nomad.DeployOptions{
+		Image:  req.Image,
+		Images: req.Images,
+	}
+	if err := deployOptions.Validate(); err != nil {
+		h.logger.WithError(err).WithField("tag_id", tagID).Error("Invalid image override in request")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Clusters) > 0 {
+		h.deployFanOut(w, r, req, scope, deployOptions)
+		return
+	}
+
+	client, scope, ok := h.clusterScope(w, scope)
+	if !ok {
+		return
+	}
+	deployOptions.Namespace, deployOptions.Region = scope.Namespace, scope.Region
+
+	if dryRun {
+		prepared, err := client.PrepareDeployment(req.ServiceName, tagID, deployOptions)
+		if err != nil {
+			if errors.Is(err, nomad.ErrInvalidImageOverride) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			h.logger.WithError(err).WithFields(logrus.Fields{
+				"service": req.ServiceName,
+				"tag_id":  tagID,
+			}).Error("Failed to prepare dry-run deployment")
+			http.Error(w, fmt.Sprintf("Failed to prepare deployment: %v", err), http.StatusBadGateway)
+			return
+		}
+		h.writePlan(w, client, prepared, tagID, nil)
+		return
+	}
+
+	h.submitDeployment(w, r, client, &models.Deployment{
+		TagID:       tagID,
+		ServiceName: req.ServiceName,
+		Namespace:   scope.Namespace,
+		Region:      scope.Region,
+		Cluster:     scope.Cluster,
+		Image:       req.Image,
+		Images:      req.Images,
+	}, deployOptions)
+}
+
+// submitDeployment records a deployment of a service and redeploys its job in Nomad with the
+// given options, then writes the response
+func (h *Handler) submitDeployment(w http.ResponseWriter, r *http.Request, client *nomad.Client, deployment *models.Deployment, deployOptions nomad.DeployOptions) {
+	tagID := deployment.TagID
+
 	// Check if deployment already exists
-	_, _, _, err := database.GetDeployment(h.db, tagID)
-	if err == nil {
+	if _, _, _, err := database.GetDeployment(h.db, tagID); err == nil {
 		// Deployment exists
 		h.logger.WithField("tag_id", tagID).Error("Deployment with this tag_id already exists")
 		http.Error(w, fmt.Sprintf("A deployment with tag_id %s already exists", tagID), http.StatusConflict)
 		return
 	}
 
+	deployment.TriggeredBy = auth.TriggeredBy(r.Context())
+	deployment.AuthClaims = auth.Claims(r.Context())
+	unlock, ok := h.lockService(w, deployment, true)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	// Image overrides that do not fit the job are refused before the tag is recorded, so the
+	// request can be corrected and retried with the same tag
+	prepared, err := client.PrepareDeployment(deployment.ServiceName, tagID, deployOptions)
+	if errors.Is(err, nomad.ErrInvalidImageOverride) {
+		h.logger.WithError(err).WithField("tag_id", tagID).Error("Image overrides do not fit the job")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		h.writeJSONResponse(w, models.DeploymentResponse{
+			Status:       "failed",
+			TagID:        tagID,
+			PromotedFrom: deployment.PromotedFrom,
+			Message:      err.Error(),
+		})
+		return
+	}
+
 	// Store initial deployment record
-	if err := database.InsertDeployment(h.db, tagID, req.ServiceName, "", "pending"); err != nil {
+	deployment.Status = models.StatusPending
+	if err := database.InsertDeploymentRecord(h.db, deployment); err != nil {
 		h.logger.WithError(err).Error("Database error inserting deployment")
 		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	// Trigger Nomad deployment
-	jobID, err := h.nomad.TriggerDeployment(req.ServiceName, tagID)
+	var jobID string
+	if err == nil {
+		jobID, err = client.SubmitPrepared(deployment.ServiceName, tagID, prepared)
+	}
 	if err != nil {
 		h.logger.WithError(err).WithFields(logrus.Fields{
-			"service": req.ServiceName,
+			"service": deployment.ServiceName,
 			"tag_id":  tagID,
 		}).Error("Nomad deployment failed")
 		if updateErr := database.UpdateDeploymentStatus(h.db, tagID, "failed"); updateErr != nil {
 			h.logger.WithError(updateErr).Error("Failed to update deployment status")
 		}
 		response := models.DeploymentResponse{
-			Status:  "failed",
-			TagID:   tagID,
-			Message: err.Error(),
+			Status:       "failed",
+			TagID:        tagID,
+			PromotedFrom: deployment.PromotedFrom,
+			Message:      err.Error(),
 		}
 		h.writeJSONResponse(w, response)
 		return
@@ -264,11 +560,18 @@ func (h *Handler) Deploy(w http.ResponseWriter, r *http.Request) {
 		}).Error("Failed to update job ID in database")
 		// Continue with response even if database update fails
 	}
+	h.supersedeOlder(deployment)
 
 	response := models.DeploymentResponse{
-		Status: "running",
-		TagID:  tagID,
-		JobID:  jobID,
+		Status:          "running",
+		TagID:           tagID,
+		JobID:           jobID,
+		Namespace:       deployment.Namespace,
+		Region:          deployment.Region,
+		Cluster:         deployment.Cluster,
+		PromotedFrom:    deployment.PromotedFrom,
+		OverwrittenMeta: prepared.OverwrittenMeta,
+		ImageChanges:    prepared.ImageChanges,
 	}
 
 	h.writeJSONResponse(w, response)
@@ -278,97 +581,196 @@ func (h *Handler) Status(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	tagID := vars["tag_id"]
 
-	_, jobID, status, err := database.GetDeployment(h.db, tagID)
+	deployment, err := database.GetDeploymentRecord(h.db, tagID)
 	if err != nil {
 		h.logger.WithError(err).WithField("tag_id", tagID).Error("Failed to get deployment")
 		http.Error(w, fmt.Sprintf("Deployment not found: %v", err), http.StatusNotFound)
 		return
 	}
 
-	// Check current status from Nomad if job is running
-	if status == "running" && jobID != "" {
-		nomadStatus, err := h.nomad.GetJobStatus(jobID)
-		if err == nil && nomadStatus != status {
-			if updateErr := database.UpdateDeploymentStatus(h.db, tagID, nomadStatus); updateErr != nil {
-				h.logger.WithError(updateErr).Error("Failed to update deployment status")
-			}
-			status = nomadStatus
-		} else if err != nil {
-			h.logger.WithError(err).Error("Failed to get job status from Nomad")
-		}
+	if !h.authorizeService(w, r, deploymentService(deployment)) {
+		return
 	}
 
 	response := models.StatusResponse{
-		Status: status,
-		TagID:  tagID,
-		JobID:  jobID,
+		Status:          deployment.Status,
+		TagID:           tagID,
+		JobID:           deployment.JobID,
+		Namespace:       deployment.Namespace,
+		Region:          deployment.Region,
+		Cluster:         deployment.Cluster,
+		DeploymentID:    deployment.DeploymentID,
+		RollbackOf:      deployment.RollbackOf,
+		RestoredVersion: deployment.RestoredVersion,
+		Variables:       deployment.Variables,
+		TriggeredBy:     deployment.TriggeredBy,
+		AuthClaims:      deployment.AuthClaims,
+		Reason:          deployment.Reason,
+		Tasks:           deployment.Tasks,
+		Clusters:        deployment.Clusters,
+		PromotedFrom:    deployment.PromotedFrom,
+		Pipeline:        deployment.Pipeline,
+		SupersededBy:    deployment.SupersededBy,
+	}
+	if deployment.Action != models.ActionDeploy {
+		response.Action = deployment.Action
+	}
+	if models.IsJobRun(deployment.Action) {
+		response.ChildJobID = deployment.NomadJobID
+	}
+	if response.PromotedTo, err = database.ListPromotions(h.db, tagID); err != nil {
+		h.logger.WithError(err).WithField("tag_id", tagID).Error("Failed to list promotions")
+	}
+
+	// Follow the rollout in Nomad until the deployment reaches a terminal state
+	if !models.IsTerminalStatus(deployment.Status) && (deployment.JobID != "" || len(deployment.Clusters) > 0) {
+		opts, err := h.blockingQueryOptions(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Without an index this is a plain refresh that still reports the index to long-poll on
+		health, lastIndex, err := h.tracker.WaitForChange(*deployment, opts)
+		if err != nil {
+			h.logger.WithError(err).Error("Failed to refresh deployment status")
+		}
+		if lastIndex > 0 {
+			w.Header().Set("X-Shipper-Index", strconv.FormatUint(lastIndex, 10))
+		}
+		if health != nil {
+			response.Status = health.Status
+			response.Message = health.Description
+			response.DeploymentID = health.DeploymentID
+			response.TaskGroups = health.TaskGroups
+			if len(health.Tasks) > 0 {
+				response.Tasks = health.Tasks
+			}
+			if len(health.Clusters) > 0 {
+				response.Clusters = health.Clusters
+			}
+		}
 	}
+	response.Lock = h.serviceLock(deployment, response.Status)
 
 	h.writeJSONResponse(w, response)
 }
 
-// parseJobFileWithNomadAPI converts HCL job content to JSON using Nomad's parse API
-func (h *Handler) parseJobFileWithNomadAPI(jobHCL, tagID string) (map[string]interface{}, error) {
-	h.logger.WithField("tag_id", tagID).Info("Parsing job file using Nomad API")
-
-	// Prepare the request payload
-	parseRequest := map[string]interface{}{
-		"JobHCL":       jobHCL,
-		"Variables":    "",
-		"Canonicalize": true,
+// Rollback reverts a service to an earlier job version and tracks the revert as its own deployment
+func (h *Handler) Rollback(w http.ResponseWriter, r *http.Request) {
+	var req models.RollbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to decode request JSON")
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
 	}
 
-	// Convert to JSON
-	payloadBytes, err := json.Marshal(parseRequest)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal parse request: %v", err)
+	h.logger.WithField("request", req).Info("Rollback request received")
+
+	if !h.authorizeRollback(w, r, req) {
+		return
 	}
+	if req.ServiceName != "" {
+		// Without a service the job is located from the deployment of the tag
+		scope, ok := h.serviceScope(w, req.ServiceName, h.config.Service(req.ServiceName), nomad.JobScope{Cluster: req.Cluster, Namespace: req.Namespace, Region: req.Region})
+		if !ok {
+			return
+		}
+		if _, scope, ok = h.clusterScope(w, scope); !ok {
+			return
+		}
+		req.Namespace, req.Region = scope.Namespace, scope.Region
+	}
+	req.TriggeredBy = auth.TriggeredBy(r.Context())
 
-	// Create request to Nomad parse API
-	url := fmt.Sprintf("%s/v1/jobs/parse?namespace=*", h.config.NomadURL)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payloadBytes))
+	result, err := h.rollback.Rollback(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create parse request: %v", err)
+		h.logger.WithError(err).WithFields(logrus.Fields{
+			"service": req.ServiceName,
+			"tag_id":  req.TagID,
+		}).Error("Rollback failed")
+
+		switch {
+		case errors.Is(err, rollback.ErrInvalidRequest), errors.Is(err, nomad.ErrUnknownCluster):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, rollback.ErrUnknownDeployment):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, rollback.ErrNoTargetVersion):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, fmt.Sprintf("Rollback failed: %v", err), http.StatusBadGateway)
+		}
+		return
 	}
 
-	// Set headers
-	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
-	req.Header.Set("Accept", "*/*")
-	if h.config.NomadToken != "" {
-		req.Header.Set("X-Nomad-Token", h.config.NomadToken)
+	deployment := result.Deployment
+	response := models.RollbackResponse{
+		Status:          deployment.Status,
+		TagID:           deployment.TagID,
+		JobID:           deployment.JobID,
+		ServiceName:     deployment.ServiceName,
+		RollbackOf:      deployment.RollbackOf,
+		RestoredVersion: *deployment.RestoredVersion,
+		RestoredTagID:   result.RestoredTagID,
 	}
 
-	// Create HTTP client with TLS configuration
-	client := h.nomad.GetHTTPClient()
+	h.writeJSONResponse(w, response)
+}
 
-	// Make the request
-	resp, err := client.Do(req)
+// blockingQueryOptions reads the optional index and wait query parameters of a status request.
+// Passing the X-Shipper-Index of a previous response as index long-polls until the rollout changes.
+func (h *Handler) blockingQueryOptions(w http.ResponseWriter, r *http.Request) (*nomad.QueryOptions, error) {
+	indexParam := r.URL.Query().Get("index")
+	if indexParam == "" {
+		return nil, nil
+	}
+
+	index, err := strconv.ParseUint(indexParam, 10, 64)
 	if err != nil {
-		return nil, fmt.Errorf("failed to call Nomad parse API: %v", err)
+		return nil, fmt.Errorf("invalid index: %v", err)
 	}
-	defer resp.Body.Close()
 
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyStr := string(bodyBytes)
-		h.logger.WithFields(logrus.Fields{
-			"status_code": resp.StatusCode,
-			"error_body":  bodyStr,
-		}).Error("Nomad parse API returned non-200 status")
-		return nil, fmt.Errorf("nomad parse API returned status %d: %s", resp.StatusCode, bodyStr)
+	wait := defaultStatusWait
+	if waitParam := r.URL.Query().Get("wait"); waitParam != "" {
+		if wait, err = time.ParseDuration(waitParam); err != nil {
+			return nil, fmt.Errorf("invalid wait: %v", err)
+		}
+	}
+	if wait > maxStatusWait {
+		wait = maxStatusWait
 	}
 
-	// Parse the response
-	var jobSpec map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&jobSpec); err != nil {
-		return nil, fmt.Errorf("failed to decode Nomad parse response: %v", err)
+	// Extend the write deadline past the server's WriteTimeout for the long poll;
+	// fall back to a wait that fits inside it when the writer does not allow that
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + 30*time.Second)); err != nil && wait > fallbackStatusWait {
+		wait = fallbackStatusWait
 	}
 
-	h.logger.WithField("tag_id", tagID).Info("Job file parsed successfully using Nomad API")
+	return &nomad.QueryOptions{WaitIndex: index, WaitTime: wait}, nil
+}
 
-	// Wrap in the expected format for job submission
-	return map[string]interface{}{
-		"Job": jobSpec,
-	}, nil
+// parseJobFile converts HCL job content to job JSON. With the local parser the file is parsed and
+// validated in process, and only files using features it does not support are sent to Nomad's
+// parse API of the cluster the file is deployed to, scoped to namespace when it is set.
+func (h *Handler) parseJobFile(client *nomad.Client, jobHCL string, variables *jobspec.Variables, namespace, tagID string) (map[string]interface{}, error) {
+	if h.config.JobParser == config.JobParserLocal {
+		jobJSON, err := jobspec.Parse(jobHCL, variables)
+		if err == nil {
+			err = jobspec.Validate(jobJSON)
+		}
+		if !errors.Is(err, jobspec.ErrUnsupported) {
+			return jobJSON, err
+		}
+		h.logger.WithError(err).WithField("tag_id", tagID).Info("Job file needs Nomad's parser, falling back to the parse API")
+	}
+
+	if namespace == "" {
+		namespace = "*"
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"tag_id":    tagID,
+		"namespace": namespace,
+	}).Info("Parsing job file using Nomad API")
+	return client.ParseJob(jobHCL, variables.HCL(), namespace)
 }
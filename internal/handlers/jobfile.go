@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"sort"
+
+	"shipper-deployment/internal/jobspec"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+)
+
+// maxJobFileSize limits uploaded job files and var files
+const maxJobFileSize = 1024 * 1024
+
+// deployJobJSON handles the JSON variant of /deploy/job, which carries the job file as a string
+func (h *Handler) deployJobJSON(w http.ResponseWriter, r *http.Request) {
+	var req models.JobDeploymentRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4*maxJobFileSize)).Decode(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to decode request JSON")
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.TagID == "" {
+		h.logger.Error("Tag ID is missing in request")
+		http.Error(w, "Tag ID is required", http.StatusBadRequest)
+		return
+	}
+	if req.JobFile == "" {
+		h.logger.WithField("tag_id", req.TagID).Error("Job file is missing in request")
+		http.Error(w, "Job file is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.JobFile) > maxJobFileSize {
+		http.Error(w, "Job file exceeds 1MB limit", http.StatusBadRequest)
+		return
+	}
+
+	h.logger.WithField("tag_id", req.TagID).Info("Job deployment request received")
+
+	variables := jobspec.NewVariables(req.JobFile)
+	for i, content := range req.VarFiles {
+		varFile := jobspec.VarFile{Name: fmt.Sprintf("var_files[%d]", i), Content: []byte(content)}
+		if err := variables.AddVarFile(varFile); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Apply variables in a stable order so errors are reported consistently
+	names := make([]string, 0, len(req.Variables))
+	for name := range req.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := variables.AddJSON(name, req.Variables[name]); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	requested := nomad.JobScope{Cluster: req.Cluster, Namespace: req.Namespace, Region: req.Region}
+	h.deployJobFile(w, r, req.TagID, req.ServiceName, requested, []byte(req.JobFile), variables)
+}
+
+// isJSONRequest reports whether the request body is JSON rather than a multipart form
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// readVarFile reads an uploaded var file
+func readVarFile(fileHeader *multipart.FileHeader) (jobspec.VarFile, error) {
+	if fileHeader.Size > maxJobFileSize {
+		return jobspec.VarFile{}, fmt.Errorf("var file %s exceeds 1MB limit", fileHeader.Filename)
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return jobspec.VarFile{}, fmt.Errorf("failed to open var file %s: %v", fileHeader.Filename, err)
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return jobspec.VarFile{}, fmt.Errorf("failed to read var file %s: %v", fileHeader.Filename, err)
+	}
+	return jobspec.VarFile{Name: fileHeader.Filename, Content: content}, nil
+}
+
+// formFieldNames lists the fields of a form without their values, for logging
+func formFieldNames(values map[string][]string) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parsedJobID returns the ID of a job parsed by Nomad's parse API
+func parsedJobID(jobJSON map[string]interface{}) string {
+	job, _ := jobJSON["Job"].(map[string]interface{})
+	jobID, _ := job["ID"].(string)
+	return jobID
+}
+
+// parsedJobScope returns the namespace and region a parsed job is placed in
+func parsedJobScope(jobJSON map[string]interface{}) nomad.JobScope {
+	job, _ := jobJSON["Job"].(map[string]interface{})
+	namespace, _ := job["Namespace"].(string)
+	region, _ := job["Region"].(string)
+	return nomad.JobScope{Namespace: namespace, Region: region}
+}
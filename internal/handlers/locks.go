@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/locks"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+
+	"github.com/sirupsen/logrus"
+)
+
+// lockService takes the deploy lock of a deployment's service and applies the service's lock
+// policy to the deployments still rolling out. It returns the unlock to call once the deploy was
+// submitted, or false once it answered the request: the deploy was refused or, when queueable,
+// recorded to wait for the lock. Under supersede the deploy goes ahead and supersedeOlder marks
+// the deployments it replaces once it was submitted.
+func (h *Handler) lockService(w http.ResponseWriter, deployment *models.Deployment, queueable bool) (func(), bool) {
+	unlock := h.locks.Lock(deployment)
+	decision, lock, err := h.locks.Check(deployment, queueable)
+	if err != nil {
+		unlock()
+		h.logger.WithError(err).WithField("tag_id", deployment.TagID).Error("Failed to check deploy lock")
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return nil, false
+	}
+	if lock == nil || lock.Blocker() == "" {
+		return unlock, true
+	}
+
+	log := h.logger.WithFields(logrus.Fields{
+		"tag_id":  deployment.TagID,
+		"service": lock.Service,
+		"cluster": lock.Cluster,
+		"holder":  lock.Blocker(),
+		"queued":  len(lock.Queue),
+		"policy":  lock.Policy,
+	})
+
+	switch decision {
+	case locks.Submit:
+		log.Info("Deploy supersedes unfinished deployments")
+		return unlock, true
+
+	case locks.Queue:
+		defer unlock()
+		deployment.Status = models.StatusQueued
+		if err := database.InsertDeploymentRecord(h.db, deployment); err != nil {
+			log.WithError(err).Error("Database error inserting deployment")
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return nil, false
+		}
+		blocker := lock.Blocker()
+		lock.Queue = append(lock.Queue, deployment.TagID)
+		log.Info("Deployment queued behind the deploy lock")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		h.writeJSONResponse(w, models.DeploymentResponse{
+			Status:       models.StatusQueued,
+			TagID:        deployment.TagID,
+			Namespace:    deployment.Namespace,
+			Region:       deployment.Region,
+			Cluster:      deployment.Cluster,
+			PromotedFrom: deployment.PromotedFrom,
+			Message:      fmt.Sprintf("Queued behind deployment %s of %s", blocker, lock.Service),
+			Lock:         lock,
+		})
+		return nil, false
+	}
+
+	unlock()
+	message := fmt.Sprintf("Service %s is locked by deployment %s", lock.Service, lock.Blocker())
+	if lock.Policy == config.LockQueue {
+		message += ", job file deploys are not queued"
+	}
+	log.Warn("Deploy refused by the deploy lock")
+	http.Error(w, message, http.StatusConflict)
+	return nil, false
+}
+
+// lockJob takes the deploy lock of a service's job for an operation that changes it without a
+// deployment of its own, so it is never sent while a deploy of the service is being submitted
+func (h *Handler) lockJob(service string, scope nomad.JobScope) (unlock func()) {
+	return h.locks.Lock(&models.Deployment{ServiceName: service, Cluster: scope.Cluster, Namespace: scope.Namespace})
+}
+
+// lockClusters takes the deploy lock of a service in every cluster of a fan-out deploy, in cluster
+// order so two fan-outs never wait on each other
+func (h *Handler) lockClusters(service string, clusters []models.ClusterDeployment) (unlock func()) {
+	sorted := append([]models.ClusterDeployment(nil), clusters...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Cluster < sorted[j].Cluster })
+
+	unlocks := make([]func(), 0, len(sorted))
+	for _, cluster := range sorted {
+		unlocks = append(unlocks, h.locks.Lock(&models.Deployment{ServiceName: service, Cluster: cluster.Cluster, Namespace: cluster.Namespace}))
+	}
+	return func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+}
+
+// supersedeOlder marks the unfinished deployments of a service superseded by a deploy that was
+// just submitted, while the caller still holds the lock. Nomad cancels a deployment still rolling
+// out itself once a newer version of the job is registered; queued deploys are never submitted.
+func (h *Handler) supersedeOlder(deployment *models.Deployment) {
+	superseded, err := h.locks.Supersede(deployment)
+	log := h.logger.WithFields(logrus.Fields{
+		"tag_id":     deployment.TagID,
+		"service":    locks.Service(deployment),
+		"superseded": superseded,
+	})
+	if err != nil {
+		log.WithError(err).Error("Failed to supersede deployments")
+		return
+	}
+	if len(superseded) > 0 {
+		log.Info("Superseded unfinished deployments")
+	}
+}
+
+// serviceLock reports the deploy lock of a deployment's service while the deployment holds it or
+// waits for it. Job runs and services without a lock policy have none, nor do fan-out deploys,
+// which are refused under a lock policy.
+func (h *Handler) serviceLock(deployment *models.Deployment, status string) *models.ServiceLock {
+	if models.IsTerminalStatus(status) || len(deployment.Clusters) > 0 || models.IsJobRun(deployment.Action) ||
+		h.locks.Policy(deployment) == config.LockNone {
+		return nil
+	}
+
+	lock, err := h.locks.State(deployment)
+	if err != nil {
+		h.logger.WithError(err).WithField("tag_id", deployment.TagID).Error("Failed to check deploy lock")
+		return nil
+	}
+	return lock
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"shipper-deployment/internal/auth"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// StopJob stops the job of a service, purging it when requested
+func (h *Handler) StopJob(w http.ResponseWriter, r *http.Request) {
+	var req models.StopJobRequest
+	if !h.decodeOperation(w, r, &req, &req.Reason) {
+		return
+	}
+	service, client, scope, ok := h.operationTarget(w, r, mux.Vars(r)["service"])
+	if !ok {
+		return
+	}
+	defer h.lockJob(service, scope)()
+
+	action := models.ActionStop
+	if req.Purge {
+		action = models.ActionPurge
+	}
+
+	evalID, err := client.StopJob(service, scope, req.Purge)
+	if err != nil {
+		h.logger.WithError(err).WithField("service", service).Error("Failed to stop job")
+		http.Error(w, fmt.Sprintf("Failed to stop job: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	h.writeOperation(w, r, scope, models.JobOperationResponse{
+		Action:      action,
+		ServiceName: service,
+		EvalID:      evalID,
+		Reason:      req.Reason,
+		Message:     "Job stopped",
+	})
+}
+
+// ScaleJob sets the count of one task group of a service's job
+func (h *Handler) ScaleJob(w http.ResponseWriter, r *http.Request) {
+	var req models.ScaleJobRequest
+	if !h.decodeOperation(w, r, &req, &req.Reason) {
+		return
+	}
+	if req.Group == "" || req.Count == nil || *req.Count < 0 {
+		http.Error(w, "group and a count of at least 0 are required", http.StatusBadRequest)
+		return
+	}
+	service, client, scope, ok := h.operationTarget(w, r, mux.Vars(r)["service"])
+	if !ok {
+		return
+	}
+	defer h.lockJob(service, scope)()
+
+	// Nomad records the message with the scaling event
+	message := req.Reason
+	if triggeredBy := auth.TriggeredBy(r.Context()); triggeredBy != "" {
+		message = fmt.Sprintf("%s (by %s)", req.Reason, triggeredBy)
+	}
+	evalID, err := client.ScaleJob(service, scope, req.Group, *req.Count, message)
+	if err != nil {
+		h.logger.WithError(err).WithField("service", service).Error("Failed to scale job")
+		http.Error(w, fmt.Sprintf("Failed to scale job: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	h.writeOperation(w, r, scope, models.JobOperationResponse{
+		Action:      models.ActionScale,
+		ServiceName: service,
+		EvalID:      evalID,
+		Group:       req.Group,
+		Count:       req.Count,
+		Reason:      req.Reason,
+		Message:     fmt.Sprintf("Task group %s scaled to %d", req.Group, *req.Count),
+	})
+}
+
+// RestartJob restarts the running allocations of a service's job one at a time
+func (h *Handler) RestartJob(w http.ResponseWriter, r *http.Request) {
+	var req models.RestartJobRequest
+	if !h.decodeOperation(w, r, &req, &req.Reason) {
+		return
+	}
+	service, client, scope, ok := h.operationTarget(w, r, mux.Vars(r)["service"])
+	if !ok {
+		return
+	}
+	defer h.lockJob(service, scope)()
+
+	restarted, err := client.RestartJob(service, scope, req.Group)
+	response := models.JobOperationResponse{
+		Action:          models.ActionRestart,
+		ServiceName:     service,
+		Group:           req.Group,
+		RestartedAllocs: restarted,
+		Reason:          req.Reason,
+		Message:         fmt.Sprintf("Restarted %d allocations", len(restarted)),
+	}
+
+	switch {
+	case errors.Is(err, nomad.ErrNoRunningAllocations):
+		http.Error(w, fmt.Sprintf("Service %s has no running allocations to restart", service), http.StatusConflict)
+		return
+	case err != nil && len(restarted) == 0:
+		h.logger.WithError(err).WithField("service", service).Error("Failed to restart job")
+		http.Error(w, fmt.Sprintf("Failed to restart job: %v", err), http.StatusBadGateway)
+		return
+	case err != nil:
+		// Some allocations were already restarted, record what happened
+		h.logger.WithError(err).WithField("service", service).Error("Restart stopped part way")
+		response.Status = models.StatusFailed
+		response.Message = err.Error()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	h.writeOperation(w, r, scope, response)
+}
+
+// decodeOperation reads the JSON body of a job operation, which must give a reason. It writes
+// a 400 and returns false when the body is invalid.
+func (h *Handler) decodeOperation(w http.ResponseWriter, r *http.Request, req interface{}, reason *string) bool {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		h.logger.WithError(err).Error("Failed to decode request JSON")
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return false
+	}
+	*reason = strings.TrimSpace(*reason)
+	if *reason == "" {
+		http.Error(w, "reason is required", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// operationTarget returns the service, the client of the cluster named by the cluster query
+// parameter and where the job lives there, after checking the caller may act on the service
+// and the service allowlist lists it
+func (h *Handler) operationTarget(w http.ResponseWriter, r *http.Request, service string) (string, *nomad.Client, nomad.JobScope, bool) {
+	if !h.authorizeService(w, r, service) {
+		return "", nil, nomad.JobScope{}, false
+	}
+	entry, ok := h.allowService(w, r, service)
+	if !ok {
+		return "", nil, nomad.JobScope{}, false
+	}
+	client, scope, ok := h.clusterScope(w, nomad.JobScope{
+		Cluster:   r.URL.Query().Get("cluster"),
+		Namespace: entry.Namespace,
+		Region:    entry.Region,
+	})
+	if !ok {
+		return "", nil, nomad.JobScope{}, false
+	}
+	return service, client, scope, true
+}
+
+// writeOperation records a job operation in deployment history and writes the response.
+// Operations take effect when Nomad accepts them, so they are recorded as completed.
+func (h *Handler) writeOperation(w http.ResponseWriter, r *http.Request, scope nomad.JobScope, response models.JobOperationResponse) {
+	if response.Status == "" {
+		response.Status = models.StatusCompleted
+	}
+	response.TagID = fmt.Sprintf("%s-%s-%d", response.Action, response.ServiceName, time.Now().UnixNano())
+
+	if err := database.InsertDeploymentRecord(h.db, &models.Deployment{
+		TagID:       response.TagID,
+		ServiceName: response.ServiceName,
+		JobID:       response.EvalID,
+		Status:      response.Status,
+		NomadJobID:  response.ServiceName,
+		Namespace:   scope.Namespace,
+		Region:      scope.Region,
+		Cluster:     scope.Cluster,
+		Action:      response.Action,
+		TriggeredBy: auth.TriggeredBy(r.Context()),
+		AuthClaims:  auth.Claims(r.Context()),
+		Reason:      response.Reason,
+	}); err != nil {
+		// The operation already happened in Nomad, report it but say it was not recorded
+		h.logger.WithError(err).WithField("tag_id", response.TagID).Error("Failed to record job operation")
+		response.TagID = ""
+		response.Message = fmt.Sprintf("%s, but it could not be recorded: %v", response.Message, err)
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"action":       response.Action,
+		"service":      response.ServiceName,
+		"tag_id":       response.TagID,
+		"triggered_by": auth.TriggeredBy(r.Context()),
+		"reason":       response.Reason,
+	}).Info("Job operation performed")
+
+	h.writeJSONResponse(w, response)
+}
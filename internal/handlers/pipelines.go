@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"shipper-deployment/internal/auth"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+	"shipper-deployment/internal/pipeline"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// CreatePipeline starts a release pipeline deploying services stage by stage under one tag_id
+func (h *Handler) CreatePipeline(w http.ResponseWriter, r *http.Request) {
+	var req models.PipelineRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.WithError(err).Error("Failed to decode request JSON")
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.TagID == "" || len(req.Stages) == 0 {
+		http.Error(w, "tag_id and at least one stage are required", http.StatusBadRequest)
+		return
+	}
+	if _, ok := h.nomadClient(w, req.Cluster); !ok {
+		return
+	}
+
+	// Every service is checked up front, a later stage must not be refused half way through
+	seen := make(map[string]bool)
+	for i, stage := range req.Stages {
+		if len(stage.Services) == 0 {
+			http.Error(w, fmt.Sprintf("stage %d deploys no services", i+1), http.StatusBadRequest)
+			return
+		}
+		if err := (nomad.DeployOptions{Image: stage.Image}).Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("stage %d: %v", i+1, err), http.StatusBadRequest)
+			return
+		}
+		for _, service := range stage.Services {
+			if service == "" || seen[service] {
+				http.Error(w, fmt.Sprintf("services must be named and appear in one stage only, got %q", service), http.StatusBadRequest)
+				return
+			}
+			seen[service] = true
+
+			if !h.authorizeService(w, r, service) {
+				return
+			}
+			entry, ok := h.allowService(w, r, service)
+			if !ok {
+				return
+			}
+			if _, ok := h.serviceScope(w, service, entry, nomad.JobScope{Cluster: req.Cluster}); !ok {
+				return
+			}
+			if _, _, _, err := database.GetDeployment(h.db, pipeline.DeploymentTagID(req.TagID, service)); err == nil {
+				http.Error(w, fmt.Sprintf("A deployment with tag_id %s already exists", pipeline.DeploymentTagID(req.TagID, service)), http.StatusConflict)
+				return
+			}
+		}
+	}
+
+	if _, err := database.GetPipeline(h.db, req.TagID); err == nil {
+		h.logger.WithField("tag_id", req.TagID).Error("Pipeline with this tag_id already exists")
+		http.Error(w, fmt.Sprintf("A pipeline with tag_id %s already exists", req.TagID), http.StatusConflict)
+		return
+	}
+
+	p := &models.Pipeline{
+		TagID:             req.TagID,
+		Stages:            req.Stages,
+		RollbackOnFailure: req.RollbackOnFailure,
+		Cluster:           req.Cluster,
+		TriggeredBy:       auth.TriggeredBy(r.Context()),
+		AuthClaims:        auth.Claims(r.Context()),
+	}
+	if err := h.pipelines.Start(p); err != nil {
+		h.logger.WithError(err).WithField("tag_id", req.TagID).Error("Failed to start pipeline")
+		http.Error(w, fmt.Sprintf("Failed to start pipeline: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"tag_id":       req.TagID,
+		"stages":       len(req.Stages),
+		"cluster":      req.Cluster,
+		"triggered_by": p.TriggeredBy,
+	}).Info("Pipeline submitted")
+
+	h.writePipeline(w, p)
+}
+
+// PipelineStatus refreshes a pipeline's current stage, moving it on when the stage finished, and
+// reports every stage
+func (h *Handler) PipelineStatus(w http.ResponseWriter, r *http.Request) {
+	tagID := mux.Vars(r)["tag_id"]
+
+	p, err := database.GetPipeline(h.db, tagID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Pipeline %s not found", tagID), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.logger.WithError(err).WithField("tag_id", tagID).Error("Failed to get pipeline")
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for _, stage := range p.Stages {
+		for _, service := range stage.Services {
+			if !h.authorizeService(w, r, service) {
+				return
+			}
+		}
+	}
+
+	advanced, err := h.pipelines.Advance(tagID)
+	if err != nil {
+		h.logger.WithError(err).WithField("tag_id", tagID).Error("Failed to advance pipeline")
+	}
+	if advanced != nil {
+		p = advanced
+	}
+	h.writePipeline(w, p)
+}
+
+func (h *Handler) writePipeline(w http.ResponseWriter, p *models.Pipeline) {
+	response, err := h.pipelines.Report(p)
+	if err != nil {
+		h.logger.WithError(err).WithField("tag_id", p.TagID).Error("Failed to list pipeline deployments")
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	h.writeJSONResponse(w, response)
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+
+	"github.com/sirupsen/logrus"
+)
+
+// isDryRun reports whether a deploy request asked for a plan instead of a submission
+func isDryRun(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("dry_run")
+	if value == "" {
+		return false, nil
+	}
+
+	dryRun, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid dry_run: %v", err)
+	}
+	return dryRun, nil
+}
+
+// writePlan asks Nomad to plan the job payload a deploy would submit and writes the result
+// together with any policy warnings. Nothing is recorded in the deployments table.
+func (h *Handler) writePlan(w http.ResponseWriter, client *nomad.Client, prepared *nomad.PreparedJob, tagID string, policyWarnings []models.PolicyViolation) {
+	plan, err := client.PlanJob(prepared.Payload, tagID)
+	if err != nil {
+		h.logger.WithError(err).WithField("tag_id", tagID).Error("Nomad job plan failed")
+		http.Error(w, fmt.Sprintf("Failed to plan job: %v", err), http.StatusBadGateway)
+		return
+	}
+	plan.OverwrittenMeta = prepared.OverwrittenMeta
+	plan.ImageChanges = prepared.ImageChanges
+	plan.PolicyWarnings = policyWarnings
+
+	h.logger.WithFields(logrus.Fields{
+		"tag_id":             tagID,
+		"job_id":             plan.JobID,
+		"placement_failures": len(plan.PlacementFailures),
+	}).Info("Dry-run deployment planned")
+
+	h.writeJSONResponse(w, plan)
+}
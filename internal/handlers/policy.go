@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+
+	"shipper-deployment/internal/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// checkPolicy evaluates a job file parsed from jobHCL against the policy rules. When a rule that is not
+// warn-only is violated it writes a 422 listing the violations and returns false; otherwise
+// it returns the warnings to include in the response.
+func (h *Handler) checkPolicy(w http.ResponseWriter, tagID, jobID string, jobJSON map[string]interface{}, jobHCL string) ([]models.PolicyViolation, bool) {
+	result := h.policy.EvaluateJobFile(jobJSON, jobHCL)
+	fields := logrus.Fields{
+		"tag_id": tagID,
+		"job_id": jobID,
+	}
+
+	if result.Denied() {
+		violations := result.Errors()
+		h.logger.WithFields(fields).WithField("violations", len(violations)).Warn("Job file violates policy")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		h.writeJSONResponse(w, models.PolicyErrorResponse{
+			Error:      "job file violates policy",
+			TagID:      tagID,
+			JobID:      jobID,
+			Violations: result.Violations,
+		})
+		return nil, false
+	}
+
+	warnings := result.Warnings()
+	if len(warnings) > 0 {
+		h.logger.WithFields(fields).WithField("warnings", len(warnings)).Info("Job file has policy warnings")
+	}
+	return warnings, true
+}
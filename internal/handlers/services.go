@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/nomad"
+
+	"github.com/sirupsen/logrus"
+)
+
+// allowService returns the registry entry of a service. With ENFORCE_SERVICE_ALLOWLIST set it
+// writes a 403 and returns false for services the services config does not list.
+func (h *Handler) allowService(w http.ResponseWriter, r *http.Request, service string) (config.ServiceConfig, bool) {
+	entry, listed := h.config.LookupService(service)
+	if listed || !h.config.EnforceServiceAllowlist {
+		return entry, true
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"service": service,
+		"path":    r.URL.Path,
+	}).Warn("Refusing deployment of service missing from the allowlist")
+	http.Error(w, fmt.Sprintf("Forbidden: service %q is not in the service allowlist", service), http.StatusForbidden)
+	return entry, false
+}
+
+// serviceScope resolves the namespace and region a service's job lives in, in the cluster the
+// request names. Values set in the service's registry entry win; a request may only fill in those
+// the entry leaves open, and a request naming another one gets a 403.
+func (h *Handler) serviceScope(w http.ResponseWriter, service string, entry config.ServiceConfig, requested nomad.JobScope) (nomad.JobScope, bool) {
+	scope := nomad.JobScope{Cluster: requested.Cluster, Namespace: entry.Namespace, Region: entry.Region}
+	fields := []struct {
+		name      string
+		resolved  *string
+		requested string
+	}{
+		{"namespace", &scope.Namespace, requested.Namespace},
+		{"region", &scope.Region, requested.Region},
+	}
+	for _, field := range fields {
+		switch {
+		case field.requested == "" || field.requested == *field.resolved:
+		case *field.resolved == "":
+			*field.resolved = field.requested
+		default:
+			h.logger.WithFields(logrus.Fields{
+				"service":  service,
+				field.name: field.requested,
+				"allowed":  *field.resolved,
+			}).Warn("Refusing request for another namespace or region")
+			http.Error(w, fmt.Sprintf("Forbidden: service %q is deployed to %s %q, the request asks for %q",
+				service, field.name, *field.resolved, field.requested), http.StatusForbidden)
+			return nomad.JobScope{}, false
+		}
+	}
+	return scope, true
+}
+
+// allowJobFile checks an uploaded job file against the registry entry of the service it is
+// deployed as, and places it in the namespace and region resolved from the entry and the
+// request. Where neither sets one, a file that leaves it unset gets the cluster's default.
+// It writes a 403 and returns false when the upload is not allowed.
+func (h *Handler) allowJobFile(w http.ResponseWriter, r *http.Request, service string, requested nomad.JobScope, jobJSON map[string]interface{}) bool {
+	entry, ok := h.allowService(w, r, service)
+	if !ok {
+		return false
+	}
+	scope, ok := h.serviceScope(w, service, entry, requested)
+	if !ok {
+		return false
+	}
+
+	job, _ := jobJSON["Job"].(map[string]interface{})
+	jobID, _ := job["ID"].(string)
+	fields := logrus.Fields{
+		"service": service,
+		"job_id":  jobID,
+	}
+
+	if h.config.EnforceServiceAllowlist {
+		if !entry.AllowJobFile {
+			h.logger.WithFields(fields).Warn("Refusing job file upload for service")
+			http.Error(w, fmt.Sprintf("Forbidden: job file deployments are not allowed for service %q", service), http.StatusForbidden)
+			return false
+		}
+		if !entry.AllowsJobID(service, jobID) {
+			h.logger.WithFields(fields).Warn("Refusing job file declaring a job ID the service does not allow")
+			http.Error(w, fmt.Sprintf("Forbidden: service %q may not deploy a job file declaring job %q", service, jobID), http.StatusForbidden)
+			return false
+		}
+	}
+
+	defaults := h.config.Clusters[scope.Cluster]
+	scopes := []struct {
+		field, name, want, fallback, clusterDefault string
+	}{
+		// Nomad's parse API fills in "default" and "global" for jobs that do not set them
+		{"Namespace", "namespace", scope.Namespace, "default", defaults.Namespace},
+		{"Region", "region", scope.Region, "global", defaults.Region},
+	}
+	for _, scope := range scopes {
+		if job == nil {
+			continue
+		}
+		declared, _ := job[scope.field].(string)
+		if scope.want == "" {
+			if scope.clusterDefault != "" && (declared == "" || declared == scope.fallback) {
+				job[scope.field] = scope.clusterDefault
+			}
+			continue
+		}
+		if declared != "" && declared != scope.want && declared != scope.fallback {
+			h.logger.WithFields(fields).WithFields(logrus.Fields{
+				scope.name: declared,
+				"allowed":  scope.want,
+			}).Warn("Refusing job file declaring another namespace or region")
+			http.Error(w, fmt.Sprintf("Forbidden: service %q is deployed to %s %q, the job file declares %q",
+				service, scope.name, scope.want, declared), http.StatusForbidden)
+			return false
+		}
+		job[scope.field] = scope.want
+	}
+	return true
+}
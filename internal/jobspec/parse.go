@@ -0,0 +1,636 @@
+package jobspec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/function/stdlib"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// ErrInvalidJob is returned when a job file is malformed or fails validation
+var ErrInvalidJob = errors.New("invalid job file")
+
+// ErrUnsupported is returned when a job file uses a feature the local parser does not
+// handle. Such files are left for Nomad's parse API.
+var ErrUnsupported = errors.New("job file uses a feature the local parser does not support")
+
+// jobFileName names the job file in parse errors
+const jobFileName = "job.nomad.hcl"
+
+// functions are the HCL functions available to job files, the subset of Nomad's that
+// does not read files or the environment
+var functions = map[string]function.Function{
+	"abs":             stdlib.AbsoluteFunc,
+	"ceil":            stdlib.CeilFunc,
+	"chomp":           stdlib.ChompFunc,
+	"chunklist":       stdlib.ChunklistFunc,
+	"coalesce":        stdlib.CoalesceFunc,
+	"coalescelist":    stdlib.CoalesceListFunc,
+	"compact":         stdlib.CompactFunc,
+	"concat":          stdlib.ConcatFunc,
+	"contains":        stdlib.ContainsFunc,
+	"csvdecode":       stdlib.CSVDecodeFunc,
+	"distinct":        stdlib.DistinctFunc,
+	"element":         stdlib.ElementFunc,
+	"flatten":         stdlib.FlattenFunc,
+	"floor":           stdlib.FloorFunc,
+	"format":          stdlib.FormatFunc,
+	"formatdate":      stdlib.FormatDateFunc,
+	"formatlist":      stdlib.FormatListFunc,
+	"indent":          stdlib.IndentFunc,
+	"join":            stdlib.JoinFunc,
+	"jsondecode":      stdlib.JSONDecodeFunc,
+	"jsonencode":      stdlib.JSONEncodeFunc,
+	"keys":            stdlib.KeysFunc,
+	"length":          stdlib.LengthFunc,
+	"log":             stdlib.LogFunc,
+	"lookup":          stdlib.LookupFunc,
+	"lower":           stdlib.LowerFunc,
+	"max":             stdlib.MaxFunc,
+	"merge":           stdlib.MergeFunc,
+	"min":             stdlib.MinFunc,
+	"parseint":        stdlib.ParseIntFunc,
+	"pow":             stdlib.PowFunc,
+	"range":           stdlib.RangeFunc,
+	"regex":           stdlib.RegexFunc,
+	"regex_replace":   stdlib.RegexReplaceFunc,
+	"regexall":        stdlib.RegexAllFunc,
+	"replace":         stdlib.ReplaceFunc,
+	"reverse":         stdlib.ReverseListFunc,
+	"setintersection": stdlib.SetIntersectionFunc,
+	"setproduct":      stdlib.SetProductFunc,
+	"setsubtract":     stdlib.SetSubtractFunc,
+	"setunion":        stdlib.SetUnionFunc,
+	"signum":          stdlib.SignumFunc,
+	"slice":           stdlib.SliceFunc,
+	"sort":            stdlib.SortFunc,
+	"split":           stdlib.SplitFunc,
+	"strrev":          stdlib.ReverseFunc,
+	"substr":          stdlib.SubstrFunc,
+	"timeadd":         stdlib.TimeAddFunc,
+	"title":           stdlib.TitleFunc,
+	"tobool":          stdlib.MakeToFunc(cty.Bool),
+	"tolist":          stdlib.MakeToFunc(cty.List(cty.DynamicPseudoType)),
+	"tomap":           stdlib.MakeToFunc(cty.Map(cty.DynamicPseudoType)),
+	"tonumber":        stdlib.MakeToFunc(cty.Number),
+	"toset":           stdlib.MakeToFunc(cty.Set(cty.DynamicPseudoType)),
+	"tostring":        stdlib.MakeToFunc(cty.String),
+	"trim":            stdlib.TrimFunc,
+	"trimprefix":      stdlib.TrimPrefixFunc,
+	"trimspace":       stdlib.TrimSpaceFunc,
+	"trimsuffix":      stdlib.TrimSuffixFunc,
+	"upper":           stdlib.UpperFunc,
+	"values":          stdlib.ValuesFunc,
+	"zipmap":          stdlib.ZipmapFunc,
+}
+
+// parser holds the state of parsing one job file
+type parser struct {
+	file *hcl.File
+	ctx  *hcl.EvalContext
+}
+
+// Parse converts an HCL2 job file into the structure Nomad's parse API returns, wrapped as
+// {"Job": {...}} and with the defaults Nomad fills in when canonicalizing. variables may be nil.
+// Files using blocks, attributes or functions the parser does not know return ErrUnsupported.
+func Parse(jobHCL string, variables *Variables) (map[string]interface{}, error) {
+	file, diags := hclsyntax.ParseConfig([]byte(jobHCL), jobFileName, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidJob, diags.Error())
+	}
+	body := file.Body.(*hclsyntax.Body)
+
+	p := &parser{
+		file: file,
+		ctx: &hcl.EvalContext{
+			Variables: map[string]cty.Value{},
+			Functions: functions,
+		},
+	}
+	if err := p.checkFunctions(body); err != nil {
+		return nil, err
+	}
+	if attrs := sortedAttributes(body); len(attrs) > 0 {
+		return nil, p.invalid(attrs[0].SrcRange, "unexpected attribute %q", attrs[0].Name)
+	}
+
+	var (
+		jobBlock     *hclsyntax.Block
+		variableDefs []*hclsyntax.Block
+		localDefs    []*hclsyntax.Attribute
+	)
+	for _, block := range body.Blocks {
+		switch block.Type {
+		case "job":
+			if jobBlock != nil {
+				return nil, p.invalid(block.TypeRange, "only one job block is allowed")
+			}
+			jobBlock = block
+		case "variable":
+			variableDefs = append(variableDefs, block)
+		case "locals":
+			if len(block.Body.Blocks) > 0 {
+				return nil, p.invalid(block.Body.Blocks[0].TypeRange, "locals blocks only hold attributes")
+			}
+			localDefs = append(localDefs, sortedAttributes(block.Body)...)
+		default:
+			return nil, p.unsupported(block.TypeRange, "block %q", block.Type)
+		}
+	}
+	if jobBlock == nil {
+		return nil, fmt.Errorf("%w: no job block", ErrInvalidJob)
+	}
+	if len(jobBlock.Labels) != 1 {
+		return nil, p.invalid(jobBlock.TypeRange, "job block needs exactly one name")
+	}
+
+	if err := p.defineVariables(variableDefs, variables); err != nil {
+		return nil, err
+	}
+	if err := p.defineLocals(localDefs); err != nil {
+		return nil, err
+	}
+
+	job := map[string]interface{}{"ID": jobBlock.Labels[0]}
+	if err := p.decodeBody(jobBlock.Body, jobSection, job); err != nil {
+		return nil, err
+	}
+	canonicalize(job)
+
+	// Round trip through JSON so values have the types decoding Nomad's response gives
+	encoded, err := json.Marshal(map[string]interface{}{"Job": job})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode parsed job: %v", err)
+	}
+	var jobJSON map[string]interface{}
+	if err := json.Unmarshal(encoded, &jobJSON); err != nil {
+		return nil, fmt.Errorf("failed to decode parsed job: %v", err)
+	}
+	return jobJSON, nil
+}
+
+// checkFunctions refuses calls to functions the parser does not provide, such as file()
+func (p *parser) checkFunctions(body *hclsyntax.Body) error {
+	var unknown *hclsyntax.FunctionCallExpr
+	_ = hclsyntax.VisitAll(body, func(node hclsyntax.Node) hcl.Diagnostics {
+		if call, ok := node.(*hclsyntax.FunctionCallExpr); ok && unknown == nil {
+			if _, known := functions[call.Name]; !known {
+				unknown = call
+			}
+		}
+		return nil
+	})
+	if unknown != nil {
+		return p.unsupported(unknown.NameRange, "function %q", unknown.Name)
+	}
+	return nil
+}
+
+// defineVariables makes the declared variables available as var.<name>, taking values
+// from variables before the defaults
+func (p *parser) defineVariables(blocks []*hclsyntax.Block, variables *Variables) error {
+	values := make(map[string]cty.Value, len(blocks))
+	for _, block := range blocks {
+		if len(block.Labels) != 1 {
+			return p.invalid(block.TypeRange, "variable block needs exactly one name")
+		}
+		name := block.Labels[0]
+		if _, ok := values[name]; ok {
+			return p.invalid(block.TypeRange, "variable %q is declared twice", name)
+		}
+		if len(block.Body.Blocks) > 0 {
+			return p.unsupported(block.Body.Blocks[0].TypeRange, "block %q in variable %q", block.Body.Blocks[0].Type, name)
+		}
+
+		valueType := cty.DynamicPseudoType
+		var (
+			value    cty.Value
+			hasValue bool
+		)
+		for _, attr := range sortedAttributes(block.Body) {
+			switch attr.Name {
+			case "type":
+				constraint, diags := typeexpr.TypeConstraint(attr.Expr)
+				if diags.HasErrors() {
+					return fmt.Errorf("%w: %s", ErrInvalidJob, diags.Error())
+				}
+				valueType = constraint
+			case "default":
+				defaultValue, diags := attr.Expr.Value(nil)
+				if diags.HasErrors() {
+					return fmt.Errorf("%w: %s", ErrInvalidJob, diags.Error())
+				}
+				value, hasValue = defaultValue, true
+			case "description", "sensitive":
+			default:
+				return p.unsupported(attr.SrcRange, "attribute %q in variable %q", attr.Name, name)
+			}
+		}
+
+		if variables != nil {
+			if given, ok := variables.values[name]; ok {
+				value, hasValue = given, true
+			}
+		}
+		if !hasValue {
+			return p.invalid(block.TypeRange, "variable %q has no value", name)
+		}
+		converted, err := convert.Convert(value, valueType)
+		if err != nil {
+			return p.invalid(block.TypeRange, "variable %q: %v", name, err)
+		}
+		values[name] = converted
+	}
+
+	if variables != nil {
+		for _, name := range variables.Names() {
+			if _, ok := values[name]; !ok {
+				return fmt.Errorf("%w: variable %q is not declared", ErrInvalidJob, name)
+			}
+		}
+	}
+
+	p.ctx.Variables["var"] = cty.ObjectVal(values)
+	return nil
+}
+
+// defineLocals evaluates the locals blocks as local.<name>, in dependency order
+func (p *parser) defineLocals(attrs []*hclsyntax.Attribute) error {
+	values := make(map[string]cty.Value, len(attrs))
+	declared := make(map[string]bool, len(attrs))
+	for _, attr := range attrs {
+		if declared[attr.Name] {
+			return p.invalid(attr.SrcRange, "local %q is declared twice", attr.Name)
+		}
+		declared[attr.Name] = true
+	}
+	p.ctx.Variables["local"] = cty.ObjectVal(values)
+
+	pending := attrs
+	for len(pending) > 0 {
+		var waiting []*hclsyntax.Attribute
+		for _, attr := range pending {
+			if !p.localsReady(attr.Expr, declared, values) {
+				waiting = append(waiting, attr)
+				continue
+			}
+			value, err := p.value(attr.Expr)
+			if err != nil {
+				return err
+			}
+			values[attr.Name] = value
+			p.ctx.Variables["local"] = cty.ObjectVal(values)
+		}
+		if len(waiting) == len(pending) {
+			return p.invalid(waiting[0].SrcRange, "local %q refers to itself through other locals", waiting[0].Name)
+		}
+		pending = waiting
+	}
+	return nil
+}
+
+// localsReady reports whether every declared local an expression refers to has a value
+func (p *parser) localsReady(expr hclsyntax.Expression, declared map[string]bool, values map[string]cty.Value) bool {
+	for _, traversal := range expr.Variables() {
+		if traversal.RootName() != "local" || len(traversal) < 2 {
+			continue
+		}
+		if attr, ok := traversal[1].(hcl.TraverseAttr); ok && declared[attr.Name] {
+			if _, done := values[attr.Name]; !done {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// value evaluates an expression. References to anything but var and local, such as
+// ${NOMAD_ALLOC_DIR} or ${attr.kernel.name}, are interpolated at runtime by Nomad and
+// are kept as written.
+func (p *parser) value(expr hclsyntax.Expression) (cty.Value, error) {
+	if !hasRuntimeReference(expr) {
+		value, diags := expr.Value(p.ctx)
+		if diags.HasErrors() {
+			return cty.NilVal, fmt.Errorf("%w: %s", ErrInvalidJob, diags.Error())
+		}
+		return value, nil
+	}
+
+	switch e := expr.(type) {
+	case *hclsyntax.ScopeTraversalExpr:
+		return cty.StringVal("${" + string(e.SrcRange.SliceBytes(p.file.Bytes)) + "}"), nil
+
+	case *hclsyntax.TemplateWrapExpr:
+		return p.value(e.Wrapped)
+
+	case *hclsyntax.TemplateExpr:
+		var b strings.Builder
+		for _, part := range e.Parts {
+			value, err := p.value(part)
+			if err != nil {
+				return cty.NilVal, err
+			}
+			s, err := convert.Convert(value, cty.String)
+			if err != nil || s.IsNull() || !s.IsKnown() {
+				return cty.NilVal, p.invalid(part.Range(), "value cannot be used in a string")
+			}
+			b.WriteString(s.AsString())
+		}
+		return cty.StringVal(b.String()), nil
+
+	case *hclsyntax.TupleConsExpr:
+		values := make([]cty.Value, 0, len(e.Exprs))
+		for _, item := range e.Exprs {
+			value, err := p.value(item)
+			if err != nil {
+				return cty.NilVal, err
+			}
+			values = append(values, value)
+		}
+		return cty.TupleVal(values), nil
+
+	case *hclsyntax.ObjectConsExpr:
+		values := make(map[string]cty.Value, len(e.Items))
+		for _, item := range e.Items {
+			key, diags := item.KeyExpr.Value(p.ctx)
+			if diags.HasErrors() {
+				return cty.NilVal, fmt.Errorf("%w: %s", ErrInvalidJob, diags.Error())
+			}
+			key, err := convert.Convert(key, cty.String)
+			if err != nil || key.IsNull() {
+				return cty.NilVal, p.invalid(item.KeyExpr.Range(), "object key must be a string")
+			}
+			value, err := p.value(item.ValueExpr)
+			if err != nil {
+				return cty.NilVal, err
+			}
+			values[key.AsString()] = value
+		}
+		return cty.ObjectVal(values), nil
+	}
+
+	return cty.NilVal, p.unsupported(expr.Range(), "runtime interpolation in a computed expression")
+}
+
+func hasRuntimeReference(expr hclsyntax.Expression) bool {
+	for _, traversal := range expr.Variables() {
+		if root := traversal.RootName(); root != "var" && root != "local" {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeBody decodes the attributes and blocks of body into obj as described by s
+func (p *parser) decodeBody(body *hclsyntax.Body, s *section, obj map[string]interface{}) error {
+	for _, attr := range sortedAttributes(body) {
+		f, ok := s.attrs[attr.Name]
+		if !ok {
+			return p.unsupported(attr.SrcRange, "attribute %q", attr.Name)
+		}
+		value, err := p.value(attr.Expr)
+		if err != nil {
+			return err
+		}
+		if value.IsNull() {
+			continue
+		}
+		converted, err := convertValue(value, f.kind)
+		if err != nil {
+			return p.invalid(attr.SrcRange, "%s: %v", attr.Name, err)
+		}
+		obj[f.key] = converted
+	}
+
+	for _, block := range body.Blocks {
+		n, ok := s.blocks[block.Type]
+		if !ok {
+			return p.unsupported(block.TypeRange, "block %q", block.Type)
+		}
+		if n.label == "" && len(block.Labels) > 0 {
+			return p.invalid(block.TypeRange, "%s block takes no name", block.Type)
+		}
+		if n.label != "" && len(block.Labels) != 1 {
+			return p.invalid(block.TypeRange, "%s block needs exactly one name", block.Type)
+		}
+
+		var child map[string]interface{}
+		switch {
+		case n.section != nil:
+			child = make(map[string]interface{})
+			if n.label != "" {
+				child[n.label] = block.Labels[0]
+			}
+			if err := p.decodeBody(block.Body, n.section, child); err != nil {
+				return err
+			}
+		case n.freeform:
+			freeform, err := p.freeform(block.Body)
+			if err != nil {
+				return err
+			}
+			child = freeform
+		default:
+			values, err := p.stringMap(block.Body)
+			if err != nil {
+				return err
+			}
+			existing, _ := obj[n.key].(map[string]interface{})
+			if existing == nil {
+				existing = make(map[string]interface{})
+			}
+			for key, value := range values {
+				existing[key] = value
+			}
+			obj[n.key] = existing
+			continue
+		}
+
+		switch {
+		case n.list:
+			list, _ := obj[n.key].([]interface{})
+			obj[n.key] = append(list, child)
+		case n.keyed:
+			keyed, _ := obj[n.key].(map[string]interface{})
+			if keyed == nil {
+				keyed = make(map[string]interface{})
+			}
+			if _, ok := keyed[block.Labels[0]]; ok {
+				return p.invalid(block.TypeRange, "%s %q is declared twice", block.Type, block.Labels[0])
+			}
+			keyed[block.Labels[0]] = child
+			obj[n.key] = keyed
+		default:
+			if _, ok := obj[n.key]; ok {
+				return p.invalid(block.TypeRange, "only one %s block is allowed here", block.Type)
+			}
+			obj[n.key] = child
+		}
+	}
+
+	if s.finish != nil {
+		s.finish(obj)
+	}
+	return nil
+}
+
+// freeform decodes a driver config block, where nested blocks become lists of objects
+func (p *parser) freeform(body *hclsyntax.Body) (map[string]interface{}, error) {
+	obj := make(map[string]interface{})
+	for _, attr := range sortedAttributes(body) {
+		value, err := p.value(attr.Expr)
+		if err != nil {
+			return nil, err
+		}
+		converted, err := convertValue(value, kindAny)
+		if err != nil {
+			return nil, p.invalid(attr.SrcRange, "%s: %v", attr.Name, err)
+		}
+		obj[attr.Name] = converted
+	}
+	for _, block := range body.Blocks {
+		if len(block.Labels) > 0 {
+			return nil, p.unsupported(block.TypeRange, "named block %q in a driver config", block.Type)
+		}
+		child, err := p.freeform(block.Body)
+		if err != nil {
+			return nil, err
+		}
+		list, _ := obj[block.Type].([]interface{})
+		obj[block.Type] = append(list, child)
+	}
+	return obj, nil
+}
+
+// stringMap decodes a block of string attributes such as meta or env
+func (p *parser) stringMap(body *hclsyntax.Body) (map[string]interface{}, error) {
+	if len(body.Blocks) > 0 {
+		return nil, p.invalid(body.Blocks[0].TypeRange, "unexpected block %q", body.Blocks[0].Type)
+	}
+	values := make(map[string]interface{}, len(body.Attributes))
+	for _, attr := range sortedAttributes(body) {
+		value, err := p.value(attr.Expr)
+		if err != nil {
+			return nil, err
+		}
+		s, err := convertValue(value, kindString)
+		if err != nil {
+			return nil, p.invalid(attr.SrcRange, "%s: %v", attr.Name, err)
+		}
+		values[attr.Name] = s
+	}
+	return values, nil
+}
+
+func (p *parser) invalid(rng hcl.Range, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s: %s", ErrInvalidJob, rng.String(), fmt.Sprintf(format, args...))
+}
+
+func (p *parser) unsupported(rng hcl.Range, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s at %s", ErrUnsupported, fmt.Sprintf(format, args...), rng.String())
+}
+
+func sortedAttributes(body *hclsyntax.Body) []*hclsyntax.Attribute {
+	attrs := make([]*hclsyntax.Attribute, 0, len(body.Attributes))
+	for _, attr := range body.Attributes {
+		attrs = append(attrs, attr)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return attrs[i].SrcRange.Start.Byte < attrs[j].SrcRange.Start.Byte
+	})
+	return attrs
+}
+
+// convertValue converts an attribute value to the Go form of an API field
+func convertValue(value cty.Value, kind fieldKind) (interface{}, error) {
+	if !value.IsWhollyKnown() {
+		return nil, fmt.Errorf("value is not known")
+	}
+
+	switch kind {
+	case kindString:
+		s, err := convert.Convert(value, cty.String)
+		if err != nil {
+			return nil, err
+		}
+		return s.AsString(), nil
+
+	case kindInt:
+		n, err := convert.Convert(value, cty.Number)
+		if err != nil {
+			return nil, err
+		}
+		i, accuracy := n.AsBigFloat().Int64()
+		if accuracy != 0 {
+			return nil, fmt.Errorf("a whole number is required")
+		}
+		return i, nil
+
+	case kindBool:
+		b, err := convert.Convert(value, cty.Bool)
+		if err != nil {
+			return nil, err
+		}
+		return b.True(), nil
+
+	case kindDuration:
+		s, err := convert.Convert(value, cty.String)
+		if err != nil {
+			return nil, err
+		}
+		d, err := time.ParseDuration(s.AsString())
+		if err != nil {
+			return nil, err
+		}
+		return d.Nanoseconds(), nil
+
+	case kindStrings:
+		list, err := convert.Convert(value, cty.List(cty.String))
+		if err != nil {
+			return nil, err
+		}
+		items := make([]string, 0, list.LengthInt())
+		for _, item := range list.AsValueSlice() {
+			if item.IsNull() {
+				return nil, fmt.Errorf("list items must not be null")
+			}
+			items = append(items, item.AsString())
+		}
+		return items, nil
+
+	case kindStringMap:
+		m, err := convert.Convert(value, cty.Map(cty.String))
+		if err != nil {
+			return nil, err
+		}
+		items := make(map[string]string, m.LengthInt())
+		for key, item := range m.AsValueMap() {
+			if item.IsNull() {
+				return nil, fmt.Errorf("map values must not be null")
+			}
+			items[key] = item.AsString()
+		}
+		return items, nil
+	}
+
+	encoded, err := ctyjson.Marshal(value, value.Type())
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
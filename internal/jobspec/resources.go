@@ -0,0 +1,47 @@
+package jobspec
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// DeclaredResources reports the attributes the resources block of every task sets in a job file,
+// keyed by "<group>/<task>". Parse and Nomad's parse API fill in cpu and memory for tasks that
+// leave them out, so the parsed job cannot tell a default from a value the file sets.
+func DeclaredResources(jobHCL string) (map[string]map[string]bool, error) {
+	file, diags := hclsyntax.ParseConfig([]byte(jobHCL), jobFileName, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidJob, diags.Error())
+	}
+
+	declared := make(map[string]map[string]bool)
+	for _, job := range childBlocks(file.Body.(*hclsyntax.Body), "job") {
+		for _, group := range childBlocks(job.Body, "group") {
+			for _, task := range childBlocks(group.Body, "task") {
+				if len(group.Labels) != 1 || len(task.Labels) != 1 {
+					continue
+				}
+				attributes := make(map[string]bool)
+				for _, resources := range childBlocks(task.Body, "resources") {
+					for name := range resources.Body.Attributes {
+						attributes[name] = true
+					}
+				}
+				declared[group.Labels[0]+"/"+task.Labels[0]] = attributes
+			}
+		}
+	}
+	return declared, nil
+}
+
+func childBlocks(body *hclsyntax.Body, blockType string) []*hclsyntax.Block {
+	var blocks []*hclsyntax.Block
+	for _, block := range body.Blocks {
+		if block.Type == blockType {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
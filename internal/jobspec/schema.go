@@ -0,0 +1,390 @@
+package jobspec
+
+import "time"
+
+// fieldKind is the Go form an attribute value is converted to
+type fieldKind int
+
+const (
+	kindString fieldKind = iota
+	kindInt
+	kindBool
+	// kindDuration is a duration string such as "30s", stored in nanoseconds as the API expects
+	kindDuration
+	kindStrings
+	kindStringMap
+	// kindAny keeps the value as it is, for driver config
+	kindAny
+)
+
+// field maps an HCL attribute onto a job API field
+type field struct {
+	key  string
+	kind fieldKind
+}
+
+// nested maps an HCL block onto a job API field
+type nested struct {
+	key string
+	// label is the API field the block name is stored in, empty for blocks without a name
+	label string
+	// list collects the blocks into a list, keyed into a map by name, or allows a single block
+	list  bool
+	keyed bool
+	// section describes the block body. Without one the body is a free-form driver config
+	// when freeform is set, and a map of strings such as meta or env otherwise.
+	section  *section
+	freeform bool
+}
+
+// section describes how the body of a block maps onto the job API structure. Attributes
+// and blocks it does not list make the job file unsupported.
+type section struct {
+	attrs  map[string]field
+	blocks map[string]nested
+	// finish adjusts the decoded object where the API layout differs from the HCL one
+	finish func(obj map[string]interface{})
+}
+
+var constraintSection = &section{
+	attrs: map[string]field{
+		"attribute": {"LTarget", kindString},
+		"value":     {"RTarget", kindString},
+		"operator":  {"Operand", kindString},
+	},
+	finish: func(obj map[string]interface{}) {
+		setDefault(obj, "Operand", "=")
+	},
+}
+
+var affinitySection = &section{
+	attrs: map[string]field{
+		"attribute": {"LTarget", kindString},
+		"value":     {"RTarget", kindString},
+		"operator":  {"Operand", kindString},
+		"weight":    {"Weight", kindInt},
+	},
+	finish: func(obj map[string]interface{}) {
+		setDefault(obj, "Operand", "=")
+		setDefault(obj, "Weight", int64(50))
+	},
+}
+
+var updateSection = &section{
+	attrs: map[string]field{
+		"max_parallel":      {"MaxParallel", kindInt},
+		"health_check":      {"HealthCheck", kindString},
+		"min_healthy_time":  {"MinHealthyTime", kindDuration},
+		"healthy_deadline":  {"HealthyDeadline", kindDuration},
+		"progress_deadline": {"ProgressDeadline", kindDuration},
+		"auto_revert":       {"AutoRevert", kindBool},
+		"auto_promote":      {"AutoPromote", kindBool},
+		"canary":            {"Canary", kindInt},
+		"stagger":           {"Stagger", kindDuration},
+	},
+}
+
+var restartSection = &section{
+	attrs: map[string]field{
+		"attempts":         {"Attempts", kindInt},
+		"interval":         {"Interval", kindDuration},
+		"delay":            {"Delay", kindDuration},
+		"mode":             {"Mode", kindString},
+		"render_templates": {"RenderTemplates", kindBool},
+	},
+}
+
+var rescheduleSection = &section{
+	attrs: map[string]field{
+		"attempts":       {"Attempts", kindInt},
+		"interval":       {"Interval", kindDuration},
+		"delay":          {"Delay", kindDuration},
+		"delay_function": {"DelayFunction", kindString},
+		"max_delay":      {"MaxDelay", kindDuration},
+		"unlimited":      {"Unlimited", kindBool},
+	},
+}
+
+var checkSection = &section{
+	attrs: map[string]field{
+		"name":           {"Name", kindString},
+		"type":           {"Type", kindString},
+		"path":           {"Path", kindString},
+		"protocol":       {"Protocol", kindString},
+		"method":         {"Method", kindString},
+		"port":           {"PortLabel", kindString},
+		"command":        {"Command", kindString},
+		"args":           {"Args", kindStrings},
+		"interval":       {"Interval", kindDuration},
+		"timeout":        {"Timeout", kindDuration},
+		"initial_status": {"InitialStatus", kindString},
+		"task":           {"TaskName", kindString},
+		"address_mode":   {"AddressMode", kindString},
+	},
+}
+
+var serviceSection = &section{
+	attrs: map[string]field{
+		"name":         {"Name", kindString},
+		"port":         {"PortLabel", kindString},
+		"tags":         {"Tags", kindStrings},
+		"canary_tags":  {"CanaryTags", kindStrings},
+		"provider":     {"Provider", kindString},
+		"address_mode": {"AddressMode", kindString},
+		"task":         {"TaskName", kindString},
+		"meta":         {"Meta", kindStringMap},
+	},
+	blocks: map[string]nested{
+		"check": {key: "Checks", list: true, section: checkSection},
+		"meta":  {key: "Meta"},
+	},
+}
+
+var portSection = &section{
+	attrs: map[string]field{
+		"static":       {"Value", kindInt},
+		"to":           {"To", kindInt},
+		"host_network": {"HostNetwork", kindString},
+	},
+}
+
+var networkSection = &section{
+	attrs: map[string]field{
+		"mode":     {"Mode", kindString},
+		"hostname": {"Hostname", kindString},
+	},
+	blocks: map[string]nested{
+		"port": {key: "Ports", label: "Label", list: true, section: portSection},
+	},
+	// Ports with a static value are reserved, the others dynamic
+	finish: func(obj map[string]interface{}) {
+		ports, _ := obj["Ports"].([]interface{})
+		delete(obj, "Ports")
+		for _, port := range ports {
+			key := "DynamicPorts"
+			if _, static := port.(map[string]interface{})["Value"]; static {
+				key = "ReservedPorts"
+			}
+			list, _ := obj[key].([]interface{})
+			obj[key] = append(list, port)
+		}
+	},
+}
+
+var volumeSection = &section{
+	attrs: map[string]field{
+		"type":            {"Type", kindString},
+		"source":          {"Source", kindString},
+		"read_only":       {"ReadOnly", kindBool},
+		"per_alloc":       {"PerAlloc", kindBool},
+		"access_mode":     {"AccessMode", kindString},
+		"attachment_mode": {"AttachmentMode", kindString},
+	},
+}
+
+var volumeMountSection = &section{
+	attrs: map[string]field{
+		"volume":           {"Volume", kindString},
+		"destination":      {"Destination", kindString},
+		"read_only":        {"ReadOnly", kindBool},
+		"propagation_mode": {"PropagationMode", kindString},
+	},
+}
+
+var ephemeralDiskSection = &section{
+	attrs: map[string]field{
+		"size":    {"SizeMB", kindInt},
+		"migrate": {"Migrate", kindBool},
+		"sticky":  {"Sticky", kindBool},
+	},
+}
+
+var resourcesSection = &section{
+	attrs: map[string]field{
+		"cpu":        {"CPU", kindInt},
+		"cores":      {"Cores", kindInt},
+		"memory":     {"MemoryMB", kindInt},
+		"memory_max": {"MemoryMaxMB", kindInt},
+	},
+}
+
+var templateSection = &section{
+	attrs: map[string]field{
+		"source":               {"SourcePath", kindString},
+		"destination":          {"DestPath", kindString},
+		"data":                 {"EmbeddedTmpl", kindString},
+		"change_mode":          {"ChangeMode", kindString},
+		"change_signal":        {"ChangeSignal", kindString},
+		"splay":                {"Splay", kindDuration},
+		"perms":                {"Perms", kindString},
+		"uid":                  {"Uid", kindInt},
+		"gid":                  {"Gid", kindInt},
+		"left_delimiter":       {"LeftDelim", kindString},
+		"right_delimiter":      {"RightDelim", kindString},
+		"env":                  {"Envvars", kindBool},
+		"error_on_missing_key": {"ErrMissingKey", kindBool},
+	},
+}
+
+var artifactSection = &section{
+	attrs: map[string]field{
+		"source":      {"GetterSource", kindString},
+		"destination": {"RelativeDest", kindString},
+		"mode":        {"GetterMode", kindString},
+		"options":     {"GetterOptions", kindStringMap},
+		"headers":     {"GetterHeaders", kindStringMap},
+	},
+	blocks: map[string]nested{
+		"options": {key: "GetterOptions"},
+		"headers": {key: "GetterHeaders"},
+	},
+}
+
+var taskSection = &section{
+	attrs: map[string]field{
+		"driver":         {"Driver", kindString},
+		"user":           {"User", kindString},
+		"kill_timeout":   {"KillTimeout", kindDuration},
+		"kill_signal":    {"KillSignal", kindString},
+		"leader":         {"Leader", kindBool},
+		"shutdown_delay": {"ShutdownDelay", kindDuration},
+		"meta":           {"Meta", kindStringMap},
+		"env":            {"Env", kindStringMap},
+	},
+	blocks: map[string]nested{
+		"config":       {key: "Config", freeform: true},
+		"env":          {key: "Env"},
+		"meta":         {key: "Meta"},
+		"resources":    {key: "Resources", section: resourcesSection},
+		"constraint":   {key: "Constraints", list: true, section: constraintSection},
+		"affinity":     {key: "Affinities", list: true, section: affinitySection},
+		"service":      {key: "Services", list: true, section: serviceSection},
+		"template":     {key: "Templates", list: true, section: templateSection},
+		"artifact":     {key: "Artifacts", list: true, section: artifactSection},
+		"volume_mount": {key: "VolumeMounts", list: true, section: volumeMountSection},
+		"restart":      {key: "RestartPolicy", section: restartSection},
+		"lifecycle": {key: "Lifecycle", section: &section{attrs: map[string]field{
+			"hook":    {"Hook", kindString},
+			"sidecar": {"Sidecar", kindBool},
+		}}},
+		"logs": {key: "LogConfig", section: &section{attrs: map[string]field{
+			"max_files":     {"MaxFiles", kindInt},
+			"max_file_size": {"MaxFileSizeMB", kindInt},
+			"disabled":      {"Disabled", kindBool},
+		}}},
+	},
+}
+
+var groupSection = &section{
+	attrs: map[string]field{
+		"count":          {"Count", kindInt},
+		"shutdown_delay": {"ShutdownDelay", kindDuration},
+		"meta":           {"Meta", kindStringMap},
+	},
+	blocks: map[string]nested{
+		"task":           {key: "Tasks", label: "Name", list: true, section: taskSection},
+		"meta":           {key: "Meta"},
+		"constraint":     {key: "Constraints", list: true, section: constraintSection},
+		"affinity":       {key: "Affinities", list: true, section: affinitySection},
+		"network":        {key: "Networks", list: true, section: networkSection},
+		"service":        {key: "Services", list: true, section: serviceSection},
+		"volume":         {key: "Volumes", label: "Name", keyed: true, section: volumeSection},
+		"update":         {key: "Update", section: updateSection},
+		"restart":        {key: "RestartPolicy", section: restartSection},
+		"reschedule":     {key: "ReschedulePolicy", section: rescheduleSection},
+		"ephemeral_disk": {key: "EphemeralDisk", section: ephemeralDiskSection},
+	},
+}
+
+var jobSection = &section{
+	attrs: map[string]field{
+		"name":        {"Name", kindString},
+		"type":        {"Type", kindString},
+		"region":      {"Region", kindString},
+		"namespace":   {"Namespace", kindString},
+		"datacenters": {"Datacenters", kindStrings},
+		"node_pool":   {"NodePool", kindString},
+		"priority":    {"Priority", kindInt},
+		"all_at_once": {"AllAtOnce", kindBool},
+		"meta":        {"Meta", kindStringMap},
+	},
+	blocks: map[string]nested{
+		"group":      {key: "TaskGroups", label: "Name", list: true, section: groupSection},
+		"meta":       {key: "Meta"},
+		"constraint": {key: "Constraints", list: true, section: constraintSection},
+		"affinity":   {key: "Affinities", list: true, section: affinitySection},
+		"update":     {key: "Update", section: updateSection},
+		"reschedule": {key: "ReschedulePolicy", section: rescheduleSection},
+	},
+}
+
+// defaultUpdate is the update strategy Nomad completes update blocks with
+var defaultUpdate = map[string]interface{}{
+	"Stagger":          (30 * time.Second).Nanoseconds(),
+	"MaxParallel":      int64(1),
+	"HealthCheck":      "checks",
+	"MinHealthyTime":   (10 * time.Second).Nanoseconds(),
+	"HealthyDeadline":  (5 * time.Minute).Nanoseconds(),
+	"ProgressDeadline": (10 * time.Minute).Nanoseconds(),
+	"AutoRevert":       false,
+	"AutoPromote":      false,
+	"Canary":           int64(0),
+}
+
+// canonicalize fills in the defaults Nomad's parse API applies when canonicalizing, for the
+// fields Shipper and the policy rules read
+func canonicalize(job map[string]interface{}) {
+	setDefault(job, "Name", job["ID"])
+	setDefault(job, "Type", "service")
+	setDefault(job, "Region", "global")
+	setDefault(job, "Namespace", "default")
+	setDefault(job, "Priority", int64(50))
+	setDefault(job, "Datacenters", []string{"*"})
+
+	// Task groups inherit the job's update block, with their own settings taking precedence
+	jobUpdate, _ := job["Update"].(map[string]interface{})
+	if jobUpdate != nil {
+		fillDefaults(jobUpdate, defaultUpdate)
+	}
+
+	groups, _ := job["TaskGroups"].([]interface{})
+	for _, item := range groups {
+		group := item.(map[string]interface{})
+		setDefault(group, "Count", int64(1))
+
+		groupUpdate, _ := group["Update"].(map[string]interface{})
+		if jobUpdate != nil || groupUpdate != nil {
+			merged := make(map[string]interface{})
+			fillDefaults(merged, groupUpdate)
+			fillDefaults(merged, jobUpdate)
+			fillDefaults(merged, defaultUpdate)
+			group["Update"] = merged
+		}
+
+		tasks, _ := group["Tasks"].([]interface{})
+		for _, item := range tasks {
+			task := item.(map[string]interface{})
+			resources, _ := task["Resources"].(map[string]interface{})
+			if resources == nil {
+				resources = make(map[string]interface{})
+				task["Resources"] = resources
+			}
+			if _, ok := resources["Cores"]; !ok {
+				setDefault(resources, "CPU", int64(100))
+			}
+			setDefault(resources, "MemoryMB", int64(300))
+		}
+	}
+}
+
+func setDefault(obj map[string]interface{}, key string, value interface{}) {
+	if _, ok := obj[key]; !ok {
+		obj[key] = value
+	}
+}
+
+func fillDefaults(obj, defaults map[string]interface{}) {
+	for key, value := range defaults {
+		setDefault(obj, key, value)
+	}
+}
@@ -0,0 +1,83 @@
+package jobspec
+
+import (
+	"fmt"
+	"strings"
+)
+
+// jobTypes are the scheduler types Nomad accepts
+var jobTypes = map[string]bool{
+	"service":  true,
+	"batch":    true,
+	"system":   true,
+	"sysbatch": true,
+}
+
+// Validate checks a parsed job of the form {"Job": {...}} for the mistakes Nomad would refuse
+// it for on submission, such as missing task groups, duplicate names or tasks without a driver.
+// It accepts the output of Parse and of Nomad's parse API alike.
+func Validate(jobJSON map[string]interface{}) error {
+	job, _ := jobJSON["Job"].(map[string]interface{})
+	if job == nil {
+		return fmt.Errorf("%w: no job definition", ErrInvalidJob)
+	}
+
+	var problems []string
+	id, _ := job["ID"].(string)
+	switch {
+	case id == "":
+		problems = append(problems, "job has no ID")
+	case strings.ContainsAny(id, " \x00"):
+		problems = append(problems, fmt.Sprintf("job ID %q contains a space or null character", id))
+	}
+	if jobType, ok := job["Type"].(string); ok && !jobTypes[jobType] {
+		problems = append(problems, fmt.Sprintf("unknown job type %q", jobType))
+	}
+
+	groups, _ := job["TaskGroups"].([]interface{})
+	if len(groups) == 0 {
+		problems = append(problems, "job has no task groups")
+	}
+	groupNames := make(map[string]bool, len(groups))
+	for i, item := range groups {
+		group, _ := item.(map[string]interface{})
+		name, _ := group["Name"].(string)
+		if name == "" {
+			problems = append(problems, fmt.Sprintf("task group %d has no name", i+1))
+			name = fmt.Sprintf("%d", i+1)
+		} else if groupNames[name] {
+			problems = append(problems, fmt.Sprintf("task group %q is declared twice", name))
+		}
+		groupNames[name] = true
+
+		if count, ok := group["Count"].(float64); ok && count < 0 {
+			problems = append(problems, fmt.Sprintf("task group %q has a negative count", name))
+		}
+
+		tasks, _ := group["Tasks"].([]interface{})
+		if len(tasks) == 0 {
+			problems = append(problems, fmt.Sprintf("task group %q has no tasks", name))
+		}
+		taskNames := make(map[string]bool, len(tasks))
+		for j, item := range tasks {
+			task, _ := item.(map[string]interface{})
+			taskName, _ := task["Name"].(string)
+			if taskName == "" {
+				problems = append(problems, fmt.Sprintf("task %d of group %q has no name", j+1, name))
+				continue
+			}
+			if taskNames[taskName] {
+				problems = append(problems, fmt.Sprintf("task %q of group %q is declared twice", taskName, name))
+			}
+			taskNames[taskName] = true
+			if driver, _ := task["Driver"].(string); driver == "" {
+				problems = append(problems, fmt.Sprintf("task %q of group %q has no driver", taskName, name))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidJob, strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package jobspec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	hcljson "github.com/hashicorp/hcl/v2/json"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// RedactedValue replaces the value of sensitive variables in recorded deployments
+const RedactedValue = "[sensitive]"
+
+// ErrInvalidVariables is returned when job variables or var files cannot be read
+var ErrInvalidVariables = errors.New("invalid job variables")
+
+// VarFile is an uploaded variable definitions file, in HCL or (with a .json name) JSON syntax
+type VarFile struct {
+	Name    string
+	Content []byte
+}
+
+// VariableDeclaration is what a job file declares about one of its variable blocks
+type VariableDeclaration struct {
+	// Type is the source of the type expression, empty when the block does not set one
+	Type      string
+	Sensitive bool
+}
+
+// Variables holds the merged variable values for a job file
+type Variables struct {
+	values   map[string]cty.Value
+	declared map[string]VariableDeclaration
+}
+
+// NewVariables starts an empty set of values for the given job file. The job's variable
+// blocks decide how string form values are typed and which values are redacted.
+func NewVariables(jobHCL string) *Variables {
+	return &Variables{
+		values:   make(map[string]cty.Value),
+		declared: DeclaredVariables(jobHCL),
+	}
+}
+
+// DeclaredVariables returns the variable blocks of an HCL2 job file. Files that do not parse
+// as HCL2 return no declarations and are left for Nomad to reject.
+func DeclaredVariables(jobHCL string) map[string]VariableDeclaration {
+	declared := make(map[string]VariableDeclaration)
+
+	file, diags := hclsyntax.ParseConfig([]byte(jobHCL), "job.nomad.hcl", hcl.InitialPos)
+	if diags.HasErrors() {
+		return declared
+	}
+	body, ok := file.Body.(*hclsyntax.Body)
+	if !ok {
+		return declared
+	}
+
+	for _, block := range body.Blocks {
+		if block.Type != "variable" || len(block.Labels) != 1 {
+			continue
+		}
+
+		var declaration VariableDeclaration
+		if attr, ok := block.Body.Attributes["type"]; ok {
+			declaration.Type = strings.TrimSpace(string(attr.Expr.Range().SliceBytes(file.Bytes)))
+		}
+		if attr, ok := block.Body.Attributes["sensitive"]; ok {
+			if value, diags := attr.Expr.Value(nil); !diags.HasErrors() && value.Type() == cty.Bool && value.True() {
+				declaration.Sensitive = true
+			}
+		}
+		declared[block.Labels[0]] = declaration
+	}
+
+	return declared
+}
+
+// AddVarFile merges the definitions of a var file, replacing values set before it
+func (v *Variables) AddVarFile(varFile VarFile) error {
+	var (
+		file  *hcl.File
+		diags hcl.Diagnostics
+	)
+	if strings.HasSuffix(varFile.Name, ".json") {
+		file, diags = hcljson.Parse(varFile.Content, varFile.Name)
+	} else {
+		file, diags = hclsyntax.ParseConfig(varFile.Content, varFile.Name, hcl.InitialPos)
+	}
+	if diags.HasErrors() {
+		return fmt.Errorf("%w: %s: %s", ErrInvalidVariables, varFile.Name, diags.Error())
+	}
+
+	attrs, diags := file.Body.JustAttributes()
+	if diags.HasErrors() {
+		return fmt.Errorf("%w: %s: %s", ErrInvalidVariables, varFile.Name, diags.Error())
+	}
+
+	for name, attr := range attrs {
+		value, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() {
+			return fmt.Errorf("%w: %s: %s", ErrInvalidVariables, varFile.Name, diags.Error())
+		}
+		v.values[name] = value
+	}
+	return nil
+}
+
+// AddAssignment merges a "name=value" form field. As with nomad's -var flag the value is a
+// plain string unless the job declares a non-string type, in which case it is read as HCL.
+func (v *Variables) AddAssignment(assignment string) error {
+	name, raw, ok := strings.Cut(assignment, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("%w: %q is not in name=value form", ErrInvalidVariables, assignment)
+	}
+
+	if declaration := v.declared[name]; declaration.Type == "" || declaration.Type == "string" {
+		v.values[name] = cty.StringVal(raw)
+		return nil
+	}
+
+	expr, diags := hclsyntax.ParseExpression([]byte(raw), name, hcl.InitialPos)
+	if diags.HasErrors() {
+		return fmt.Errorf("%w: %s: %s", ErrInvalidVariables, name, diags.Error())
+	}
+	value, diags := expr.Value(nil)
+	if diags.HasErrors() {
+		return fmt.Errorf("%w: %s: %s", ErrInvalidVariables, name, diags.Error())
+	}
+	v.values[name] = value
+	return nil
+}
+
+// AddJSON merges a value given as JSON, as the JSON deploy endpoint receives them
+func (v *Variables) AddJSON(name string, raw json.RawMessage) error {
+	if name == "" {
+		return fmt.Errorf("%w: variable name is empty", ErrInvalidVariables)
+	}
+
+	valueType, err := ctyjson.ImpliedType(raw)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidVariables, name, err)
+	}
+	value, err := ctyjson.Unmarshal(raw, valueType)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidVariables, name, err)
+	}
+	v.values[name] = value
+	return nil
+}
+
+// Len returns the number of variables set
+func (v *Variables) Len() int {
+	return len(v.values)
+}
+
+// Names returns the names of the variables set, sorted
+func (v *Variables) Names() []string {
+	names := make([]string, 0, len(v.values))
+	for name := range v.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HCL renders the merged values as a single var file, the form Nomad's parse API accepts
+func (v *Variables) HCL() string {
+	if len(v.values) == 0 {
+		return ""
+	}
+
+	file := hclwrite.NewEmptyFile()
+	for _, name := range v.Names() {
+		file.Body().SetAttributeValue(name, v.values[name])
+	}
+	return string(file.Bytes())
+}
+
+// Redacted returns the values as JSON with those of sensitive variables replaced,
+// suitable for storing alongside the deployment
+func (v *Variables) Redacted() map[string]json.RawMessage {
+	recorded := make(map[string]json.RawMessage, len(v.values))
+	for name, value := range v.values {
+		if v.declared[name].Sensitive {
+			recorded[name], _ = json.Marshal(RedactedValue)
+			continue
+		}
+
+		encoded, err := ctyjson.Marshal(value, value.Type())
+		if err != nil {
+			recorded[name], _ = json.Marshal(value.GoString())
+			continue
+		}
+		recorded[name] = encoded
+	}
+	return recorded
+}
@@ -0,0 +1,278 @@
+package locks
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/logger"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Manager serialises the deploys of a service. Deploys, pipeline stages, rollbacks and job operations
+// of the same service, cluster and namespace are submitted one at a time, and the service's lock
+// policy decides what happens to a deploy while an earlier deployment is still rolling out. Queued
+// deploys live in the database and are submitted once the deployment holding the lock has finished.
+type Manager struct {
+	db       *sql.DB
+	clusters *nomad.Clusters
+	config   *config.Config
+	logger   *logrus.Entry
+
+	mu   sync.Mutex
+	keys map[string]*sync.Mutex
+}
+
+// Decision is what a service's lock policy makes of a deploy
+type Decision int
+
+const (
+	// Submit lets the deploy go ahead
+	Submit Decision = iota
+	// Queue records the deploy to wait for the lock
+	Queue
+	// Refuse turns the deploy down
+	Refuse
+)
+
+func New(db *sql.DB, clusters *nomad.Clusters, cfg *config.Config) *Manager {
+	return &Manager{
+		db:       db,
+		clusters: clusters,
+		config:   cfg,
+		logger:   logger.WithModule("locks"),
+		keys:     make(map[string]*sync.Mutex),
+	}
+}
+
+// Service is the service a deployment locks: its registered name, or the job a job file declared
+func Service(deployment *models.Deployment) string {
+	if deployment.ServiceName != "" {
+		return deployment.ServiceName
+	}
+	return deployment.NomadJobID
+}
+
+func lockKey(deployment *models.Deployment) string {
+	return deployment.Cluster + "/" + deployment.Namespace + "/" + Service(deployment)
+}
+
+// Lock takes the lock of a deployment's service in its cluster and namespace. It is held while a
+// deploy is checked against the deployments already rolling out and submitted.
+func (m *Manager) Lock(deployment *models.Deployment) (unlock func()) {
+	key := lockKey(deployment)
+
+	m.mu.Lock()
+	mu, ok := m.keys[key]
+	if !ok {
+		mu = &sync.Mutex{}
+		m.keys[key] = mu
+	}
+	m.mu.Unlock()
+
+	mu.Lock()
+	return mu.Unlock
+}
+
+// Policy returns the lock policy of a deployment's service
+func (m *Manager) Policy(deployment *models.Deployment) string {
+	if m.config == nil {
+		return config.LockNone
+	}
+	return m.config.LockPolicy(Service(deployment))
+}
+
+// State reports the deployment holding the lock of a deployment's service and the deploys queued
+// behind it
+func (m *Manager) State(deployment *models.Deployment) (*models.ServiceLock, error) {
+	service := Service(deployment)
+	deployments, err := database.ListServiceDeployments(m.db, service, deployment.Cluster, deployment.Namespace, "")
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &models.ServiceLock{
+		Service:   service,
+		Cluster:   deployment.Cluster,
+		Namespace: deployment.Namespace,
+		Policy:    m.Policy(deployment),
+	}
+	for _, locked := range deployments {
+		switch {
+		case locked.Status == models.StatusQueued:
+			lock.Queue = append(lock.Queue, locked.TagID)
+		case lock.Holder == "":
+			lock.Holder = locked.TagID
+		}
+	}
+	return lock, nil
+}
+
+// Check applies the lock policy of a deployment's service to the deployments still rolling out.
+// Deploys that cannot wait are refused where the policy would queue them. The caller holds the
+// lock; the state is nil when the service has no lock policy.
+func (m *Manager) Check(deployment *models.Deployment, queueable bool) (Decision, *models.ServiceLock, error) {
+	policy := m.Policy(deployment)
+	if policy == config.LockNone {
+		return Submit, nil, nil
+	}
+
+	lock, err := m.State(deployment)
+	if err != nil {
+		return Refuse, nil, err
+	}
+	switch {
+	case lock.Holder == "" && len(lock.Queue) == 0, policy == config.LockSupersede:
+		return Submit, lock, nil
+	case policy == config.LockQueue && queueable:
+		return Queue, lock, nil
+	}
+	return Refuse, lock, nil
+}
+
+// Supersede marks the unfinished deployments of a deployment's service superseded by it once it
+// was submitted, when the service's policy is supersede. The queued ones are never submitted. It
+// returns their tags; the caller holds the lock.
+func (m *Manager) Supersede(deployment *models.Deployment) ([]string, error) {
+	if m.Policy(deployment) != config.LockSupersede {
+		return nil, nil
+	}
+
+	deployments, err := database.ListServiceDeployments(m.db, Service(deployment), deployment.Cluster, deployment.Namespace, deployment.TagID)
+	if err != nil {
+		return nil, err
+	}
+
+	var superseded []string
+	for _, older := range deployments {
+		if err := database.MarkDeploymentSuperseded(m.db, older.TagID, deployment.TagID); err != nil {
+			return superseded, err
+		}
+		m.finishOperations(&older, models.OperationFailed, fmt.Sprintf("Superseded by deployment %s", deployment.TagID))
+		superseded = append(superseded, older.TagID)
+	}
+	return superseded, nil
+}
+
+// Advance submits the oldest deploy queued for a deployment's service once no deployment holds
+// its lock any more
+func (m *Manager) Advance(deployment *models.Deployment) error {
+	unlock := m.Lock(deployment)
+	defer unlock()
+
+	tried := make(map[string]bool)
+	for {
+		deployments, err := database.ListServiceDeployments(m.db, Service(deployment), deployment.Cluster, deployment.Namespace, "")
+		if err != nil {
+			return err
+		}
+
+		var next *models.Deployment
+		for i := range deployments {
+			if deployments[i].Status != models.StatusQueued {
+				// Still held
+				return nil
+			}
+			if next == nil {
+				next = &deployments[i]
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		if tried[next.TagID] {
+			return fmt.Errorf("queued deployment %s could not be recorded as failed", next.TagID)
+		}
+		tried[next.TagID] = true
+
+		// A deploy Nomad refuses is recorded as failed, the one queued behind it goes next
+		if err := m.submit(next); err != nil {
+			m.logger.WithError(err).WithField("tag_id", next.TagID).Warn("Failed to submit queued deployment")
+			continue
+		}
+		return nil
+	}
+}
+
+// AdvanceAll submits the next queued deploy of every service whose lock is free. It runs after
+// the reconciler has refreshed the deployments holding the locks.
+func (m *Manager) AdvanceAll(ctx context.Context) {
+	queued, err := database.ListQueuedDeployments(m.db)
+	if err != nil {
+		m.logger.WithError(err).Error("Failed to list queued deployments")
+		return
+	}
+
+	seen := make(map[string]bool)
+	for i := range queued {
+		if ctx.Err() != nil {
+			return
+		}
+		key := lockKey(&queued[i])
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if err := m.Advance(&queued[i]); err != nil {
+			m.logger.WithError(err).WithField("tag_id", queued[i].TagID).Warn("Failed to advance deploy queue")
+		}
+	}
+}
+
+// submit redeploys a queued deployment with the image overrides it was requested with
+func (m *Manager) submit(deployment *models.Deployment) error {
+	client, err := m.clusters.Client(deployment.Cluster)
+	if err != nil {
+		m.fail(deployment, err)
+		return err
+	}
+
+	evalID, _, err := client.TriggerDeployment(deployment.ServiceName, deployment.TagID, nomad.DeployOptions{
+		Image:     deployment.Image,
+		Images:    deployment.Images,
+		Namespace: deployment.Namespace,
+		Region:    deployment.Region,
+	})
+	if err != nil {
+		m.fail(deployment, err)
+		return err
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"tag_id":       deployment.TagID,
+		"service_name": deployment.ServiceName,
+		"cluster":      deployment.Cluster,
+		"job_id":       evalID,
+	}).Info("Queued deployment submitted")
+
+	if err := database.UpdateDeploymentJobID(m.db, deployment.TagID, evalID, models.StatusRunning); err != nil {
+		// Nomad has the deploy, it must not be submitted again
+		m.logger.WithError(err).WithField("tag_id", deployment.TagID).Error("Failed to update job ID in database")
+	}
+	m.finishOperations(deployment, models.OperationSucceeded, "")
+	return nil
+}
+
+func (m *Manager) fail(deployment *models.Deployment, err error) {
+	if err := database.UpdateDeploymentStatus(m.db, deployment.TagID, models.StatusFailed); err != nil {
+		m.logger.WithError(err).WithField("tag_id", deployment.TagID).Error("Failed to update deployment status")
+	}
+	m.finishOperations(deployment, models.OperationFailed, err.Error())
+}
+
+// finishOperations records the outcome of the async deploys that waited for a queued deployment
+func (m *Manager) finishOperations(deployment *models.Deployment, status, message string) {
+	if deployment.Status != models.StatusQueued {
+		return
+	}
+	if err := database.FinishWaitingOperations(m.db, deployment.TagID, status, message); err != nil {
+		m.logger.WithError(err).WithField("tag_id", deployment.TagID).Error("Failed to finish waiting operations")
+	}
+}
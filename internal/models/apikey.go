@@ -0,0 +1,60 @@
+package models
+
+import "time"
+
+// API key scopes. Admin grants every other scope.
+const (
+	ScopeDeploy   = "deploy"
+	ScopeStatus   = "status"
+	ScopeRollback = "rollback"
+	// ScopeOperate allows stopping, scaling and restarting jobs
+	ScopeOperate = "operate"
+	// ScopeDispatch allows dispatching parameterized jobs and forcing periodic runs
+	ScopeDispatch = "dispatch"
+	ScopeAdmin    = "admin"
+)
+
+// Scopes lists every scope an API key can hold
+var Scopes = []string{ScopeDeploy, ScopeStatus, ScopeRollback, ScopeOperate, ScopeDispatch, ScopeAdmin}
+
+// IsValidScope reports whether scope is a known API key scope
+func IsValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey is a client credential. Only a hash of the key itself is stored.
+type APIKey struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Owner   string   `json:"owner"`
+	KeyHash string   `json:"-"`
+	Scopes  []string `json:"scopes"`
+	// Services limits the key to these service names or glob patterns, empty allows every service
+	Services   []string   `json:"services,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// CreateAPIKeyRequest creates an API key. ExpiresIn is a duration such as "720h" and
+// takes precedence over ExpiresAt; with neither the key does not expire.
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Owner     string     `json:"owner"`
+	Scopes    []string   `json:"scopes"`
+	Services  []string   `json:"services,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	ExpiresIn string     `json:"expires_in,omitempty"`
+}
+
+// CreateAPIKeyResponse returns a new API key. Key is only ever shown here.
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
@@ -1,32 +1,349 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
+
+// Deployment statuses stored in the deployments table
+const (
+	StatusPending    = "pending"
+	StatusRunning    = "running"
+	StatusCompleted  = "completed"
+	StatusSuccessful = "successful"
+	StatusFailed     = "failed"
+	StatusCancelled  = "cancelled"
+	StatusRolledBack = "rolled_back"
+
+	// StatusAwaitingPromotion means the canaries are healthy and the rollout waits for promotion
+	StatusAwaitingPromotion = "awaiting_promotion"
+
+	// StatusQueued means the deploy waits for an earlier deployment of the service to finish
+	StatusQueued = "queued"
+	// StatusSuperseded means a newer deploy of the service replaced the deployment before it finished
+	StatusSuperseded = "superseded"
+)
+
+// TerminalStatuses lists the statuses a deployment never leaves
+var TerminalStatuses = []string{
+	StatusCompleted,
+	StatusSuccessful,
+	StatusFailed,
+	StatusCancelled,
+	StatusRolledBack,
+	StatusSuperseded,
+}
+
+// Deployment actions recorded in the deployments table
+const (
+	ActionDeploy   = "deploy"
+	ActionRollback = "rollback"
+	ActionStop     = "stop"
+	ActionPurge    = "purge"
+	ActionScale    = "scale"
+	ActionRestart  = "restart"
+	ActionDispatch = "dispatch"
+
+	// ActionPeriodicForce is a periodic job launched ahead of its schedule
+	ActionPeriodicForce = "periodic_force"
+)
+
+// IsJobRun reports whether an action started a child job of a parameterized or periodic job
+func IsJobRun(action string) bool {
+	return action == ActionDispatch || action == ActionPeriodicForce
+}
+
+// IsTerminalStatus reports whether a deployment status will no longer change
+func IsTerminalStatus(status string) bool {
+	for _, terminal := range TerminalStatuses {
+		if status == terminal {
+			return true
+		}
+	}
+	return false
+}
 
 type DeploymentRequest struct {
 	ServiceName string `json:"service_name"`
 	TagID       string `json:"tag_id"` // Support tag_id format
+	// Image replaces the image of every docker task running the same repository
+	Image string `json:"image,omitempty"`
+	// Images replaces the image of named tasks, keyed by "task" or "group/task"
+	Images map[string]string `json:"images,omitempty"`
+	// Namespace and Region locate the job when the service's config leaves them open
+	Namespace string `json:"namespace,omitempty"`
+	Region    string `json:"region,omitempty"`
+	// Cluster picks a named Nomad cluster, the default one when empty
+	Cluster string `json:"cluster,omitempty"`
+	// Clusters deploys the same tag to each named cluster, tracked as one deployment
+	Clusters []string `json:"clusters,omitempty"`
+}
+
+// JobDeploymentRequest is the JSON form of a job file deployment. Var files are merged in
+// order, then Variables, which take JSON values of the variable's type.
+type JobDeploymentRequest struct {
+	TagID string `json:"tag_id"`
+	// ServiceName deploys the file as a registered service, the job ID it declares when empty
+	ServiceName string                     `json:"service_name,omitempty"`
+	JobFile     string                     `json:"job_file"`
+	Variables   map[string]json.RawMessage `json:"variables,omitempty"`
+	VarFiles    []string                   `json:"var_files,omitempty"`
+	// Namespace and Region place the job when the service's config leaves them open
+	Namespace string `json:"namespace,omitempty"`
+	Region    string `json:"region,omitempty"`
+	// Cluster picks a named Nomad cluster, the default one when empty
+	Cluster string `json:"cluster,omitempty"`
 }
 
 type DeploymentResponse struct {
-	Status  string `json:"status"`
-	TagID   string `json:"tag_id"`
-	JobID   string `json:"job_id,omitempty"`
-	Message string `json:"message,omitempty"`
+	Status    string `json:"status"`
+	TagID     string `json:"tag_id"`
+	JobID     string `json:"job_id,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Region    string `json:"region,omitempty"`
+	Cluster   string `json:"cluster,omitempty"`
+	Message   string `json:"message,omitempty"`
+	// Clusters reports each cluster of a fan-out deploy
+	Clusters []ClusterDeployment `json:"clusters,omitempty"`
+	// PromotedFrom is the deployment an environment promotion took its build from
+	PromotedFrom string `json:"promoted_from,omitempty"`
+	// OverwrittenMeta lists existing job Meta keys that Shipper replaced
+	OverwrittenMeta []string `json:"overwritten_meta,omitempty"`
+	// ImageChanges lists the task images rewritten by the request's image overrides
+	ImageChanges []ImageChange `json:"image_changes,omitempty"`
+	// PolicyWarnings lists violations of warn-only policy rules
+	PolicyWarnings []PolicyViolation `json:"policy_warnings,omitempty"`
+	// Lock is the deploy lock a queued deploy waits for
+	Lock *ServiceLock `json:"lock,omitempty"`
+}
+
+// ClusterDeployment is the part of a fan-out deploy submitted to one cluster
+type ClusterDeployment struct {
+	Cluster      string `json:"cluster"`
+	Status       string `json:"status"`
+	JobID        string `json:"job_id,omitempty"`
+	DeploymentID string `json:"deployment_id,omitempty"`
+	Namespace    string `json:"namespace,omitempty"`
+	Region       string `json:"region,omitempty"`
+	Message      string `json:"message,omitempty"`
+}
+
+// FanOutStatus combines the statuses of the clusters of a fan-out deploy. It is running until
+// every cluster has finished, then failed if any cluster did not succeed.
+func FanOutStatus(clusters []ClusterDeployment) string {
+	status := StatusCompleted
+	for _, cluster := range clusters {
+		switch {
+		case !IsTerminalStatus(cluster.Status):
+			return StatusRunning
+		case cluster.Status == StatusSuccessful && status == StatusCompleted:
+			status = StatusSuccessful
+		case cluster.Status != StatusSuccessful && cluster.Status != StatusCompleted:
+			status = StatusFailed
+		}
+	}
+	return status
+}
+
+// ImageChange records a task image Shipper rewrote before submitting a job
+type ImageChange struct {
+	Group string `json:"group"`
+	Task  string `json:"task"`
+	From  string `json:"from"`
+	To    string `json:"to"`
 }
 
 type StatusResponse struct {
-	Status  string `json:"status"`
-	TagID   string `json:"tag_id"`
-	JobID   string `json:"job_id"`
-	Message string `json:"message,omitempty"`
+	Status          string                       `json:"status"`
+	TagID           string                       `json:"tag_id"`
+	JobID           string                       `json:"job_id"`
+	Namespace       string                       `json:"namespace,omitempty"`
+	Region          string                       `json:"region,omitempty"`
+	Cluster         string                       `json:"cluster,omitempty"`
+	Message         string                       `json:"message,omitempty"`
+	DeploymentID    string                       `json:"deployment_id,omitempty"`
+	TaskGroups      map[string]TaskGroupProgress `json:"task_groups,omitempty"`
+	Action          string                       `json:"action,omitempty"`
+	RollbackOf      string                       `json:"rollback_of,omitempty"`
+	RestoredVersion *uint64                      `json:"restored_version,omitempty"`
+	Variables       map[string]json.RawMessage   `json:"variables,omitempty"`
+	TriggeredBy     string                       `json:"triggered_by,omitempty"`
+	AuthClaims      map[string]string            `json:"auth_claims,omitempty"`
+	Reason          string                       `json:"reason,omitempty"`
+	// ChildJobID is the job a dispatch or periodic force started
+	ChildJobID string `json:"child_job_id,omitempty"`
+	// Tasks reports the exit state of a child job's tasks
+	Tasks []TaskExitState `json:"tasks,omitempty"`
+	// Clusters reports each cluster of a fan-out deploy
+	Clusters []ClusterDeployment `json:"clusters,omitempty"`
+	// PromotedFrom and PromotedTo link the deployments of a build across environments
+	PromotedFrom string   `json:"promoted_from,omitempty"`
+	PromotedTo   []string `json:"promoted_to,omitempty"`
+	// Pipeline is the tag of the release pipeline that started the deployment
+	Pipeline string `json:"pipeline,omitempty"`
+	// SupersededBy is the deploy that replaced the deployment before it finished
+	SupersededBy string `json:"superseded_by,omitempty"`
+	// Lock is the deploy lock of the service while the deployment holds it or waits for it
+	Lock *ServiceLock `json:"lock,omitempty"`
+}
+
+// RollbackRequest reverts a service to an earlier job version. Either the service
+// name or the tag_id of the deployment to return to must be given; with only a
+// service name the previous stable version is restored.
+type RollbackRequest struct {
+	ServiceName string `json:"service_name,omitempty"`
+	TagID       string `json:"tag_id,omitempty"`
+	// Namespace and Region locate the job, by default those of the service or of the tag's deployment
+	Namespace string `json:"namespace,omitempty"`
+	Region    string `json:"region,omitempty"`
+	// Cluster the job runs in, by default the cluster of the tag's deployment
+	Cluster string `json:"cluster,omitempty"`
+	// TriggeredBy is recorded on the rollback row, it is set from the authenticated caller
+	TriggeredBy string `json:"-"`
+}
+
+type RollbackResponse struct {
+	Status          string `json:"status"`
+	TagID           string `json:"tag_id"`
+	JobID           string `json:"job_id,omitempty"`
+	ServiceName     string `json:"service_name"`
+	RollbackOf      string `json:"rollback_of,omitempty"`
+	RestoredVersion uint64 `json:"restored_version"`
+	RestoredTagID   string `json:"restored_tag_id,omitempty"`
+	Message         string `json:"message,omitempty"`
+}
+
+// PromoteRequest optionally limits a canary promotion to some task groups
+type PromoteRequest struct {
+	Groups []string `json:"groups,omitempty"`
+}
+
+// EnvironmentPromotionRequest deploys the build of a successful deployment to another cluster
+type EnvironmentPromotionRequest struct {
+	// TagID is the deployment to promote, it must have succeeded
+	TagID string `json:"tag_id"`
+	// Cluster is the environment to promote to
+	Cluster string `json:"cluster"`
+	// TargetTagID names the new deployment, "<tag_id>@<cluster>" when empty
+	TargetTagID string `json:"target_tag_id,omitempty"`
+	// Namespace and Region locate the job when the service's config leaves them open
+	Namespace string `json:"namespace,omitempty"`
+	Region    string `json:"region,omitempty"`
+}
+
+// DeploymentActionResponse is returned after promoting or failing a deployment
+type DeploymentActionResponse struct {
+	Status       string `json:"status"`
+	TagID        string `json:"tag_id"`
+	DeploymentID string `json:"deployment_id"`
+	EvalID       string `json:"eval_id,omitempty"`
+	Message      string `json:"message,omitempty"`
+}
+
+// PlanResponse is returned by a dry-run deploy and describes what Nomad would change
+type PlanResponse struct {
+	DryRun            bool                       `json:"dry_run"`
+	TagID             string                     `json:"tag_id"`
+	JobID             string                     `json:"job_id"`
+	JobModifyIndex    uint64                     `json:"job_modify_index"`
+	Summary           PlanSummary                `json:"summary"`
+	TaskGroups        map[string]PlanGroupUpdate `json:"task_groups,omitempty"`
+	PlacementFailures map[string]json.RawMessage `json:"placement_failures,omitempty"`
+	Warnings          []string                   `json:"warnings,omitempty"`
+	OverwrittenMeta   []string                   `json:"overwritten_meta,omitempty"`
+	ImageChanges      []ImageChange              `json:"image_changes,omitempty"`
+	PolicyWarnings    []PolicyViolation          `json:"policy_warnings,omitempty"`
+	Diff              json.RawMessage            `json:"diff,omitempty"`
+}
+
+// PlanSummary totals the allocation changes of a plan across all task groups
+type PlanSummary struct {
+	Create      int `json:"create"`
+	Destroy     int `json:"destroy"`
+	InPlace     int `json:"in_place"`
+	Destructive int `json:"destructive"`
+	Canary      int `json:"canary"`
+}
+
+// PlanGroupUpdate lists the allocation changes a plan makes to a single task group
+type PlanGroupUpdate struct {
+	Place             int `json:"place"`
+	Stop              int `json:"stop"`
+	InPlaceUpdate     int `json:"in_place_update"`
+	DestructiveUpdate int `json:"destructive_update"`
+	Canary            int `json:"canary"`
+	Migrate           int `json:"migrate"`
+	Ignore            int `json:"ignore"`
+	Preemptions       int `json:"preemptions"`
+}
+
+// TaskGroupProgress reports rollout progress for a single task group
+type TaskGroupProgress struct {
+	DesiredTotal    int  `json:"desired_total"`
+	PlacedAllocs    int  `json:"placed_allocs"`
+	HealthyAllocs   int  `json:"healthy_allocs"`
+	UnhealthyAllocs int  `json:"unhealthy_allocs"`
+	DesiredCanaries int  `json:"desired_canaries,omitempty"`
+	PlacedCanaries  int  `json:"placed_canaries,omitempty"`
+	Promoted        bool `json:"promoted,omitempty"`
+}
+
+// DeploymentHealth is the rollout state of a submitted job as seen by Nomad
+type DeploymentHealth struct {
+	Status       string
+	Description  string
+	DeploymentID string
+	NomadJobID   string
+	JobVersion   uint64
+	TaskGroups   map[string]TaskGroupProgress
+	// Tasks is the state of the tasks of a dispatched or periodic child job
+	Tasks []TaskExitState
+	// Clusters is the state of each cluster of a fan-out deploy
+	Clusters []ClusterDeployment
 }
 
 type Deployment struct {
-	ID          int       `json:"id"`
-	TagID       string    `json:"tag_id"`
-	ServiceName string    `json:"service_name"`
-	JobID       string    `json:"job_id"`
-	Status      string    `json:"status"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID           int    `json:"id"`
+	TagID        string `json:"tag_id"`
+	ServiceName  string `json:"service_name"`
+	JobID        string `json:"job_id"`
+	Status       string `json:"status"`
+	DeploymentID string `json:"deployment_id,omitempty"`
+	NomadJobID   string `json:"nomad_job_id,omitempty"`
+	// Namespace and Region are where the job was submitted, the token's defaults when empty
+	Namespace string `json:"namespace,omitempty"`
+	Region    string `json:"region,omitempty"`
+	// Cluster is the named Nomad cluster the job was submitted to, the default one when empty
+	Cluster    string `json:"cluster,omitempty"`
+	JobVersion uint64 `json:"job_version,omitempty"`
+	Action     string `json:"action,omitempty"`
+	// RollbackOf is the tag of the deployment a rollback replaced
+	RollbackOf string `json:"rollback_of,omitempty"`
+	// RestoredVersion is the job version a rollback returned to
+	RestoredVersion *uint64 `json:"restored_version,omitempty"`
+	// Variables are the job file variables the deployment was submitted with, sensitive values redacted
+	Variables map[string]json.RawMessage `json:"variables,omitempty"`
+	// TriggeredBy identifies the credential that started the deployment
+	TriggeredBy string `json:"triggered_by,omitempty"`
+	// AuthClaims are the OIDC token claims of the CI run that started the deployment
+	AuthClaims map[string]string `json:"auth_claims,omitempty"`
+	// Reason is why a stop, scale or restart was performed
+	Reason string `json:"reason,omitempty"`
+	// Tasks is the last known state of the tasks of a dispatched or periodic child job
+	Tasks []TaskExitState `json:"tasks,omitempty"`
+	// Clusters is the state of each cluster of a fan-out deploy, which has no single job
+	Clusters []ClusterDeployment `json:"clusters,omitempty"`
+	// Image and Images are the image overrides the service was deployed with
+	Image  string            `json:"image,omitempty"`
+	Images map[string]string `json:"images,omitempty"`
+	// JobFile is set for deployments of an uploaded job file
+	JobFile bool `json:"job_file,omitempty"`
+	// PromotedFrom is the deployment an environment promotion took its build from
+	PromotedFrom string `json:"promoted_from,omitempty"`
+	// Pipeline is the tag of the release pipeline that started the deployment
+	Pipeline string `json:"pipeline,omitempty"`
+	// SupersededBy is the deploy that replaced the deployment before it finished
+	SupersededBy string    `json:"superseded_by,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
@@ -172,3 +172,30 @@ func TestDeployment(t *testing.T) {
 		t.Errorf("Status = %v, want %v", unmarshaled.Status, deployment.Status)
 	}
 }
+
+func TestFanOutStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []string
+		expected string
+	}{
+		{"still rolling out", []string{StatusSuccessful, StatusRunning}, StatusRunning},
+		{"awaiting promotion", []string{StatusAwaitingPromotion, StatusFailed}, StatusRunning},
+		{"all successful", []string{StatusSuccessful, StatusSuccessful}, StatusSuccessful},
+		{"successful and completed", []string{StatusCompleted, StatusSuccessful}, StatusSuccessful},
+		{"one failed", []string{StatusSuccessful, StatusFailed}, StatusFailed},
+		{"one cancelled", []string{StatusCancelled, StatusSuccessful}, StatusFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clusters := make([]ClusterDeployment, len(tt.statuses))
+			for i, status := range tt.statuses {
+				clusters[i] = ClusterDeployment{Cluster: string(rune('a' + i)), Status: status}
+			}
+			if got := FanOutStatus(clusters); got != tt.expected {
+				t.Errorf("FanOutStatus(%v) = %v, want %v", tt.statuses, got, tt.expected)
+			}
+		})
+	}
+}
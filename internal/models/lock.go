@@ -0,0 +1,21 @@
+package models
+
+// ServiceLock is the deploy lock of a service in one cluster and namespace: the deployment
+// rolling it out and the deploys queued behind it, oldest first
+type ServiceLock struct {
+	Service   string   `json:"service"`
+	Cluster   string   `json:"cluster,omitempty"`
+	Namespace string   `json:"namespace,omitempty"`
+	Policy    string   `json:"policy"`
+	Holder    string   `json:"holder,omitempty"`
+	Queue     []string `json:"queue,omitempty"`
+}
+
+// Blocker is the deployment a deploy waits for or is refused by: the one holding the lock, or the
+// oldest queued deploy while no one submitted it yet
+func (l *ServiceLock) Blocker() string {
+	if l.Holder == "" && len(l.Queue) > 0 {
+		return l.Queue[0]
+	}
+	return l.Holder
+}
@@ -1,10 +1,142 @@
 package models
 
+import "encoding/json"
+
 type NomadJobResponse struct {
 	EvalID string `json:"EvalID"`
 	JobID  string `json:"JobID"`
 }
 
 type NomadEvalResponse struct {
+	ID                string `json:"ID"`
+	Status            string `json:"Status"`
+	StatusDescription string `json:"StatusDescription"`
+	JobID             string `json:"JobID"`
+	JobModifyIndex    uint64 `json:"JobModifyIndex"`
+	DeploymentID      string `json:"DeploymentID"`
+}
+
+// NomadDeployment is the subset of a Nomad deployment that Shipper tracks
+type NomadDeployment struct {
+	ID                string                          `json:"ID"`
+	JobID             string                          `json:"JobID"`
+	JobVersion        uint64                          `json:"JobVersion"`
+	JobModifyIndex    uint64                          `json:"JobModifyIndex"`
+	Status            string                          `json:"Status"`
+	StatusDescription string                          `json:"StatusDescription"`
+	TaskGroups        map[string]NomadDeploymentState `json:"TaskGroups"`
+}
+
+// NomadDeploymentState holds the rollout counters for a single task group
+type NomadDeploymentState struct {
+	AutoRevert      bool     `json:"AutoRevert"`
+	Promoted        bool     `json:"Promoted"`
+	DesiredCanaries int      `json:"DesiredCanaries"`
+	DesiredTotal    int      `json:"DesiredTotal"`
+	PlacedCanaries  []string `json:"PlacedCanaries"`
+	PlacedAllocs    int      `json:"PlacedAllocs"`
+	HealthyAllocs   int      `json:"HealthyAllocs"`
+	UnhealthyAllocs int      `json:"UnhealthyAllocs"`
+}
+
+// NomadEventBatch is a single line of the Nomad event stream
+type NomadEventBatch struct {
+	Index  uint64       `json:"Index"`
+	Events []NomadEvent `json:"Events"`
+}
+
+// NomadEvent is a single event from the Nomad event stream. Key holds the ID of the
+// object that changed and FilterKeys the IDs of related objects, such as the job ID
+// and deployment ID of an allocation.
+type NomadEvent struct {
+	Topic      string   `json:"Topic"`
+	Type       string   `json:"Type"`
+	Key        string   `json:"Key"`
+	Namespace  string   `json:"Namespace"`
+	FilterKeys []string `json:"FilterKeys"`
+	Index      uint64   `json:"Index"`
+}
+
+// NomadJobVersionsResponse is the response of /v1/job/{id}/versions
+type NomadJobVersionsResponse struct {
+	Versions []NomadJobVersion `json:"Versions"`
+}
+
+// NomadJobVersion is the subset of a historical job version Shipper needs to pick a rollback target
+type NomadJobVersion struct {
+	ID         string            `json:"ID"`
+	Version    uint64            `json:"Version"`
+	Stable     bool              `json:"Stable"`
+	SubmitTime int64             `json:"SubmitTime"`
+	Meta       map[string]string `json:"Meta"`
+}
+
+// NomadDeploymentUpdateResponse is returned by the deployment promote and fail endpoints
+type NomadDeploymentUpdateResponse struct {
+	EvalID             string  `json:"EvalID"`
+	RevertedJobVersion *uint64 `json:"RevertedJobVersion"`
+}
+
+// NomadPlanResponse is the response of /v1/job/{id}/plan
+type NomadPlanResponse struct {
+	JobModifyIndex uint64                     `json:"JobModifyIndex"`
+	Diff           json.RawMessage            `json:"Diff"`
+	FailedTGAllocs map[string]json.RawMessage `json:"FailedTGAllocs"`
+	Warnings       string                     `json:"Warnings"`
+	Annotations    *NomadPlanAnnotations      `json:"Annotations"`
+}
+
+// NomadPlanAnnotations holds the scheduler's summary of a plan
+type NomadPlanAnnotations struct {
+	DesiredTGUpdates map[string]NomadDesiredUpdates `json:"DesiredTGUpdates"`
+}
+
+// NomadDesiredUpdates counts the changes the scheduler would make to a task group
+type NomadDesiredUpdates struct {
+	Ignore            int `json:"Ignore"`
+	Place             int `json:"Place"`
+	Migrate           int `json:"Migrate"`
+	Stop              int `json:"Stop"`
+	InPlaceUpdate     int `json:"InPlaceUpdate"`
+	DestructiveUpdate int `json:"DestructiveUpdate"`
+	Canary            int `json:"Canary"`
+	Preemptions       int `json:"Preemptions"`
+}
+
+// NomadAllocationStub is the subset of an allocation listing Shipper reads
+type NomadAllocationStub struct {
+	ID            string `json:"ID"`
+	TaskGroup     string `json:"TaskGroup"`
+	ClientStatus  string `json:"ClientStatus"`
+	DesiredStatus string `json:"DesiredStatus"`
+	// NextAllocation is set when the allocation was replaced by a reschedule
+	NextAllocation string                    `json:"NextAllocation"`
+	TaskStates     map[string]NomadTaskState `json:"TaskStates"`
+}
+
+// NomadTaskState is the client side state of a task in an allocation
+type NomadTaskState struct {
+	State  string           `json:"State"`
+	Failed bool             `json:"Failed"`
+	Events []NomadTaskEvent `json:"Events"`
+}
+
+// NomadTaskEvent is one entry of a task's event history
+type NomadTaskEvent struct {
+	Type           string `json:"Type"`
+	ExitCode       int    `json:"ExitCode"`
+	DisplayMessage string `json:"DisplayMessage"`
+}
+
+// NomadJobSummary is the subset of a job Shipper reads to follow a child job
+type NomadJobSummary struct {
+	ID     string `json:"ID"`
 	Status string `json:"Status"`
+	Stop   bool   `json:"Stop"`
+}
+
+// NomadDispatchResponse is the response of /v1/job/{id}/dispatch
+type NomadDispatchResponse struct {
+	DispatchedJobID string `json:"DispatchedJobID"`
+	EvalID          string `json:"EvalID"`
 }
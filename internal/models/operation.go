@@ -0,0 +1,57 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Operation statuses of queued deploy requests
+const (
+	OperationQueued  = "queued"
+	OperationRunning = "running"
+	// OperationWaiting means the deploy lock queued the deployment, the operation finishes once
+	// the deployment is submitted or superseded
+	OperationWaiting   = "waiting"
+	OperationSucceeded = "succeeded"
+	OperationFailed    = "failed"
+)
+
+// Operation kinds, one per endpoint that can be queued
+const (
+	OperationDeploy    = "deploy"
+	OperationDeployJob = "deploy_job"
+)
+
+// Operation is a deploy request accepted with 202 and submitted to Nomad by the deploy queue
+type Operation struct {
+	ID     string `json:"id"`
+	Kind   string `json:"kind"`
+	Status string `json:"status"`
+	// TagID is the deployment the operation creates, known once it ran for multipart uploads
+	TagID string `json:"tag_id,omitempty"`
+	// HTTPStatus and Result are the response the endpoint would have returned synchronously
+	HTTPStatus int             `json:"http_status,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	// TriggeredBy identifies the credential that queued the operation
+	TriggeredBy string     `json:"triggered_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// QueueStats reports the deploy queue since the server started
+type QueueStats struct {
+	Workers   int   `json:"workers"`
+	Capacity  int   `json:"capacity"`
+	Depth     int   `json:"depth"`
+	InFlight  int64 `json:"in_flight"`
+	Completed int64 `json:"completed"`
+	Failed    int64 `json:"failed"`
+}
+
+// OperationsResponse lists recent operations together with the state of the deploy queue
+type OperationsResponse struct {
+	Queue      QueueStats  `json:"queue"`
+	Operations []Operation `json:"operations"`
+}
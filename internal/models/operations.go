@@ -0,0 +1,73 @@
+package models
+
+// StopJobRequest stops the job of a service. Purge also removes it from Nomad's job list.
+type StopJobRequest struct {
+	Purge  bool   `json:"purge,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// ScaleJobRequest sets the count of one task group
+type ScaleJobRequest struct {
+	Group  string `json:"group"`
+	Count  *int   `json:"count"`
+	Reason string `json:"reason"`
+}
+
+// RestartJobRequest restarts the running allocations of a job, or of one of its task groups
+type RestartJobRequest struct {
+	Group  string `json:"group,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// JobOperationResponse is returned after stopping, scaling or restarting a job. The operation
+// is recorded in deployment history under TagID.
+type JobOperationResponse struct {
+	Status      string `json:"status"`
+	TagID       string `json:"tag_id"`
+	Action      string `json:"action"`
+	ServiceName string `json:"service_name"`
+	EvalID      string `json:"eval_id,omitempty"`
+	Group       string `json:"group,omitempty"`
+	Count       *int   `json:"count,omitempty"`
+	// RestartedAllocs lists the allocations a restart restarted
+	RestartedAllocs []string `json:"restarted_allocs,omitempty"`
+	Reason          string   `json:"reason"`
+	Message         string   `json:"message,omitempty"`
+}
+
+// DispatchJobRequest dispatches a parameterized job. TagID is generated when empty.
+type DispatchJobRequest struct {
+	TagID string            `json:"tag_id,omitempty"`
+	Meta  map[string]string `json:"meta,omitempty"`
+	// Payload is the base64 encoded dispatch payload
+	Payload string `json:"payload,omitempty"`
+}
+
+// ForcePeriodicRequest launches a periodic job immediately. TagID is generated when empty.
+type ForcePeriodicRequest struct {
+	TagID string `json:"tag_id,omitempty"`
+}
+
+// JobRunResponse is returned after dispatching or forcing a job. The child job it started is
+// tracked through /status/{tag_id}.
+type JobRunResponse struct {
+	Status      string `json:"status"`
+	TagID       string `json:"tag_id"`
+	Action      string `json:"action"`
+	ServiceName string `json:"service_name"`
+	ChildJobID  string `json:"child_job_id"`
+	EvalID      string `json:"eval_id"`
+	Message     string `json:"message,omitempty"`
+}
+
+// TaskExitState is the state of one task of a dispatched or periodic child job
+type TaskExitState struct {
+	AllocID string `json:"alloc_id"`
+	Group   string `json:"group"`
+	Task    string `json:"task"`
+	State   string `json:"state"`
+	Failed  bool   `json:"failed"`
+	// ExitCode is set once the task has exited
+	ExitCode *int   `json:"exit_code,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
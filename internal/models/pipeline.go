@@ -0,0 +1,88 @@
+package models
+
+import "time"
+
+// PipelineRequest deploys services in ordered stages under one tag_id. A stage starts once every
+// deployment of the stage before it is healthy.
+type PipelineRequest struct {
+	TagID  string          `json:"tag_id"`
+	Stages []PipelineStage `json:"stages"`
+	// RollbackOnFailure rolls back the services the pipeline rolled out when a stage fails
+	RollbackOnFailure bool `json:"rollback_on_failure,omitempty"`
+	// Cluster picks a named Nomad cluster for every stage, the default one when empty
+	Cluster string `json:"cluster,omitempty"`
+}
+
+// PipelineStage deploys its services at the same time
+type PipelineStage struct {
+	Name     string   `json:"name,omitempty"`
+	Services []string `json:"services"`
+	// Image replaces the image of every docker task of the stage's services running the same repository
+	Image string `json:"image,omitempty"`
+}
+
+// Pipeline is a release pipeline; its deployments are rows of the deployments table linked by tag
+type Pipeline struct {
+	ID                int               `json:"id"`
+	TagID             string            `json:"tag_id"`
+	Status            string            `json:"status"`
+	Stages            []PipelineStage   `json:"stages"`
+	CurrentStage      int               `json:"current_stage"`
+	RollbackOnFailure bool              `json:"rollback_on_failure,omitempty"`
+	Cluster           string            `json:"cluster,omitempty"`
+	Message           string            `json:"message,omitempty"`
+	TriggeredBy       string            `json:"triggered_by,omitempty"`
+	AuthClaims        map[string]string `json:"auth_claims,omitempty"`
+	CreatedAt         time.Time         `json:"created_at"`
+	UpdatedAt         time.Time         `json:"updated_at"`
+}
+
+// PipelineResponse reports a pipeline and the deployments of each of its stages
+type PipelineResponse struct {
+	TagID        string                `json:"tag_id"`
+	Status       string                `json:"status"`
+	CurrentStage int                   `json:"current_stage"`
+	Cluster      string                `json:"cluster,omitempty"`
+	Message      string                `json:"message,omitempty"`
+	TriggeredBy  string                `json:"triggered_by,omitempty"`
+	Stages       []PipelineStageStatus `json:"stages"`
+}
+
+// PipelineStageStatus is the progress of one stage, pending until the stage is started
+type PipelineStageStatus struct {
+	Name        string               `json:"name,omitempty"`
+	Status      string               `json:"status"`
+	Deployments []PipelineDeployment `json:"deployments,omitempty"`
+}
+
+// PipelineDeployment is the deployment of one service of a stage
+type PipelineDeployment struct {
+	ServiceName string `json:"service_name"`
+	TagID       string `json:"tag_id"`
+	Status      string `json:"status"`
+}
+
+// StageStatus combines the statuses of the deployments of a stage. A stage fails as soon as one
+// deployment does, and succeeds once all of them have.
+func StageStatus(deployments []PipelineDeployment) string {
+	if len(deployments) == 0 {
+		return StatusPending
+	}
+
+	status, running := StatusCompleted, false
+	for _, deployment := range deployments {
+		switch {
+		case deployment.Status == StatusSuccessful:
+			status = StatusSuccessful
+		case deployment.Status == StatusCompleted:
+		case IsTerminalStatus(deployment.Status):
+			return StatusFailed
+		default:
+			running = true
+		}
+	}
+	if running {
+		return StatusRunning
+	}
+	return status
+}
@@ -0,0 +1,30 @@
+package models
+
+import "testing"
+
+func TestStageStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []string
+		expected string
+	}{
+		{"not started", nil, StatusPending},
+		{"still rolling out", []string{StatusCompleted, StatusRunning}, StatusRunning},
+		{"running after a success", []string{StatusRunning, StatusSuccessful}, StatusRunning},
+		{"fails without waiting", []string{StatusRunning, StatusFailed}, StatusFailed},
+		{"successful and completed", []string{StatusCompleted, StatusSuccessful}, StatusSuccessful},
+		{"batch jobs only", []string{StatusCompleted}, StatusCompleted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployments := make([]PipelineDeployment, len(tt.statuses))
+			for i, status := range tt.statuses {
+				deployments[i] = PipelineDeployment{ServiceName: string(rune('a' + i)), Status: status}
+			}
+			if got := StageStatus(deployments); got != tt.expected {
+				t.Errorf("StageStatus(%v) = %v, want %v", tt.statuses, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package models
+
+// Policy violation severities
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// PolicyViolation is a job file failing one organisational policy rule
+type PolicyViolation struct {
+	Rule     string `json:"rule"`
+	Type     string `json:"type"`
+	Severity string `json:"severity"`
+	Group    string `json:"group,omitempty"`
+	Task     string `json:"task,omitempty"`
+	Message  string `json:"message"`
+}
+
+// PolicyErrorResponse is returned with 422 when a job file violates a policy rule
+type PolicyErrorResponse struct {
+	Error      string            `json:"error"`
+	TagID      string            `json:"tag_id"`
+	JobID      string            `json:"job_id,omitempty"`
+	Violations []PolicyViolation `json:"violations"`
+}
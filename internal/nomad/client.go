@@ -8,6 +8,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"time"
 
 	"shipper-deployment/internal/logger"
@@ -17,22 +18,30 @@ import (
 )
 
 type Client struct {
-	URL    string
-	Token  string
-	client *http.Client
-	logger *logrus.Entry
+	URL   string
+	Token string
+	// MetaPrefix is prepended to the Meta keys Shipper sets on submitted jobs
+	MetaPrefix string
+	client     *http.Client
+	// streamClient has no overall timeout, for blocking queries and the event stream
+	streamClient *http.Client
+	logger       *logrus.Entry
 }
 
 func NewClient(url string, skipTLSVerify bool, token string) *Client {
+	return NewClientWithTLS(url, token, &tls.Config{
+		// #nosec G402 - InsecureSkipVerify is configurable for development environments
+		InsecureSkipVerify: skipTLSVerify,
+	})
+}
+
+// NewClientWithTLS creates a client for clusters that need their own CA or a client certificate
+func NewClientWithTLS(url, token string, tlsConfig *tls.Config) *Client {
 	// Get a logger instance with the nomad client module context
 	clientLogger := logger.WithModule("nomad-client")
 
-	// Create custom transport with TLS verification option
 	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			// #nosec G402 - InsecureSkipVerify is configurable for development environments
-			InsecureSkipVerify: skipTLSVerify,
-		},
+		TLSClientConfig: tlsConfig,
 	}
 
 	return &Client{
@@ -42,6 +51,9 @@ func NewClient(url string, skipTLSVerify bool, token string) *Client {
 			Timeout:   30 * time.Second,
 			Transport: transport,
 		},
+		streamClient: &http.Client{
+			Transport: transport,
+		},
 		logger: clientLogger,
 	}
 }
@@ -56,16 +68,42 @@ func (c *Client) GetHTTPClient() *http.Client {
 	return c.client
 }
 
-func (c *Client) TriggerDeployment(serviceName, tagID string) (string, error) {
-	// Use the existing client logger
+// TriggerDeployment redeploys an existing Nomad job with the given tag and returns the evaluation ID
+// together with the prepared job, which records the Meta keys and images Shipper changed
+func (c *Client) TriggerDeployment(serviceName, tagID string, opts DeployOptions) (string, *PreparedJob, error) {
 	c.logger.WithFields(logrus.Fields{
 		"service_name": serviceName,
 		"tag_id":       tagID,
 		"nomad_url":    c.URL,
-	}).Info("Starting deployment trigger111111111")
+	}).Info("Starting deployment trigger")
 
+	prepared, err := c.PrepareDeployment(serviceName, tagID, opts)
+	if err != nil {
+		return "", nil, err
+	}
+
+	evalID, err := c.SubmitPrepared(serviceName, tagID, prepared)
+	if err != nil {
+		return "", nil, err
+	}
+	return evalID, prepared, nil
+}
+
+// SubmitPrepared submits a job PrepareDeployment prepared and returns the evaluation ID
+func (c *Client) SubmitPrepared(serviceName, tagID string, prepared *PreparedJob) (string, error) {
+	c.logger.Info("Submitting updated job to Nomad")
+
+	return c.submitJob(prepared.Payload, logrus.Fields{
+		"service_name": serviceName,
+		"tag_id":       tagID,
+	})
+}
+
+// PrepareDeployment fetches the current definition of a job and applies the tag and image
+// overrides, returning the payload TriggerDeployment would submit
+func (c *Client) PrepareDeployment(serviceName, tagID string, opts DeployOptions) (*PreparedJob, error) {
 	// Fetch existing job definition from Nomad
-	getURL := fmt.Sprintf("%s/v1/job/%s", c.URL, serviceName)
+	getURL := fmt.Sprintf("%s/v1/job/%s%s", c.URL, serviceName, scopeQuery(opts.Namespace, opts.Region))
 
 	c.logger.WithFields(logrus.Fields{
 		"service_name": serviceName,
@@ -84,7 +122,7 @@ func (c *Client) TriggerDeployment(serviceName, tagID string) (string, error) {
 			"get_url":      getURL,
 			"error":        err.Error(),
 		}).Error("Failed to fetch job definition from Nomad")
-		return "", fmt.Errorf("failed to fetch job definition from Nomad: %v", err)
+		return nil, fmt.Errorf("failed to fetch job definition from Nomad: %v", err)
 	}
 	defer resp.Body.Close()
 
@@ -94,7 +132,7 @@ func (c *Client) TriggerDeployment(serviceName, tagID string) (string, error) {
 			"status_code":  resp.StatusCode,
 			"get_url":      getURL,
 		}).Error("Nomad returned non-200 status for job fetch")
-		return "", fmt.Errorf("failed to fetch job definition, Nomad returned status: %d", resp.StatusCode)
+		return nil, fmt.Errorf("failed to fetch job definition, Nomad returned status: %d", resp.StatusCode)
 	}
 
 	var jobSpec map[string]interface{}
@@ -103,7 +141,7 @@ func (c *Client) TriggerDeployment(serviceName, tagID string) (string, error) {
 			"service_name": serviceName,
 			"error":        err.Error(),
 		}).Error("Failed to decode job definition response")
-		return "", fmt.Errorf("failed to decode job definition response: %v", err)
+		return nil, fmt.Errorf("failed to decode job definition response: %v", err)
 	}
 
 	log.Print("Fetching existing job json definition from Nomad: ", jobSpec)
@@ -114,226 +152,278 @@ func (c *Client) TriggerDeployment(serviceName, tagID string) (string, error) {
 		"job_spec":     jobSpec,
 	}).Debug("Fetched job definition from Nomad")
 
-	// Create or update the Meta field
-	newMeta := map[string]interface{}{
-		"tag_id":     tagID,
-		"timestamp":  fmt.Sprintf("%d", time.Now().Unix()),
-		"updated_by": "shipper",
-	}
-	jobSpec["Meta"] = newMeta
-
-	// Create the job payload with the updated job definition
-	jobPayload := map[string]interface{}{
-		"Job": jobSpec,
-	}
-
-	// Convert to JSON
-	payloadBytes, _ := json.Marshal(jobPayload)
-
-	// Make HTTP request to Nomad
-	url := fmt.Sprintf("%s/v1/jobs", c.URL)
-
-	c.logger.Info("Submitting updated job to Nomad")
-
-	// Create POST request with token header
-	req, err = http.NewRequest("POST", url, bytes.NewBuffer(payloadBytes))
+	imageChanges, err := substituteImages(jobSpec, opts)
 	if err != nil {
 		c.logger.WithFields(logrus.Fields{
 			"service_name": serviceName,
-			"post_url":     url,
+			"tag_id":       tagID,
 			"error":        err.Error(),
-		}).Error("Failed to create POST request")
-		return "", fmt.Errorf("failed to create POST request: %v", err)
+		}).Error("Failed to apply image overrides")
+		return nil, err
 	}
-
-	// Set content type and add token header
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Add("X-Nomad-Token", c.Token)
-
-	resp, err = c.client.Do(req)
-	if err != nil {
+	for _, change := range imageChanges {
 		c.logger.WithFields(logrus.Fields{
 			"service_name": serviceName,
-			"post_url":     url,
-			"error":        err.Error(),
-		}).Error("Failed to submit job to Nomad")
-		return "", fmt.Errorf("failed to submit job to Nomad: %v", err)
+			"group":        change.Group,
+			"task":         change.Task,
+			"from":         change.From,
+			"to":           change.To,
+		}).Info("Substituting task image")
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		// Read the response body to get the actual error message
-		bodyBytes, err := io.ReadAll(resp.Body)
-		var bodyStr string
-		if err != nil {
-			bodyStr = "failed to read response body"
-		} else {
-			bodyStr = string(bodyBytes)
-		}
 
+	overwritten := c.applyShipperMeta(jobSpec, tagID)
+	if len(overwritten) > 0 {
 		c.logger.WithFields(logrus.Fields{
 			"service_name": serviceName,
 			"tag_id":       tagID,
-			"status_code":  resp.StatusCode,
-			"error_body":   bodyStr,
-		}).Error("Nomad returned non-200 status for job submission")
-		return "", fmt.Errorf("nomad returned status: %d with message: %s", resp.StatusCode, bodyStr)
+			"meta_keys":    overwritten,
+		}).Warn("Overwriting existing job Meta keys")
 	}
 
-	var nomadResp models.NomadJobResponse
-	if err := json.NewDecoder(resp.Body).Decode(&nomadResp); err != nil {
-		c.logger.Error("Failed to decode Nomad job submission response")
-		return "", fmt.Errorf("failed to decode Nomad response: %v", err)
-	}
+	// Create the job payload with the updated job definition
+	return &PreparedJob{
+		Payload: map[string]interface{}{
+			"Job": jobSpec,
+		},
+		OverwrittenMeta: overwritten,
+		ImageChanges:    imageChanges,
+	}, nil
+}
 
-	c.logger.WithFields(logrus.Fields{
-		"service_name": serviceName,
-		"tag_id":       tagID,
-		"eval_id":      nomadResp.EvalID,
-		"job_id":       nomadResp.JobID,
-	}).Info("Successfully triggered deployment")
+// GetJobStatus returns the mapped rollout status for the job submitted by the given evaluation
+func (c *Client) GetJobStatus(evalID string, scope JobScope) (string, error) {
+	health, err := c.GetDeploymentHealth(evalID, scope)
+	if err != nil {
+		return "", err
+	}
+	return health.Status, nil
+}
 
-	return nomadResp.EvalID, nil
+// GetDeploymentHealth follows an evaluation through to the Nomad deployment it produced
+// and reports the rollout state together with per task group health counts. The scope is the
+// namespace and region the job was submitted to.
+func (c *Client) GetDeploymentHealth(evalID string, scope JobScope) (*models.DeploymentHealth, error) {
+	health, _, err := c.GetDeploymentHealthWithOptions(evalID, scope, nil)
+	return health, err
 }
 
-func (c *Client) GetJobStatus(evalID string) (string, error) {
+// GetDeploymentHealthWithOptions is GetDeploymentHealth with support for blocking queries.
+// When opts carries a WaitIndex the call blocks on whichever object decides the next
+// state change: the evaluation while it is being scheduled, then the deployment.
+func (c *Client) GetDeploymentHealthWithOptions(evalID string, scope JobScope, opts *QueryOptions) (*models.DeploymentHealth, *QueryMeta, error) {
 	c.logger.WithFields(logrus.Fields{
 		"eval_id":   evalID,
 		"nomad_url": c.URL,
 	}).Info("Starting job status check")
 
-	url := fmt.Sprintf("%s/v1/evaluation/%s", c.URL, evalID)
+	evalPath := fmt.Sprintf("/v1/evaluation/%s%s", evalID, scope.query())
+
+	var evalResp models.NomadEvalResponse
+	meta, err := c.query(evalPath, nil, &evalResp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if evalResp.Status != "complete" && opts.blocking() {
+		// Wait for the scheduler to make progress before reporting
+		if meta, err = c.query(evalPath, opts, &evalResp); err != nil {
+			return nil, nil, err
+		}
+		opts = nil
+	}
 
 	c.logger.WithFields(logrus.Fields{
-		"eval_id":    evalID,
-		"status_url": url,
-		"timeout":    "30s",
-	}).Debug("Making request to get evaluation status")
+		"eval_id":       evalID,
+		"nomad_status":  evalResp.Status,
+		"deployment_id": evalResp.DeploymentID,
+	}).Debug("Successfully decoded Nomad evaluation response")
 
-	// Create request with token header
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		c.logger.WithFields(logrus.Fields{
-			"eval_id":    evalID,
-			"status_url": url,
-			"error":      err.Error(),
-		}).Error("Failed to create GET request")
-		return "", fmt.Errorf("failed to create GET request: %v", err)
+	health := &models.DeploymentHealth{
+		NomadJobID:  evalResp.JobID,
+		Description: evalResp.StatusDescription,
 	}
 
-	// Add token header if available
-	if c.Token != "" {
-		req.Header.Add("X-Nomad-Token", c.Token)
+	switch evalResp.Status {
+	case "failed":
+		health.Status = models.StatusFailed
+		return health, meta, nil
+	case "canceled", "cancelled":
+		health.Status = models.StatusCancelled
+		return health, meta, nil
+	case "complete":
+		// The scheduler has placed the job, the deployment decides the outcome
+	default:
+		health.Status = models.StatusRunning
+		return health, meta, nil
 	}
 
-	resp, err := c.client.Do(req)
+	deployment, deploymentMeta, err := c.findDeployment(&evalResp, scope, opts)
 	if err != nil {
-		c.logger.WithFields(logrus.Fields{
-			"eval_id":    evalID,
-			"status_url": url,
-			"error":      err.Error(),
-		}).Error("Failed to get job status from Nomad")
-		return "", fmt.Errorf("failed to get job status from Nomad: %v", err)
+		return nil, nil, err
+	}
+	if deploymentMeta != nil {
+		meta = deploymentMeta
+	}
+
+	if deployment == nil {
+		// Batch jobs and no-op updates do not create a deployment
+		health.Status = models.StatusCompleted
+		return health, meta, nil
+	}
+
+	health.Status = mapDeploymentStatus(deployment)
+	health.Description = deployment.StatusDescription
+	health.DeploymentID = deployment.ID
+	health.JobVersion = deployment.JobVersion
+	health.TaskGroups = make(map[string]models.TaskGroupProgress, len(deployment.TaskGroups))
+	for name, state := range deployment.TaskGroups {
+		health.TaskGroups[name] = models.TaskGroupProgress{
+			DesiredTotal:    state.DesiredTotal,
+			PlacedAllocs:    state.PlacedAllocs,
+			HealthyAllocs:   state.HealthyAllocs,
+			UnhealthyAllocs: state.UnhealthyAllocs,
+			DesiredCanaries: state.DesiredCanaries,
+			PlacedCanaries:  len(state.PlacedCanaries),
+			Promoted:        state.Promoted,
+		}
 	}
-	defer resp.Body.Close()
 
 	c.logger.WithFields(logrus.Fields{
-		"eval_id":     evalID,
-		"status_code": resp.StatusCode,
-	}).Debug("Received response from Nomad status check")
+		"eval_id":           evalID,
+		"deployment_id":     deployment.ID,
+		"deployment_status": deployment.Status,
+		"mapped_status":     health.Status,
+	}).Info("Successfully mapped deployment status")
 
-	if resp.StatusCode != http.StatusOK {
-		// Read the response body to get the actual error message
-		bodyBytes, err := io.ReadAll(resp.Body)
-		var bodyStr string
+	return health, meta, nil
+}
+
+// findDeployment returns the Nomad deployment created for the job version the evaluation scheduled.
+// Evaluations created by job registration do not always carry a DeploymentID, so the job's
+// deployments are matched on JobModifyIndex instead.
+func (c *Client) findDeployment(eval *models.NomadEvalResponse, scope JobScope, opts *QueryOptions) (*models.NomadDeployment, *QueryMeta, error) {
+	if eval.DeploymentID != "" {
+		var deployment models.NomadDeployment
+		meta, err := c.query(fmt.Sprintf("/v1/deployment/%s%s", eval.DeploymentID, scope.query()), opts, &deployment)
 		if err != nil {
-			bodyStr = "failed to read response body"
-		} else {
-			bodyStr = string(bodyBytes)
+			return nil, nil, err
 		}
+		return &deployment, meta, nil
+	}
 
-		c.logger.WithFields(logrus.Fields{
-			"eval_id":     evalID,
-			"status_code": resp.StatusCode,
-			"status_url":  url,
-			"error_body":  bodyStr,
-		}).Error("Nomad returned non-200 status for evaluation status")
-		return "", fmt.Errorf("nomad returned status: %d with message: %s", resp.StatusCode, bodyStr)
+	if eval.JobID == "" {
+		return nil, nil, nil
 	}
 
-	var evalResp models.NomadEvalResponse
-	if err := json.NewDecoder(resp.Body).Decode(&evalResp); err != nil {
-		c.logger.WithFields(logrus.Fields{
-			"eval_id": evalID,
-			"error":   err.Error(),
-		}).Error("Failed to decode Nomad evaluation response")
-		return "", fmt.Errorf("failed to decode Nomad response: %v", err)
+	var deployments []models.NomadDeployment
+	meta, err := c.query(fmt.Sprintf("/v1/job/%s/deployments%s", url.PathEscape(eval.JobID), scope.query()), opts, &deployments)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	c.logger.WithFields(logrus.Fields{
-		"eval_id":      evalID,
-		"nomad_status": evalResp.Status,
-	}).Debug("Successfully decoded Nomad evaluation response")
+	for i := range deployments {
+		if deployments[i].JobModifyIndex == eval.JobModifyIndex {
+			return &deployments[i], meta, nil
+		}
+	}
+	return nil, meta, nil
+}
 
-	// Map Nomad status to our status
-	var mappedStatus string
-	switch evalResp.Status {
-	case "complete":
-		mappedStatus = "completed"
+// mapDeploymentStatus maps a Nomad deployment to a Shipper deployment status
+func mapDeploymentStatus(deployment *models.NomadDeployment) string {
+	switch deployment.Status {
+	case "successful":
+		return models.StatusSuccessful
 	case "failed":
-		mappedStatus = "failed"
-	case "pending":
-		mappedStatus = "running"
+		return models.StatusFailed
+	case "cancelled":
+		return models.StatusCancelled
+	case "running":
+		if needsPromotion(deployment) {
+			return models.StatusAwaitingPromotion
+		}
+		return models.StatusRunning
 	default:
-		mappedStatus = "running"
+		// pending, paused, blocked, unblocking and initializing are still in progress
+		return models.StatusRunning
 	}
+}
 
-	c.logger.WithFields(logrus.Fields{
-		"eval_id":       evalID,
-		"nomad_status":  evalResp.Status,
-		"mapped_status": mappedStatus,
-	}).Info("Successfully mapped job status")
+// needsPromotion reports whether a running deployment is blocked on canary promotion
+func needsPromotion(deployment *models.NomadDeployment) bool {
+	if deployment.StatusDescription == "Deployment is running but requires manual promotion" {
+		return true
+	}
 
-	return mappedStatus, nil
+	waiting := false
+	for _, state := range deployment.TaskGroups {
+		if state.DesiredCanaries == 0 || state.Promoted {
+			continue
+		}
+		if len(state.PlacedCanaries) < state.DesiredCanaries || state.HealthyAllocs < state.DesiredCanaries {
+			// Canaries are still being placed or becoming healthy
+			return false
+		}
+		waiting = true
+	}
+	return waiting
 }
 
-// SubmitJobFile submits a Nomad job file directly to Nomad
-func (c *Client) SubmitJobFile(jobJSON map[string]interface{}, tagID string) (string, error) {
+// SubmitJobFile submits a Nomad job file directly to Nomad and returns the evaluation ID
+// together with the prepared job, which records the Meta keys Shipper overwrote
+func (c *Client) SubmitJobFile(jobJSON map[string]interface{}, tagID string) (string, *PreparedJob, error) {
 	c.logger.WithFields(logrus.Fields{
 		"tag_id":    tagID,
 		"nomad_url": c.URL,
 	}).Info("Starting job file submission")
 
-	// Add metadata to the job
-	if job, ok := jobJSON["Job"].(map[string]interface{}); ok {
-		newMeta := map[string]interface{}{
-			"tag_id":     tagID,
-			"timestamp":  fmt.Sprintf("%d", time.Now().Unix()),
-			"updated_by": "shipper",
-		}
-		job["Meta"] = newMeta
+	prepared := c.PrepareJobFile(jobJSON, tagID)
+
+	evalID, err := c.submitJob(prepared.Payload, logrus.Fields{"tag_id": tagID})
+	if err != nil {
+		return "", nil, err
 	}
+	return evalID, prepared, nil
+}
+
+// PrepareJobFile merges Shipper's metadata into a parsed job file payload, as SubmitJobFile would
+func (c *Client) PrepareJobFile(jobJSON map[string]interface{}, tagID string) *PreparedJob {
+	prepared := &PreparedJob{Payload: jobJSON}
+
+	job, ok := jobJSON["Job"].(map[string]interface{})
+	if !ok {
+		return prepared
+	}
+
+	prepared.OverwrittenMeta = c.applyShipperMeta(job, tagID)
+	if len(prepared.OverwrittenMeta) > 0 {
+		c.logger.WithFields(logrus.Fields{
+			"tag_id":    tagID,
+			"meta_keys": prepared.OverwrittenMeta,
+		}).Warn("Overwriting existing job Meta keys")
+	}
+	return prepared
+}
 
+// submitJob registers a job payload with Nomad and returns the evaluation ID
+func (c *Client) submitJob(jobPayload map[string]interface{}, fields logrus.Fields) (string, error) {
 	// Convert to JSON
-	payloadBytes, err := json.Marshal(jobJSON)
+	payloadBytes, err := json.Marshal(jobPayload)
 	if err != nil {
-		c.logger.WithFields(logrus.Fields{
-			"tag_id": tagID,
-			"error":  err.Error(),
-		}).Error("Failed to marshal job JSON")
+		c.logger.WithFields(fields).WithError(err).Error("Failed to marshal job JSON")
 		return "", fmt.Errorf("failed to marshal job JSON: %v", err)
 	}
 
-	// Make HTTP request to Nomad
-	url := fmt.Sprintf("%s/v1/jobs", c.URL)
+	// Register the job in the namespace and region it declares
+	job, _ := jobPayload["Job"].(map[string]interface{})
 
-	c.logger.Info("Submitting job file to Nomad to url - ", url)
+	// Make HTTP request to Nomad
+	url := fmt.Sprintf("%s/v1/jobs%s", c.URL, declaredScope(job).query())
 
 	// Create POST request with token header
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payloadBytes))
 	if err != nil {
-		c.logger.WithFields(logrus.Fields{
-			"tag_id":   tagID,
+		c.logger.WithFields(fields).WithFields(logrus.Fields{
 			"post_url": url,
 			"error":    err.Error(),
 		}).Error("Failed to create POST request")
@@ -346,12 +436,11 @@ func (c *Client) SubmitJobFile(jobJSON map[string]interface{}, tagID string) (st
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		c.logger.WithFields(logrus.Fields{
-			"tag_id":   tagID,
+		c.logger.WithFields(fields).WithFields(logrus.Fields{
 			"post_url": url,
 			"error":    err.Error(),
-		}).Error("Failed to submit job file to Nomad")
-		return "", fmt.Errorf("failed to submit job file to Nomad: %v", err)
+		}).Error("Failed to submit job to Nomad")
+		return "", fmt.Errorf("failed to submit job to Nomad: %v", err)
 	}
 	defer resp.Body.Close()
 
@@ -365,28 +454,55 @@ func (c *Client) SubmitJobFile(jobJSON map[string]interface{}, tagID string) (st
 			bodyStr = string(bodyBytes)
 		}
 
-		c.logger.WithFields(logrus.Fields{
-			"tag_id":      tagID,
+		c.logger.WithFields(fields).WithFields(logrus.Fields{
 			"status_code": resp.StatusCode,
 			"error_body":  bodyStr,
-		}).Error("Nomad returned non-200 status for job file submission")
+		}).Error("Nomad returned non-200 status for job submission")
 		return "", fmt.Errorf("nomad returned status: %d with message: %s", resp.StatusCode, bodyStr)
 	}
 
 	var nomadResp models.NomadJobResponse
 	if err := json.NewDecoder(resp.Body).Decode(&nomadResp); err != nil {
-		c.logger.WithFields(logrus.Fields{
-			"tag_id": tagID,
-			"error":  err.Error(),
-		}).Error("Failed to decode Nomad job file submission response")
+		c.logger.WithFields(fields).WithError(err).Error("Failed to decode Nomad job submission response")
 		return "", fmt.Errorf("failed to decode Nomad response: %v", err)
 	}
 
-	c.logger.WithFields(logrus.Fields{
-		"tag_id":  tagID,
+	c.logger.WithFields(fields).WithFields(logrus.Fields{
 		"eval_id": nomadResp.EvalID,
 		"job_id":  nomadResp.JobID,
-	}).Info("Successfully submitted job file")
+	}).Info("Successfully submitted job")
 
 	return nomadResp.EvalID, nil
 }
+
+// declaredScope returns the namespace and region a job definition declares
+func declaredScope(job map[string]interface{}) JobScope {
+	namespace, _ := job["Namespace"].(string)
+	region, _ := job["Region"].(string)
+	return JobScope{Namespace: namespace, Region: region}
+}
+
+// scopeQuery returns the query string selecting a namespace and region, empty when neither is set
+func scopeQuery(namespace, region string) string {
+	return encodeQuery(scopeValues(namespace, region))
+}
+
+// scopeValues returns the query parameters selecting a namespace and region
+func scopeValues(namespace, region string) url.Values {
+	query := url.Values{}
+	if namespace != "" {
+		query.Set("namespace", namespace)
+	}
+	if region != "" {
+		query.Set("region", region)
+	}
+	return query
+}
+
+// encodeQuery returns query as a query string, empty when it has no parameters
+func encodeQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	return "?" + query.Encode()
+}
@@ -0,0 +1,50 @@
+package nomad
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrUnknownCluster is returned for a cluster name that is not configured
+var ErrUnknownCluster = errors.New("unknown cluster")
+
+// Clusters holds a client per Nomad cluster Shipper deploys to. The default cluster, NOMAD_URL,
+// has the empty name.
+type Clusters struct {
+	clients map[string]*Client
+}
+
+// NewClusters returns the clusters with only the default one
+func NewClusters(defaultClient *Client) *Clusters {
+	return &Clusters{clients: map[string]*Client{"": defaultClient}}
+}
+
+// Add registers the client of a named cluster
+func (c *Clusters) Add(name string, client *Client) {
+	c.clients[name] = client
+}
+
+// Default returns the client of the default cluster
+func (c *Clusters) Default() *Client {
+	return c.clients[""]
+}
+
+// Client returns the client of a cluster, the default one when name is empty
+func (c *Clusters) Client(name string) (*Client, error) {
+	client, ok := c.clients[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownCluster, name)
+	}
+	return client, nil
+}
+
+// Names returns the names of all clusters in order, starting with the default cluster's ""
+func (c *Clusters) Names() []string {
+	names := make([]string, 0, len(c.clients))
+	for name := range c.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
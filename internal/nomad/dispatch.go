@@ -0,0 +1,158 @@
+package nomad
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+
+	"shipper-deployment/internal/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DispatchJob dispatches a parameterized job and returns the ID of the child job it started
+// and of its evaluation
+func (c *Client) DispatchJob(jobID string, scope JobScope, meta map[string]string, payload []byte) (string, string, error) {
+	c.logger.WithFields(logrus.Fields{
+		"job_id":       jobID,
+		"meta_keys":    len(meta),
+		"payload_size": len(payload),
+	}).Info("Dispatching job in Nomad")
+
+	// Nomad expects the payload base64 encoded, which is how []byte is marshalled
+	request := map[string]interface{}{
+		"Meta":    meta,
+		"Payload": payload,
+	}
+
+	var resp models.NomadDispatchResponse
+	if err := c.write("POST", fmt.Sprintf("/v1/job/%s/dispatch%s", url.PathEscape(jobID), scope.query()), request, &resp); err != nil {
+		return "", "", fmt.Errorf("failed to dispatch job: %v", err)
+	}
+	return resp.DispatchedJobID, resp.EvalID, nil
+}
+
+// ForcePeriodicJob launches a periodic job immediately and returns the ID of the child job it
+// started and of its evaluation. Nomad only returns the evaluation, which names the child job.
+func (c *Client) ForcePeriodicJob(jobID string, scope JobScope) (string, string, error) {
+	c.logger.WithField("job_id", jobID).Info("Forcing periodic job in Nomad")
+
+	query := scope.query()
+
+	var resp models.NomadJobResponse
+	if err := c.write("POST", fmt.Sprintf("/v1/job/%s/periodic/force%s", url.PathEscape(jobID), query), nil, &resp); err != nil {
+		return "", "", fmt.Errorf("failed to force periodic job: %v", err)
+	}
+
+	var eval models.NomadEvalResponse
+	if err := c.getJSON(fmt.Sprintf("/v1/evaluation/%s%s", url.PathEscape(resp.EvalID), query), &eval); err != nil {
+		return "", resp.EvalID, fmt.Errorf("failed to read evaluation %s: %v", resp.EvalID, err)
+	}
+	return eval.JobID, resp.EvalID, nil
+}
+
+// GetJobRunHealthWithOptions reports the progress of a dispatched or periodic child job from its
+// allocations. The run completes when the job is dead, an allocation finished and none of its
+// final allocations failed.
+// When opts carries a WaitIndex the call blocks on the job's allocations.
+func (c *Client) GetJobRunHealthWithOptions(childJobID string, scope JobScope, opts *QueryOptions) (*models.DeploymentHealth, *QueryMeta, error) {
+	jobPath := fmt.Sprintf("/v1/job/%s", url.PathEscape(childJobID))
+	query := scope.query()
+
+	var allocs []models.NomadAllocationStub
+	meta, err := c.query(jobPath+"/allocations"+query, opts, &allocs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list allocations: %v", err)
+	}
+
+	var job models.NomadJobSummary
+	if err := c.getJSON(jobPath+query, &job); err != nil {
+		return nil, nil, fmt.Errorf("failed to read job: %v", err)
+	}
+
+	health := &models.DeploymentHealth{
+		NomadJobID: childJobID,
+		Tasks:      taskExitStates(allocs),
+	}
+
+	failed, finished := 0, 0
+	for _, alloc := range allocs {
+		switch alloc.ClientStatus {
+		case "complete":
+			finished++
+		case "failed", "lost":
+			finished++
+			// Rescheduled allocations are judged by their replacement
+			if alloc.NextAllocation == "" {
+				failed++
+			}
+		}
+	}
+
+	switch {
+	case job.Status != "dead":
+		health.Status = models.StatusRunning
+		health.Description = fmt.Sprintf("%d of %d allocations finished", finished, len(allocs))
+	case job.Stop:
+		health.Status = models.StatusCancelled
+		health.Description = "Job was stopped"
+	case failed > 0:
+		health.Status = models.StatusFailed
+		health.Description = fmt.Sprintf("%d allocations failed", failed)
+	case finished == 0:
+		// Nothing ran, for example when the allocations could not be placed or were garbage collected
+		health.Status = models.StatusFailed
+		health.Description = "Job finished without running any allocation"
+	default:
+		health.Status = models.StatusCompleted
+		health.Description = fmt.Sprintf("%d allocations completed", finished)
+	}
+
+	c.logger.WithFields(logrus.Fields{
+		"job_id":        childJobID,
+		"job_status":    job.Status,
+		"mapped_status": health.Status,
+	}).Debug("Mapped child job status")
+
+	return health, meta, nil
+}
+
+// taskExitStates lists the tasks of allocations ordered by group, task and allocation, with the
+// exit code of the last time each task terminated
+func taskExitStates(allocs []models.NomadAllocationStub) []models.TaskExitState {
+	var states []models.TaskExitState
+	for _, alloc := range allocs {
+		for name, task := range alloc.TaskStates {
+			state := models.TaskExitState{
+				AllocID: alloc.ID,
+				Group:   alloc.TaskGroup,
+				Task:    name,
+				State:   task.State,
+				Failed:  task.Failed,
+			}
+			for i := len(task.Events) - 1; i >= 0; i-- {
+				if task.Events[i].Type == "Terminated" {
+					exitCode := task.Events[i].ExitCode
+					state.ExitCode = &exitCode
+					break
+				}
+			}
+			if task.Failed && len(task.Events) > 0 {
+				state.Message = task.Events[len(task.Events)-1].DisplayMessage
+			}
+			states = append(states, state)
+		}
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		a, b := states[i], states[j]
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		if a.Task != b.Task {
+			return a.Task < b.Task
+		}
+		return a.AllocID < b.AllocID
+	})
+	return states
+}
@@ -0,0 +1,88 @@
+package nomad
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"shipper-deployment/internal/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DeploymentEventTopics are the event stream topics that affect deployment status
+var DeploymentEventTopics = []string{"Job", "Evaluation", "Deployment", "Allocation"}
+
+// StreamEvents subscribes to the Nomad event stream starting at index and calls fn for every
+// batch received. It returns when the context is cancelled or the stream breaks, together with
+// the index of the last batch delivered so the caller can resume without losing events.
+func (c *Client) StreamEvents(ctx context.Context, index uint64, topics []string, fn func(models.NomadEventBatch)) (uint64, error) {
+	params := url.Values{}
+	params.Set("index", strconv.FormatUint(index, 10))
+	// Watch every namespace, deployments are matched to events by namespace as well as ID
+	params.Set("namespace", "*")
+	for _, topic := range topics {
+		params.Add("topic", topic+":*")
+	}
+	requestURL := fmt.Sprintf("%s/v1/event/stream?%s", c.URL, params.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, "GET", requestURL, nil)
+	if err != nil {
+		return index, fmt.Errorf("failed to create event stream request: %v", err)
+	}
+	if c.Token != "" {
+		req.Header.Add("X-Nomad-Token", c.Token)
+	}
+
+	resp, err := c.streamClient.Do(req)
+	if err != nil {
+		return index, fmt.Errorf("failed to connect to Nomad event stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return index, fmt.Errorf("nomad returned status: %d with message: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	c.logger.WithFields(logrus.Fields{
+		"index":  index,
+		"topics": topics,
+	}).Info("Connected to Nomad event stream")
+
+	lastIndex := index
+	scanner := bufio.NewScanner(resp.Body)
+	// Allocation events carry the full allocation and can be large
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var batch models.NomadEventBatch
+		if err := json.Unmarshal(line, &batch); err != nil {
+			return lastIndex, fmt.Errorf("failed to decode event stream line: %v", err)
+		}
+
+		// Heartbeats are sent as empty objects
+		if batch.Index == 0 {
+			continue
+		}
+
+		fn(batch)
+		lastIndex = batch.Index
+	}
+
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return lastIndex, fmt.Errorf("event stream interrupted: %v", err)
+	}
+
+	return lastIndex, ctx.Err()
+}
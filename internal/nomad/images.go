@@ -0,0 +1,138 @@
+package nomad
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"shipper-deployment/internal/models"
+)
+
+// ErrInvalidImageOverride is returned when a requested image substitution cannot be applied to the job
+var ErrInvalidImageOverride = errors.New("invalid image override")
+
+// DeployOptions holds the per-deploy changes Shipper applies to a fetched job before resubmitting it
+type DeployOptions struct {
+	// Image replaces the image of every docker task that runs the same repository
+	Image string
+	// Images replaces the image of named tasks, keyed by "task" or "group/task"
+	Images map[string]string
+	// Namespace and Region locate the job, the token's defaults when empty
+	Namespace string
+	Region    string
+}
+
+// PreparedJob is a job payload ready to submit or plan, together with what Shipper changed in it
+type PreparedJob struct {
+	Payload         map[string]interface{}
+	OverwrittenMeta []string
+	ImageChanges    []models.ImageChange
+}
+
+// Validate checks the image overrides that can be checked without the job definition
+func (o DeployOptions) Validate() error {
+	if o.Image != "" && imageRepository(o.Image) == "" {
+		return fmt.Errorf("%w: %q is not an image reference", ErrInvalidImageOverride, o.Image)
+	}
+	for task, image := range o.Images {
+		if task == "" || image == "" {
+			return fmt.Errorf("%w: images entries need a task name and an image", ErrInvalidImageOverride)
+		}
+	}
+	return nil
+}
+
+// imageRepository strips the tag and digest from an image reference
+func imageRepository(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// substituteImages rewrites Config.image of the job's tasks as requested by opts
+func substituteImages(job map[string]interface{}, opts DeployOptions) ([]models.ImageChange, error) {
+	if opts.Image == "" && len(opts.Images) == 0 {
+		return nil, nil
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	repository := ""
+	if opts.Image != "" {
+		repository = imageRepository(opts.Image)
+	}
+
+	var changes []models.ImageChange
+	matched := make(map[string]bool, len(opts.Images))
+	repositoryMatched := false
+
+	groups, _ := job["TaskGroups"].([]interface{})
+	for _, g := range groups {
+		group, _ := g.(map[string]interface{})
+		groupName, _ := group["Name"].(string)
+		tasks, _ := group["Tasks"].([]interface{})
+
+		for _, t := range tasks {
+			task, _ := t.(map[string]interface{})
+			taskName, _ := task["Name"].(string)
+			driver, _ := task["Driver"].(string)
+			taskConfig, _ := task["Config"].(map[string]interface{})
+			current, _ := taskConfig["image"].(string)
+
+			target := ""
+			for _, key := range []string{groupName + "/" + taskName, taskName} {
+				if image, ok := opts.Images[key]; ok {
+					matched[key] = true
+					target = image
+					break
+				}
+			}
+			if target == "" && repository != "" && current != "" && imageRepository(current) == repository {
+				repositoryMatched = true
+				target = opts.Image
+			}
+			if target == "" {
+				continue
+			}
+
+			if driver != "docker" {
+				return nil, fmt.Errorf("%w: task %s/%s uses the %s driver, only docker tasks support image substitution",
+					ErrInvalidImageOverride, groupName, taskName, driver)
+			}
+			if taskConfig == nil {
+				taskConfig = make(map[string]interface{})
+				task["Config"] = taskConfig
+			}
+
+			taskConfig["image"] = target
+			changes = append(changes, models.ImageChange{
+				Group: groupName,
+				Task:  taskName,
+				From:  current,
+				To:    target,
+			})
+		}
+	}
+
+	var missing []string
+	for key := range opts.Images {
+		if !matched[key] {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%w: no task named %s in job", ErrInvalidImageOverride, strings.Join(missing, ", "))
+	}
+	if repository != "" && !repositoryMatched {
+		return nil, fmt.Errorf("%w: no docker task runs an image from %s", ErrInvalidImageOverride, repository)
+	}
+
+	return changes, nil
+}
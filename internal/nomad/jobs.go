@@ -0,0 +1,188 @@
+package nomad
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"shipper-deployment/internal/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// GetJobVersions returns every version of a job Nomad still retains, newest first
+func (c *Client) GetJobVersions(jobID string, scope JobScope) ([]models.NomadJobVersion, error) {
+	c.logger.WithField("job_id", jobID).Debug("Fetching job versions from Nomad")
+
+	var resp models.NomadJobVersionsResponse
+	if err := c.getJSON(fmt.Sprintf("/v1/job/%s/versions%s", url.PathEscape(jobID), scope.query()), &resp); err != nil {
+		return nil, fmt.Errorf("failed to fetch job versions: %v", err)
+	}
+
+	sort.Slice(resp.Versions, func(i, j int) bool {
+		return resp.Versions[i].Version > resp.Versions[j].Version
+	})
+
+	return resp.Versions, nil
+}
+
+// RevertJob reverts a job to a previous version and returns the evaluation ID of the new rollout
+func (c *Client) RevertJob(jobID string, scope JobScope, version uint64) (string, error) {
+	c.logger.WithFields(logrus.Fields{
+		"job_id":      jobID,
+		"job_version": version,
+	}).Info("Reverting job in Nomad")
+
+	request := map[string]interface{}{
+		"JobID":      jobID,
+		"JobVersion": version,
+	}
+
+	var resp models.NomadJobResponse
+	if err := c.write("POST", fmt.Sprintf("/v1/job/%s/revert%s", url.PathEscape(jobID), scope.query()), request, &resp); err != nil {
+		return "", fmt.Errorf("failed to revert job: %v", err)
+	}
+
+	c.logger.WithFields(logrus.Fields{
+		"job_id":      jobID,
+		"job_version": version,
+		"eval_id":     resp.EvalID,
+	}).Info("Successfully reverted job")
+
+	return resp.EvalID, nil
+}
+
+// PromoteDeployment promotes the canaries of a deployment. With no groups every task group is promoted.
+func (c *Client) PromoteDeployment(deploymentID string, scope JobScope, groups []string) (string, error) {
+	c.logger.WithFields(logrus.Fields{
+		"deployment_id": deploymentID,
+		"groups":        groups,
+	}).Info("Promoting deployment in Nomad")
+
+	request := map[string]interface{}{
+		"DeploymentID": deploymentID,
+		"All":          len(groups) == 0,
+	}
+	if len(groups) > 0 {
+		request["Groups"] = groups
+	}
+
+	var resp models.NomadDeploymentUpdateResponse
+	if err := c.write("POST", fmt.Sprintf("/v1/deployment/promote/%s%s", url.PathEscape(deploymentID), scope.query()), request, &resp); err != nil {
+		return "", fmt.Errorf("failed to promote deployment: %v", err)
+	}
+
+	return resp.EvalID, nil
+}
+
+// FailDeployment marks a deployment as failed, which stops the rollout and
+// triggers Nomad's auto_revert when the job enables it
+func (c *Client) FailDeployment(deploymentID string, scope JobScope) (string, error) {
+	c.logger.WithField("deployment_id", deploymentID).Info("Failing deployment in Nomad")
+
+	request := map[string]interface{}{
+		"DeploymentID": deploymentID,
+	}
+
+	var resp models.NomadDeploymentUpdateResponse
+	if err := c.write("POST", fmt.Sprintf("/v1/deployment/fail/%s%s", url.PathEscape(deploymentID), scope.query()), request, &resp); err != nil {
+		return "", fmt.Errorf("failed to fail deployment: %v", err)
+	}
+
+	return resp.EvalID, nil
+}
+
+// PlanJob runs the scheduler against a job payload without registering it and
+// returns the diff and allocation changes the submission would cause
+func (c *Client) PlanJob(jobPayload map[string]interface{}, tagID string) (*models.PlanResponse, error) {
+	job, ok := jobPayload["Job"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("job payload has no Job definition")
+	}
+	jobID, _ := job["ID"].(string)
+	if jobID == "" {
+		return nil, fmt.Errorf("job definition has no ID")
+	}
+
+	c.logger.WithFields(logrus.Fields{
+		"job_id": jobID,
+		"tag_id": tagID,
+	}).Info("Planning job in Nomad")
+
+	request := map[string]interface{}{
+		"Job":  job,
+		"Diff": true,
+	}
+
+	var resp models.NomadPlanResponse
+	if err := c.write("POST", fmt.Sprintf("/v1/job/%s/plan%s", url.PathEscape(jobID), declaredScope(job).query()), request, &resp); err != nil {
+		return nil, fmt.Errorf("failed to plan job: %v", err)
+	}
+
+	plan := &models.PlanResponse{
+		DryRun:            true,
+		TagID:             tagID,
+		JobID:             jobID,
+		JobModifyIndex:    resp.JobModifyIndex,
+		PlacementFailures: resp.FailedTGAllocs,
+	}
+	if len(resp.Diff) > 0 && string(resp.Diff) != "null" {
+		plan.Diff = resp.Diff
+	}
+
+	for _, warning := range strings.Split(resp.Warnings, "\n") {
+		if warning = strings.TrimSpace(warning); warning != "" {
+			plan.Warnings = append(plan.Warnings, warning)
+		}
+	}
+
+	if resp.Annotations != nil {
+		plan.TaskGroups = make(map[string]models.PlanGroupUpdate, len(resp.Annotations.DesiredTGUpdates))
+		for name, updates := range resp.Annotations.DesiredTGUpdates {
+			plan.TaskGroups[name] = models.PlanGroupUpdate{
+				Place:             updates.Place,
+				Stop:              updates.Stop,
+				InPlaceUpdate:     updates.InPlaceUpdate,
+				DestructiveUpdate: updates.DestructiveUpdate,
+				Canary:            updates.Canary,
+				Migrate:           updates.Migrate,
+				Ignore:            updates.Ignore,
+				Preemptions:       updates.Preemptions,
+			}
+			plan.Summary.Create += updates.Place
+			plan.Summary.Destroy += updates.Stop
+			plan.Summary.InPlace += updates.InPlaceUpdate
+			plan.Summary.Destructive += updates.DestructiveUpdate
+			plan.Summary.Canary += updates.Canary
+		}
+	}
+
+	c.logger.WithFields(logrus.Fields{
+		"job_id":             jobID,
+		"tag_id":             tagID,
+		"placement_failures": len(plan.PlacementFailures),
+		"summary":            plan.Summary,
+	}).Info("Successfully planned job")
+
+	return plan, nil
+}
+
+// ParseJob converts an HCL job file to the job JSON structure with Nomad's parse API, wrapped
+// as {"Job": {...}}. variables is a var file applied to the job, namespace scopes the request.
+func (c *Client) ParseJob(jobHCL, variables, namespace string) (map[string]interface{}, error) {
+	request := map[string]interface{}{
+		"JobHCL":       jobHCL,
+		"Variables":    variables,
+		"Canonicalize": true,
+	}
+
+	var jobSpec map[string]interface{}
+	if err := c.write("POST", "/v1/jobs/parse"+scopeQuery(namespace, ""), request, &jobSpec); err != nil {
+		return nil, fmt.Errorf("failed to parse job file with Nomad: %v", err)
+	}
+
+	return map[string]interface{}{
+		"Job": jobSpec,
+	}, nil
+}
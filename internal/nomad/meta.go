@@ -0,0 +1,48 @@
+package nomad
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Meta keys Shipper sets on every job it submits, before MetaPrefix is applied
+const (
+	MetaTagID     = "tag_id"
+	MetaTimestamp = "timestamp"
+	MetaUpdatedBy = "updated_by"
+)
+
+// MetaKey returns the Meta key Shipper uses for name under the client's MetaPrefix
+func (c *Client) MetaKey(name string) string {
+	return c.MetaPrefix + name
+}
+
+// applyShipperMeta merges Shipper's keys into the job's Meta, keeping every other key
+// the job already had. It returns the existing keys whose values were replaced, except
+// when Shipper wrote them itself on the previous deploy.
+func (c *Client) applyShipperMeta(job map[string]interface{}, tagID string) []string {
+	meta, _ := job["Meta"].(map[string]interface{})
+	if meta == nil {
+		meta = make(map[string]interface{})
+	}
+	redeploy := meta[c.MetaKey(MetaUpdatedBy)] == "shipper"
+
+	values := map[string]string{
+		c.MetaKey(MetaTagID):     tagID,
+		c.MetaKey(MetaTimestamp): fmt.Sprintf("%d", time.Now().Unix()),
+		c.MetaKey(MetaUpdatedBy): "shipper",
+	}
+
+	var overwritten []string
+	for key, value := range values {
+		if existing, ok := meta[key]; ok && existing != value && !redeploy {
+			overwritten = append(overwritten, key)
+		}
+		meta[key] = value
+	}
+	sort.Strings(overwritten)
+
+	job["Meta"] = meta
+	return overwritten
+}
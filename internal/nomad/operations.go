@@ -0,0 +1,103 @@
+package nomad
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"shipper-deployment/internal/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNoRunningAllocations is returned when a restart finds nothing to restart
+var ErrNoRunningAllocations = errors.New("no running allocations")
+
+// JobScope locates a job, Nomad's defaults for the token are used when empty
+type JobScope struct {
+	// Cluster names the entry of Clusters the job is reached through, a Client ignores it
+	Cluster   string
+	Namespace string
+	Region    string
+}
+
+// query returns the query string selecting the scope, empty when neither is set
+func (s JobScope) query() string {
+	return scopeQuery(s.Namespace, s.Region)
+}
+
+// StopJob deregisters a job and returns the evaluation ID. Purge removes it from Nomad entirely
+// instead of leaving it listed as dead.
+func (c *Client) StopJob(jobID string, scope JobScope, purge bool) (string, error) {
+	c.logger.WithFields(logrus.Fields{
+		"job_id": jobID,
+		"purge":  purge,
+	}).Info("Stopping job in Nomad")
+
+	query := scopeValues(scope.Namespace, scope.Region)
+	if purge {
+		query.Set("purge", "true")
+	}
+
+	var resp models.NomadJobResponse
+	if err := c.write("DELETE", fmt.Sprintf("/v1/job/%s%s", url.PathEscape(jobID), encodeQuery(query)), nil, &resp); err != nil {
+		return "", fmt.Errorf("failed to stop job: %v", err)
+	}
+	return resp.EvalID, nil
+}
+
+// ScaleJob sets the count of a task group and returns the evaluation ID. The message is
+// recorded with the scaling event in Nomad.
+func (c *Client) ScaleJob(jobID string, scope JobScope, group string, count int, message string) (string, error) {
+	c.logger.WithFields(logrus.Fields{
+		"job_id": jobID,
+		"group":  group,
+		"count":  count,
+	}).Info("Scaling job in Nomad")
+
+	request := map[string]interface{}{
+		"Count":   count,
+		"Target":  map[string]string{"Group": group},
+		"Message": message,
+	}
+
+	var resp models.NomadJobResponse
+	if err := c.write("POST", fmt.Sprintf("/v1/job/%s/scale%s", url.PathEscape(jobID), scope.query()), request, &resp); err != nil {
+		return "", fmt.Errorf("failed to scale job: %v", err)
+	}
+	return resp.EvalID, nil
+}
+
+// RestartJob restarts every task of the running allocations of a job, one allocation at a
+// time, optionally limited to one task group. It returns the allocations restarted before
+// any failure.
+func (c *Client) RestartJob(jobID string, scope JobScope, group string) ([]string, error) {
+	var allocs []models.NomadAllocationStub
+	if err := c.getJSON(fmt.Sprintf("/v1/job/%s/allocations%s", url.PathEscape(jobID), scope.query()), &allocs); err != nil {
+		return nil, fmt.Errorf("failed to list allocations: %v", err)
+	}
+
+	var restarted []string
+	for _, alloc := range allocs {
+		if alloc.ClientStatus != "running" || alloc.DesiredStatus != "run" || (group != "" && alloc.TaskGroup != group) {
+			continue
+		}
+
+		c.logger.WithFields(logrus.Fields{
+			"job_id":   jobID,
+			"alloc_id": alloc.ID,
+			"group":    alloc.TaskGroup,
+		}).Info("Restarting allocation")
+
+		request := map[string]interface{}{"AllTasks": true}
+		if err := c.write("POST", fmt.Sprintf("/v1/client/allocation/%s/restart%s", url.PathEscape(alloc.ID), scope.query()), request, nil); err != nil {
+			return restarted, fmt.Errorf("failed to restart allocation %s: %v", alloc.ID, err)
+		}
+		restarted = append(restarted, alloc.ID)
+	}
+
+	if len(restarted) == 0 {
+		return nil, ErrNoRunningAllocations
+	}
+	return restarted, nil
+}
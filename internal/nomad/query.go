@@ -0,0 +1,196 @@
+package nomad
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxBlockingWait mirrors the upper bound Nomad applies to blocking queries
+const maxBlockingWait = 10 * time.Minute
+
+// QueryOptions configures a Nomad blocking query. The request returns once the
+// queried object changes past WaitIndex or WaitTime elapses.
+type QueryOptions struct {
+	WaitIndex uint64
+	WaitTime  time.Duration
+}
+
+// QueryMeta carries the response metadata of a Nomad read
+type QueryMeta struct {
+	// LastIndex is the X-Nomad-Index of the response, to be passed as the next WaitIndex
+	LastIndex uint64
+}
+
+func (o *QueryOptions) blocking() bool {
+	return o != nil && o.WaitIndex > 0
+}
+
+// getJSON performs an authenticated GET against the Nomad API and decodes the JSON response into out
+func (c *Client) getJSON(path string, out interface{}) error {
+	_, err := c.query(path, nil, out)
+	return err
+}
+
+// query performs an authenticated GET against the Nomad API, optionally as a blocking query,
+// and decodes the JSON response into out
+func (c *Client) query(path string, opts *QueryOptions, out interface{}) (*QueryMeta, error) {
+	requestURL := c.URL + path
+	ctx := context.Background()
+	httpClient := c.client
+
+	if opts.blocking() {
+		wait := opts.WaitTime
+		if wait <= 0 || wait > maxBlockingWait {
+			wait = maxBlockingWait
+		}
+
+		params := url.Values{}
+		params.Set("index", strconv.FormatUint(opts.WaitIndex, 10))
+		params.Set("wait", wait.String())
+		separator := "?"
+		if strings.Contains(path, "?") {
+			separator = "&"
+		}
+		requestURL += separator + params.Encode()
+
+		// Nomad adds up to wait/16 of jitter, leave headroom on top of that
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, wait+wait/16+15*time.Second)
+		defer cancel()
+		httpClient = c.streamClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", requestURL, nil)
+	if err != nil {
+		c.logger.WithFields(logrus.Fields{
+			"url":   requestURL,
+			"error": err.Error(),
+		}).Error("Failed to create GET request")
+		return nil, fmt.Errorf("failed to create GET request: %v", err)
+	}
+
+	if c.Token != "" {
+		req.Header.Add("X-Nomad-Token", c.Token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		c.logger.WithFields(logrus.Fields{
+			"url":   requestURL,
+			"error": err.Error(),
+		}).Error("Failed to query Nomad")
+		return nil, fmt.Errorf("failed to query Nomad: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, err := io.ReadAll(resp.Body)
+		var bodyStr string
+		if err != nil {
+			bodyStr = "failed to read response body"
+		} else {
+			bodyStr = string(bodyBytes)
+		}
+
+		c.logger.WithFields(logrus.Fields{
+			"url":         requestURL,
+			"status_code": resp.StatusCode,
+			"error_body":  bodyStr,
+		}).Error("Nomad returned non-200 status")
+		return nil, fmt.Errorf("nomad returned status: %d with message: %s", resp.StatusCode, bodyStr)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		c.logger.WithFields(logrus.Fields{
+			"url":   requestURL,
+			"error": err.Error(),
+		}).Error("Failed to decode Nomad response")
+		return nil, fmt.Errorf("failed to decode Nomad response: %v", err)
+	}
+
+	meta := &QueryMeta{}
+	if index := resp.Header.Get("X-Nomad-Index"); index != "" {
+		meta.LastIndex, _ = strconv.ParseUint(index, 10, 64)
+	}
+
+	return meta, nil
+}
+
+// write sends an authenticated request with a JSON body to the Nomad API and decodes the JSON response into out
+func (c *Client) write(method, path string, body, out interface{}) error {
+	requestURL := c.URL + path
+
+	var payload io.Reader
+	if body != nil {
+		payloadBytes, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request body: %v", err)
+		}
+		payload = bytes.NewBuffer(payloadBytes)
+	}
+
+	req, err := http.NewRequest(method, requestURL, payload)
+	if err != nil {
+		c.logger.WithFields(logrus.Fields{
+			"url":   requestURL,
+			"error": err.Error(),
+		}).Errorf("Failed to create %s request", method)
+		return fmt.Errorf("failed to create %s request: %v", method, err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		req.Header.Add("X-Nomad-Token", c.Token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.logger.WithFields(logrus.Fields{
+			"url":   requestURL,
+			"error": err.Error(),
+		}).Error("Failed to call Nomad")
+		return fmt.Errorf("failed to call Nomad: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, err := io.ReadAll(resp.Body)
+		var bodyStr string
+		if err != nil {
+			bodyStr = "failed to read response body"
+		} else {
+			bodyStr = string(bodyBytes)
+		}
+
+		c.logger.WithFields(logrus.Fields{
+			"url":         requestURL,
+			"status_code": resp.StatusCode,
+			"error_body":  bodyStr,
+		}).Error("Nomad returned non-200 status")
+		return fmt.Errorf("nomad returned status: %d with message: %s", resp.StatusCode, bodyStr)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		c.logger.WithFields(logrus.Fields{
+			"url":   requestURL,
+			"error": err.Error(),
+		}).Error("Failed to decode Nomad response")
+		return fmt.Errorf("failed to decode Nomad response: %v", err)
+	}
+
+	return nil
+}
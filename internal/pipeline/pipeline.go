@@ -0,0 +1,390 @@
+package pipeline
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/locks"
+	"shipper-deployment/internal/logger"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+	"shipper-deployment/internal/rollback"
+	"shipper-deployment/internal/tracker"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Runner starts the stages of release pipelines and moves each pipeline on once the deployments
+// of its current stage are healthy. Pipelines live in the database, so a restart resumes them.
+type Runner struct {
+	db       *sql.DB
+	clusters *nomad.Clusters
+	config   *config.Config
+	tracker  *tracker.Tracker
+	rollback *rollback.Service
+	locks    *locks.Manager
+	logger   *logrus.Entry
+
+	// mu keeps two callers from starting the same stage twice
+	mu sync.Mutex
+}
+
+func New(db *sql.DB, clusters *nomad.Clusters, cfg *config.Config, deploymentTracker *tracker.Tracker, rollbackService *rollback.Service, deployLocks *locks.Manager) *Runner {
+	return &Runner{
+		db:       db,
+		clusters: clusters,
+		config:   cfg,
+		tracker:  deploymentTracker,
+		rollback: rollbackService,
+		locks:    deployLocks,
+		logger:   logger.WithModule("pipeline"),
+	}
+}
+
+// DeploymentTagID is the tag of the deployment of a service a pipeline starts
+func DeploymentTagID(pipelineTagID, service string) string {
+	return pipelineTagID + "-" + service
+}
+
+// Start records a pipeline and deploys its first stage
+func (r *Runner) Start(pipeline *models.Pipeline) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pipeline.Status = models.StatusRunning
+	pipeline.CurrentStage = 0
+	if err := database.InsertPipeline(r.db, pipeline); err != nil {
+		return err
+	}
+
+	r.logger.WithFields(logrus.Fields{
+		"tag_id": pipeline.TagID,
+		"stages": len(pipeline.Stages),
+	}).Info("Pipeline started")
+
+	return r.startStage(pipeline)
+}
+
+// Advance refreshes the deployments of a running pipeline's current stage from Nomad, then starts
+// the next stage or stops the pipeline when they have finished. It returns the updated pipeline.
+func (r *Runner) Advance(tagID string) (*models.Pipeline, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pipeline, err := database.GetPipeline(r.db, tagID)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.advance(pipeline, true); err != nil {
+		return pipeline, err
+	}
+	return pipeline, nil
+}
+
+// AdvanceAll moves every running pipeline on from the stored status of its deployments. It runs
+// after the reconciler has refreshed them.
+func (r *Runner) AdvanceAll(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pipelines, err := database.ListActivePipelines(r.db)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to list running pipelines")
+		return
+	}
+
+	for i := range pipelines {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := r.advance(&pipelines[i], false); err != nil {
+			r.logger.WithError(err).WithField("tag_id", pipelines[i].TagID).Warn("Failed to advance pipeline")
+		}
+	}
+}
+
+// Report lists the deployments of each stage of a pipeline
+func (r *Runner) Report(pipeline *models.Pipeline) (models.PipelineResponse, error) {
+	response := models.PipelineResponse{
+		TagID:        pipeline.TagID,
+		Status:       pipeline.Status,
+		CurrentStage: pipeline.CurrentStage,
+		Cluster:      pipeline.Cluster,
+		Message:      pipeline.Message,
+		TriggeredBy:  pipeline.TriggeredBy,
+	}
+
+	deployments, err := database.ListPipelineDeployments(r.db, pipeline.TagID)
+	if err != nil {
+		return response, err
+	}
+	for i, stage := range pipeline.Stages {
+		stageDeployments := stageStatuses(pipeline, i, deployments)
+		response.Stages = append(response.Stages, models.PipelineStageStatus{
+			Name:        stage.Name,
+			Status:      models.StageStatus(stageDeployments),
+			Deployments: stageDeployments,
+		})
+	}
+	return response, nil
+}
+
+func (r *Runner) advance(pipeline *models.Pipeline, refresh bool) error {
+	if pipeline.Status != models.StatusRunning {
+		return nil
+	}
+
+	deployments, err := database.ListPipelineDeployments(r.db, pipeline.TagID)
+	if err != nil {
+		return err
+	}
+	if refresh {
+		for i := range deployments {
+			if models.IsTerminalStatus(deployments[i].Status) {
+				continue
+			}
+			health, err := r.tracker.Refresh(deployments[i])
+			if err != nil {
+				r.logger.WithError(err).WithField("tag_id", deployments[i].TagID).Warn("Failed to refresh pipeline deployment")
+				continue
+			}
+			if health != nil {
+				deployments[i].Status = health.Status
+			}
+		}
+	}
+
+	stage := stageStatuses(pipeline, pipeline.CurrentStage, deployments)
+	switch models.StageStatus(stage) {
+	case models.StatusFailed:
+		var failed []string
+		for _, deployment := range stage {
+			if models.IsTerminalStatus(deployment.Status) && deployment.Status != models.StatusSuccessful && deployment.Status != models.StatusCompleted {
+				failed = append(failed, fmt.Sprintf("%s %s", deployment.ServiceName, deployment.Status))
+			}
+		}
+		return r.fail(pipeline, strings.Join(failed, ", "))
+	case models.StatusSuccessful, models.StatusCompleted:
+		if pipeline.CurrentStage == len(pipeline.Stages)-1 {
+			pipeline.Status = models.StatusSuccessful
+			pipeline.Message = "All stages succeeded"
+			r.logger.WithField("tag_id", pipeline.TagID).Info("Pipeline succeeded")
+			return database.UpdatePipeline(r.db, pipeline.TagID, pipeline.Status, pipeline.CurrentStage, pipeline.Message)
+		}
+		pipeline.CurrentStage++
+		return r.startStage(pipeline)
+	}
+	return nil
+}
+
+// startStage deploys every service of the pipeline's current stage. A service Nomad refuses fails
+// the pipeline right away.
+func (r *Runner) startStage(pipeline *models.Pipeline) error {
+	stage := pipeline.Stages[pipeline.CurrentStage]
+	pipeline.Message = fmt.Sprintf("Stage %s started", stageName(pipeline, pipeline.CurrentStage))
+	if err := database.UpdatePipeline(r.db, pipeline.TagID, pipeline.Status, pipeline.CurrentStage, pipeline.Message); err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, service := range stage.Services {
+		if err := r.deploy(pipeline, stage, service); err != nil {
+			r.logger.WithError(err).WithFields(logrus.Fields{
+				"tag_id":  pipeline.TagID,
+				"service": service,
+			}).Error("Pipeline deployment failed")
+			failed = append(failed, fmt.Sprintf("%s: %v", service, err))
+		}
+	}
+	if len(failed) > 0 {
+		return r.fail(pipeline, strings.Join(failed, ", "))
+	}
+
+	r.logger.WithFields(logrus.Fields{
+		"tag_id":   pipeline.TagID,
+		"stage":    stageName(pipeline, pipeline.CurrentStage),
+		"services": stage.Services,
+	}).Info("Pipeline stage started")
+	return nil
+}
+
+// deploy redeploys one service of a stage in the pipeline's cluster, recording the deployment
+// as failed when Nomad refuses it. The service's lock policy applies as for /deploy: the
+// deployment is queued behind the lock, or refused, while an earlier one is rolling out.
+func (r *Runner) deploy(pipeline *models.Pipeline, stage models.PipelineStage, service string) error {
+	client, err := r.clusters.Client(pipeline.Cluster)
+	if err != nil {
+		return err
+	}
+
+	// The service's config wins, the cluster's defaults fill what it leaves open
+	entry, defaults := r.config.Service(service), r.config.Clusters[pipeline.Cluster]
+	scope := nomad.JobScope{Cluster: pipeline.Cluster, Namespace: entry.Namespace, Region: entry.Region}
+	if scope.Namespace == "" {
+		scope.Namespace = defaults.Namespace
+	}
+	if scope.Region == "" {
+		scope.Region = defaults.Region
+	}
+
+	tagID := DeploymentTagID(pipeline.TagID, service)
+	deployment := &models.Deployment{
+		TagID:       tagID,
+		ServiceName: service,
+		Status:      models.StatusPending,
+		Namespace:   scope.Namespace,
+		Region:      scope.Region,
+		Cluster:     scope.Cluster,
+		Image:       stage.Image,
+		Pipeline:    pipeline.TagID,
+		TriggeredBy: pipeline.TriggeredBy,
+		AuthClaims:  pipeline.AuthClaims,
+	}
+
+	unlock := r.locks.Lock(deployment)
+	defer unlock()
+
+	decision, lock, err := r.locks.Check(deployment, true)
+	if err != nil {
+		return err
+	}
+	switch decision {
+	case locks.Refuse:
+		return fmt.Errorf("service %s is locked by deployment %s", lock.Service, lock.Blocker())
+	case locks.Queue:
+		// The reconciler submits it once the lock is free, the stage waits for it meanwhile
+		deployment.Status = models.StatusQueued
+		r.logger.WithFields(logrus.Fields{
+			"tag_id": tagID,
+			"holder": lock.Blocker(),
+		}).Info("Pipeline deployment queued behind the deploy lock")
+		return database.InsertDeploymentRecord(r.db, deployment)
+	}
+
+	if err := database.InsertDeploymentRecord(r.db, deployment); err != nil {
+		return err
+	}
+
+	evalID, _, err := client.TriggerDeployment(service, tagID, nomad.DeployOptions{
+		Image:     stage.Image,
+		Namespace: scope.Namespace,
+		Region:    scope.Region,
+	})
+	if err != nil {
+		if updateErr := database.UpdateDeploymentStatus(r.db, tagID, models.StatusFailed); updateErr != nil {
+			r.logger.WithError(updateErr).WithField("tag_id", tagID).Error("Failed to update deployment status")
+		}
+		return err
+	}
+
+	if err := database.UpdateDeploymentJobID(r.db, tagID, evalID, models.StatusRunning); err != nil {
+		return err
+	}
+	if superseded, err := r.locks.Supersede(deployment); err != nil {
+		r.logger.WithError(err).WithField("tag_id", tagID).Error("Failed to supersede deployments")
+	} else if len(superseded) > 0 {
+		r.logger.WithFields(logrus.Fields{
+			"tag_id":     tagID,
+			"superseded": superseded,
+		}).Info("Superseded unfinished deployments")
+	}
+	return nil
+}
+
+// fail stops a pipeline at its current stage and, when it asks for it, rolls back the services
+// it rolled out successfully. Completed batch jobs such as migrations are left alone.
+func (r *Runner) fail(pipeline *models.Pipeline, reason string) error {
+	pipeline.Status = models.StatusFailed
+	pipeline.Message = fmt.Sprintf("Stage %s failed: %s", stageName(pipeline, pipeline.CurrentStage), reason)
+
+	log := r.logger.WithFields(logrus.Fields{
+		"tag_id": pipeline.TagID,
+		"stage":  stageName(pipeline, pipeline.CurrentStage),
+	})
+	log.WithField("reason", reason).Warn("Pipeline failed")
+
+	if pipeline.RollbackOnFailure {
+		rolledBack, err := r.rollBack(pipeline)
+		if err != nil {
+			log.WithError(err).Error("Pipeline rollback incomplete")
+			pipeline.Message += fmt.Sprintf("; rollback incomplete: %v", err)
+		}
+		if len(rolledBack) > 0 {
+			pipeline.Message += "; rolled back " + strings.Join(rolledBack, ", ")
+		}
+	}
+
+	return database.UpdatePipeline(r.db, pipeline.TagID, pipeline.Status, pipeline.CurrentStage, pipeline.Message)
+}
+
+// rollBack reverts every service of the pipeline whose deployment succeeded to the version it
+// ran before, returning the services it rolled back
+func (r *Runner) rollBack(pipeline *models.Pipeline) ([]string, error) {
+	deployments, err := database.ListPipelineDeployments(r.db, pipeline.TagID)
+	if err != nil {
+		return nil, err
+	}
+
+	var rolledBack, failed []string
+	for _, deployment := range deployments {
+		if deployment.Status != models.StatusSuccessful {
+			continue
+		}
+
+		scope := r.tracker.JobScope(deployment)
+		result, err := r.rollback.Rollback(models.RollbackRequest{
+			ServiceName: deployment.ServiceName,
+			Cluster:     scope.Cluster,
+			Namespace:   scope.Namespace,
+			Region:      scope.Region,
+			TriggeredBy: "pipeline:" + pipeline.TagID,
+		})
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", deployment.ServiceName, err))
+			continue
+		}
+		if err := database.MarkDeploymentRolledBack(r.db, deployment.TagID, *result.Deployment.RestoredVersion); err != nil {
+			r.logger.WithError(err).WithField("tag_id", deployment.TagID).Error("Failed to mark deployment as rolled back")
+		}
+		rolledBack = append(rolledBack, deployment.ServiceName)
+	}
+
+	if len(failed) > 0 {
+		return rolledBack, fmt.Errorf("%s", strings.Join(failed, ", "))
+	}
+	return rolledBack, nil
+}
+
+// stageStatuses returns the deployments of the services of a stage, leaving out services whose
+// deployment has not been recorded yet
+func stageStatuses(pipeline *models.Pipeline, index int, deployments []models.Deployment) []models.PipelineDeployment {
+	var stage []models.PipelineDeployment
+	for _, service := range pipeline.Stages[index].Services {
+		tagID := DeploymentTagID(pipeline.TagID, service)
+		for _, deployment := range deployments {
+			if deployment.TagID == tagID {
+				stage = append(stage, models.PipelineDeployment{
+					ServiceName: service,
+					TagID:       tagID,
+					Status:      deployment.Status,
+				})
+				break
+			}
+		}
+	}
+	return stage
+}
+
+// stageName names a stage in messages, by its position when it has no name
+func stageName(pipeline *models.Pipeline, index int) string {
+	if name := pipeline.Stages[index].Name; name != "" {
+		return name
+	}
+	return strconv.Itoa(index + 1)
+}
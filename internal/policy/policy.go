@@ -0,0 +1,265 @@
+// Package policy checks parsed job files against the organisational rules in POLICY_CONFIG
+package policy
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/jobspec"
+	"shipper-deployment/internal/models"
+)
+
+// Engine evaluates policy rules against job JSON as returned by Nomad's parse API
+type Engine struct {
+	rules []config.PolicyRule
+}
+
+// New creates an engine for the configured rules. A nil config yields an engine without rules.
+func New(cfg *config.PolicyConfig) *Engine {
+	engine := &Engine{}
+	if cfg != nil {
+		engine.rules = cfg.Rules
+	}
+	return engine
+}
+
+// Result holds the violations found in a job, in rule order
+type Result struct {
+	Violations []models.PolicyViolation
+}
+
+// Errors returns the violations that refuse the deployment
+func (r *Result) Errors() []models.PolicyViolation {
+	return r.filter(models.SeverityError)
+}
+
+// Warnings returns the violations of warn-only rules
+func (r *Result) Warnings() []models.PolicyViolation {
+	return r.filter(models.SeverityWarning)
+}
+
+// Denied reports whether any rule that is not warn-only was violated
+func (r *Result) Denied() bool {
+	return len(r.Errors()) > 0
+}
+
+func (r *Result) filter(severity string) []models.PolicyViolation {
+	var violations []models.PolicyViolation
+	for _, violation := range r.Violations {
+		if violation.Severity == severity {
+			violations = append(violations, violation)
+		}
+	}
+	return violations
+}
+
+// Evaluate checks a job payload of the form {"Job": {...}} against every rule
+func (e *Engine) Evaluate(jobJSON map[string]interface{}) *Result {
+	return e.evaluate(jobJSON, nil)
+}
+
+// EvaluateJobFile checks a job parsed from jobHCL against every rule. Parsing fills in default cpu
+// and memory, so required_resources reads the resources each task sets from the job file itself.
+func (e *Engine) EvaluateJobFile(jobJSON map[string]interface{}, jobHCL string) *Result {
+	// A file the scan cannot read leaves the job JSON to check as it is
+	declared, _ := jobspec.DeclaredResources(jobHCL)
+	return e.evaluate(jobJSON, declared)
+}
+
+// evaluate checks a job against every rule. declared holds the resources attributes of each
+// "<group>/<task>" the job file sets, nil when the job is checked as it is.
+func (e *Engine) evaluate(jobJSON map[string]interface{}, declared map[string]map[string]bool) *Result {
+	result := &Result{}
+	job, _ := jobJSON["Job"].(map[string]interface{})
+	if job == nil || len(e.rules) == 0 {
+		return result
+	}
+
+	for _, rule := range e.rules {
+		report := func(group, task, format string, args ...interface{}) {
+			severity := models.SeverityError
+			if rule.WarnOnly {
+				severity = models.SeverityWarning
+			}
+			result.Violations = append(result.Violations, models.PolicyViolation{
+				Rule:     rule.RuleName(),
+				Type:     rule.Type,
+				Severity: severity,
+				Group:    group,
+				Task:     task,
+				Message:  fmt.Sprintf(format, args...),
+			})
+		}
+
+		switch rule.Type {
+		case config.PolicyAllowedDatacenters:
+			for _, datacenter := range stringList(job["Datacenters"]) {
+				if !matchAny(rule.Values, datacenter) {
+					report("", "", "datacenter %q is not allowed, allowed datacenters are %s", datacenter, strings.Join(rule.Values, ", "))
+				}
+			}
+
+		case config.PolicyRequireUpdate:
+			jobUpdate := hasUpdate(job["Update"])
+			for _, group := range objectList(job["TaskGroups"]) {
+				if !jobUpdate && !hasUpdate(group["Update"]) {
+					report(stringField(group, "Name"), "", "task group has no update block enabling deployments")
+				}
+			}
+
+		case config.PolicyMaxCount:
+			for _, group := range objectList(job["TaskGroups"]) {
+				if count := numberField(group, "Count"); count > float64(rule.Max) {
+					report(stringField(group, "Name"), "", "count %v exceeds the maximum of %d", count, rule.Max)
+				}
+			}
+
+		case config.PolicyNoHostVolumes:
+			for _, group := range objectList(job["TaskGroups"]) {
+				volumes, _ := group["Volumes"].(map[string]interface{})
+				names := make([]string, 0, len(volumes))
+				for name := range volumes {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				for _, name := range names {
+					if volume, ok := volumes[name].(map[string]interface{}); ok && stringField(volume, "Type") == "host" {
+						report(stringField(group, "Name"), "", "host volume %q is not allowed", name)
+					}
+				}
+			}
+			eachTask(job, func(group, task map[string]interface{}) {
+				taskConfig, _ := task["Config"].(map[string]interface{})
+				for _, volume := range stringList(taskConfig["volumes"]) {
+					if strings.HasPrefix(volume, "/") {
+						report(stringField(group, "Name"), stringField(task, "Name"), "bind mount of host path %q is not allowed", strings.SplitN(volume, ":", 2)[0])
+					}
+				}
+			})
+
+		case config.PolicyDeniedDrivers:
+			eachTask(job, func(group, task map[string]interface{}) {
+				if driver := stringField(task, "Driver"); matchAny(rule.Values, driver) {
+					report(stringField(group, "Name"), stringField(task, "Name"), "driver %q is not allowed", driver)
+				}
+			})
+
+		case config.PolicyNoPrivileged:
+			eachTask(job, func(group, task map[string]interface{}) {
+				taskConfig, _ := task["Config"].(map[string]interface{})
+				if privileged, _ := taskConfig["privileged"].(bool); privileged {
+					report(stringField(group, "Name"), stringField(task, "Name"), "privileged containers are not allowed")
+				}
+			})
+
+		case config.PolicyNoLatestTag:
+			eachTask(job, func(group, task map[string]interface{}) {
+				taskConfig, _ := task["Config"].(map[string]interface{})
+				image, _ := taskConfig["image"].(string)
+				if image != "" && isFloatingTag(image) {
+					report(stringField(group, "Name"), stringField(task, "Name"), "image %q must be pinned to a tag other than latest or a digest", image)
+				}
+			})
+
+		case config.PolicyRequiredResources:
+			eachTask(job, func(group, task map[string]interface{}) {
+				groupName, taskName := stringField(group, "Name"), stringField(task, "Name")
+				resources, _ := task["Resources"].(map[string]interface{})
+				cpu := numberField(resources, "CPU")
+				cores := numberField(resources, "Cores")
+				memory := numberField(resources, "MemoryMB")
+
+				setCPU, setMemory := cpu > 0 || cores > 0, memory > 0
+				if attributes, ok := declared[groupName+"/"+taskName]; ok {
+					setCPU = setCPU && (attributes["cpu"] || attributes["cores"])
+					setMemory = setMemory && attributes["memory"]
+				}
+				if !setCPU {
+					report(groupName, taskName, "task must set cpu or cores")
+				}
+				if !setMemory {
+					report(groupName, taskName, "task must set memory")
+				}
+				if rule.MaxCPU > 0 && cpu > float64(rule.MaxCPU) {
+					report(groupName, taskName, "cpu %v exceeds the maximum of %d", cpu, rule.MaxCPU)
+				}
+				if rule.MaxMemoryMB > 0 && memory > float64(rule.MaxMemoryMB) {
+					report(groupName, taskName, "memory %v exceeds the maximum of %d", memory, rule.MaxMemoryMB)
+				}
+			})
+		}
+	}
+
+	return result
+}
+
+// eachTask calls fn for every task of every task group
+func eachTask(job map[string]interface{}, fn func(group, task map[string]interface{})) {
+	for _, group := range objectList(job["TaskGroups"]) {
+		for _, task := range objectList(group["Tasks"]) {
+			fn(group, task)
+		}
+	}
+}
+
+// hasUpdate reports whether an update block enables deployments
+func hasUpdate(value interface{}) bool {
+	update, ok := value.(map[string]interface{})
+	return ok && numberField(update, "MaxParallel") > 0
+}
+
+// isFloatingTag reports whether an image reference has no tag, or the latest tag, and no digest
+func isFloatingTag(image string) bool {
+	if strings.Contains(image, "@") {
+		return false
+	}
+	i := strings.LastIndex(image, ":")
+	if i <= strings.LastIndex(image, "/") {
+		return true
+	}
+	return image[i+1:] == "latest"
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, value); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+func objectList(value interface{}) []map[string]interface{} {
+	items, _ := value.([]interface{})
+	objects := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		if object, ok := item.(map[string]interface{}); ok {
+			objects = append(objects, object)
+		}
+	}
+	return objects
+}
+
+func stringList(value interface{}) []string {
+	items, _ := value.([]interface{})
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+func stringField(object map[string]interface{}, field string) string {
+	value, _ := object[field].(string)
+	return value
+}
+
+func numberField(object map[string]interface{}, field string) float64 {
+	value, _ := object[field].(float64)
+	return value
+}
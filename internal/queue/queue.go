@@ -0,0 +1,261 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/logger"
+	"shipper-deployment/internal/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrFull is returned when every slot of the deploy queue is taken
+var ErrFull = errors.New("deploy queue is full")
+
+// Task runs a queued operation and returns the HTTP status and body of its response
+type Task func() (int, []byte)
+
+type job struct {
+	operation *models.Operation
+	task      Task
+}
+
+// Queue runs deploy requests on a fixed pool of workers, so clients get an answer before Nomad
+// does. Operations are recorded in the database; ones a restart interrupted are marked failed.
+type Queue struct {
+	db      *sql.DB
+	workers int
+	jobs    chan job
+	logger  *logrus.Entry
+
+	inFlight  atomic.Int64
+	completed atomic.Int64
+	failed    atomic.Int64
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func New(db *sql.DB, workers, size int) *Queue {
+	if workers < 1 {
+		workers = 1
+	}
+	if size < 1 {
+		size = 1
+	}
+
+	return &Queue{
+		db:      db,
+		workers: workers,
+		jobs:    make(chan job, size),
+		logger:  logger.WithModule("queue"),
+	}
+}
+
+// Start fails the operations a previous run left unfinished and launches the workers
+func (q *Queue) Start() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.cancel != nil {
+		return
+	}
+
+	if interrupted, err := database.FailUnfinishedOperations(q.db, "Shipper restarted before the operation finished"); err != nil {
+		q.logger.WithError(err).Error("Failed to clean up unfinished operations")
+	} else if interrupted > 0 {
+		q.logger.WithField("count", interrupted).Warn("Marked operations interrupted by a restart as failed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.work(ctx)
+	}
+
+	q.logger.WithFields(logrus.Fields{
+		"workers":  q.workers,
+		"capacity": cap(q.jobs),
+	}).Info("Starting deploy queue")
+}
+
+// Stop waits for the running operations to finish. Operations still queued are failed on the next start.
+func (q *Queue) Stop() {
+	q.mu.Lock()
+	cancel := q.cancel
+	q.cancel = nil
+	q.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+
+	cancel()
+	q.wg.Wait()
+	q.logger.Info("Deploy queue stopped")
+}
+
+// Enqueue records an operation and queues its task. When the queue is full the operation is
+// recorded as failed and ErrFull is returned.
+func (q *Queue) Enqueue(operation *models.Operation, task Task) error {
+	id, err := operationID()
+	if err != nil {
+		return err
+	}
+	operation.ID = id
+	operation.Status = models.OperationQueued
+	if err := database.InsertOperation(q.db, operation); err != nil {
+		return err
+	}
+
+	select {
+	case q.jobs <- job{operation: operation, task: task}:
+	default:
+		operation.Status = models.OperationFailed
+		operation.Error = ErrFull.Error()
+		q.finish(operation)
+		return ErrFull
+	}
+
+	q.logger.WithFields(logrus.Fields{
+		"operation": operation.ID,
+		"kind":      operation.Kind,
+		"tag_id":    operation.TagID,
+		"depth":     len(q.jobs),
+	}).Info("Operation queued")
+	return nil
+}
+
+// Stats reports the queue's depth and the operations it ran since it was created
+func (q *Queue) Stats() models.QueueStats {
+	return models.QueueStats{
+		Workers:   q.workers,
+		Capacity:  cap(q.jobs),
+		Depth:     len(q.jobs),
+		InFlight:  q.inFlight.Load(),
+		Completed: q.completed.Load(),
+		Failed:    q.failed.Load(),
+	}
+}
+
+func (q *Queue) work(ctx context.Context) {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-q.jobs:
+			q.run(j)
+		}
+	}
+}
+
+func (q *Queue) run(j job) {
+	operation := j.operation
+	if err := database.StartOperation(q.db, operation.ID); err != nil {
+		q.logger.WithError(err).WithField("operation", operation.ID).Error("Failed to record operation start")
+	}
+
+	q.inFlight.Add(1)
+	status, body := q.runTask(j)
+	q.inFlight.Add(-1)
+
+	operation.HTTPStatus = status
+	applyResult(operation, status, body)
+	q.finish(operation)
+}
+
+// runTask runs a task, turning a panic into a failed operation instead of losing the worker
+func (q *Queue) runTask(j job) (status int, body []byte) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			q.logger.WithField("operation", j.operation.ID).Errorf("Operation panicked: %v", recovered)
+			status, body = http.StatusInternalServerError, []byte(fmt.Sprintf("operation panicked: %v", recovered))
+		}
+	}()
+	return j.task()
+}
+
+func (q *Queue) finish(operation *models.Operation) {
+	if operation.Status == models.OperationFailed {
+		q.failed.Add(1)
+	} else {
+		q.completed.Add(1)
+	}
+
+	log := q.logger.WithFields(logrus.Fields{
+		"operation":   operation.ID,
+		"kind":        operation.Kind,
+		"tag_id":      operation.TagID,
+		"status":      operation.Status,
+		"http_status": operation.HTTPStatus,
+	})
+	switch operation.Status {
+	case models.OperationFailed:
+		log.WithField("error", operation.Error).Warn("Operation failed")
+	case models.OperationWaiting:
+		log.Info("Operation waiting for the deploy lock")
+	default:
+		log.Info("Operation finished")
+	}
+
+	if err := database.FinishOperation(q.db, operation); err != nil {
+		q.logger.WithError(err).WithField("operation", operation.ID).Error("Failed to record operation result")
+	}
+}
+
+// applyResult fills in an operation from the response its endpoint wrote. It failed when the
+// endpoint refused the request or reported a failed deployment, and waits when the deploy lock
+// queued the deployment.
+func applyResult(operation *models.Operation, status int, body []byte) {
+	var result struct {
+		Status  string `json:"status"`
+		TagID   string `json:"tag_id"`
+		Message string `json:"message"`
+	}
+	if json.Valid(body) {
+		operation.Result = body
+		_ = json.Unmarshal(body, &result)
+	}
+	if result.TagID != "" {
+		operation.TagID = result.TagID
+	}
+
+	switch {
+	case status >= http.StatusBadRequest:
+		operation.Status = models.OperationFailed
+		operation.Error = string(bytes.TrimSpace(body))
+		if result.Message != "" {
+			operation.Error = result.Message
+		}
+	case result.Status == models.StatusFailed:
+		operation.Status = models.OperationFailed
+		operation.Error = result.Message
+	case status == http.StatusAccepted && result.Status == models.StatusQueued:
+		operation.Status = models.OperationWaiting
+	default:
+		operation.Status = models.OperationSucceeded
+	}
+}
+
+func operationID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate operation id: %w", err)
+	}
+	return "op-" + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,160 @@
+package reconciler
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/locks"
+	"shipper-deployment/internal/logger"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/pipeline"
+	"shipper-deployment/internal/tracker"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Reconciler periodically refreshes deployments that have not reached a terminal
+// status, so rows keep moving even when no client polls the status endpoint.
+type Reconciler struct {
+	db          *sql.DB
+	tracker     *tracker.Tracker
+	pipelines   *pipeline.Runner
+	locks       *locks.Manager
+	interval    time.Duration
+	concurrency int
+	logger      *logrus.Entry
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New creates a reconciler. pipelines and deployLocks may be nil when release pipelines are not
+// moved on and queued deploys are not submitted.
+func New(db *sql.DB, deploymentTracker *tracker.Tracker, pipelines *pipeline.Runner, deployLocks *locks.Manager, interval time.Duration, concurrency int) *Reconciler {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &Reconciler{
+		db:          db,
+		tracker:     deploymentTracker,
+		pipelines:   pipelines,
+		locks:       deployLocks,
+		interval:    interval,
+		concurrency: concurrency,
+		logger:      logger.WithModule("reconciler"),
+	}
+}
+
+// Start launches the reconcile loop in the background. A non-positive interval disables it.
+func (r *Reconciler) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.interval <= 0 {
+		r.logger.Info("Status reconciler is disabled")
+		return
+	}
+	if r.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+
+	r.logger.WithFields(logrus.Fields{
+		"interval":    r.interval.String(),
+		"concurrency": r.concurrency,
+	}).Info("Starting status reconciler")
+
+	go r.run(ctx)
+}
+
+// Stop signals the reconcile loop to exit and waits for in-flight checks to finish
+func (r *Reconciler) Stop() {
+	r.mu.Lock()
+	cancel, done := r.cancel, r.done
+	r.cancel = nil
+	r.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+
+	cancel()
+	<-done
+	r.logger.Info("Status reconciler stopped")
+}
+
+func (r *Reconciler) run(ctx context.Context) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.ReconcileOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReconcileOnce refreshes every active deployment, running at most the configured
+// number of Nomad checks at the same time, then submits the queued deploys of services
+// whose lock is free and moves running pipelines on
+func (r *Reconciler) ReconcileOnce(ctx context.Context) {
+	r.refreshDeployments(ctx)
+	if r.locks != nil {
+		r.locks.AdvanceAll(ctx)
+	}
+	if r.pipelines != nil {
+		r.pipelines.AdvanceAll(ctx)
+	}
+}
+
+func (r *Reconciler) refreshDeployments(ctx context.Context) {
+	deployments, err := database.ListActiveDeployments(r.db)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to list active deployments")
+		return
+	}
+
+	if len(deployments) == 0 {
+		return
+	}
+
+	r.logger.WithField("count", len(deployments)).Debug("Reconciling active deployments")
+
+	sem := make(chan struct{}, r.concurrency)
+	var wg sync.WaitGroup
+
+	for _, deployment := range deployments {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(deployment models.Deployment) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if _, err := r.tracker.Refresh(deployment); err != nil {
+				r.logger.WithError(err).WithField("tag_id", deployment.TagID).Warn("Failed to reconcile deployment")
+			}
+		}(deployment)
+	}
+
+	wg.Wait()
+}
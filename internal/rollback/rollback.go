@@ -0,0 +1,238 @@
+package rollback
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/locks"
+	"shipper-deployment/internal/logger"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrInvalidRequest is returned when neither a service nor a tag to return to is given
+	ErrInvalidRequest = errors.New("service_name or tag_id is required")
+	// ErrUnknownDeployment is returned when the tag to return to was never deployed by Shipper
+	ErrUnknownDeployment = errors.New("deployment not found")
+	// ErrNoTargetVersion is returned when Nomad holds no version to roll back to
+	ErrNoTargetVersion = errors.New("no previous job version to roll back to")
+)
+
+// Service reverts Nomad jobs to earlier versions and records each rollback as its own deployment
+type Service struct {
+	db       *sql.DB
+	clusters *nomad.Clusters
+	locks    *locks.Manager
+	logger   *logrus.Entry
+}
+
+// NewService creates a rollback service. Reverts take the service's deploy lock from deployLocks
+// when it is set.
+func NewService(db *sql.DB, clusters *nomad.Clusters, deployLocks *locks.Manager) *Service {
+	return &Service{
+		db:       db,
+		clusters: clusters,
+		locks:    deployLocks,
+		logger:   logger.WithModule("rollback"),
+	}
+}
+
+// Result describes a rollback that was submitted to Nomad
+type Result struct {
+	Deployment    *models.Deployment
+	RestoredTagID string
+}
+
+// Rollback reverts the requested service and stores the rollback row, linked to the deployment it replaces
+func (s *Service) Rollback(req models.RollbackRequest) (*Result, error) {
+	if req.ServiceName == "" && req.TagID == "" {
+		return nil, ErrInvalidRequest
+	}
+
+	jobID := req.ServiceName
+	scope := nomad.JobScope{Cluster: req.Cluster, Namespace: req.Namespace, Region: req.Region}
+	if req.TagID != "" {
+		target, err := database.GetDeploymentRecord(s.db, req.TagID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, fmt.Errorf("%w: %s", ErrUnknownDeployment, req.TagID)
+			}
+			return nil, err
+		}
+		if jobID == "" {
+			jobID = deploymentJobID(target)
+		}
+		if scope, err = targetScope(target, scope); err != nil {
+			return nil, err
+		}
+	}
+
+	if jobID == "" {
+		return nil, fmt.Errorf("%w: cannot tell which job %s belongs to", ErrUnknownDeployment, req.TagID)
+	}
+
+	client, err := s.clusters.Client(scope.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := client.GetJobVersions(jobID, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	current, target, err := selectTargetVersion(versions, req.TagID, tagKey(client))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.revert(client, jobID, scope, current, target, current.Meta[tagKey(client)], req.TriggeredBy)
+}
+
+// targetScope returns where the job of the deployment being returned to lives, unless the request
+// named a namespace or region. A fan-out deploy has a job in each of its clusters, so the request
+// must pick one.
+func targetScope(target *models.Deployment, requested nomad.JobScope) (nomad.JobScope, error) {
+	if len(target.Clusters) == 0 {
+		if requested.Cluster == "" {
+			requested.Cluster = target.Cluster
+		}
+		if requested.Namespace == "" && requested.Region == "" {
+			requested.Namespace, requested.Region = target.Namespace, target.Region
+		}
+		return requested, nil
+	}
+
+	for _, cluster := range target.Clusters {
+		if cluster.Cluster != requested.Cluster {
+			continue
+		}
+		if requested.Namespace == "" && requested.Region == "" {
+			requested.Namespace, requested.Region = cluster.Namespace, cluster.Region
+		}
+		return requested, nil
+	}
+	return nomad.JobScope{}, fmt.Errorf("%w: %s was deployed to several clusters, cluster must name one of them", ErrInvalidRequest, target.TagID)
+}
+
+// RevertToVersion reverts a job to a specific version on behalf of the deployment replacedTagID
+func (s *Service) RevertToVersion(jobID string, scope nomad.JobScope, version uint64, replacedTagID, triggeredBy string) (*Result, error) {
+	client, err := s.clusters.Client(scope.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := client.GetJobVersions(jobID, scope)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, ErrNoTargetVersion
+	}
+
+	for i := range versions {
+		if versions[i].Version == version {
+			return s.revert(client, jobID, scope, &versions[0], &versions[i], replacedTagID, triggeredBy)
+		}
+	}
+	return nil, fmt.Errorf("%w: version %d is no longer retained by Nomad", ErrNoTargetVersion, version)
+}
+
+// revert submits the revert and records it. It holds the service's deploy lock, so a revert is
+// never submitted while a deploy is, but it is never queued or refused: the rollback row holds
+// the lock like any deployment while it rolls out.
+func (s *Service) revert(client *nomad.Client, jobID string, scope nomad.JobScope, current, target *models.NomadJobVersion, replacedTag, triggeredBy string) (*Result, error) {
+	if s.locks != nil {
+		unlock := s.locks.Lock(&models.Deployment{ServiceName: jobID, Cluster: scope.Cluster, Namespace: scope.Namespace})
+		defer unlock()
+	}
+
+	restoredTag := target.Meta[tagKey(client)]
+
+	s.logger.WithFields(logrus.Fields{
+		"job_id":          jobID,
+		"cluster":         scope.Cluster,
+		"namespace":       scope.Namespace,
+		"current_version": current.Version,
+		"target_version":  target.Version,
+		"replaced_tag":    replacedTag,
+		"restored_tag":    restoredTag,
+	}).Info("Rolling back job")
+
+	evalID, err := client.RevertJob(jobID, scope, target.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	restoredVersion := target.Version
+	deployment := &models.Deployment{
+		TagID:           fmt.Sprintf("rollback-%s-v%d-%d", jobID, target.Version, time.Now().Unix()),
+		ServiceName:     jobID,
+		JobID:           evalID,
+		Status:          models.StatusRunning,
+		NomadJobID:      jobID,
+		Namespace:       scope.Namespace,
+		Region:          scope.Region,
+		Cluster:         scope.Cluster,
+		Action:          models.ActionRollback,
+		RollbackOf:      replacedTag,
+		RestoredVersion: &restoredVersion,
+		TriggeredBy:     triggeredBy,
+	}
+
+	if err := database.InsertDeploymentRecord(s.db, deployment); err != nil {
+		// The revert already happened in Nomad, surface the tracking failure to the caller
+		s.logger.WithError(err).WithField("eval_id", evalID).Error("Failed to record rollback")
+		return nil, fmt.Errorf("rollback submitted (eval %s) but could not be recorded: %v", evalID, err)
+	}
+
+	return &Result{Deployment: deployment, RestoredTagID: restoredTag}, nil
+}
+
+// selectTargetVersion picks the version to revert to. With a tag it is the newest version
+// deployed with that tag; otherwise the newest stable version older than the current one,
+// falling back to the version right before it.
+func selectTargetVersion(versions []models.NomadJobVersion, tagID, tagKey string) (*models.NomadJobVersion, *models.NomadJobVersion, error) {
+	if len(versions) < 2 {
+		return nil, nil, ErrNoTargetVersion
+	}
+
+	current := &versions[0]
+	older := versions[1:]
+
+	if tagID != "" {
+		if current.Meta[tagKey] == tagID {
+			return nil, nil, fmt.Errorf("%w: %s is the version currently running", ErrNoTargetVersion, tagID)
+		}
+		for i := range older {
+			if older[i].Meta[tagKey] == tagID {
+				return current, &older[i], nil
+			}
+		}
+		return nil, nil, fmt.Errorf("%w: no retained job version was deployed with tag %s", ErrNoTargetVersion, tagID)
+	}
+
+	for i := range older {
+		if older[i].Stable {
+			return current, &older[i], nil
+		}
+	}
+	return current, &older[0], nil
+}
+
+// tagKey is the job Meta key holding the tag a version was deployed with
+func tagKey(client *nomad.Client) string {
+	return client.MetaKey(nomad.MetaTagID)
+}
+
+// deploymentJobID returns the Nomad job a deployment row belongs to
+func deploymentJobID(deployment *models.Deployment) string {
+	if deployment.NomadJobID != "" {
+		return deployment.NomadJobID
+	}
+	return deployment.ServiceName
+}
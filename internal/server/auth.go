@@ -0,0 +1,127 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"shipper-deployment/internal/auth"
+	"shipper-deployment/internal/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errMissingCredentials is returned when a request carries no credential at all
+var errMissingCredentials = errors.New("missing credentials")
+
+// errSigningDisabled is returned for signed requests when the shared secret is disabled
+var errSigningDisabled = errors.New("request signing is disabled with the shared secret")
+
+// authMiddleware authenticates the request and stores the caller's identity in its context
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.logger.WithFields(logrus.Fields{
+			"path":   r.URL.Path,
+			"method": r.Method,
+		}).Debug("Authenticating request")
+
+		identity, err := s.authenticate(r)
+		if err != nil {
+			s.logger.WithFields(logrus.Fields{
+				"path":   r.URL.Path,
+				"method": r.Method,
+				"ip":     r.RemoteAddr,
+				"error":  err.Error(),
+			}).Warn("Request authentication failed")
+			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			return
+		}
+
+		// Continue to next handler
+		next.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), identity)))
+	})
+}
+
+// authenticate resolves the credential of a request to an identity. API keys and, when
+// OIDC_CONFIG is set, CI OIDC ID tokens are accepted in X-Secret-Key or as a bearer token;
+// the shared RPC_SECRET is accepted as an HMAC request signature or, unless
+// REQUIRE_SIGNED_REQUESTS is set, in X-Secret-Key. Requests without a credential are
+// authenticated by their TLS client certificate when it maps to an identity.
+func (s *Server) authenticate(r *http.Request) (*auth.Identity, error) {
+	if auth.IsSigned(r) {
+		if s.signatures == nil {
+			return nil, errSigningDisabled
+		}
+		if err := s.signatures.Verify(r); err != nil {
+			return nil, err
+		}
+		return &auth.Identity{
+			Method:  auth.MethodSignedRequest,
+			Subject: "rpc_secret",
+			Scopes:  []string{models.ScopeAdmin},
+		}, nil
+	}
+
+	credential := r.Header.Get("X-Secret-Key")
+	if credential == "" {
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			credential = strings.TrimSpace(bearer)
+		}
+	}
+	if credential == "" {
+		if identity, ok := s.clientCertIdentity(r); ok {
+			return identity, nil
+		}
+		return nil, errMissingCredentials
+	}
+
+	if s.oidc != nil && auth.IsJWT(credential) {
+		return s.oidc.Verify(credential)
+	}
+
+	if auth.IsAPIKey(credential) {
+		return s.keys.Authenticate(credential)
+	}
+
+	if s.config.SharedSecretEnabled && !s.config.RequireSignedRequests && s.config.ValidSecret != "" &&
+		subtle.ConstantTimeCompare([]byte(credential), []byte(s.config.ValidSecret)) == 1 {
+		return &auth.Identity{
+			Method:  auth.MethodSharedSecret,
+			Subject: "rpc_secret",
+			Scopes:  []string{models.ScopeAdmin},
+		}, nil
+	}
+
+	return nil, auth.ErrInvalidKey
+}
+
+// clientCertIdentity maps the verified client certificate of a mutual TLS connection to an
+// identity through TLS_CLIENT_IDENTITIES
+func (s *Server) clientCertIdentity(r *http.Request) (*auth.Identity, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || s.config.TLS == nil {
+		return nil, false
+	}
+	return auth.ClientCertIdentity(r.TLS.VerifiedChains[0][0], s.config.TLS.ClientIdentities)
+}
+
+// requireScope only lets callers holding scope through to handler
+func (s *Server) requireScope(scope string, handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := auth.FromContext(r.Context())
+		if !ok || !identity.HasScope(scope) {
+			fields := logrus.Fields{
+				"path":  r.URL.Path,
+				"scope": scope,
+			}
+			if ok {
+				fields["identity"] = identity.String()
+			}
+			s.logger.WithFields(fields).Warn("Request lacks required scope")
+			http.Error(w, "Forbidden: the "+scope+" scope is required", http.StatusForbidden)
+			return
+		}
+
+		handler(w, r)
+	})
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/nomad"
+)
+
+// newNomadClusters creates a client for NOMAD_URL and one for each cluster in CLUSTERS_CONFIG
+func newNomadClusters(cfg *config.Config) (*nomad.Clusters, error) {
+	defaultClient := nomad.NewClient(cfg.NomadURL, cfg.SkipTLSVerify, cfg.NomadToken)
+	defaultClient.MetaPrefix = cfg.MetaKeyPrefix
+	clusters := nomad.NewClusters(defaultClient)
+
+	for name, cluster := range cfg.Clusters {
+		tlsConfig, err := clusterTLSConfig(cluster)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %v", name, err)
+		}
+		client := nomad.NewClientWithTLS(cluster.URL, cluster.Token, tlsConfig)
+		client.MetaPrefix = cfg.MetaKeyPrefix
+		clusters.Add(name, client)
+	}
+	return clusters, nil
+}
+
+// clusterTLSConfig returns the TLS settings used to reach a cluster
+func clusterTLSConfig(cluster config.ClusterConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// #nosec G402 - InsecureSkipVerify is configurable for development environments
+		InsecureSkipVerify: cluster.SkipTLSVerify,
+	}
+
+	if cluster.CAFile != "" {
+		pem, err := os.ReadFile(cluster.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", cluster.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cluster.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cluster.ClientCertFile, cluster.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -1,15 +1,20 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
-	"fmt"
+	"errors"
 	"net/http"
 	"time"
 
+	"shipper-deployment/internal/auth"
 	"shipper-deployment/internal/config"
 	"shipper-deployment/internal/handlers"
 	"shipper-deployment/internal/logger"
-	"shipper-deployment/internal/nomad"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/reconciler"
+	"shipper-deployment/internal/watcher"
 
 	"github.com/gorilla/mux"
 	"github.com/newrelic/go-agent/v3/newrelic"
@@ -17,12 +22,18 @@ import (
 )
 
 type Server struct {
-	config  *config.Config
-	db      *sql.DB
-	handler *handlers.Handler
-	router  *mux.Router
-	logger  *logrus.Entry
-	nrApp   *newrelic.Application
+	config     *config.Config
+	db         *sql.DB
+	handler    *handlers.Handler
+	router     *mux.Router
+	logger     *logrus.Entry
+	nrApp      *newrelic.Application
+	reconciler *reconciler.Reconciler
+	watchers   []*watcher.Watcher
+	keys       *auth.KeyStore
+	oidc       *auth.OIDCVerifier
+	signatures *auth.RequestVerifier
+	httpServer *http.Server
 }
 
 func NewServer(cfg *config.Config, db *sql.DB, nrApp *newrelic.Application) *Server {
@@ -32,21 +43,66 @@ func NewServer(cfg *config.Config, db *sql.DB, nrApp *newrelic.Application) *Ser
 	// Get a logger instance with the server module context
 	serverLogger := logger.WithModule("server")
 
-	// Create Nomad client with the shared logger
-	nomadClient := nomad.NewClient(cfg.NomadURL, cfg.SkipTLSVerify, cfg.NomadToken)
+	// Create a Nomad client per cluster with the shared logger
+	clusters, err := newNomadClusters(cfg)
+	if err != nil {
+		serverLogger.WithError(err).Fatal("Failed to configure Nomad clusters")
+	}
 
-	handler := handlers.NewHandler(db, cfg, nomadClient)
+	handler := handlers.NewHandlerWithClusters(db, cfg, clusters)
 
 	s := &Server{
-		config:  cfg,
-		db:      db,
-		handler: handler,
-		router:  mux.NewRouter(),
-		logger:  serverLogger,
-		nrApp:   nrApp,
+		config:     cfg,
+		db:         db,
+		handler:    handler,
+		router:     mux.NewRouter(),
+		logger:     serverLogger,
+		nrApp:      nrApp,
+		reconciler: reconciler.New(db, handler.Tracker(), handler.Pipelines(), handler.Locks(), cfg.ReconcileInterval, cfg.ReconcileConcurrency),
+		keys:       auth.NewKeyStore(db),
+	}
+
+	if cfg.SharedSecretEnabled && cfg.ValidSecret != "" {
+		s.signatures = auth.NewRequestVerifier(cfg.ValidSecret, cfg.SignatureMaxSkew)
+	}
+
+	if cfg.OIDC != nil {
+		s.oidc = auth.NewOIDCVerifier(cfg.OIDC)
+		serverLogger.WithField("providers", len(cfg.OIDC.Providers)).Info("OIDC token authentication enabled")
+	}
+
+	if cfg.NomadEventStream {
+		for _, name := range clusters.Names() {
+			client, _ := clusters.Client(name)
+			s.watchers = append(s.watchers, watcher.New(db, name, client, handler.Tracker()))
+		}
 	}
 
 	s.setupRoutes()
+
+	// Create server with timeouts for security
+	s.httpServer = &http.Server{
+		Addr:         ":" + cfg.Port,
+		Handler:      s.router,
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+
+	if cfg.TLS.Enabled() {
+		reloader, err := newCertReloader(cfg.TLS, serverLogger)
+		if err != nil {
+			serverLogger.WithError(err).Fatal("Failed to load TLS certificates")
+		}
+		s.httpServer.TLSConfig = reloader.tlsConfig()
+		serverLogger.WithFields(logrus.Fields{
+			"cert_file":           cfg.TLS.CertFile,
+			"client_ca_file":      cfg.TLS.ClientCAFile,
+			"require_client_cert": cfg.TLS.RequireClientCert,
+			"client_identities":   len(cfg.TLS.ClientIdentities),
+		}).Info("TLS enabled")
+	}
+
 	return s
 }
 
@@ -63,40 +119,46 @@ func (s *Server) setupRoutes() {
 	protectedRouter := s.router.PathPrefix("").Subrouter()
 	protectedRouter.Use(s.authMiddleware)
 
-	protectedRouter.HandleFunc("/deploy/job", s.handler.DeployJob).Methods("POST")
+	protectedRouter.Handle("/deploy/job", s.requireScope(models.ScopeDeploy, s.handler.DeployJob)).Methods("POST")
 
 	// Deploy endpoint
-	protectedRouter.HandleFunc("/deploy", s.handler.Deploy).Methods("POST")
+	protectedRouter.Handle("/deploy", s.requireScope(models.ScopeDeploy, s.handler.Deploy)).Methods("POST")
 
 	// Status endpoint
-	protectedRouter.HandleFunc("/status/{tag_id}", s.handler.Status).Methods("GET")
+	protectedRouter.Handle("/status/{tag_id}", s.requireScope(models.ScopeStatus, s.handler.Status)).Methods("GET")
 
-}
+	// Rollback endpoint
+	protectedRouter.Handle("/rollback", s.requireScope(models.ScopeRollback, s.handler.Rollback)).Methods("POST")
 
-func (s *Server) authMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get secret key from header
-		secretKey := r.Header.Get("X-Secret-Key")
-		s.logger.WithFields(logrus.Fields{
-			"secret_key": secretKey,
-			"path":       r.URL.Path,
-			"method":     r.Method,
-		}).Debug("Authenticating request")
-
-		// Validate secret key
-		if secretKey != s.config.ValidSecret {
-			s.logger.WithFields(logrus.Fields{
-				"path":   r.URL.Path,
-				"method": r.Method,
-				"ip":     r.RemoteAddr,
-			}).Warn("Invalid secret key provided")
-			http.Error(w, fmt.Sprintf("Invalid secret key - %s", s.config.ValidSecret), http.StatusUnauthorized)
-			return
-		}
+	// Queued deploy operations
+	protectedRouter.Handle("/operations", s.requireScope(models.ScopeStatus, s.handler.ListOperations)).Methods("GET")
+	protectedRouter.Handle("/operations/{operation_id}", s.requireScope(models.ScopeStatus, s.handler.GetOperation)).Methods("GET")
+
+	// Release pipelines
+	protectedRouter.Handle("/pipelines", s.requireScope(models.ScopeDeploy, s.handler.CreatePipeline)).Methods("POST")
+	protectedRouter.Handle("/pipelines/{tag_id}", s.requireScope(models.ScopeStatus, s.handler.PipelineStatus)).Methods("GET")
+
+	// Environment promotion between clusters
+	protectedRouter.Handle("/promote", s.requireScope(models.ScopeDeploy, s.handler.PromoteEnvironment)).Methods("POST")
+
+	// Canary promotion and manual approval gates
+	protectedRouter.Handle("/deployments/{tag_id}/promote", s.requireScope(models.ScopeDeploy, s.handler.PromoteDeployment)).Methods("POST")
+	protectedRouter.Handle("/deployments/{tag_id}/fail", s.requireScope(models.ScopeRollback, s.handler.FailDeployment)).Methods("POST")
+
+	// Job operations
+	protectedRouter.Handle("/jobs/{service}/stop", s.requireScope(models.ScopeOperate, s.handler.StopJob)).Methods("POST")
+	protectedRouter.Handle("/jobs/{service}/scale", s.requireScope(models.ScopeOperate, s.handler.ScaleJob)).Methods("POST")
+	protectedRouter.Handle("/jobs/{service}/restart", s.requireScope(models.ScopeOperate, s.handler.RestartJob)).Methods("POST")
+
+	// Parameterized and periodic job runs
+	protectedRouter.Handle("/dispatch/{job}", s.requireScope(models.ScopeDispatch, s.handler.DispatchJob)).Methods("POST")
+	protectedRouter.Handle("/periodic/{job}/force", s.requireScope(models.ScopeDispatch, s.handler.ForcePeriodicJob)).Methods("POST")
+
+	// API key administration
+	protectedRouter.Handle("/admin/keys", s.requireScope(models.ScopeAdmin, s.handler.CreateAPIKey)).Methods("POST")
+	protectedRouter.Handle("/admin/keys", s.requireScope(models.ScopeAdmin, s.handler.ListAPIKeys)).Methods("GET")
+	protectedRouter.Handle("/admin/keys/{key_id}", s.requireScope(models.ScopeAdmin, s.handler.RevokeAPIKey)).Methods("DELETE")
 
-		// Continue to next handler
-		next.ServeHTTP(w, r)
-	})
 }
 
 // newRelicMiddleware wraps HTTP handlers with New Relic monitoring
@@ -125,17 +187,52 @@ func (s *Server) newRelicMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// Handler returns the server's HTTP handler with all routes and middleware
+func (s *Server) Handler() http.Handler {
+	return s.router
+}
+
+// TLSConfig returns the server's TLS configuration, nil when it serves plain HTTP
+func (s *Server) TLSConfig() *tls.Config {
+	return s.httpServer.TLSConfig
+}
+
+// Start runs the background workers and serves HTTP, or HTTPS when TLS is configured,
+// until Shutdown is called
 func (s *Server) Start() error {
-	s.logger.WithField("port", s.config.Port).Info("Server starting1")
+	s.logger.WithFields(logrus.Fields{
+		"port": s.config.Port,
+		"tls":  s.httpServer.TLSConfig != nil,
+	}).Info("Server starting")
+
+	s.handler.Queue().Start()
+	s.reconciler.Start()
+	for _, w := range s.watchers {
+		w.Start()
+	}
 
-	// Create server with timeouts for security
-	srv := &http.Server{
-		Addr:         ":" + s.config.Port,
-		Handler:      s.router,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	var err error
+	if s.httpServer.TLSConfig != nil {
+		// The certificate comes from the TLS config so it can be reloaded
+		err = s.httpServer.ListenAndServeTLS("", "")
+	} else {
+		err = s.httpServer.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
 	}
+	return err
+}
 
-	return srv.ListenAndServe()
+// Shutdown stops accepting requests, drains in-flight ones and stops the background workers
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.logger.Info("Server shutting down")
+
+	err := s.httpServer.Shutdown(ctx)
+	s.handler.Queue().Stop()
+	s.reconciler.Stop()
+	for _, w := range s.watchers {
+		w.Stop()
+	}
+	return err
 }
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"shipper-deployment/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// certReloader serves the current certificate and client CA pool, reloading them when the
+// files change. Files are checked at most once per interval, during TLS handshakes.
+type certReloader struct {
+	cfg    *config.TLSConfig
+	logger *logrus.Entry
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+	checkedAt time.Time
+}
+
+// newCertReloader loads the certificate and client CA files, failing when they are unusable
+func newCertReloader(cfg *config.TLSConfig, logger *logrus.Entry) (*certReloader, error) {
+	r := &certReloader{
+		cfg:    cfg,
+		logger: logger,
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	r.checkedAt = time.Now()
+	return r, nil
+}
+
+// tlsConfig returns the server TLS configuration backed by the reloader
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, clientCAs := r.current()
+			clientAuth := tls.NoClientCert
+			if clientCAs != nil {
+				clientAuth = tls.VerifyClientCertIfGiven
+				if r.cfg.RequireClientCert {
+					clientAuth = tls.RequireAndVerifyClientCert
+				}
+			}
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientCAs:    clientCAs,
+				ClientAuth:   clientAuth,
+			}, nil
+		},
+	}
+}
+
+// current returns the loaded certificate and CA pool, reloading them first if a file changed
+func (r *certReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checkedAt) >= r.cfg.ReloadInterval {
+		r.checkedAt = time.Now()
+		if r.changed() {
+			// Keep serving the previous certificate when the new files are incomplete or invalid
+			if err := r.load(); err != nil {
+				r.logger.WithError(err).Error("Failed to reload TLS certificates, keeping the previous ones")
+			} else {
+				r.logger.WithField("cert_file", r.cfg.CertFile).Info("Reloaded TLS certificates")
+			}
+		}
+	}
+	return r.cert, r.clientCAs
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+	return files
+}
+
+func (r *certReloader) changed() bool {
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *certReloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %v", file, err)
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS key pair: %v", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile) // #nosec G304 - path comes from operator configuration
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file: %v", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client CA file %s", r.cfg.ClientCAFile)
+		}
+	}
+
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	return nil
+}
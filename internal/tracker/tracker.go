@@ -0,0 +1,315 @@
+package tracker
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/locks"
+	"shipper-deployment/internal/logger"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+	"shipper-deployment/internal/rollback"
+
+	"github.com/sirupsen/logrus"
+)
+
+// nomadAutoRevertDescription is part of the status description of a Nomad deployment that failed
+// and was reverted by the job's own auto_revert
+const nomadAutoRevertDescription = "rolling back to job version"
+
+// AutoRollbackIdentity is recorded as the trigger of rollbacks the tracker starts
+const AutoRollbackIdentity = "shipper:auto_rollback"
+
+// Tracker refreshes deployment rows from Nomad and records status transitions.
+// It is shared by the status endpoint and the background reconciler so both
+// apply the same rules when a deployment changes state.
+type Tracker struct {
+	db       *sql.DB
+	clusters *nomad.Clusters
+	config   *config.Config
+	rollback *rollback.Service
+	logger   *logrus.Entry
+
+	// rollbackMu serialises automatic rollbacks so concurrent refreshes revert at most once
+	rollbackMu sync.Mutex
+}
+
+// New creates a tracker. Automatic rollbacks take the service's deploy lock from deployLocks when
+// it is set.
+func New(db *sql.DB, clusters *nomad.Clusters, cfg *config.Config, deployLocks *locks.Manager) *Tracker {
+	return &Tracker{
+		db:       db,
+		clusters: clusters,
+		config:   cfg,
+		rollback: rollback.NewService(db, clusters, deployLocks),
+		logger:   logger.WithModule("tracker"),
+	}
+}
+
+// Refresh queries Nomad for the rollout state of a deployment and persists any status change.
+// Deployments that are already terminal or have not been submitted yet are returned unchanged.
+func (t *Tracker) Refresh(deployment models.Deployment) (*models.DeploymentHealth, error) {
+	health, _, err := t.refresh(deployment, nil)
+	return health, err
+}
+
+// WaitForChange is Refresh as a Nomad blocking query: it returns once the rollout changes
+// past opts.WaitIndex or opts.WaitTime elapses, together with the index to wait on next.
+// A nil opts refreshes without blocking.
+func (t *Tracker) WaitForChange(deployment models.Deployment, opts *nomad.QueryOptions) (*models.DeploymentHealth, uint64, error) {
+	return t.refresh(deployment, opts)
+}
+
+func (t *Tracker) refresh(deployment models.Deployment, opts *nomad.QueryOptions) (*models.DeploymentHealth, uint64, error) {
+	if models.IsTerminalStatus(deployment.Status) || (deployment.JobID == "" && len(deployment.Clusters) == 0) {
+		return &models.DeploymentHealth{
+			Status:       deployment.Status,
+			DeploymentID: deployment.DeploymentID,
+			NomadJobID:   deployment.NomadJobID,
+			Clusters:     deployment.Clusters,
+		}, 0, nil
+	}
+	if len(deployment.Clusters) > 0 {
+		health, err := t.refreshClusters(deployment)
+		return health, 0, err
+	}
+
+	scope := t.JobScope(deployment)
+	client, err := t.clusters.Client(scope.Cluster)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		health *models.DeploymentHealth
+		meta   *nomad.QueryMeta
+	)
+	if models.IsJobRun(deployment.Action) {
+		// Dispatched and periodic child jobs are batch runs without a Nomad deployment
+		health, meta, err = client.GetJobRunHealthWithOptions(deployment.NomadJobID, scope, opts)
+	} else {
+		health, meta, err = client.GetDeploymentHealthWithOptions(deployment.JobID, scope, opts)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var lastIndex uint64
+	if meta != nil {
+		lastIndex = meta.LastIndex
+	}
+
+	// The revert runs before the status is stored: a failed deployment whose revert did not go
+	// through stays non-terminal, so the next refresh retries it
+	if t.shouldAutoRollback(deployment, health) {
+		if err := t.autoRollback(deployment, health); err != nil && health.Status == models.StatusFailed {
+			health.Status = deployment.Status
+			health.Description = fmt.Sprintf("Deployment failed, automatic rollback will be retried: %v", err)
+		}
+	}
+
+	if health.Status != deployment.Status || health.DeploymentID != deployment.DeploymentID ||
+		(health.NomadJobID != "" && health.NomadJobID != deployment.NomadJobID) ||
+		(len(health.Tasks) > 0 && !reflect.DeepEqual(health.Tasks, deployment.Tasks)) {
+		if err := database.UpdateDeploymentHealth(t.db, deployment.TagID, health); err != nil {
+			t.logger.WithError(err).WithField("tag_id", deployment.TagID).Error("Failed to update deployment status")
+			return health, lastIndex, err
+		}
+
+		if health.Status != deployment.Status {
+			t.logger.WithFields(logrus.Fields{
+				"tag_id":        deployment.TagID,
+				"service_name":  deployment.ServiceName,
+				"from_status":   deployment.Status,
+				"to_status":     health.Status,
+				"deployment_id": health.DeploymentID,
+			}).Info("Deployment status changed")
+		}
+	}
+
+	return health, lastIndex, nil
+}
+
+// refreshClusters refreshes each cluster of a fan-out deploy that is still rolling out and
+// records their combined status. A cluster Nomad cannot be reached for keeps its last state.
+// Fan-out deploys are never long-polled, the clusters change independently.
+func (t *Tracker) refreshClusters(deployment models.Deployment) (*models.DeploymentHealth, error) {
+	clusters := append([]models.ClusterDeployment(nil), deployment.Clusters...)
+	changed := false
+	for i := range clusters {
+		cluster := &clusters[i]
+		if models.IsTerminalStatus(cluster.Status) || cluster.JobID == "" {
+			continue
+		}
+
+		log := t.logger.WithFields(logrus.Fields{
+			"tag_id":  deployment.TagID,
+			"cluster": cluster.Cluster,
+		})
+		client, err := t.clusters.Client(cluster.Cluster)
+		if err != nil {
+			log.WithError(err).Warn("Failed to refresh cluster of fan-out deployment")
+			continue
+		}
+		health, err := client.GetDeploymentHealth(cluster.JobID, nomad.JobScope{Namespace: cluster.Namespace, Region: cluster.Region})
+		if err != nil {
+			log.WithError(err).Warn("Failed to refresh cluster of fan-out deployment")
+			continue
+		}
+
+		if health.Status != cluster.Status || health.DeploymentID != cluster.DeploymentID || health.Description != cluster.Message {
+			cluster.Status = health.Status
+			cluster.DeploymentID = health.DeploymentID
+			cluster.Message = health.Description
+			changed = true
+		}
+	}
+
+	status := models.FanOutStatus(clusters)
+	if changed || status != deployment.Status {
+		if err := database.UpdateClusterDeployments(t.db, deployment.TagID, status, clusters); err != nil {
+			t.logger.WithError(err).WithField("tag_id", deployment.TagID).Error("Failed to update deployment status")
+			return nil, err
+		}
+
+		if status != deployment.Status {
+			t.logger.WithFields(logrus.Fields{
+				"tag_id":       deployment.TagID,
+				"service_name": deployment.ServiceName,
+				"from_status":  deployment.Status,
+				"to_status":    status,
+			}).Info("Deployment status changed")
+		}
+	}
+
+	return &models.DeploymentHealth{Status: status, NomadJobID: deployment.NomadJobID, Clusters: clusters}, nil
+}
+
+// JobScope returns where the job of a deployment lives. Rows recorded before namespaces were
+// stored fall back to the services config.
+func (t *Tracker) JobScope(deployment models.Deployment) nomad.JobScope {
+	if deployment.Namespace != "" || deployment.Region != "" || t.config == nil {
+		return nomad.JobScope{Cluster: deployment.Cluster, Namespace: deployment.Namespace, Region: deployment.Region}
+	}
+	service := t.config.Service(deployment.ServiceName)
+	return nomad.JobScope{Cluster: deployment.Cluster, Namespace: service.Namespace, Region: service.Region}
+}
+
+// shouldAutoRollback applies the service's auto rollback policy to a refreshed deployment.
+// Only deploys Shipper triggered to a single cluster are reverted, never rollbacks themselves.
+func (t *Tracker) shouldAutoRollback(deployment models.Deployment, health *models.DeploymentHealth) bool {
+	if deployment.Action != models.ActionDeploy || len(deployment.Clusters) > 0 || t.config == nil {
+		return false
+	}
+
+	policy := t.config.Service(deployment.ServiceName).AutoRollback
+	if policy == nil || !policy.Enabled {
+		return false
+	}
+
+	if health.Status == models.StatusFailed {
+		return true
+	}
+
+	if models.IsTerminalStatus(health.Status) || policy.HealthDeadline.Duration <= 0 {
+		return false
+	}
+
+	if time.Since(deployment.CreatedAt) < policy.HealthDeadline.Duration {
+		return false
+	}
+
+	// The deadline has passed without success; tolerate it only if enough allocations are healthy
+	return policy.MinHealthyPercent == 0 || healthyPercent(health) < policy.MinHealthyPercent
+}
+
+// autoRollback reverts the job to the version of its last successful deployment and marks
+// the deployment as rolled back. It returns an error when the revert should be retried; a
+// deployment without an earlier successful one, or one Nomad's auto_revert already reverted,
+// is left alone.
+func (t *Tracker) autoRollback(deployment models.Deployment, health *models.DeploymentHealth) error {
+	t.rollbackMu.Lock()
+	defer t.rollbackMu.Unlock()
+
+	log := t.logger.WithFields(logrus.Fields{
+		"tag_id":       deployment.TagID,
+		"service_name": deployment.ServiceName,
+		"status":       health.Status,
+	})
+
+	// Another refresh may have rolled this deployment back while we waited
+	current, err := database.GetDeploymentRecord(t.db, deployment.TagID)
+	if err != nil {
+		log.WithError(err).Error("Failed to reload deployment before automatic rollback")
+		return err
+	}
+	if current.Status == models.StatusRolledBack {
+		health.Status = models.StatusRolledBack
+		return nil
+	}
+
+	// Reverting again would add a second job version on top of Nomad's own revert
+	if strings.Contains(health.Description, nomadAutoRevertDescription) {
+		log.WithField("description", health.Description).Info("Automatic rollback skipped, Nomad's auto_revert already reverted the job")
+		return nil
+	}
+
+	jobID := health.NomadJobID
+	if jobID == "" {
+		jobID = deployment.NomadJobID
+	}
+	if jobID == "" {
+		jobID = deployment.ServiceName
+	}
+
+	scope := t.JobScope(deployment)
+	lastGood, err := database.GetLastSuccessfulDeployment(t.db, jobID, scope.Cluster, scope.Namespace, deployment.TagID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Automatic rollback skipped, no earlier successful deployment to return to")
+			return nil
+		}
+		log.WithError(err).Error("Failed to find last successful deployment")
+		return err
+	}
+
+	log.WithFields(logrus.Fields{
+		"restore_tag_id":  lastGood.TagID,
+		"restore_version": lastGood.JobVersion,
+	}).Warn("Deployment unhealthy, rolling back automatically")
+
+	result, err := t.rollback.RevertToVersion(jobID, scope, lastGood.JobVersion, deployment.TagID, AutoRollbackIdentity)
+	if err != nil {
+		log.WithError(err).Error("Automatic rollback failed")
+		return err
+	}
+
+	// The revert is submitted, retrying would revert twice
+	if err := database.MarkDeploymentRolledBack(t.db, deployment.TagID, lastGood.JobVersion); err != nil {
+		log.WithError(err).Error("Failed to mark deployment as rolled back")
+	}
+	health.Status = models.StatusRolledBack
+
+	log.WithField("rollback_tag_id", result.Deployment.TagID).Info("Automatic rollback submitted")
+	return nil
+}
+
+// healthyPercent returns the share of desired allocations that are healthy across all task groups
+func healthyPercent(health *models.DeploymentHealth) int {
+	desired, healthy := 0, 0
+	for _, group := range health.TaskGroups {
+		desired += group.DesiredTotal
+		healthy += group.HealthyAllocs
+	}
+	if desired == 0 {
+		return 0
+	}
+	return healthy * 100 / desired
+}
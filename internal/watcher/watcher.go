@@ -0,0 +1,215 @@
+package watcher
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/logger"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+	"shipper-deployment/internal/tracker"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// Watcher follows the event stream of one Nomad cluster and refreshes the
+// deployments Shipper submitted there as soon as one of their jobs,
+// evaluations, deployments or allocations changes.
+type Watcher struct {
+	db      *sql.DB
+	cluster string
+	nomad   *nomad.Client
+	tracker *tracker.Tracker
+	logger  *logrus.Entry
+
+	mu        sync.Mutex
+	lastIndex uint64
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// New returns a watcher for the named cluster, "" being the default one
+func New(db *sql.DB, cluster string, nomadClient *nomad.Client, deploymentTracker *tracker.Tracker) *Watcher {
+	return &Watcher{
+		db:      db,
+		cluster: cluster,
+		nomad:   nomadClient,
+		tracker: deploymentTracker,
+		logger:  logger.WithModule("watcher"),
+	}
+}
+
+// Start connects to the event stream in the background and keeps reconnecting until Stop
+func (w *Watcher) Start() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.done = make(chan struct{})
+
+	w.logger.WithFields(logrus.Fields{
+		"cluster": w.cluster,
+		"topics":  nomad.DeploymentEventTopics,
+	}).Info("Starting Nomad event watcher")
+
+	go w.run(ctx)
+}
+
+// Stop closes the event stream and waits for the watcher to exit
+func (w *Watcher) Stop() {
+	w.mu.Lock()
+	cancel, done := w.cancel, w.done
+	w.cancel = nil
+	w.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+
+	cancel()
+	<-done
+	w.logger.Info("Nomad event watcher stopped")
+}
+
+// LastIndex returns the index of the last event batch handled
+func (w *Watcher) LastIndex() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastIndex
+}
+
+func (w *Watcher) run(ctx context.Context) {
+	defer close(w.done)
+
+	backoff := minBackoff
+	for {
+		// Resume after the last batch handled so reconnects neither skip nor replay events
+		startIndex := w.LastIndex()
+		if startIndex > 0 {
+			startIndex++
+		}
+
+		lastIndex, err := w.nomad.StreamEvents(ctx, startIndex, nomad.DeploymentEventTopics, w.HandleBatch)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if lastIndex > startIndex {
+			// The stream delivered events, so the connection itself was healthy
+			backoff = minBackoff
+		}
+
+		w.logger.WithError(err).WithFields(logrus.Fields{
+			"last_index": w.LastIndex(),
+			"retry_in":   backoff.String(),
+		}).Warn("Nomad event stream disconnected, reconnecting")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// HandleBatch refreshes every active deployment referenced by the events in the batch
+func (w *Watcher) HandleBatch(batch models.NomadEventBatch) {
+	defer func() {
+		w.mu.Lock()
+		if batch.Index > w.lastIndex {
+			w.lastIndex = batch.Index
+		}
+		w.mu.Unlock()
+	}()
+
+	// keys maps each referenced ID to the namespaces of the events referencing it
+	keys := make(map[string]map[string]bool)
+	addKey := func(key, namespace string) {
+		if key == "" {
+			return
+		}
+		if keys[key] == nil {
+			keys[key] = make(map[string]bool)
+		}
+		keys[key][namespace] = true
+	}
+	for _, event := range batch.Events {
+		addKey(event.Key, event.Namespace)
+		for _, key := range event.FilterKeys {
+			addKey(key, event.Namespace)
+		}
+	}
+
+	if len(keys) == 0 {
+		return
+	}
+
+	deployments, err := database.ListActiveDeployments(w.db)
+	if err != nil {
+		w.logger.WithError(err).Error("Failed to list active deployments")
+		return
+	}
+
+	for _, deployment := range deployments {
+		if !matchesEvent(keys, w.cluster, deployment) {
+			continue
+		}
+
+		w.logger.WithFields(logrus.Fields{
+			"tag_id": deployment.TagID,
+			"index":  batch.Index,
+		}).Debug("Event matched tracked deployment")
+
+		if _, err := w.tracker.Refresh(deployment); err != nil {
+			w.logger.WithError(err).WithField("tag_id", deployment.TagID).Warn("Failed to refresh deployment from event")
+		}
+	}
+}
+
+// matchesEvent reports whether a deployment in the watched cluster is referenced by the events'
+// keys. Job IDs are only unique within a namespace, so a deployment with a recorded namespace
+// ignores events from others. A fan-out deploy matches through the part submitted to the cluster.
+func matchesEvent(keys map[string]map[string]bool, cluster string, deployment models.Deployment) bool {
+	if len(deployment.Clusters) == 0 {
+		return deployment.Cluster == cluster && matchesIDs(keys, deployment.Namespace,
+			deployment.JobID, deployment.DeploymentID, deployment.NomadJobID, deployment.ServiceName)
+	}
+	for _, part := range deployment.Clusters {
+		if part.Cluster == cluster && matchesIDs(keys, part.Namespace, part.JobID, part.DeploymentID, deployment.ServiceName) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesIDs reports whether any of the IDs is referenced by an event in the namespace
+func matchesIDs(keys map[string]map[string]bool, namespace string, ids ...string) bool {
+	for _, id := range ids {
+		namespaces := keys[id]
+		if id == "" || namespaces == nil {
+			continue
+		}
+		if namespace == "" || namespaces[namespace] || namespaces[""] {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,12 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"shipper-deployment/internal/config"
 	"shipper-deployment/internal/database"
 	"shipper-deployment/internal/logger"
@@ -28,7 +34,20 @@ func main() {
 
 	// Create and start server with New Relic app
 	srv := server.NewServer(cfg, db, nrApp)
-	if err := srv.Start(); err != nil {
-		appLogger.Fatal("Server failed to start:", err)
+	go func() {
+		if err := srv.Start(); err != nil {
+			appLogger.Fatal("Server failed to start:", err)
+		}
+	}()
+
+	// Wait for a termination signal and shut down gracefully
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		appLogger.WithError(err).Error("Server shutdown failed")
 	}
 }
@@ -0,0 +1,155 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/handlers"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+)
+
+func TestServiceAllowlist(t *testing.T) {
+	var (
+		fetchQuery  string
+		submitQuery string
+		submitted   map[string]map[string]interface{}
+		parsedJob   = `{"ID": "payments-api", "Name": "payments-api", "Namespace": "default", "Region": "global"}`
+	)
+	server := newFakeNomad(t, map[string]interface{}{
+		"/v1/job/payments": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fetchQuery = r.URL.RawQuery
+			_, _ = w.Write([]byte(`{"ID": "payments", "Namespace": "payments", "Region": "eu"}`))
+		}),
+		"/v1/job/shipper": map[string]interface{}{"ID": "shipper"},
+		"/v1/jobs/parse": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(parsedJob))
+		}),
+		"/v1/jobs": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			submitQuery = r.URL.RawQuery
+			submitted = nil
+			_ = json.NewDecoder(r.Body).Decode(&submitted)
+			_, _ = w.Write([]byte(`{"EvalID": "eval-allow"}`))
+		}),
+	})
+
+	cfg := &config.Config{
+		NomadURL:                server.URL,
+		EnforceServiceAllowlist: true,
+		Services: map[string]config.ServiceConfig{
+			"payments": {Namespace: "payments", Region: "eu"},
+			"payments-*": {
+				Namespace:    "payments",
+				AllowJobFile: true,
+				JobIDs:       []string{"payments-api", "payments-worker"},
+			},
+			"billing": {AllowJobFile: true},
+		},
+	}
+	db := setupTestDB(t)
+	handler := handlers.NewHandler(db, cfg, nomad.NewClient(server.URL, true, "test-token"))
+
+	deploy := func(service, tagID string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.DeploymentRequest{ServiceName: service, TagID: tagID})
+		rr := httptest.NewRecorder()
+		handler.Deploy(rr, httptest.NewRequest("POST", "/deploy", bytes.NewBuffer(body)))
+		return rr
+	}
+	deployJob := func(service, tagID string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.JobDeploymentRequest{TagID: tagID, ServiceName: service, JobFile: `job "payments-api" {}`})
+		req := httptest.NewRequest("POST", "/deploy/job", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		handler.DeployJob(rr, req)
+		return rr
+	}
+
+	t.Run("listed service deploys in its namespace and region", func(t *testing.T) {
+		if rr := deploy("payments", "allow-1"); rr.Code != http.StatusOK {
+			t.Fatalf("Deploy returned %d: %s", rr.Code, rr.Body.String())
+		}
+		if fetchQuery != "namespace=payments&region=eu" || submitQuery != "namespace=payments&region=eu" {
+			t.Errorf("Job fetched with %q and submitted with %q", fetchQuery, submitQuery)
+		}
+	})
+
+	t.Run("unlisted service is refused", func(t *testing.T) {
+		rr := deploy("shipper", "allow-2")
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("Expected 403, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if _, err := database.GetDeploymentRecord(db, "allow-2"); err == nil {
+			t.Error("A refused deploy was recorded")
+		}
+	})
+
+	t.Run("job file uses the glob entry and its namespace", func(t *testing.T) {
+		rr := deployJob("", "allow-3")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("DeployJob returned %d: %s", rr.Code, rr.Body.String())
+		}
+		if submitted["Job"]["Namespace"] != "payments" || submitted["Job"]["Region"] != "global" {
+			t.Errorf("Job submitted to %v/%v", submitted["Job"]["Namespace"], submitted["Job"]["Region"])
+		}
+	})
+
+	t.Run("job file uploads need allow_job_file", func(t *testing.T) {
+		if rr := deployJob("payments", "allow-4"); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("job file must declare an allowed job ID", func(t *testing.T) {
+		// billing only allows a job file declaring the billing job
+		if rr := deployJob("billing", "allow-5"); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("job file declaring another namespace is refused", func(t *testing.T) {
+		parsedJob = `{"ID": "payments-api", "Name": "payments-api", "Namespace": "infra"}`
+		defer func() { parsedJob = `{"ID": "payments-api", "Name": "payments-api"}` }()
+		if rr := deployJob("", "allow-6"); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("nothing is refused when the allowlist is not enforced", func(t *testing.T) {
+		cfg.EnforceServiceAllowlist = false
+		defer func() { cfg.EnforceServiceAllowlist = true }()
+		if rr := deploy("shipper", "allow-7"); rr.Code != http.StatusOK {
+			t.Errorf("Deploy returned %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+}
+
+func TestLookupService(t *testing.T) {
+	cfg := &config.Config{Services: map[string]config.ServiceConfig{
+		"api":        {Namespace: "exact"},
+		"api-*":      {Namespace: "glob"},
+		"api-edge-*": {Namespace: "longer-glob"},
+		"*":          {Namespace: "default"},
+	}}
+
+	for name, want := range map[string]string{
+		"api":         "exact",
+		"api-worker":  "glob",
+		"api-edge-eu": "longer-glob",
+		"billing":     "default",
+	} {
+		service, ok := cfg.LookupService(name)
+		if !ok || service.Namespace != want {
+			t.Errorf("LookupService(%q) = %q, %v; want %q", name, service.Namespace, ok, want)
+		}
+	}
+
+	delete(cfg.Services, "*")
+	if _, ok := cfg.LookupService("billing"); ok {
+		t.Error("Expected no entry without a * fallback")
+	}
+}
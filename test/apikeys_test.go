@@ -0,0 +1,249 @@
+package test
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/server"
+)
+
+// newServerRouter returns the full router, middleware included, of a server using db
+func newServerRouter(t *testing.T, cfg *config.Config, db *sql.DB) http.Handler {
+	t.Helper()
+	return server.NewServer(cfg, db, nil).Handler()
+}
+
+func TestAPIKeys(t *testing.T) {
+	const sharedSecret = "test-secret-key-64-characters-long-for-testing-purposes"
+	nomadServer := newFakeNomad(t, map[string]interface{}{
+		"/v1/evaluation/eval-1": map[string]interface{}{"ID": "eval-1", "Status": "pending", "JobID": "payments"},
+	})
+	db := setupTestDB(t)
+	cfg := &config.Config{
+		NomadURL:            nomadServer.URL,
+		ValidSecret:         sharedSecret,
+		SharedSecretEnabled: true,
+		SkipTLSVerify:       true,
+	}
+	router := newServerRouter(t, cfg, db)
+
+	call := func(method, path, credential string, body interface{}) *httptest.ResponseRecorder {
+		var payload bytes.Buffer
+		if body != nil {
+			_ = json.NewEncoder(&payload).Encode(body)
+		}
+		req := httptest.NewRequest(method, path, &payload)
+		req.Header.Set("Content-Type", "application/json")
+		if credential != "" {
+			req.Header.Set("Authorization", "Bearer "+credential)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if err := database.InsertDeployment(db, "pay-1", "payments", "eval-1", models.StatusRunning); err != nil {
+		t.Fatalf("InsertDeployment failed: %v", err)
+	}
+	if err := database.InsertDeployment(db, "other-1", "billing", "eval-1", models.StatusSuccessful); err != nil {
+		t.Fatalf("InsertDeployment failed: %v", err)
+	}
+
+	// The shared secret bootstraps the first key
+	rr := call("POST", "/admin/keys", sharedSecret, models.CreateAPIKeyRequest{
+		Name:      "ci-payments",
+		Owner:     "payments-team",
+		Scopes:    []string{models.ScopeStatus},
+		Services:  []string{"pay*"},
+		ExpiresIn: "24h",
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Create key returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var created models.CreateAPIKeyResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to unmarshal key: %v", err)
+	}
+	if !strings.HasPrefix(created.Key, "shk_"+created.ID+"_") || created.ExpiresAt == nil {
+		t.Fatalf("Unexpected key: %+v", created)
+	}
+
+	t.Run("scoped key can read its own service", func(t *testing.T) {
+		if rr := call("GET", "/status/pay-1", created.Key, nil); rr.Code != http.StatusOK {
+			t.Errorf("Status returned %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("scoped key is denied other services", func(t *testing.T) {
+		if rr := call("GET", "/status/other-1", created.Key, nil); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", rr.Code)
+		}
+	})
+
+	t.Run("scoped key sees only its services' operations", func(t *testing.T) {
+		for _, operation := range []*models.Operation{
+			{ID: "op-pay", Kind: models.OperationDeploy, Status: models.OperationSucceeded, TagID: "pay-1"},
+			{ID: "op-other", Kind: models.OperationDeploy, Status: models.OperationSucceeded, TagID: "other-1"},
+			{ID: "op-unknown", Kind: models.OperationDeployJob, Status: models.OperationFailed},
+			{ID: "op-own", Kind: models.OperationDeployJob, Status: models.OperationFailed, TriggeredBy: "api_key:ci-payments/" + created.ID},
+		} {
+			if err := database.InsertOperation(db, operation); err != nil {
+				t.Fatalf("InsertOperation failed: %v", err)
+			}
+		}
+
+		for id, want := range map[string]int{
+			"op-pay":     http.StatusOK,
+			"op-other":   http.StatusForbidden,
+			"op-unknown": http.StatusForbidden,
+			"op-own":     http.StatusOK,
+		} {
+			if rr := call("GET", "/operations/"+id, created.Key, nil); rr.Code != want {
+				t.Errorf("Operation %s returned %d, want %d: %s", id, rr.Code, want, rr.Body.String())
+			}
+		}
+
+		rr := call("GET", "/operations", created.Key, nil)
+		var listed models.OperationsResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil {
+			t.Fatalf("Failed to unmarshal operations %q: %v", rr.Body.String(), err)
+		}
+		var ids []string
+		for _, operation := range listed.Operations {
+			ids = append(ids, operation.ID)
+		}
+		sort.Strings(ids)
+		if !reflect.DeepEqual(ids, []string{"op-own", "op-pay"}) {
+			t.Errorf("Listed operations %v", ids)
+		}
+		if rr := call("GET", "/operations/op-other", sharedSecret, nil); rr.Code != http.StatusOK {
+			t.Errorf("Unrestricted credential got %d", rr.Code)
+		}
+	})
+
+	t.Run("scoped key lacks deploy scope", func(t *testing.T) {
+		rr := call("POST", "/deploy", created.Key, models.DeploymentRequest{ServiceName: "payments", TagID: "pay-2"})
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", rr.Code)
+		}
+	})
+
+	t.Run("non-admin cannot manage keys", func(t *testing.T) {
+		if rr := call("GET", "/admin/keys", created.Key, nil); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", rr.Code)
+		}
+	})
+
+	t.Run("invalid credentials do not leak the secret", func(t *testing.T) {
+		rr := call("GET", "/status/pay-1", "wrong", nil)
+		if rr.Code != http.StatusUnauthorized || strings.Contains(rr.Body.String(), sharedSecret) {
+			t.Errorf("Unexpected response %d: %s", rr.Code, rr.Body.String())
+		}
+		if rr := call("GET", "/status/pay-1", created.Key+"x", nil); rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for a tampered key, got %d", rr.Code)
+		}
+	})
+
+	t.Run("list and revoke", func(t *testing.T) {
+		rr := call("GET", "/admin/keys", sharedSecret, nil)
+		if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), created.Key) {
+			t.Fatalf("List returned %d: %s", rr.Code, rr.Body.String())
+		}
+		var keys []models.APIKey
+		_ = json.Unmarshal(rr.Body.Bytes(), &keys)
+		if len(keys) != 1 || keys[0].LastUsedAt == nil {
+			t.Errorf("Unexpected key list: %+v", keys)
+		}
+
+		if rr := call("DELETE", "/admin/keys/"+created.ID, sharedSecret, nil); rr.Code != http.StatusOK {
+			t.Fatalf("Revoke returned %d: %s", rr.Code, rr.Body.String())
+		}
+		if rr := call("GET", "/status/pay-1", created.Key, nil); rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 after revoke, got %d", rr.Code)
+		}
+		if rr := call("DELETE", "/admin/keys/"+created.ID, sharedSecret, nil); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 revoking twice, got %d", rr.Code)
+		}
+	})
+
+	t.Run("deployments record the key", func(t *testing.T) {
+		rr := call("POST", "/admin/keys", sharedSecret, models.CreateAPIKeyRequest{
+			Name:   "deployer",
+			Scopes: []string{models.ScopeDeploy},
+		})
+		var deployer models.CreateAPIKeyResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &deployer)
+
+		call("POST", "/deploy", deployer.Key, models.DeploymentRequest{ServiceName: "payments", TagID: "pay-3"})
+
+		deployment, err := database.GetDeploymentRecord(db, "pay-3")
+		if err != nil {
+			t.Fatalf("GetDeploymentRecord failed: %v", err)
+		}
+		if want := "api_key:deployer/" + deployer.ID; deployment.TriggeredBy != want {
+			t.Errorf("TriggeredBy = %q, want %q", deployment.TriggeredBy, want)
+		}
+	})
+
+	t.Run("job files may not declare a job outside the key's services", func(t *testing.T) {
+		local := *cfg
+		local.JobParser = config.JobParserLocal
+		router := newServerRouter(t, &local, db)
+
+		rr := call("POST", "/admin/keys", sharedSecret, models.CreateAPIKeyRequest{
+			Name:     "payments-deployer",
+			Scopes:   []string{models.ScopeDeploy},
+			Services: []string{"payments"},
+		})
+		var deployer models.CreateAPIKeyResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &deployer)
+
+		body, _ := json.Marshal(models.JobDeploymentRequest{
+			TagID:       "pay-foreign",
+			ServiceName: "payments",
+			JobFile: `job "shipper" {
+  datacenters = ["dc1"]
+  group "app" {
+    task "app" {
+      driver = "docker"
+      config {
+        image = "shipper:1.0.0"
+      }
+    }
+  }
+}`,
+		})
+		req := httptest.NewRequest("POST", "/deploy/job", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+deployer.Key)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "shipper") {
+			t.Errorf("Expected 403 for job shipper, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("shared secret can be disabled", func(t *testing.T) {
+		disabled := *cfg
+		disabled.SharedSecretEnabled = false
+		router := newServerRouter(t, &disabled, db)
+
+		req := httptest.NewRequest("GET", "/status/pay-1", nil)
+		req.Header.Set("X-Secret-Key", sharedSecret)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 with the shared secret disabled, got %d", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/handlers"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAsyncDeploy(t *testing.T) {
+	server := newFakeNomad(t, map[string]interface{}{
+		"/v1/job/api": map[string]interface{}{"ID": "api"},
+		"/v1/jobs": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"EvalID": "eval-async"}`))
+		}),
+		"/v1/job/broken": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "nomad is down", http.StatusInternalServerError)
+		}),
+	})
+
+	var db *sql.DB
+	newRouter := func(t *testing.T, cfg *config.Config) (*handlers.Handler, *mux.Router) {
+		cfg.NomadURL = server.URL
+		db = setupTestDB(t)
+		handler := handlers.NewHandler(db, cfg, nomad.NewClient(server.URL, true, "test-token"))
+		router := mux.NewRouter()
+		router.HandleFunc("/deploy", handler.Deploy).Methods("POST")
+		router.HandleFunc("/operations", handler.ListOperations).Methods("GET")
+		router.HandleFunc("/operations/{operation_id}", handler.GetOperation).Methods("GET")
+		return handler, router
+	}
+	deploy := func(router *mux.Router, req models.DeploymentRequest, async bool) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(req)
+		r := httptest.NewRequest("POST", "/deploy", bytes.NewBuffer(body))
+		if async {
+			r.Header.Set("Prefer", "respond-async")
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+		return rr
+	}
+	accepted := func(rr *httptest.ResponseRecorder) models.Operation {
+		t.Helper()
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("Expected 202, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var operation models.Operation
+		if err := json.Unmarshal(rr.Body.Bytes(), &operation); err != nil {
+			t.Fatalf("Failed to unmarshal operation: %v", err)
+		}
+		if location := rr.Header().Get("Location"); location != "/operations/"+operation.ID {
+			t.Errorf("Location = %q for operation %s", location, operation.ID)
+		}
+		return operation
+	}
+	// wait polls an operation until the queue ran it
+	wait := func(router *mux.Router, id string) models.Operation {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/operations/"+id, nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Operation lookup returned %d: %s", rr.Code, rr.Body.String())
+			}
+			var operation models.Operation
+			if err := json.Unmarshal(rr.Body.Bytes(), &operation); err != nil {
+				t.Fatalf("Failed to unmarshal operation: %v", err)
+			}
+			switch operation.Status {
+			case models.OperationWaiting, models.OperationSucceeded, models.OperationFailed:
+				return operation
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Operation %s still %s", id, operation.Status)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	t.Run("queued deploys succeed in the background", func(t *testing.T) {
+		handler, router := newRouter(t, &config.Config{DeployWorkers: 2, DeployQueueSize: 10})
+		handler.Queue().Start()
+		t.Cleanup(handler.Queue().Stop)
+
+		operation := accepted(deploy(router, models.DeploymentRequest{ServiceName: "api", TagID: "async-1"}, true))
+		if operation.Status != models.OperationQueued || operation.TagID != "async-1" || operation.Kind != models.OperationDeploy {
+			t.Errorf("Unexpected operation: %+v", operation)
+		}
+
+		operation = wait(router, operation.ID)
+		if operation.Status != models.OperationSucceeded || operation.HTTPStatus != http.StatusOK {
+			t.Fatalf("Unexpected operation: %+v", operation)
+		}
+		var response models.DeploymentResponse
+		if err := json.Unmarshal(operation.Result, &response); err != nil {
+			t.Fatalf("Failed to unmarshal result: %v", err)
+		}
+		if response.TagID != "async-1" || response.JobID != "eval-async" {
+			t.Errorf("Unexpected result: %+v", response)
+		}
+	})
+
+	t.Run("failed submissions fail the operation", func(t *testing.T) {
+		handler, router := newRouter(t, &config.Config{AsyncDeploys: true, DeployWorkers: 1, DeployQueueSize: 10})
+		handler.Queue().Start()
+		t.Cleanup(handler.Queue().Stop)
+
+		operation := wait(router, accepted(deploy(router, models.DeploymentRequest{ServiceName: "broken", TagID: "async-2"}, false)).ID)
+		if operation.Status != models.OperationFailed || !strings.Contains(operation.Error, "status: 500") {
+			t.Fatalf("Unexpected operation: %+v", operation)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/operations?status=failed", nil))
+		var list models.OperationsResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+			t.Fatalf("Failed to unmarshal operations: %v", err)
+		}
+		if len(list.Operations) != 1 || list.Operations[0].ID != operation.ID || list.Queue.Failed != 1 {
+			t.Errorf("Unexpected operations: %+v", list)
+		}
+	})
+
+	t.Run("deploys the deploy lock queued wait for their submission", func(t *testing.T) {
+		handler, router := newRouter(t, &config.Config{DeployWorkers: 1, DeployQueueSize: 10, DeployLockPolicy: config.LockQueue})
+		handler.Queue().Start()
+		t.Cleanup(handler.Queue().Stop)
+
+		if rr := deploy(router, models.DeploymentRequest{ServiceName: "api", TagID: "async-6"}, false); rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		operation := wait(router, accepted(deploy(router, models.DeploymentRequest{ServiceName: "api", TagID: "async-7"}, true)).ID)
+		if operation.Status != models.OperationWaiting || operation.HTTPStatus != http.StatusAccepted || operation.FinishedAt != nil {
+			t.Fatalf("Unexpected operation: %+v", operation)
+		}
+
+		// The deployment holding the lock finishes and the queued one is submitted
+		if err := database.UpdateDeploymentStatus(db, "async-6", models.StatusSuccessful); err != nil {
+			t.Fatalf("UpdateDeploymentStatus failed: %v", err)
+		}
+		handler.Locks().AdvanceAll(context.Background())
+
+		if operation = wait(router, operation.ID); operation.Status != models.OperationSucceeded || operation.FinishedAt == nil {
+			t.Errorf("Unexpected operation: %+v", operation)
+		}
+	})
+
+	t.Run("a full queue refuses deploys", func(t *testing.T) {
+		// The queue is not started, so the first deploy keeps the only slot
+		handler, router := newRouter(t, &config.Config{DeployWorkers: 1, DeployQueueSize: 1})
+		accepted(deploy(router, models.DeploymentRequest{ServiceName: "api", TagID: "async-3"}, true))
+
+		rr := deploy(router, models.DeploymentRequest{ServiceName: "api", TagID: "async-4"}, true)
+		if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") == "" {
+			t.Errorf("Expected 503 with Retry-After, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if stats := handler.Queue().Stats(); stats.Depth != 1 || stats.Capacity != 1 {
+			t.Errorf("Unexpected queue stats: %+v", stats)
+		}
+	})
+
+	t.Run("requests without a preference are answered directly", func(t *testing.T) {
+		_, router := newRouter(t, &config.Config{})
+		if rr := deploy(router, models.DeploymentRequest{ServiceName: "api", TagID: "async-5"}, false); rr.Code != http.StatusOK {
+			t.Errorf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/operations?status=lost", nil))
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "lost") {
+			t.Errorf("Expected 400 for an unknown status, got %d", rr.Code)
+		}
+	})
+}
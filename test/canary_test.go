@@ -0,0 +1,124 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+func TestCanaryPromotionAndFail(t *testing.T) {
+	var promoted, failed map[string]interface{}
+	server := newFakeNomad(t, map[string]interface{}{
+		"/v1/evaluation/eval-canary": map[string]interface{}{
+			"ID": "eval-canary", "Status": "complete", "JobID": "api", "DeploymentID": "dep-canary",
+		},
+		"/v1/deployment/dep-canary": map[string]interface{}{
+			"ID": "dep-canary", "JobID": "api", "Status": "running",
+			"TaskGroups": map[string]interface{}{
+				"web": map[string]interface{}{
+					"DesiredTotal": 4, "DesiredCanaries": 1, "PlacedCanaries": []string{"alloc-1"},
+					"PlacedAllocs": 1, "HealthyAllocs": 1,
+				},
+			},
+		},
+		"/v1/deployment/promote/dep-canary": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := json.NewDecoder(r.Body).Decode(&promoted); err != nil {
+				t.Errorf("Failed to decode promote body: %v", err)
+			}
+			_, _ = w.Write([]byte(`{"EvalID":"eval-promote"}`))
+		}),
+		"/v1/deployment/fail/dep-canary": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := json.NewDecoder(r.Body).Decode(&failed); err != nil {
+				t.Errorf("Failed to decode fail body: %v", err)
+			}
+			_, _ = w.Write([]byte(`{"EvalID":"eval-fail"}`))
+		}),
+	})
+	handler, db := setupTestHandlerWithNomad(t, server.URL)
+
+	if err := database.InsertDeployment(db, "canary-1", "api", "eval-canary", models.StatusRunning); err != nil {
+		t.Fatalf("InsertDeployment failed: %v", err)
+	}
+	if err := database.InsertDeployment(db, "done-1", "api", "eval-done", models.StatusSuccessful); err != nil {
+		t.Fatalf("InsertDeployment failed: %v", err)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/status/{tag_id}", handler.Status).Methods("GET")
+	router.HandleFunc("/deployments/{tag_id}/promote", handler.PromoteDeployment).Methods("POST")
+	router.HandleFunc("/deployments/{tag_id}/fail", handler.FailDeployment).Methods("POST")
+
+	t.Run("status reports awaiting promotion", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/status/canary-1", nil))
+
+		var response models.StatusResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if response.Status != models.StatusAwaitingPromotion {
+			t.Errorf("Status = %v, want %v", response.Status, models.StatusAwaitingPromotion)
+		}
+		if response.TaskGroups["web"].PlacedCanaries != 1 {
+			t.Errorf("PlacedCanaries = %d, want 1", response.TaskGroups["web"].PlacedCanaries)
+		}
+	})
+
+	t.Run("promote selected groups", func(t *testing.T) {
+		body, _ := json.Marshal(models.PromoteRequest{Groups: []string{"web"}})
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/deployments/canary-1/promote", bytes.NewBuffer(body)))
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Promote returned %d: %s", rr.Code, rr.Body.String())
+		}
+		if promoted["DeploymentID"] != "dep-canary" || promoted["All"] != false {
+			t.Errorf("Unexpected promote request: %v", promoted)
+		}
+
+		var response models.DeploymentActionResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if response.EvalID != "eval-promote" {
+			t.Errorf("EvalID = %v, want eval-promote", response.EvalID)
+		}
+	})
+
+	t.Run("fail deployment", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/deployments/canary-1/fail", nil))
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Fail returned %d: %s", rr.Code, rr.Body.String())
+		}
+		if failed["DeploymentID"] != "dep-canary" {
+			t.Errorf("Unexpected fail request: %v", failed)
+		}
+	})
+
+	t.Run("finished deployment cannot be promoted", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/deployments/done-1/promote", nil))
+
+		if rr.Code != http.StatusConflict {
+			t.Errorf("Expected status 409, got %d", rr.Code)
+		}
+	})
+
+	t.Run("unknown deployment", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/deployments/missing/fail", nil))
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rr.Code)
+		}
+	})
+}
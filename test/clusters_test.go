@@ -0,0 +1,240 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/handlers"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+
+	"github.com/gorilla/mux"
+)
+
+// fakeCluster is a Nomad cluster running the api job as one docker task, whose deployment of it
+// reports status. It records the image of the last submission and refuses submissions while
+// reject is set.
+type fakeCluster struct {
+	server      *httptest.Server
+	fetchQuery  string
+	submissions int
+	image       string
+	status      string
+	reject      bool
+}
+
+func newFakeCluster(t *testing.T, name string) *fakeCluster {
+	cluster := &fakeCluster{status: "running"}
+	cluster.server = newFakeNomad(t, map[string]interface{}{
+		"/v1/job/api": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cluster.fetchQuery = r.URL.RawQuery
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"ID": "api",
+				"TaskGroups": []interface{}{map[string]interface{}{
+					"Name": "web",
+					"Tasks": []interface{}{map[string]interface{}{
+						"Name":   "api",
+						"Driver": "docker",
+						"Config": map[string]interface{}{"image": "registry.example.com/api:0.9.0"},
+					}},
+				}},
+			})
+		}),
+		"/v1/jobs": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if cluster.reject {
+				http.Error(w, "job rejected", http.StatusInternalServerError)
+				return
+			}
+			var body struct {
+				Job struct {
+					TaskGroups []struct {
+						Tasks []struct {
+							Config map[string]interface{}
+						}
+					}
+				}
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("Failed to decode submission: %v", err)
+			}
+			cluster.submissions++
+			cluster.image, _ = body.Job.TaskGroups[0].Tasks[0].Config["image"].(string)
+			_, _ = w.Write([]byte(`{"EvalID": "eval-` + name + `"}`))
+		}),
+		"/v1/evaluation/eval-" + name: map[string]interface{}{"ID": "eval-" + name, "Status": "complete", "DeploymentID": "dep-" + name},
+		"/v1/deployment/dep-" + name: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"ID": "dep-` + name + `", "JobID": "api", "Status": "` + cluster.status + `"}`))
+		}),
+	})
+	return cluster
+}
+
+func TestMultipleClusters(t *testing.T) {
+	home, eu, us := newFakeCluster(t, "home"), newFakeCluster(t, "eu"), newFakeCluster(t, "us")
+	// A cluster Nomad refuses the job in, it knows no job to fetch
+	broken := newFakeNomad(t, map[string]interface{}{})
+
+	cfg := &config.Config{
+		NomadURL: home.server.URL,
+		Clusters: map[string]config.ClusterConfig{
+			"eu":     {URL: eu.server.URL, Region: "eu-west"},
+			"us":     {URL: us.server.URL},
+			"broken": {URL: broken.URL},
+		},
+	}
+	clusters := nomad.NewClusters(nomad.NewClient(home.server.URL, true, "test-token"))
+	clusters.Add("eu", nomad.NewClient(eu.server.URL, true, "eu-token"))
+	clusters.Add("us", nomad.NewClient(us.server.URL, true, "us-token"))
+	clusters.Add("broken", nomad.NewClient(broken.URL, true, "broken-token"))
+
+	db := setupTestDB(t)
+	handler := handlers.NewHandlerWithClusters(db, cfg, clusters)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/deploy", handler.Deploy).Methods("POST")
+	router.HandleFunc("/status/{tag_id}", handler.Status).Methods("GET")
+
+	deploy := func(req models.DeploymentRequest) (*httptest.ResponseRecorder, models.DeploymentResponse) {
+		payload, _ := json.Marshal(req)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/deploy", bytes.NewBuffer(payload)))
+
+		var response models.DeploymentResponse
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+		}
+		return rr, response
+	}
+	status := func(tagID string) models.StatusResponse {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/status/"+tagID, nil))
+		var response models.StatusResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal status %q: %v", rr.Body.String(), err)
+		}
+		return response
+	}
+
+	t.Run("cluster picks the target and its defaults", func(t *testing.T) {
+		rr, response := deploy(models.DeploymentRequest{ServiceName: "api", TagID: "eu-1", Cluster: "eu"})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Deploy returned %d: %s", rr.Code, rr.Body.String())
+		}
+		if eu.submissions != 1 || home.submissions != 0 || us.submissions != 0 {
+			t.Errorf("Submissions home=%d eu=%d us=%d", home.submissions, eu.submissions, us.submissions)
+		}
+		if eu.fetchQuery != "region=eu-west" || response.Cluster != "eu" || response.Region != "eu-west" {
+			t.Errorf("Fetched with %q, responded %+v", eu.fetchQuery, response)
+		}
+
+		eu.status = "successful"
+		if got := status("eu-1"); got.Status != models.StatusSuccessful || got.Cluster != "eu" {
+			t.Errorf("Unexpected status: %+v", got)
+		}
+	})
+
+	t.Run("fan-out tracks every cluster in one deployment", func(t *testing.T) {
+		eu.status, us.status = "running", "running"
+		rr, response := deploy(models.DeploymentRequest{ServiceName: "api", TagID: "all-1", Clusters: []string{"eu", "us"}})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Deploy returned %d: %s", rr.Code, rr.Body.String())
+		}
+		if response.Status != models.StatusRunning || len(response.Clusters) != 2 {
+			t.Fatalf("Unexpected response: %+v", response)
+		}
+
+		eu.status = "successful"
+		got := status("all-1")
+		if got.Status != models.StatusRunning || len(got.Clusters) != 2 {
+			t.Fatalf("Half finished fan-out reported as %+v", got)
+		}
+		if got.Clusters[0].Status != models.StatusSuccessful || got.Clusters[1].Status != models.StatusRunning {
+			t.Errorf("Unexpected clusters: %+v", got.Clusters)
+		}
+
+		us.status = "successful"
+		if got := status("all-1"); got.Status != models.StatusSuccessful {
+			t.Errorf("Finished fan-out reported as %+v", got)
+		}
+
+		deployment, err := database.GetDeploymentRecord(db, "all-1")
+		if err != nil {
+			t.Fatalf("GetDeploymentRecord failed: %v", err)
+		}
+		if deployment.Status != models.StatusSuccessful || deployment.Clusters[1].DeploymentID != "dep-us" {
+			t.Errorf("Recorded %s with clusters %+v", deployment.Status, deployment.Clusters)
+		}
+	})
+
+	t.Run("a cluster refusing the job fails the fan-out", func(t *testing.T) {
+		eu.status = "running"
+		_, response := deploy(models.DeploymentRequest{ServiceName: "api", TagID: "all-2", Clusters: []string{"eu", "broken"}})
+		if response.Status != models.StatusRunning || response.Clusters[1].Status != models.StatusFailed {
+			t.Fatalf("Unexpected response: %+v", response)
+		}
+
+		eu.status = "successful"
+		if got := status("all-2"); got.Status != models.StatusFailed {
+			t.Errorf("Fan-out with a failed cluster reported as %+v", got)
+		}
+	})
+
+	t.Run("invalid targets are refused", func(t *testing.T) {
+		tests := []struct {
+			name string
+			req  models.DeploymentRequest
+		}{
+			{"unknown cluster", models.DeploymentRequest{ServiceName: "api", TagID: "x-1", Cluster: "mars"}},
+			{"unknown fan-out cluster", models.DeploymentRequest{ServiceName: "api", TagID: "x-2", Clusters: []string{"eu", "mars"}}},
+			{"cluster listed twice", models.DeploymentRequest{ServiceName: "api", TagID: "x-3", Clusters: []string{"eu", "eu"}}},
+			{"cluster and clusters", models.DeploymentRequest{ServiceName: "api", TagID: "x-4", Cluster: "eu", Clusters: []string{"eu", "us"}}},
+		}
+		for _, tt := range tests {
+			if rr, _ := deploy(tt.req); rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected 400, got %d: %s", tt.name, rr.Code, rr.Body.String())
+			}
+		}
+	})
+}
+
+func TestLoadClusters(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "clusters.json")
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		return path
+	}
+
+	invalid := map[string]string{
+		"missing url":              `{"eu": {"token": "t"}}`,
+		"empty name":               `{"": {"url": "https://nomad"}}`,
+		"cert without key":         `{"eu": {"url": "https://nomad", "client_cert_file": "/c.pem"}}`,
+		"token and token_env":      `{"eu": {"url": "https://nomad", "token": "t", "token_env": "EU_TOKEN"}}`,
+		"unknown promotion source": `{"prod": {"url": "https://nomad", "promotion": {"from": ["staging"]}}}`,
+	}
+	for name, content := range invalid {
+		if _, err := config.LoadClusters(write(content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	t.Setenv("SHIPPER_TEST_EU_TOKEN", "secret")
+	clusters, err := config.LoadClusters(write(`{"eu": {"url": "https://nomad.eu", "token_env": "SHIPPER_TEST_EU_TOKEN", "region": "eu"}}`))
+	if err != nil {
+		t.Fatalf("LoadClusters failed: %v", err)
+	}
+	if clusters["eu"].Token != "secret" || clusters["eu"].Region != "eu" {
+		t.Errorf("Unexpected cluster: %+v", clusters["eu"])
+	}
+}
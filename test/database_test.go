@@ -3,6 +3,7 @@ package test
 import (
 	"database/sql"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,7 +14,7 @@ import (
 
 func setupTestDB(t *testing.T) *sql.DB {
 	// Create a temporary database file
-	tmpFile := "/tmp/test_" + t.Name() + "_" + time.Now().Format("20060102150405") + ".db"
+	tmpFile := "/tmp/test_" + strings.ReplaceAll(t.Name(), "/", "_") + "_" + time.Now().Format("20060102150405") + ".db"
 
 	// Ensure cleanup
 	t.Cleanup(func() {
@@ -42,6 +43,11 @@ func setupTestDB(t *testing.T) *sql.DB {
 		t.Fatalf("Failed to create table: %v", err)
 	}
 
+	// Bring the table up to the current schema
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
 	t.Cleanup(func() {
 		db.Close()
 	})
@@ -0,0 +1,164 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/handlers"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+
+	"github.com/gorilla/mux"
+)
+
+func TestDispatchJob(t *testing.T) {
+	var (
+		dispatched    map[string]interface{}
+		dispatchQuery string
+		childStatus   = "running"
+		childAllocs   = `[{"ID": "alloc-1", "TaskGroup": "report", "ClientStatus": "running",
+			"TaskStates": {"render": {"State": "running", "Events": [{"Type": "Started"}]}}}]`
+	)
+	server := newFakeNomad(t, map[string]interface{}{
+		"/v1/job/report/dispatch": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			dispatchQuery = r.URL.RawQuery
+			dispatched = nil
+			_ = json.NewDecoder(r.Body).Decode(&dispatched)
+			_, _ = w.Write([]byte(`{"DispatchedJobID": "report/dispatch-1700000000-abcd", "EvalID": "eval-dispatch"}`))
+		}),
+		// Child job IDs contain a slash, which is escaped into a single path segment
+		"/v1/job/{child}": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"ID": "report/dispatch-1700000000-abcd", "Status": "` + childStatus + `"}`))
+		}),
+		"/v1/job/{child}/allocations": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(childAllocs))
+		}),
+		"/v1/job/nightly/periodic/force": map[string]interface{}{"EvalID": "eval-force"},
+		"/v1/evaluation/eval-force":      map[string]interface{}{"ID": "eval-force", "JobID": "nightly/periodic-1700000000"},
+	})
+
+	cfg := &config.Config{
+		NomadURL:                server.URL,
+		EnforceServiceAllowlist: true,
+		Services: map[string]config.ServiceConfig{
+			"report":  {Namespace: "batch"},
+			"nightly": {},
+		},
+	}
+	db := setupTestDB(t)
+	handler := handlers.NewHandler(db, cfg, nomad.NewClient(server.URL, true, "test-token"))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/dispatch/{job}", handler.DispatchJob).Methods("POST")
+	router.HandleFunc("/periodic/{job}/force", handler.ForcePeriodicJob).Methods("POST")
+	router.HandleFunc("/status/{tag_id}", handler.Status).Methods("GET")
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", path, bytes.NewBufferString(body)))
+		return rr
+	}
+	status := func(tagID string) models.StatusResponse {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/status/"+tagID, nil))
+		var response models.StatusResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal status %q: %v", rr.Body.String(), err)
+		}
+		return response
+	}
+
+	t.Run("dispatch passes meta and payload and tracks the child job", func(t *testing.T) {
+		rr := post("/dispatch/report", `{"tag_id": "report-1", "meta": {"customer": "acme"}, "payload": "aGVsbG8="}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("DispatchJob returned %d: %s", rr.Code, rr.Body.String())
+		}
+		var response models.JobRunResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if response.ChildJobID != "report/dispatch-1700000000-abcd" || response.EvalID != "eval-dispatch" {
+			t.Errorf("Unexpected response: %+v", response)
+		}
+		if dispatchQuery != "namespace=batch" || dispatched["Payload"] != "aGVsbG8=" {
+			t.Errorf("Dispatched with %q and body %v", dispatchQuery, dispatched)
+		}
+		if meta, _ := dispatched["Meta"].(map[string]interface{}); meta["customer"] != "acme" {
+			t.Errorf("Dispatched meta %v", dispatched["Meta"])
+		}
+
+		if got := status("report-1"); got.Status != models.StatusRunning || got.ChildJobID != response.ChildJobID {
+			t.Errorf("Running child job reported as %+v", got)
+		}
+
+		childStatus = "dead"
+		childAllocs = `[{"ID": "alloc-1", "TaskGroup": "report", "ClientStatus": "failed",
+			"TaskStates": {"render": {"State": "dead", "Failed": true, "Events": [
+				{"Type": "Started"}, {"Type": "Terminated", "ExitCode": 3}, {"Type": "Not Restarting", "DisplayMessage": "Exceeded allowed attempts"}]}}}]`
+
+		got := status("report-1")
+		if got.Status != models.StatusFailed || got.Action != models.ActionDispatch {
+			t.Fatalf("Finished child job reported as %+v", got)
+		}
+		if len(got.Tasks) != 1 || got.Tasks[0].ExitCode == nil || *got.Tasks[0].ExitCode != 3 {
+			t.Fatalf("Unexpected task states %+v", got.Tasks)
+		}
+		if got.Tasks[0].Message != "Exceeded allowed attempts" {
+			t.Errorf("Task message = %q", got.Tasks[0].Message)
+		}
+
+		// The exit state is kept once the run is over
+		deployment, err := database.GetDeploymentRecord(db, "report-1")
+		if err != nil {
+			t.Fatalf("GetDeploymentRecord failed: %v", err)
+		}
+		if deployment.Status != models.StatusFailed || len(deployment.Tasks) != 1 {
+			t.Errorf("Recorded %s with tasks %+v", deployment.Status, deployment.Tasks)
+		}
+	})
+
+	t.Run("a child job that never ran fails", func(t *testing.T) {
+		childStatus, childAllocs = "dead", `[]`
+		if rr := post("/dispatch/report", `{"tag_id": "report-2"}`); rr.Code != http.StatusOK {
+			t.Fatalf("DispatchJob returned %d: %s", rr.Code, rr.Body.String())
+		}
+		if got := status("report-2"); got.Status != models.StatusFailed {
+			t.Errorf("Child job without allocations reported as %+v", got)
+		}
+	})
+
+	t.Run("periodic force reports the child job", func(t *testing.T) {
+		rr := post("/periodic/nightly/force", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("ForcePeriodicJob returned %d: %s", rr.Code, rr.Body.String())
+		}
+		var response models.JobRunResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if response.ChildJobID != "nightly/periodic-1700000000" || response.Action != models.ActionPeriodicForce || response.TagID == "" {
+			t.Errorf("Unexpected response: %+v", response)
+		}
+	})
+
+	t.Run("invalid requests are refused", func(t *testing.T) {
+		tests := []struct {
+			name, path, body string
+			want             int
+		}{
+			{"payload not base64", "/dispatch/report", `{"payload": "not base64!"}`, http.StatusBadRequest},
+			{"tag already used", "/dispatch/report", `{"tag_id": "report-1"}`, http.StatusConflict},
+			{"unlisted job", "/dispatch/billing", `{}`, http.StatusForbidden},
+		}
+		for _, tt := range tests {
+			if rr := post(tt.path, tt.body); rr.Code != tt.want {
+				t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.want, rr.Code, rr.Body.String())
+			}
+		}
+	})
+}
@@ -0,0 +1,159 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/handlers"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+
+	"github.com/gorilla/mux"
+)
+
+func TestPromoteEnvironment(t *testing.T) {
+	home, staging, qa, prod := newFakeCluster(t, "home"), newFakeCluster(t, "staging"), newFakeCluster(t, "qa"), newFakeCluster(t, "prod")
+
+	cfg := &config.Config{
+		NomadURL: home.server.URL,
+		Clusters: map[string]config.ClusterConfig{
+			"staging": {URL: staging.server.URL},
+			"qa":      {URL: qa.server.URL},
+			"prod": {URL: prod.server.URL, Promotion: &config.PromotionPolicy{
+				From:     []string{"staging"},
+				SoakTime: config.Duration{Duration: time.Hour},
+			}},
+		},
+	}
+	clusters := nomad.NewClusters(nomad.NewClient(home.server.URL, true, "test-token"))
+	clusters.Add("staging", nomad.NewClient(staging.server.URL, true, "staging-token"))
+	clusters.Add("qa", nomad.NewClient(qa.server.URL, true, "qa-token"))
+	clusters.Add("prod", nomad.NewClient(prod.server.URL, true, "prod-token"))
+
+	db := setupTestDB(t)
+	handler := handlers.NewHandlerWithClusters(db, cfg, clusters)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/deploy", handler.Deploy).Methods("POST")
+	router.HandleFunc("/promote", handler.PromoteEnvironment).Methods("POST")
+	router.HandleFunc("/status/{tag_id}", handler.Status).Methods("GET")
+
+	post := func(path string, body interface{}) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(body)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", path, bytes.NewBuffer(payload)))
+		return rr
+	}
+	status := func(tagID string) models.StatusResponse {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/status/"+tagID, nil))
+		var response models.StatusResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal status %q: %v", rr.Body.String(), err)
+		}
+		return response
+	}
+
+	rr := post("/deploy", models.DeploymentRequest{ServiceName: "api", TagID: "build-1", Cluster: "staging", Image: "registry.example.com/api:1.2.0"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Deploy returned %d: %s", rr.Code, rr.Body.String())
+	}
+
+	t.Run("a running deployment is not promoted", func(t *testing.T) {
+		if rr := post("/promote", models.EnvironmentPromotionRequest{TagID: "build-1", Cluster: "qa"}); rr.Code != http.StatusConflict {
+			t.Errorf("Expected 409, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	staging.status = "successful"
+	if got := status("build-1"); got.Status != models.StatusSuccessful {
+		t.Fatalf("Unexpected status: %+v", got)
+	}
+
+	t.Run("the build is deployed to the target and linked to its source", func(t *testing.T) {
+		rr := post("/promote", models.EnvironmentPromotionRequest{TagID: "build-1", Cluster: "qa"})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Promote returned %d: %s", rr.Code, rr.Body.String())
+		}
+		var response models.DeploymentResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if response.TagID != "build-1@qa" || response.Cluster != "qa" || response.PromotedFrom != "build-1" {
+			t.Errorf("Unexpected response: %+v", response)
+		}
+		if qa.image != "registry.example.com/api:1.2.0" {
+			t.Errorf("qa was deployed with image %q", qa.image)
+		}
+
+		if got := status("build-1"); len(got.PromotedTo) != 1 || got.PromotedTo[0] != "build-1@qa" {
+			t.Errorf("Source reports promotions %v", got.PromotedTo)
+		}
+		if got := status("build-1@qa"); got.PromotedFrom != "build-1" {
+			t.Errorf("Promotion reports source %q", got.PromotedFrom)
+		}
+	})
+
+	t.Run("the target's soak time must pass", func(t *testing.T) {
+		if rr := post("/promote", models.EnvironmentPromotionRequest{TagID: "build-1", Cluster: "prod"}); rr.Code != http.StatusConflict {
+			t.Fatalf("Expected 409 while soaking, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		if _, err := db.Exec("UPDATE deployments SET updated_at = ? WHERE tag_id = ?", time.Now().Add(-2*time.Hour).UTC(), "build-1"); err != nil {
+			t.Fatalf("Failed to age deployment: %v", err)
+		}
+		rr := post("/promote", models.EnvironmentPromotionRequest{TagID: "build-1", Cluster: "prod", TargetTagID: "release-1"})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Promote returned %d: %s", rr.Code, rr.Body.String())
+		}
+		if prod.image != "registry.example.com/api:1.2.0" {
+			t.Errorf("prod was deployed with image %q", prod.image)
+		}
+		if got := status("build-1"); len(got.PromotedTo) != 2 || got.PromotedTo[1] != "release-1" {
+			t.Errorf("Source reports promotions %v", got.PromotedTo)
+		}
+	})
+
+	t.Run("invalid promotions are refused", func(t *testing.T) {
+		for _, deployment := range []models.Deployment{
+			{TagID: "home-1", ServiceName: "api", Status: models.StatusSuccessful},
+			{TagID: "file-1", ServiceName: "api", Cluster: "staging", Status: models.StatusSuccessful, JobFile: true},
+		} {
+			deployment := deployment
+			if err := database.InsertDeploymentRecord(db, &deployment); err != nil {
+				t.Fatalf("InsertDeploymentRecord failed: %v", err)
+			}
+		}
+
+		tests := []struct {
+			name string
+			req  models.EnvironmentPromotionRequest
+			code int
+		}{
+			{"missing cluster", models.EnvironmentPromotionRequest{TagID: "build-1"}, http.StatusBadRequest},
+			{"unknown tag", models.EnvironmentPromotionRequest{TagID: "build-9", Cluster: "qa"}, http.StatusNotFound},
+			{"unknown cluster", models.EnvironmentPromotionRequest{TagID: "build-1", Cluster: "mars"}, http.StatusBadRequest},
+			{"same cluster", models.EnvironmentPromotionRequest{TagID: "build-1", Cluster: "staging"}, http.StatusBadRequest},
+			{"source not allowed", models.EnvironmentPromotionRequest{TagID: "home-1", Cluster: "prod"}, http.StatusForbidden},
+			{"job file", models.EnvironmentPromotionRequest{TagID: "file-1", Cluster: "qa"}, http.StatusConflict},
+			{"already promoted", models.EnvironmentPromotionRequest{TagID: "build-1", Cluster: "qa"}, http.StatusConflict},
+		}
+		for _, tt := range tests {
+			if rr := post("/promote", tt.req); rr.Code != tt.code {
+				t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.code, rr.Code, rr.Body.String())
+			}
+		}
+
+		// The NOMAD_URL cluster has no promotion policy and is never a target
+		if rr := post("/promote", models.EnvironmentPromotionRequest{TagID: "build-1"}); !strings.Contains(rr.Body.String(), "NOMAD_URL") {
+			t.Errorf("Expected the refusal to name the NOMAD_URL cluster, got %s", rr.Body.String())
+		}
+	})
+}
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/database"
 	"shipper-deployment/internal/handlers"
 	"shipper-deployment/internal/models"
 	"shipper-deployment/internal/nomad"
@@ -22,8 +23,13 @@ import (
 )
 
 func setupTestHandler(t *testing.T) (*handlers.Handler, *sql.DB) {
+	return setupTestHandlerWithNomad(t, "http://test-nomad:4646")
+}
+
+// setupTestHandlerWithNomad creates a handler that talks to the given Nomad URL
+func setupTestHandlerWithNomad(t *testing.T, nomadURL string) (*handlers.Handler, *sql.DB) {
 	// Create test database
-	tmpFile := "/tmp/test_handler_" + t.Name() + "_" + time.Now().Format("20060102150405") + ".db"
+	tmpFile := "/tmp/test_handler_" + strings.ReplaceAll(t.Name(), "/", "_") + "_" + time.Now().Format("20060102150405") + ".db"
 
 	t.Cleanup(func() {
 		os.Remove(tmpFile)
@@ -51,13 +57,18 @@ func setupTestHandler(t *testing.T) (*handlers.Handler, *sql.DB) {
 		t.Fatalf("Failed to create table: %v", err)
 	}
 
+	// Bring the table up to the current schema
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
 	t.Cleanup(func() {
 		db.Close()
 	})
 
 	// Create test config
 	cfg := &config.Config{
-		NomadURL:        "http://test-nomad:4646",
+		NomadURL:        nomadURL,
 		ValidSecret:     "test-secret-key-64-characters-long-for-testing-purposes",
 		Port:            "16166",
 		SkipTLSVerify:   true,
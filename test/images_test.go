@@ -0,0 +1,129 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"shipper-deployment/internal/models"
+)
+
+func TestDeployImageSubstitution(t *testing.T) {
+	var submitted map[string]map[string]interface{}
+	server := newFakeNomad(t, map[string]interface{}{
+		"/v1/job/api": map[string]interface{}{
+			"ID": "api",
+			"TaskGroups": []map[string]interface{}{
+				{
+					"Name": "web",
+					"Tasks": []map[string]interface{}{
+						{"Name": "app", "Driver": "docker", "Config": map[string]interface{}{"image": "registry.example.com:5000/api:abc123"}},
+						{"Name": "proxy", "Driver": "docker", "Config": map[string]interface{}{"image": "nginx:1.25"}},
+					},
+				},
+				{
+					"Name": "jobs",
+					"Tasks": []map[string]interface{}{
+						{"Name": "worker", "Driver": "docker", "Config": map[string]interface{}{"image": "registry.example.com:5000/api@sha256:0ff1ce"}},
+						{"Name": "cron", "Driver": "raw_exec", "Config": map[string]interface{}{"command": "/bin/cron"}},
+					},
+				},
+			},
+		},
+		"/v1/jobs": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			submitted = nil
+			if err := json.NewDecoder(r.Body).Decode(&submitted); err != nil {
+				t.Errorf("Failed to decode submitted job: %v", err)
+			}
+			_, _ = w.Write([]byte(`{"EvalID":"eval-image","JobID":"api"}`))
+		}),
+	})
+	handler, _ := setupTestHandlerWithNomad(t, server.URL)
+
+	deploy := func(req models.DeploymentRequest) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(req)
+		rr := httptest.NewRecorder()
+		handler.Deploy(rr, httptest.NewRequest("POST", "/deploy", bytes.NewBuffer(body)))
+		return rr
+	}
+
+	images := func() map[string]string {
+		result := map[string]string{}
+		groups, _ := submitted["Job"]["TaskGroups"].([]interface{})
+		for _, g := range groups {
+			group := g.(map[string]interface{})
+			for _, tk := range group["Tasks"].([]interface{}) {
+				task := tk.(map[string]interface{})
+				config := task["Config"].(map[string]interface{})
+				if image, ok := config["image"].(string); ok {
+					result[task["Name"].(string)] = image
+				}
+			}
+		}
+		return result
+	}
+
+	t.Run("matching repository", func(t *testing.T) {
+		rr := deploy(models.DeploymentRequest{ServiceName: "api", TagID: "img-1", Image: "registry.example.com:5000/api:def456"})
+
+		var response models.DeploymentResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if rr.Code != http.StatusOK || len(response.ImageChanges) != 2 {
+			t.Fatalf("Unexpected response %d: %s", rr.Code, rr.Body.String())
+		}
+
+		got := images()
+		if got["app"] != "registry.example.com:5000/api:def456" || got["worker"] != "registry.example.com:5000/api:def456" {
+			t.Errorf("Repository tasks not rewritten: %v", got)
+		}
+		if got["proxy"] != "nginx:1.25" {
+			t.Errorf("Unrelated task was rewritten: %v", got)
+		}
+	})
+
+	t.Run("named tasks", func(t *testing.T) {
+		rr := deploy(models.DeploymentRequest{ServiceName: "api", TagID: "img-2", Images: map[string]string{"web/proxy": "nginx:1.27"}})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Unexpected response %d: %s", rr.Code, rr.Body.String())
+		}
+
+		got := images()
+		if got["proxy"] != "nginx:1.27" || got["app"] != "registry.example.com:5000/api:abc123" {
+			t.Errorf("Named task substitution wrong: %v", got)
+		}
+	})
+
+	tests := []struct {
+		name    string
+		request models.DeploymentRequest
+		want    string
+	}{
+		{"non-docker task", models.DeploymentRequest{ServiceName: "api", TagID: "img-3", Images: map[string]string{"cron": "busybox:1"}}, "raw_exec driver"},
+		{"unknown task", models.DeploymentRequest{ServiceName: "api", TagID: "img-4", Images: map[string]string{"missing": "busybox:1"}}, "no task named missing"},
+		{"unknown repository", models.DeploymentRequest{ServiceName: "api", TagID: "img-5", Image: "other/repo:1"}, "no docker task runs an image from other/repo"},
+		{"empty image", models.DeploymentRequest{ServiceName: "api", TagID: "img-6", Images: map[string]string{"app": ""}}, "need a task name and an image"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := deploy(tt.request)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected 400, got %d: %s", rr.Code, rr.Body.String())
+			}
+			if !strings.Contains(rr.Body.String(), tt.want) {
+				t.Errorf("Expected error containing %q, got %s", tt.want, rr.Body.String())
+			}
+		})
+	}
+
+	t.Run("a refused tag can be retried", func(t *testing.T) {
+		rr := deploy(models.DeploymentRequest{ServiceName: "api", TagID: "img-4", Images: map[string]string{"app": "busybox:1"}})
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "running") {
+			t.Errorf("Expected the corrected request to deploy, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+}
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/database"
 	"shipper-deployment/internal/handlers"
 	"shipper-deployment/internal/models"
 	"shipper-deployment/internal/nomad"
@@ -49,6 +50,11 @@ func setupTestRouter(t *testing.T) (*mux.Router, *sql.DB) {
 		t.Fatalf("Failed to create table: %v", err)
 	}
 
+	// Bring the table up to the current schema
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
 	t.Cleanup(func() {
 		db.Close()
 	})
@@ -0,0 +1,258 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/handlers"
+	"shipper-deployment/internal/jobspec"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+)
+
+const parseJobHCL = `
+variable "replicas" {
+  type    = number
+  default = 1
+}
+
+variable "image_tag" {
+  type = string
+}
+
+locals {
+  image = "registry.example.com/api:${local.tag}"
+  tag   = lower(var.image_tag)
+}
+
+job "api" {
+  datacenters = ["eu-1", "eu-2"]
+  namespace   = "payments"
+
+  update {
+    max_parallel = 2
+    canary       = 1
+  }
+
+  group "web" {
+    count = var.replicas
+
+    network {
+      port "http" {
+        to = 8080
+      }
+      port "metrics" {
+        static = 9100
+      }
+    }
+
+    volume "data" {
+      type   = "host"
+      source = "data"
+    }
+
+    update {
+      auto_promote = true
+    }
+
+    task "app" {
+      driver = "docker"
+
+      config {
+        image = local.image
+        args  = ["--dir", "${NOMAD_ALLOC_DIR}/data", "--node=${node.unique.name}"]
+        mount {
+          type   = "tmpfs"
+          target = "/tmp"
+        }
+      }
+
+      env {
+        REPLICAS = var.replicas
+      }
+
+      template {
+        data        = "{{ key \"api/config\" }}"
+        destination = "local/config.json"
+        splay       = "10s"
+      }
+    }
+  }
+}
+`
+
+func TestParseJobFile(t *testing.T) {
+	variables := jobspec.NewVariables(parseJobHCL)
+	for _, assignment := range []string{"replicas=3", "image_tag=V1.2"} {
+		if err := variables.AddAssignment(assignment); err != nil {
+			t.Fatalf("AddAssignment(%q) failed: %v", assignment, err)
+		}
+	}
+
+	jobJSON, err := jobspec.Parse(parseJobHCL, variables)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if err := jobspec.Validate(jobJSON); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	job := jobJSON["Job"].(map[string]interface{})
+	group := job["TaskGroups"].([]interface{})[0].(map[string]interface{})
+	task := group["Tasks"].([]interface{})[0].(map[string]interface{})
+	network := group["Networks"].([]interface{})[0].(map[string]interface{})
+	update := group["Update"].(map[string]interface{})
+	taskConfig := task["Config"].(map[string]interface{})
+	template := task["Templates"].([]interface{})[0].(map[string]interface{})
+
+	checks := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"job ID", job["ID"], "api"},
+		{"job name", job["Name"], "api"},
+		{"type default", job["Type"], "service"},
+		{"priority default", job["Priority"], float64(50)},
+		{"namespace", job["Namespace"], "payments"},
+		{"count from variable", group["Count"], float64(3)},
+		{"dynamic port", network["DynamicPorts"], []interface{}{map[string]interface{}{"Label": "http", "To": float64(8080)}}},
+		{"reserved port", network["ReservedPorts"], []interface{}{map[string]interface{}{"Label": "metrics", "Value": float64(9100)}}},
+		{"host volume", group["Volumes"].(map[string]interface{})["data"].(map[string]interface{})["Type"], "host"},
+		{"update inherited", update["MaxParallel"], float64(2)},
+		{"update override", update["AutoPromote"], true},
+		{"update default", update["HealthCheck"], "checks"},
+		{"image from locals", taskConfig["image"], "registry.example.com/api:v1.2"},
+		{"runtime interpolation", taskConfig["args"], []interface{}{"--dir", "${NOMAD_ALLOC_DIR}/data", "--node=${node.unique.name}"}},
+		{"config block", taskConfig["mount"], []interface{}{map[string]interface{}{"type": "tmpfs", "target": "/tmp"}}},
+		{"env", task["Env"], map[string]interface{}{"REPLICAS": "3"}},
+		{"template", template["DestPath"], "local/config.json"},
+		{"duration", template["Splay"], float64(10e9)},
+		{"resources default", task["Resources"], map[string]interface{}{"CPU": float64(100), "MemoryMB": float64(300)}},
+	}
+	for _, check := range checks {
+		if !reflect.DeepEqual(check.got, check.want) {
+			t.Errorf("%s: got %#v, want %#v", check.name, check.got, check.want)
+		}
+	}
+}
+
+func TestParseJobFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		hcl     string
+		wantErr error
+	}{
+		{"malformed HCL", `job "api" {`, jobspec.ErrInvalidJob},
+		{"missing variable", "variable \"tag\" {}\njob \"api\" {}", jobspec.ErrInvalidJob},
+		{"bad duration", "job \"api\" {\n  update { min_healthy_time = \"soon\" }\n}", jobspec.ErrInvalidJob},
+		{"no job block", `variable "tag" { default = "x" }`, jobspec.ErrInvalidJob},
+		{"unknown function", `job "api" { datacenters = [file("dcs.txt")] }`, jobspec.ErrUnsupported},
+		{"unknown block", "job \"api\" {\n  periodic { crons = [\"@daily\"] }\n}", jobspec.ErrUnsupported},
+		{"unknown attribute", "job \"api\" {\n  group \"web\" { stop_after_client_disconnect = \"1m\" }\n}", jobspec.ErrUnsupported},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := jobspec.Parse(tt.hcl, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Parse error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	jobJSON, err := jobspec.Parse(`job "api" {
+  group "web" {
+    task "app" {}
+    task "app" { driver = "docker" }
+  }
+  group "worker" {}
+}`, nil)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	err = jobspec.Validate(jobJSON)
+	if !errors.Is(err, jobspec.ErrInvalidJob) {
+		t.Fatalf("Validate error = %v, want %v", err, jobspec.ErrInvalidJob)
+	}
+	for _, want := range []string{`task "app" of group "web" has no driver`, `task "app" of group "web" is declared twice`, `task group "worker" has no tasks`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestDeployJobLocalParser(t *testing.T) {
+	var (
+		parseCalls int
+		parseQuery string
+		submitted  map[string]map[string]interface{}
+	)
+	server := newFakeNomad(t, map[string]interface{}{
+		"/v1/jobs/parse": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			parseCalls++
+			parseQuery = r.URL.RawQuery
+			_, _ = w.Write([]byte(`{"ID": "vaulted", "TaskGroups": [{"Name": "web", "Tasks": [{"Name": "app", "Driver": "docker"}]}]}`))
+		}),
+		"/v1/jobs": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			submitted = nil
+			_ = json.NewDecoder(r.Body).Decode(&submitted)
+			_, _ = w.Write([]byte(`{"EvalID": "eval-local"}`))
+		}),
+	})
+
+	cfg := &config.Config{
+		NomadURL:  server.URL,
+		JobParser: config.JobParserLocal,
+		Services:  map[string]config.ServiceConfig{"vaulted": {Namespace: "secrets"}},
+	}
+	handler := handlers.NewHandler(setupTestDB(t), cfg, nomad.NewClient(server.URL, true, "test-token"))
+
+	deployJob := func(tagID, service, jobFile string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.JobDeploymentRequest{TagID: tagID, ServiceName: service, JobFile: jobFile})
+		req := httptest.NewRequest("POST", "/deploy/job", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		handler.DeployJob(rr, req)
+		return rr
+	}
+
+	t.Run("supported job files are parsed without Nomad", func(t *testing.T) {
+		rr := deployJob("local-1", "", "job \"api\" {\n  group \"web\" {\n    task \"app\" { driver = \"docker\" }\n  }\n}")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("DeployJob returned %d: %s", rr.Code, rr.Body.String())
+		}
+		if parseCalls != 0 {
+			t.Errorf("Nomad's parse API was called %d times", parseCalls)
+		}
+		if submitted["Job"]["ID"] != "api" {
+			t.Errorf("Unexpected submitted job: %v", submitted)
+		}
+	})
+
+	t.Run("malformed job files are refused without Nomad", func(t *testing.T) {
+		rr := deployJob("local-2", "", `job "api" { group "web" {`)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("Expected 400, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if parseCalls != 0 {
+			t.Errorf("Nomad's parse API was called %d times", parseCalls)
+		}
+	})
+
+	t.Run("unsupported features fall back to Nomad", func(t *testing.T) {
+		rr := deployJob("local-3", "vaulted", "job \"vaulted\" {\n  group \"web\" {\n    task \"app\" {\n      vault { policies = [\"api\"] }\n    }\n  }\n}")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("DeployJob returned %d: %s", rr.Code, rr.Body.String())
+		}
+		if parseCalls != 1 || parseQuery != "namespace=secrets" {
+			t.Errorf("Parse API called %d times with %q", parseCalls, parseQuery)
+		}
+	})
+}
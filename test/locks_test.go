@@ -0,0 +1,238 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/handlers"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+	"shipper-deployment/internal/reconciler"
+
+	"github.com/gorilla/mux"
+)
+
+func TestDeployLocks(t *testing.T) {
+	var db *sql.DB
+	newRouter := func(t *testing.T, env *fakeCluster, cfg *config.Config) (*handlers.Handler, *mux.Router) {
+		cfg.NomadURL = env.server.URL
+		db = setupTestDB(t)
+		handler := handlers.NewHandler(db, cfg, nomad.NewClient(env.server.URL, true, "test-token"))
+		router := mux.NewRouter()
+		router.HandleFunc("/deploy", handler.Deploy).Methods("POST")
+		router.HandleFunc("/status/{tag_id}", handler.Status).Methods("GET")
+		router.HandleFunc("/pipelines", handler.CreatePipeline).Methods("POST")
+		return handler, router
+	}
+	deploy := func(router *mux.Router, tagID, image string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.DeploymentRequest{ServiceName: "api", TagID: tagID, Image: image})
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/deploy", bytes.NewBuffer(body)))
+		return rr
+	}
+	status := func(router *mux.Router, tagID string) models.StatusResponse {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/status/"+tagID, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Status returned %d: %s", rr.Code, rr.Body.String())
+		}
+		var response models.StatusResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal status: %v", err)
+		}
+		return response
+	}
+
+	t.Run("queued deploys wait for the deployment holding the lock", func(t *testing.T) {
+		env := newFakeCluster(t, "queue")
+		handler, router := newRouter(t, env, &config.Config{DeployLockPolicy: config.LockQueue})
+
+		if rr := deploy(router, "q-1", "registry.example.com/api:1.0.0"); rr.Code != http.StatusOK {
+			t.Fatalf("First deploy returned %d: %s", rr.Code, rr.Body.String())
+		}
+		rr := deploy(router, "q-2", "registry.example.com/api:2.0.0")
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("Expected 202, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var queued models.DeploymentResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &queued); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if queued.Status != models.StatusQueued || queued.Lock == nil || queued.Lock.Holder != "q-1" {
+			t.Errorf("Unexpected response: %+v", queued)
+		}
+		deploy(router, "q-3", "registry.example.com/api:3.0.0")
+
+		response := status(router, "q-2")
+		if response.Status != models.StatusQueued || response.Lock == nil ||
+			response.Lock.Holder != "q-1" || !reflect.DeepEqual(response.Lock.Queue, []string{"q-2", "q-3"}) {
+			t.Fatalf("Unexpected status: %+v lock %+v", response, response.Lock)
+		}
+		if env.image != "registry.example.com/api:1.0.0" {
+			t.Errorf("Queued deploy was submitted, Nomad runs %s", env.image)
+		}
+
+		env.status = "successful"
+		if response = status(router, "q-1"); response.Status != models.StatusSuccessful || response.Lock != nil {
+			t.Fatalf("Unexpected status: %+v", response)
+		}
+		// Status only reports the queue, the reconciler submits from it
+		if response = status(router, "q-2"); response.Status != models.StatusQueued || env.image != "registry.example.com/api:1.0.0" {
+			t.Fatalf("Polling a queued deploy changed it: %+v, Nomad runs %s", response, env.image)
+		}
+		reconciler.New(db, handler.Tracker(), nil, handler.Locks(), 0, 1).ReconcileOnce(context.Background())
+		if env.image != "registry.example.com/api:2.0.0" {
+			t.Errorf("Expected the oldest queued deploy to be submitted, Nomad runs %s", env.image)
+		}
+
+		env.status = "running"
+		if response = status(router, "q-3"); response.Status != models.StatusQueued || response.Lock.Holder != "q-2" {
+			t.Errorf("Unexpected status: %+v lock %+v", response, response.Lock)
+		}
+	})
+
+	t.Run("pipeline stages wait for the lock like deploys", func(t *testing.T) {
+		env := newFakeCluster(t, "pipeline")
+		handler, router := newRouter(t, env, &config.Config{DeployLockPolicy: config.LockQueue})
+
+		deploy(router, "p-1", "registry.example.com/api:1.0.0")
+		body, _ := json.Marshal(models.PipelineRequest{TagID: "rel-1", Stages: []models.PipelineStage{
+			{Services: []string{"api"}, Image: "registry.example.com/api:2.0.0"},
+		}})
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/pipelines", bytes.NewBuffer(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Pipeline returned %d: %s", rr.Code, rr.Body.String())
+		}
+		if response := status(router, "rel-1-api"); response.Status != models.StatusQueued || response.Lock.Holder != "p-1" {
+			t.Fatalf("Unexpected status: %+v lock %+v", response, response.Lock)
+		}
+		if env.image != "registry.example.com/api:1.0.0" {
+			t.Errorf("Pipeline stage was submitted past the lock, Nomad runs %s", env.image)
+		}
+
+		env.status = "successful"
+		status(router, "p-1")
+		reconciler.New(db, handler.Tracker(), handler.Pipelines(), handler.Locks(), 0, 1).ReconcileOnce(context.Background())
+		if env.image != "registry.example.com/api:2.0.0" {
+			t.Errorf("Expected the pipeline stage to be submitted, Nomad runs %s", env.image)
+		}
+	})
+
+	t.Run("rejected deploys get a conflict", func(t *testing.T) {
+		env := newFakeCluster(t, "reject")
+		cfg := &config.Config{DeployLockPolicy: config.LockReject}
+		_, router := newRouter(t, env, cfg)
+
+		deploy(router, "r-1", "")
+		rr := deploy(router, "r-2", "")
+		if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), "r-1") {
+			t.Errorf("Expected 409 naming r-1, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		// A service's own policy wins over DEPLOY_LOCK_POLICY
+		cfg.Services = map[string]config.ServiceConfig{"api": {Lock: config.LockNone}}
+		if rr := deploy(router, "r-3", ""); rr.Code != http.StatusOK {
+			t.Errorf("Expected 200 without a lock, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("newer deploys supersede unfinished ones", func(t *testing.T) {
+		env := newFakeCluster(t, "supersede")
+		_, router := newRouter(t, env, &config.Config{DeployLockPolicy: config.LockSupersede})
+
+		deploy(router, "s-1", "registry.example.com/api:1.0.0")
+
+		// A deploy Nomad refuses supersedes nothing
+		env.reject = true
+		deploy(router, "s-bad", "registry.example.com/api:1.5.0")
+		env.reject = false
+		if response := status(router, "s-1"); response.Status == models.StatusSuperseded {
+			t.Fatalf("A failed submission superseded s-1: %+v", response)
+		}
+
+		if rr := deploy(router, "s-2", "registry.example.com/api:2.0.0"); rr.Code != http.StatusOK {
+			t.Fatalf("Superseding deploy returned %d: %s", rr.Code, rr.Body.String())
+		}
+		if env.image != "registry.example.com/api:2.0.0" {
+			t.Errorf("Nomad runs %s", env.image)
+		}
+
+		if response := status(router, "s-1"); response.Status != models.StatusSuperseded || response.SupersededBy != "s-2" {
+			t.Errorf("Unexpected status: %+v", response)
+		}
+		if response := status(router, "s-2"); response.Lock == nil || response.Lock.Holder != "s-2" || response.Lock.Policy != config.LockSupersede {
+			t.Errorf("Unexpected lock: %+v", response.Lock)
+		}
+	})
+
+	t.Run("fan-out deploys are refused under a lock policy", func(t *testing.T) {
+		home, eu, us := newFakeCluster(t, "home"), newFakeCluster(t, "eu"), newFakeCluster(t, "us")
+		cfg := &config.Config{
+			NomadURL: home.server.URL,
+			Clusters: map[string]config.ClusterConfig{"eu": {URL: eu.server.URL}, "us": {URL: us.server.URL}},
+			Services: map[string]config.ServiceConfig{"api": {Lock: config.LockQueue}},
+		}
+		clusters := nomad.NewClusters(nomad.NewClient(home.server.URL, true, "test-token"))
+		clusters.Add("eu", nomad.NewClient(eu.server.URL, true, "eu-token"))
+		clusters.Add("us", nomad.NewClient(us.server.URL, true, "us-token"))
+		handler := handlers.NewHandlerWithClusters(setupTestDB(t), cfg, clusters)
+		router := mux.NewRouter()
+		router.HandleFunc("/deploy", handler.Deploy).Methods("POST")
+
+		fanOut := func() *httptest.ResponseRecorder {
+			body, _ := json.Marshal(models.DeploymentRequest{ServiceName: "api", TagID: "f-1", Image: "registry.example.com/api:2.0.0", Clusters: []string{"eu", "us"}})
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("POST", "/deploy", bytes.NewBuffer(body)))
+			return rr
+		}
+		rr := fanOut()
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "one cluster at a time") {
+			t.Fatalf("Expected 400, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if eu.image != "" || us.image != "" {
+			t.Errorf("Refused fan-out was submitted, Nomad runs %q and %q", eu.image, us.image)
+		}
+
+		cfg.Services["api"] = config.ServiceConfig{}
+		if rr := fanOut(); rr.Code != http.StatusOK {
+			t.Fatalf("Fan-out without a lock policy returned %d: %s", rr.Code, rr.Body.String())
+		}
+		if eu.image != "registry.example.com/api:2.0.0" || us.image != "registry.example.com/api:2.0.0" {
+			t.Errorf("Nomad runs %q and %q", eu.image, us.image)
+		}
+	})
+}
+
+func TestLockPolicy(t *testing.T) {
+	cfg := &config.Config{Services: map[string]config.ServiceConfig{
+		"api":        {Lock: config.LockQueue},
+		"payments-*": {Lock: config.LockReject},
+		"worker":     {Namespace: "jobs"},
+	}}
+
+	for service, want := range map[string]string{
+		"api":          config.LockQueue,
+		"payments-eu":  config.LockReject,
+		"worker":       config.LockNone,
+		"unregistered": config.LockNone,
+	} {
+		if got := cfg.LockPolicy(service); got != want {
+			t.Errorf("LockPolicy(%q) = %q, want %q", service, got, want)
+		}
+	}
+
+	cfg.DeployLockPolicy = config.LockSupersede
+	if got := cfg.LockPolicy("worker"); got != config.LockSupersede {
+		t.Errorf("Expected DEPLOY_LOCK_POLICY as the fallback, got %q", got)
+	}
+}
@@ -0,0 +1,105 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+)
+
+func TestDeployPreservesJobMeta(t *testing.T) {
+	var submitted map[string]map[string]interface{}
+	server := newFakeNomad(t, map[string]interface{}{
+		"/v1/job/api": map[string]interface{}{
+			"ID": "api",
+			"Meta": map[string]string{
+				"owner":   "team-a",
+				"runbook": "https://runbooks.example.com/api",
+				// Set by the team, not by a previous deploy
+				"tag_id": "JIRA-1234",
+			},
+		},
+		"/v1/job/worker": map[string]interface{}{
+			"ID": "worker",
+			"Meta": map[string]string{
+				"owner":      "team-b",
+				"tag_id":     "old-sha",
+				"timestamp":  "1700000000",
+				"updated_by": "shipper",
+			},
+		},
+		"/v1/jobs": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := json.NewDecoder(r.Body).Decode(&submitted); err != nil {
+				t.Errorf("Failed to decode submitted job: %v", err)
+			}
+			_, _ = w.Write([]byte(`{"EvalID":"eval-meta","JobID":"api"}`))
+		}),
+	})
+
+	t.Run("merges into existing meta", func(t *testing.T) {
+		handler, _ := setupTestHandlerWithNomad(t, server.URL)
+
+		body, _ := json.Marshal(models.DeploymentRequest{ServiceName: "api", TagID: "new-sha"})
+		rr := httptest.NewRecorder()
+		handler.Deploy(rr, httptest.NewRequest("POST", "/deploy", bytes.NewBuffer(body)))
+
+		var response models.DeploymentResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if response.JobID != "eval-meta" {
+			t.Fatalf("Unexpected response: %+v", response)
+		}
+		if !reflect.DeepEqual(response.OverwrittenMeta, []string{"tag_id"}) {
+			t.Errorf("OverwrittenMeta = %v, want [tag_id]", response.OverwrittenMeta)
+		}
+
+		meta, _ := submitted["Job"]["Meta"].(map[string]interface{})
+		if meta["owner"] != "team-a" || meta["runbook"] != "https://runbooks.example.com/api" {
+			t.Errorf("Existing meta was not preserved: %v", meta)
+		}
+		if meta["tag_id"] != "new-sha" || meta["updated_by"] != "shipper" || meta["timestamp"] == nil {
+			t.Errorf("Shipper meta was not set: %v", meta)
+		}
+	})
+
+	t.Run("redeploys do not report Shipper's own keys", func(t *testing.T) {
+		client := nomad.NewClient(server.URL, true, "test-token")
+
+		_, prepared, err := client.TriggerDeployment("worker", "new-sha", nomad.DeployOptions{})
+		if err != nil {
+			t.Fatalf("TriggerDeployment failed: %v", err)
+		}
+		if len(prepared.OverwrittenMeta) != 0 {
+			t.Errorf("OverwrittenMeta = %v, want none", prepared.OverwrittenMeta)
+		}
+
+		meta, _ := submitted["Job"]["Meta"].(map[string]interface{})
+		if meta["owner"] != "team-b" || meta["tag_id"] != "new-sha" || meta["timestamp"] == "1700000000" {
+			t.Errorf("Shipper meta was not replaced: %v", meta)
+		}
+	})
+
+	t.Run("uses the configured prefix", func(t *testing.T) {
+		client := nomad.NewClient(server.URL, true, "test-token")
+		client.MetaPrefix = "shipper_"
+
+		evalID, prepared, err := client.TriggerDeployment("api", "new-sha", nomad.DeployOptions{})
+		if err != nil {
+			t.Fatalf("TriggerDeployment failed: %v", err)
+		}
+		if evalID != "eval-meta" || len(prepared.OverwrittenMeta) != 0 {
+			t.Errorf("Got eval %s and overwritten %v", evalID, prepared.OverwrittenMeta)
+		}
+
+		meta, _ := submitted["Job"]["Meta"].(map[string]interface{})
+		if meta["tag_id"] != "JIRA-1234" || meta["shipper_tag_id"] != "new-sha" {
+			t.Errorf("Prefixed meta not applied as expected: %v", meta)
+		}
+	})
+}
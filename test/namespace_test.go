@@ -0,0 +1,132 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/handlers"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+
+	"github.com/gorilla/mux"
+)
+
+func TestNamespaceAndRegion(t *testing.T) {
+	queries := make(map[string]string)
+	record := func(name string, body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			queries[name] = r.URL.RawQuery
+			_, _ = w.Write([]byte(body))
+		}
+	}
+	server := newFakeNomad(t, map[string]interface{}{
+		"/v1/job/api":             record("fetch", `{"ID": "api", "Namespace": "team-a", "Region": "eu"}`),
+		"/v1/jobs":                record("submit", `{"EvalID": "eval-ns"}`),
+		"/v1/evaluation/eval-ns":  record("eval", `{"ID": "eval-ns", "Status": "complete", "JobID": "api"}`),
+		"/v1/job/api/deployments": record("deployments", `[]`),
+		"/v1/jobs/parse":          record("parse", `{"ID": "api", "Namespace": "default", "Region": "global"}`),
+	})
+
+	cfg := &config.Config{
+		NomadURL: server.URL,
+		Services: map[string]config.ServiceConfig{
+			"api":      {AllowJobFile: true},
+			"payments": {Namespace: "payments"},
+		},
+	}
+	db := setupTestDB(t)
+	handler := handlers.NewHandler(db, cfg, nomad.NewClient(server.URL, true, "test-token"))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/deploy", handler.Deploy).Methods("POST")
+	router.HandleFunc("/deploy/job", handler.DeployJob).Methods("POST")
+	router.HandleFunc("/status/{tag_id}", handler.Status).Methods("GET")
+
+	post := func(path string, body interface{}) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", path, bytes.NewBuffer(payload))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("deploy is carried through to every Nomad call", func(t *testing.T) {
+		rr := post("/deploy", models.DeploymentRequest{ServiceName: "api", TagID: "ns-1", Namespace: "team-a", Region: "eu"})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Deploy returned %d: %s", rr.Code, rr.Body.String())
+		}
+
+		status := httptest.NewRecorder()
+		router.ServeHTTP(status, httptest.NewRequest("GET", "/status/ns-1", nil))
+		var response models.StatusResponse
+		if err := json.Unmarshal(status.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal status: %v", err)
+		}
+		if response.Namespace != "team-a" || response.Region != "eu" || response.Status != models.StatusCompleted {
+			t.Errorf("Unexpected status: %+v", response)
+		}
+
+		for _, call := range []string{"fetch", "submit", "eval", "deployments"} {
+			if queries[call] != "namespace=team-a&region=eu" {
+				t.Errorf("%s query = %q", call, queries[call])
+			}
+		}
+	})
+
+	t.Run("job files are placed in the requested namespace", func(t *testing.T) {
+		rr := post("/deploy/job", models.JobDeploymentRequest{
+			TagID:     "ns-2",
+			JobFile:   `job "api" {}`,
+			Namespace: "team-b",
+		})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("DeployJob returned %d: %s", rr.Code, rr.Body.String())
+		}
+		if queries["parse"] != "namespace=team-b" || queries["submit"] != "namespace=team-b&region=global" {
+			t.Errorf("Parsed with %q and submitted with %q", queries["parse"], queries["submit"])
+		}
+
+		deployment, err := database.GetDeploymentRecord(db, "ns-2")
+		if err != nil {
+			t.Fatalf("GetDeploymentRecord failed: %v", err)
+		}
+		if deployment.Namespace != "team-b" || deployment.Region != "global" {
+			t.Errorf("Recorded namespace %q and region %q", deployment.Namespace, deployment.Region)
+		}
+	})
+
+	t.Run("the service config wins over the request", func(t *testing.T) {
+		rr := post("/deploy", models.DeploymentRequest{ServiceName: "payments", TagID: "ns-3", Namespace: "team-a"})
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("jobs with the same name in different namespaces do not collide", func(t *testing.T) {
+		for _, deployment := range []models.Deployment{
+			{TagID: "a-good", NomadJobID: "web", Namespace: "team-a", Status: models.StatusSuccessful, DeploymentID: "dep-a"},
+			{TagID: "b-good", NomadJobID: "web", Namespace: "team-b", Status: models.StatusSuccessful, DeploymentID: "dep-b"},
+		} {
+			deployment := deployment
+			if err := database.InsertDeploymentRecord(db, &deployment); err != nil {
+				t.Fatalf("InsertDeploymentRecord failed: %v", err)
+			}
+		}
+
+		for _, namespace := range []string{"team-a", "team-b"} {
+			lastGood, err := database.GetLastSuccessfulDeployment(db, "web", "", namespace, "")
+			if err != nil {
+				t.Fatalf("GetLastSuccessfulDeployment(%s) failed: %v", namespace, err)
+			}
+			if lastGood.Namespace != namespace {
+				t.Errorf("Last good deployment in %s came from %s", namespace, lastGood.Namespace)
+			}
+		}
+	})
+}
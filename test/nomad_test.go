@@ -0,0 +1,166 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+
+	"github.com/gorilla/mux"
+)
+
+// newFakeNomad starts a Nomad API stub serving the given path to JSON body routes
+func newFakeNomad(t *testing.T, routes map[string]interface{}) *httptest.Server {
+	t.Helper()
+
+	serveMux := http.NewServeMux()
+	for path, body := range routes {
+		body := body
+		serveMux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if handler, ok := body.(http.HandlerFunc); ok {
+				handler(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(body); err != nil {
+				t.Errorf("Failed to encode fake Nomad response: %v", err)
+			}
+		})
+	}
+
+	server := httptest.NewServer(serveMux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGetDeploymentHealth(t *testing.T) {
+	tests := []struct {
+		name           string
+		routes         map[string]interface{}
+		expectedStatus string
+		expectedGroups int
+	}{
+		{
+			name: "successful deployment",
+			routes: map[string]interface{}{
+				"/v1/evaluation/eval-1": map[string]interface{}{
+					"ID": "eval-1", "Status": "complete", "JobID": "api", "DeploymentID": "dep-1",
+				},
+				"/v1/deployment/dep-1": map[string]interface{}{
+					"ID": "dep-1", "JobID": "api", "JobVersion": 4, "Status": "successful",
+					"TaskGroups": map[string]interface{}{
+						"web": map[string]interface{}{"DesiredTotal": 2, "PlacedAllocs": 2, "HealthyAllocs": 2},
+					},
+				},
+			},
+			expectedStatus: models.StatusSuccessful,
+			expectedGroups: 1,
+		},
+		{
+			name: "crash looping deployment fails",
+			routes: map[string]interface{}{
+				"/v1/evaluation/eval-1": map[string]interface{}{
+					"ID": "eval-1", "Status": "complete", "JobID": "api", "JobModifyIndex": 42,
+				},
+				"/v1/job/api/deployments": []map[string]interface{}{
+					{"ID": "dep-old", "JobModifyIndex": 40, "Status": "successful"},
+					{"ID": "dep-2", "JobModifyIndex": 42, "Status": "failed",
+						"TaskGroups": map[string]interface{}{
+							"web": map[string]interface{}{"DesiredTotal": 2, "PlacedAllocs": 2, "UnhealthyAllocs": 2},
+						}},
+				},
+			},
+			expectedStatus: models.StatusFailed,
+			expectedGroups: 1,
+		},
+		{
+			name: "evaluation without deployment completes",
+			routes: map[string]interface{}{
+				"/v1/evaluation/eval-1": map[string]interface{}{
+					"ID": "eval-1", "Status": "complete", "JobID": "batch", "JobModifyIndex": 7,
+				},
+				"/v1/job/batch/deployments": []map[string]interface{}{},
+			},
+			expectedStatus: models.StatusCompleted,
+		},
+		{
+			name: "pending evaluation is running",
+			routes: map[string]interface{}{
+				"/v1/evaluation/eval-1": map[string]interface{}{"ID": "eval-1", "Status": "pending"},
+			},
+			expectedStatus: models.StatusRunning,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeNomad(t, tt.routes)
+			client := nomad.NewClient(server.URL, true, "test-token")
+
+			health, err := client.GetDeploymentHealth("eval-1", nomad.JobScope{})
+			if err != nil {
+				t.Fatalf("GetDeploymentHealth failed: %v", err)
+			}
+
+			if health.Status != tt.expectedStatus {
+				t.Errorf("Status = %v, want %v", health.Status, tt.expectedStatus)
+			}
+
+			if len(health.TaskGroups) != tt.expectedGroups {
+				t.Errorf("TaskGroups = %d, want %d", len(health.TaskGroups), tt.expectedGroups)
+			}
+		})
+	}
+}
+
+func TestStatusHandlerReportsDeploymentHealth(t *testing.T) {
+	server := newFakeNomad(t, map[string]interface{}{
+		"/v1/evaluation/eval-1": map[string]interface{}{
+			"ID": "eval-1", "Status": "complete", "JobID": "api", "DeploymentID": "dep-1",
+		},
+		"/v1/deployment/dep-1": map[string]interface{}{
+			"ID": "dep-1", "JobID": "api", "Status": "running",
+			"StatusDescription": "Deployment is running",
+			"TaskGroups": map[string]interface{}{
+				"web": map[string]interface{}{"DesiredTotal": 3, "PlacedAllocs": 2, "HealthyAllocs": 1},
+			},
+		},
+	})
+	handler, db := setupTestHandlerWithNomad(t, server.URL)
+
+	if err := database.InsertDeployment(db, "health-123", "api", "eval-1", models.StatusRunning); err != nil {
+		t.Fatalf("InsertDeployment failed: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/status/health-123", nil)
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/status/{tag_id}", handler.Status).Methods("GET")
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+
+	var response models.StatusResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	if response.DeploymentID != "dep-1" {
+		t.Errorf("DeploymentID = %v, want dep-1", response.DeploymentID)
+	}
+
+	web, ok := response.TaskGroups["web"]
+	if !ok {
+		t.Fatalf("Expected task group progress for web, got %v", response.TaskGroups)
+	}
+
+	if web.DesiredTotal != 3 || web.PlacedAllocs != 2 || web.HealthyAllocs != 1 {
+		t.Errorf("Unexpected task group progress: %+v", web)
+	}
+}
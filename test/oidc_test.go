@@ -0,0 +1,242 @@
+package test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/models"
+)
+
+// signJWT returns an RS256 token over claims signed by key
+func signJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// writeOIDCConfig writes a JWKS holding key and an OIDC config trusting it, returning the config path
+func writeOIDCConfig(t *testing.T, key *rsa.PrivateKey, kid string) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+	jwksPath := filepath.Join(dir, "jwks.json")
+	if err := os.WriteFile(jwksPath, jwks, 0600); err != nil {
+		t.Fatalf("Failed to write jwks: %v", err)
+	}
+
+	oidc, _ := json.Marshal(map[string]interface{}{
+		"providers": []map[string]string{{
+			"name":      "github",
+			"issuer":    "https://token.actions.githubusercontent.com",
+			"audience":  "shipper",
+			"jwks_file": jwksPath,
+		}},
+		"rules": []map[string]interface{}{{
+			"provider": "github",
+			"claims": map[string]string{
+				"repository":  "acme/payments",
+				"ref":         "refs/heads/main",
+				"environment": "production",
+			},
+			"scopes":   []string{models.ScopeDeploy, models.ScopeStatus},
+			"services": []string{"payments"},
+		}},
+	})
+	oidcPath := filepath.Join(dir, "oidc.json")
+	if err := os.WriteFile(oidcPath, oidc, 0600); err != nil {
+		t.Fatalf("Failed to write oidc config: %v", err)
+	}
+	return oidcPath
+}
+
+func TestOIDCAuthentication(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	oidcConfig, err := config.LoadOIDC(writeOIDCConfig(t, key, "key-1"))
+	if err != nil {
+		t.Fatalf("LoadOIDC failed: %v", err)
+	}
+
+	nomadServer := newFakeNomad(t, map[string]interface{}{
+		"/v1/job/payments": map[string]interface{}{"ID": "payments"},
+		"/v1/jobs":         map[string]interface{}{"EvalID": "eval-oidc", "JobID": "payments"},
+	})
+	db := setupTestDB(t)
+	router := newServerRouter(t, &config.Config{
+		NomadURL:      nomadServer.URL,
+		SkipTLSVerify: true,
+		OIDC:          oidcConfig,
+	}, db)
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		now := time.Now()
+		c := map[string]interface{}{
+			"iss":         "https://token.actions.githubusercontent.com",
+			"aud":         "shipper",
+			"sub":         "repo:acme/payments:environment:production",
+			"repository":  "acme/payments",
+			"ref":         "refs/heads/main",
+			"environment": "production",
+			"sha":         "0a1b2c3d",
+			"actor":       "octocat",
+			"run_id":      "4242",
+			"iat":         now.Unix(),
+			"exp":         now.Add(5 * time.Minute).Unix(),
+		}
+		for name, value := range overrides {
+			c[name] = value
+		}
+		return c
+	}
+
+	deploy := func(token, service, tagID string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.DeploymentRequest{ServiceName: service, TagID: tagID})
+		req := httptest.NewRequest("POST", "/deploy", bytes.NewBuffer(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("valid token deploys and records claims", func(t *testing.T) {
+		rr := deploy(signJWT(t, key, "key-1", claims(nil)), "payments", "oidc-1")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Deploy returned %d: %s", rr.Code, rr.Body.String())
+		}
+
+		deployment, err := database.GetDeploymentRecord(db, "oidc-1")
+		if err != nil {
+			t.Fatalf("GetDeploymentRecord failed: %v", err)
+		}
+		if deployment.TriggeredBy != "oidc:github:repo:acme/payments:environment:production" {
+			t.Errorf("TriggeredBy = %q", deployment.TriggeredBy)
+		}
+		if deployment.AuthClaims["repository"] != "acme/payments" || deployment.AuthClaims["sha"] != "0a1b2c3d" ||
+			deployment.AuthClaims["run_id"] != "4242" {
+			t.Errorf("Unexpected recorded claims: %v", deployment.AuthClaims)
+		}
+		if _, ok := deployment.AuthClaims["iss"]; ok {
+			t.Errorf("Claims outside record_claims were stored: %v", deployment.AuthClaims)
+		}
+	})
+
+	t.Run("service outside the rule is forbidden", func(t *testing.T) {
+		if rr := deploy(signJWT(t, key, "key-1", claims(nil)), "billing", "oidc-2"); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	rejected := []struct {
+		name  string
+		token string
+	}{
+		{"wrong audience", signJWT(t, key, "key-1", claims(map[string]interface{}{"aud": "someone-else"}))},
+		{"audience list", signJWT(t, key, "key-1", claims(map[string]interface{}{"aud": []string{"a", "b"}}))},
+		{"untrusted issuer", signJWT(t, key, "key-1", claims(map[string]interface{}{"iss": "https://gitlab.example.com"}))},
+		{"expired", signJWT(t, key, "key-1", claims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}))},
+		{"unmatched branch", signJWT(t, key, "key-1", claims(map[string]interface{}{"ref": "refs/heads/feature"}))},
+		{"unmatched repository", signJWT(t, key, "key-1", claims(map[string]interface{}{"repository": "acme/fork"}))},
+		{"wrong signing key", signJWT(t, otherKey, "key-1", claims(nil))},
+		{"unknown key id", signJWT(t, key, "key-2", claims(nil))},
+	}
+	for i, tc := range rejected {
+		t.Run(tc.name, func(t *testing.T) {
+			tagID := "rejected-" + string(rune('a'+i))
+			if rr := deploy(tc.token, "payments", tagID); rr.Code != http.StatusUnauthorized {
+				t.Errorf("Expected 401, got %d: %s", rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	t.Run("audience list containing shipper is accepted", func(t *testing.T) {
+		token := signJWT(t, key, "key-1", claims(map[string]interface{}{"aud": []string{"sts.amazonaws.com", "shipper"}}))
+		req := httptest.NewRequest("GET", "/status/oidc-1", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Status returned %d: %s", rr.Code, rr.Body.String())
+		}
+
+		var status models.StatusResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+			t.Fatalf("Failed to unmarshal status: %v", err)
+		}
+		if status.AuthClaims["environment"] != "production" {
+			t.Errorf("Status did not report claims: %+v", status)
+		}
+	})
+}
+
+func TestLoadOIDCValidation(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "oidc.json")
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		return path
+	}
+
+	invalid := map[string]string{
+		"missing jwks":            `{"providers":[{"name":"gh","issuer":"https://i","audience":"a"}]}`,
+		"both jwks":               `{"providers":[{"name":"gh","issuer":"https://i","audience":"a","jwks_url":"https://i/jwks","jwks_file":"/k"}]}`,
+		"unknown provider":        `{"providers":[{"name":"gh","issuer":"https://i","audience":"a","jwks_file":"/k"}],"rules":[{"provider":"gl","scopes":["deploy"],"services":["*"]}]}`,
+		"rule without scope":      `{"providers":[{"name":"gh","issuer":"https://i","audience":"a","jwks_file":"/k"}],"rules":[{"provider":"gh","claims":{"repository":"acme/api"},"services":["*"]}]}`,
+		"rule without claims":     `{"providers":[{"name":"gh","issuer":"https://i","audience":"a","jwks_file":"/k"}],"rules":[{"provider":"gh","scopes":["deploy"],"services":["*"]}]}`,
+		"rule without repository": `{"providers":[{"name":"gh","issuer":"https://i","audience":"a","jwks_file":"/k"}],"rules":[{"provider":"gh","claims":{"ref":"refs/heads/main"},"scopes":["deploy"],"services":["*"]}]}`,
+		"wildcard repository":     `{"providers":[{"name":"gh","issuer":"https://i","audience":"a","jwks_file":"/k"}],"rules":[{"provider":"gh","claims":{"repository":"*/*"},"scopes":["deploy"],"services":["*"]}]}`,
+	}
+	for name, content := range invalid {
+		if _, err := config.LoadOIDC(write(content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	cfg, err := config.LoadOIDC(write(`{"providers":[{"name":"gh","issuer":"https://i","audience":"a","jwks_url":"https://i/jwks"}]}`))
+	if err != nil {
+		t.Fatalf("LoadOIDC failed: %v", err)
+	}
+	if len(cfg.RecordClaims) == 0 {
+		t.Error("Expected default record_claims")
+	}
+}
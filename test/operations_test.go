@@ -0,0 +1,181 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"shipper-deployment/internal/auth"
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/handlers"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+
+	"github.com/gorilla/mux"
+)
+
+func TestJobOperations(t *testing.T) {
+	var (
+		stopMethod, stopQuery string
+		scaleBody             map[string]interface{}
+		restarted             []string
+		allocations           = `[
+			{"ID": "alloc-1", "TaskGroup": "web", "ClientStatus": "running", "DesiredStatus": "run"},
+			{"ID": "alloc-2", "TaskGroup": "worker", "ClientStatus": "running", "DesiredStatus": "run"},
+			{"ID": "alloc-3", "TaskGroup": "web", "ClientStatus": "complete", "DesiredStatus": "stop"}
+		]`
+	)
+	restart := func(id string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			restarted = append(restarted, id)
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}
+	server := newFakeNomad(t, map[string]interface{}{
+		"/v1/job/payments": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			stopMethod, stopQuery = r.Method, r.URL.RawQuery
+			_, _ = w.Write([]byte(`{"EvalID": "eval-stop"}`))
+		}),
+		"/v1/job/payments/scale": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scaleBody = nil
+			_ = json.NewDecoder(r.Body).Decode(&scaleBody)
+			_, _ = w.Write([]byte(`{"EvalID": "eval-scale"}`))
+		}),
+		"/v1/job/payments/allocations": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(allocations))
+		}),
+		"/v1/client/allocation/alloc-1/restart": restart("alloc-1"),
+		"/v1/client/allocation/alloc-2/restart": restart("alloc-2"),
+	})
+
+	cfg := &config.Config{
+		NomadURL:                server.URL,
+		EnforceServiceAllowlist: true,
+		Services:                map[string]config.ServiceConfig{"payments": {Namespace: "payments"}},
+	}
+	db := setupTestDB(t)
+	handler := handlers.NewHandler(db, cfg, nomad.NewClient(server.URL, true, "test-token"))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/jobs/{service}/stop", handler.StopJob).Methods("POST")
+	router.HandleFunc("/jobs/{service}/scale", handler.ScaleJob).Methods("POST")
+	router.HandleFunc("/jobs/{service}/restart", handler.RestartJob).Methods("POST")
+
+	identity := &auth.Identity{Method: auth.MethodAPIKey, Subject: "oncall", KeyID: "k1", Scopes: []string{models.ScopeOperate}}
+	operate := func(path string, body interface{}) (*httptest.ResponseRecorder, models.JobOperationResponse) {
+		payload, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", path, bytes.NewBuffer(payload))
+		req = req.WithContext(auth.WithIdentity(req.Context(), identity))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response models.JobOperationResponse
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+		}
+		return rr, response
+	}
+	recorded := func(tagID string) *models.Deployment {
+		deployment, err := database.GetDeploymentRecord(db, tagID)
+		if err != nil {
+			t.Fatalf("GetDeploymentRecord(%s) failed: %v", tagID, err)
+		}
+		return deployment
+	}
+
+	t.Run("purge stops the job and is recorded", func(t *testing.T) {
+		rr, response := operate("/jobs/payments/stop", models.StopJobRequest{Purge: true, Reason: "decommissioned"})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("StopJob returned %d: %s", rr.Code, rr.Body.String())
+		}
+		if stopMethod != "DELETE" || stopQuery != "namespace=payments&purge=true" {
+			t.Errorf("Job stopped with %s %q", stopMethod, stopQuery)
+		}
+		if response.Action != models.ActionPurge || response.EvalID != "eval-stop" {
+			t.Errorf("Unexpected response: %+v", response)
+		}
+
+		deployment := recorded(response.TagID)
+		if deployment.Action != models.ActionPurge || deployment.Status != models.StatusCompleted {
+			t.Errorf("Recorded action %q with status %q", deployment.Action, deployment.Status)
+		}
+		if deployment.Reason != "decommissioned" || deployment.TriggeredBy != "api_key:oncall/k1" {
+			t.Errorf("Recorded reason %q by %q", deployment.Reason, deployment.TriggeredBy)
+		}
+	})
+
+	t.Run("scale sets the group count", func(t *testing.T) {
+		count := 4
+		rr, response := operate("/jobs/payments/scale", models.ScaleJobRequest{Group: "web", Count: &count, Reason: "traffic spike"})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("ScaleJob returned %d: %s", rr.Code, rr.Body.String())
+		}
+		target, _ := scaleBody["Target"].(map[string]interface{})
+		if scaleBody["Count"] != float64(4) || target["Group"] != "web" {
+			t.Errorf("Unexpected scale request: %v", scaleBody)
+		}
+		if scaleBody["Message"] != "traffic spike (by api_key:oncall/k1)" {
+			t.Errorf("Scale message = %v", scaleBody["Message"])
+		}
+		if deployment := recorded(response.TagID); deployment.Action != models.ActionScale {
+			t.Errorf("Recorded action %q, want %q", deployment.Action, models.ActionScale)
+		}
+	})
+
+	t.Run("restart only touches running allocations of the group", func(t *testing.T) {
+		restarted = nil
+		rr, response := operate("/jobs/payments/restart", models.RestartJobRequest{Group: "web", Reason: "stuck connections"})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("RestartJob returned %d: %s", rr.Code, rr.Body.String())
+		}
+		if len(restarted) != 1 || restarted[0] != "alloc-1" {
+			t.Errorf("Restarted allocations %v, want [alloc-1]", restarted)
+		}
+		if len(response.RestartedAllocs) != 1 {
+			t.Errorf("Response lists %v", response.RestartedAllocs)
+		}
+	})
+
+	t.Run("restart without running allocations conflicts", func(t *testing.T) {
+		rr, _ := operate("/jobs/payments/restart", models.RestartJobRequest{Group: "cron", Reason: "retry"})
+		if rr.Code != http.StatusConflict {
+			t.Errorf("Expected 409, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("reason is required", func(t *testing.T) {
+		rr, _ := operate("/jobs/payments/stop", models.StopJobRequest{Reason: "  "})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("scale needs a group and count", func(t *testing.T) {
+		rr, _ := operate("/jobs/payments/scale", models.ScaleJobRequest{Group: "web", Reason: "oops"})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("unlisted services are refused", func(t *testing.T) {
+		rr, _ := operate("/jobs/billing/stop", models.StopJobRequest{Reason: "cleanup"})
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("callers limited to other services are refused", func(t *testing.T) {
+		identity.Services = []string{"billing"}
+		defer func() { identity.Services = nil }()
+
+		rr, _ := operate("/jobs/payments/stop", models.StopJobRequest{Reason: "cleanup"})
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+}
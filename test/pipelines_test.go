@@ -0,0 +1,193 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/handlers"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+	"shipper-deployment/internal/reconciler"
+
+	"github.com/gorilla/mux"
+)
+
+func TestPipelines(t *testing.T) {
+	// migrate is a batch job that completes without a Nomad deployment, api and worker roll out
+	// with the status set in statuses
+	var submitted []string
+	statuses := map[string]string{"api": "running", "worker": "running"}
+	reverted := false
+
+	routes := map[string]interface{}{
+		"/v1/jobs": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body struct{ Job struct{ ID string } }
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("Failed to decode submission: %v", err)
+			}
+			submitted = append(submitted, body.Job.ID)
+			_, _ = w.Write([]byte(`{"EvalID": "eval-` + body.Job.ID + `"}`))
+		}),
+		"/v1/job/migrate":             map[string]interface{}{"ID": "migrate", "Type": "batch"},
+		"/v1/evaluation/eval-migrate": map[string]interface{}{"ID": "eval-migrate", "Status": "complete", "JobID": "migrate"},
+		"/v1/job/migrate/deployments": []interface{}{},
+		"/v1/job/api/versions":        fakeJobVersions(),
+		"/v1/job/api/revert": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reverted = true
+			_, _ = w.Write([]byte(`{"EvalID": "eval-revert"}`))
+		}),
+	}
+	for _, job := range []string{"api", "worker"} {
+		job := job
+		routes["/v1/job/"+job] = map[string]interface{}{"ID": job}
+		routes["/v1/evaluation/eval-"+job] = map[string]interface{}{"ID": "eval-" + job, "Status": "complete", "JobID": job, "DeploymentID": "dep-" + job}
+		routes["/v1/deployment/dep-"+job] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"ID": "dep-` + job + `", "JobID": "` + job + `", "Status": "` + statuses[job] + `"}`))
+		})
+	}
+	server := newFakeNomad(t, routes)
+
+	db := setupTestDB(t)
+	handler := handlers.NewHandler(db, &config.Config{NomadURL: server.URL}, nomad.NewClient(server.URL, true, "test-token"))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/pipelines", handler.CreatePipeline).Methods("POST")
+	router.HandleFunc("/pipelines/{tag_id}", handler.PipelineStatus).Methods("GET")
+
+	decode := func(rr *httptest.ResponseRecorder) models.PipelineResponse {
+		t.Helper()
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Pipeline request returned %d: %s", rr.Code, rr.Body.String())
+		}
+		var response models.PipelineResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		return response
+	}
+	create := func(req models.PipelineRequest) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(req)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/pipelines", bytes.NewBuffer(payload)))
+		return rr
+	}
+	status := func(tagID string) models.PipelineResponse {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/pipelines/"+tagID, nil))
+		return decode(rr)
+	}
+
+	t.Run("stages start in order once the previous one is healthy", func(t *testing.T) {
+		submitted = nil
+		response := decode(create(models.PipelineRequest{TagID: "rel-1", Stages: []models.PipelineStage{
+			{Name: "migrations", Services: []string{"migrate"}},
+			{Name: "api", Services: []string{"api"}},
+			{Services: []string{"worker"}},
+		}}))
+		if response.Status != models.StatusRunning || response.Stages[0].Status != models.StatusRunning || response.Stages[1].Status != models.StatusPending {
+			t.Fatalf("Unexpected response: %+v", response)
+		}
+		if len(submitted) != 1 || submitted[0] != "migrate" {
+			t.Fatalf("Submitted %v", submitted)
+		}
+
+		// The migration completes, so the api stage starts and waits for the rollout
+		response = status("rel-1")
+		if response.CurrentStage != 1 || response.Stages[0].Status != models.StatusCompleted || len(submitted) != 2 {
+			t.Fatalf("Unexpected progress %+v after submitting %v", response, submitted)
+		}
+		if response = status("rel-1"); response.CurrentStage != 1 || len(submitted) != 2 {
+			t.Fatalf("Moved on from a running stage: %+v", response)
+		}
+
+		statuses["api"] = "successful"
+		if response = status("rel-1"); response.CurrentStage != 2 || len(submitted) != 3 || submitted[2] != "worker" {
+			t.Fatalf("Unexpected progress %+v after submitting %v", response, submitted)
+		}
+
+		statuses["worker"] = "successful"
+		if response = status("rel-1"); response.Status != models.StatusSuccessful {
+			t.Errorf("Finished pipeline reported as %+v", response)
+		}
+
+		deployment, err := database.GetDeploymentRecord(db, "rel-1-api")
+		if err != nil {
+			t.Fatalf("GetDeploymentRecord failed: %v", err)
+		}
+		if deployment.Pipeline != "rel-1" || deployment.Status != models.StatusSuccessful {
+			t.Errorf("Unexpected pipeline deployment: %+v", deployment)
+		}
+	})
+
+	t.Run("a failed stage stops the pipeline and rolls back earlier stages", func(t *testing.T) {
+		submitted = nil
+		statuses["api"], statuses["worker"] = "successful", "failed"
+		decode(create(models.PipelineRequest{TagID: "rel-2", RollbackOnFailure: true, Stages: []models.PipelineStage{
+			{Services: []string{"api"}},
+			{Services: []string{"worker"}},
+		}}))
+
+		status("rel-2")
+		response := status("rel-2")
+		if response.Status != models.StatusFailed || response.CurrentStage != 1 {
+			t.Fatalf("Unexpected response: %+v", response)
+		}
+		if !reverted || !strings.Contains(response.Message, "rolled back api") {
+			t.Errorf("Expected api to be rolled back, message %q", response.Message)
+		}
+
+		deployment, err := database.GetDeploymentRecord(db, "rel-2-api")
+		if err != nil {
+			t.Fatalf("GetDeploymentRecord failed: %v", err)
+		}
+		if deployment.Status != models.StatusRolledBack {
+			t.Errorf("Earlier stage deployment is %s", deployment.Status)
+		}
+	})
+
+	t.Run("the reconciler moves pipelines on", func(t *testing.T) {
+		submitted = nil
+		statuses["api"] = "running"
+		decode(create(models.PipelineRequest{TagID: "rel-3", Stages: []models.PipelineStage{
+			{Services: []string{"migrate"}},
+			{Services: []string{"api"}},
+		}}))
+
+		reconciler.New(db, handler.Tracker(), handler.Pipelines(), nil, 0, 1).ReconcileOnce(context.Background())
+		if len(submitted) != 2 || submitted[1] != "api" {
+			t.Errorf("Submitted %v", submitted)
+		}
+	})
+
+	t.Run("invalid pipelines are refused", func(t *testing.T) {
+		tests := []struct {
+			name string
+			req  models.PipelineRequest
+			code int
+		}{
+			{"no stages", models.PipelineRequest{TagID: "bad-1"}, http.StatusBadRequest},
+			{"empty stage", models.PipelineRequest{TagID: "bad-2", Stages: []models.PipelineStage{{}}}, http.StatusBadRequest},
+			{"service in two stages", models.PipelineRequest{TagID: "bad-3", Stages: []models.PipelineStage{
+				{Services: []string{"api"}}, {Services: []string{"api"}},
+			}}, http.StatusBadRequest},
+			{"unknown cluster", models.PipelineRequest{TagID: "bad-4", Cluster: "mars", Stages: []models.PipelineStage{
+				{Services: []string{"api"}},
+			}}, http.StatusBadRequest},
+			{"existing pipeline", models.PipelineRequest{TagID: "rel-1", Stages: []models.PipelineStage{
+				{Services: []string{"web"}},
+			}}, http.StatusConflict},
+		}
+		for _, tt := range tests {
+			if rr := create(tt.req); rr.Code != tt.code {
+				t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.code, rr.Code, rr.Body.String())
+			}
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/models"
+)
+
+func TestDryRunDeploy(t *testing.T) {
+	var planned map[string]interface{}
+	server := newFakeNomad(t, map[string]interface{}{
+		"/v1/job/api": map[string]interface{}{
+			"ID": "api", "Name": "api", "Meta": map[string]string{"tag_id": "old"},
+		},
+		"/v1/jobs/parse": map[string]interface{}{
+			"ID": "api", "Name": "api",
+		},
+		"/v1/job/api/plan": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := json.NewDecoder(r.Body).Decode(&planned); err != nil {
+				t.Errorf("Failed to decode plan body: %v", err)
+			}
+			_, _ = w.Write([]byte(`{
+				"JobModifyIndex": 42,
+				"Diff": {"Type": "Edited", "ID": "api"},
+				"FailedTGAllocs": {"worker": {"NodesEvaluated": 3}},
+				"Warnings": "Group \"web\" has warnings\n",
+				"Annotations": {"DesiredTGUpdates": {
+					"web": {"Place": 1, "Stop": 1, "DestructiveUpdate": 2},
+					"worker": {"InPlaceUpdate": 3}
+				}}
+			}`))
+		}),
+	})
+	handler, db := setupTestHandlerWithNomad(t, server.URL)
+
+	checkPlan := func(t *testing.T, rr *httptest.ResponseRecorder, tagID string) {
+		t.Helper()
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Dry run returned %d: %s", rr.Code, rr.Body.String())
+		}
+
+		var plan models.PlanResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &plan); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if !plan.DryRun || plan.JobID != "api" || plan.JobModifyIndex != 42 {
+			t.Errorf("Unexpected plan header: %+v", plan)
+		}
+		want := models.PlanSummary{Create: 1, Destroy: 1, InPlace: 3, Destructive: 2}
+		if plan.Summary != want {
+			t.Errorf("Summary = %+v, want %+v", plan.Summary, want)
+		}
+		if _, ok := plan.PlacementFailures["worker"]; !ok {
+			t.Errorf("Expected placement failure for worker, got %v", plan.PlacementFailures)
+		}
+		if len(plan.Warnings) != 1 || len(plan.Diff) == 0 {
+			t.Errorf("Expected one warning and a diff, got %v and %s", plan.Warnings, plan.Diff)
+		}
+
+		if planned["Diff"] != true {
+			t.Errorf("Plan request did not enable diff: %v", planned["Diff"])
+		}
+		job, _ := planned["Job"].(map[string]interface{})
+		meta, _ := job["Meta"].(map[string]interface{})
+		if meta["tag_id"] != tagID {
+			t.Errorf("Planned job Meta tag_id = %v, want %s", meta["tag_id"], tagID)
+		}
+
+		if _, err := database.GetDeploymentRecord(db, tagID); err == nil {
+			t.Errorf("Dry run recorded deployment %s", tagID)
+		}
+	}
+
+	t.Run("deploy", func(t *testing.T) {
+		body, _ := json.Marshal(models.DeploymentRequest{ServiceName: "api", TagID: "plan-1"})
+		rr := httptest.NewRecorder()
+		handler.Deploy(rr, httptest.NewRequest("POST", "/deploy?dry_run=true", bytes.NewBuffer(body)))
+		checkPlan(t, rr, "plan-1")
+	})
+
+	t.Run("deploy job", func(t *testing.T) {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		_ = writer.WriteField("tag_id", "plan-2")
+		part, _ := writer.CreateFormFile("job_file", "api.nomad")
+		_, _ = part.Write([]byte(`job "api" {}`))
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/deploy/job?dry_run=true", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+		handler.DeployJob(rr, req)
+		checkPlan(t, rr, "plan-2")
+	})
+
+	t.Run("invalid flag", func(t *testing.T) {
+		body, _ := json.Marshal(models.DeploymentRequest{ServiceName: "api", TagID: "plan-3"})
+		rr := httptest.NewRecorder()
+		handler.Deploy(rr, httptest.NewRequest("POST", "/deploy?dry_run=maybe", bytes.NewBuffer(body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for invalid dry_run, got %d", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,271 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/handlers"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+	"shipper-deployment/internal/policy"
+)
+
+// policyTestJob is a parsed job breaking most policy rules
+const policyTestJob = `{
+	"Job": {
+		"ID": "risky",
+		"Datacenters": ["dc1", "*"],
+		"TaskGroups": [
+			{
+				"Name": "web",
+				"Count": 20,
+				"Volumes": {"data": {"Type": "host", "Source": "data"}, "certs": {"Type": "csi", "Source": "certs"}},
+				"Tasks": [
+					{
+						"Name": "app",
+						"Driver": "docker",
+						"Config": {"image": "nginx:latest", "privileged": true, "volumes": ["/var/run/docker.sock:/var/run/docker.sock", "local/conf:/etc/conf"]},
+						"Resources": {"CPU": 100, "MemoryMB": 4096}
+					},
+					{
+						"Name": "shell",
+						"Driver": "raw_exec",
+						"Config": {"command": "/bin/sh"}
+					}
+				]
+			},
+			{
+				"Name": "sidecar",
+				"Count": 1,
+				"Update": {"MaxParallel": 1},
+				"Tasks": [
+					{"Name": "proxy", "Driver": "docker", "Config": {"image": "envoy@sha256:abc"}, "Resources": {"CPU": 50, "MemoryMB": 64}}
+				]
+			}
+		]
+	}
+}`
+
+func TestPolicyEngine(t *testing.T) {
+	var job map[string]interface{}
+	if err := json.Unmarshal([]byte(policyTestJob), &job); err != nil {
+		t.Fatalf("Failed to unmarshal job: %v", err)
+	}
+
+	engine := policy.New(&config.PolicyConfig{Rules: []config.PolicyRule{
+		{Type: config.PolicyDeniedDrivers, Values: []string{"raw_exec", "exec"}},
+		{Type: config.PolicyRequiredResources, MaxMemoryMB: 2048},
+		{Type: config.PolicyMaxCount, Max: 10},
+		{Name: "pin-images", Type: config.PolicyNoLatestTag, WarnOnly: true},
+		{Type: config.PolicyRequireUpdate},
+		{Type: config.PolicyAllowedDatacenters, Values: []string{"dc*"}},
+		{Type: config.PolicyNoPrivileged},
+		{Type: config.PolicyNoHostVolumes},
+	}})
+	result := engine.Evaluate(job)
+
+	type key struct{ rule, group, task string }
+	found := make(map[key]models.PolicyViolation)
+	for _, violation := range result.Violations {
+		found[key{violation.Rule, violation.Group, violation.Task}] = violation
+	}
+
+	expected := []key{
+		{config.PolicyDeniedDrivers, "web", "shell"},
+		{config.PolicyRequiredResources, "web", "app"},
+		{config.PolicyRequiredResources, "web", "shell"},
+		{config.PolicyMaxCount, "web", ""},
+		{"pin-images", "web", "app"},
+		{config.PolicyRequireUpdate, "web", ""},
+		{config.PolicyAllowedDatacenters, "", ""},
+		{config.PolicyNoPrivileged, "web", "app"},
+		{config.PolicyNoHostVolumes, "web", ""},
+		{config.PolicyNoHostVolumes, "web", "app"},
+	}
+	for _, want := range expected {
+		if _, ok := found[want]; !ok {
+			t.Errorf("Missing violation %+v", want)
+		}
+	}
+	if len(found) != len(expected) {
+		t.Errorf("Got %d distinct violations, want %d: %+v", len(found), len(expected), result.Violations)
+	}
+
+	if found[key{"pin-images", "web", "app"}].Severity != models.SeverityWarning {
+		t.Error("Warn-only rule was not reported as a warning")
+	}
+	if !result.Denied() || len(result.Warnings()) != 1 {
+		t.Errorf("Denied() = %v with %d warnings", result.Denied(), len(result.Warnings()))
+	}
+
+	if policy.New(nil).Evaluate(job).Denied() {
+		t.Error("An engine without rules denied the job")
+	}
+}
+
+func TestDeployJobPolicy(t *testing.T) {
+	submitted := false
+	server := newFakeNomad(t, map[string]interface{}{
+		"/v1/jobs/parse": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"ID": "api", "Datacenters": ["dc1"], "TaskGroups": [{"Name": "api", "Count": 3,
+				"Tasks": [{"Name": "api", "Driver": "docker", "Config": {"image": "api:latest"}}]}]}`))
+		}),
+		"/v1/jobs": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			submitted = true
+			_, _ = w.Write([]byte(`{"EvalID": "eval-policy"}`))
+		}),
+	})
+
+	cfg := &config.Config{NomadURL: server.URL, Policy: &config.PolicyConfig{}}
+	db := setupTestDB(t)
+	handler := handlers.NewHandler(db, cfg, nomad.NewClient(server.URL, true, "test-token"))
+
+	deployJob := func(tagID string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.JobDeploymentRequest{TagID: tagID, JobFile: `job "api" {}`})
+		req := httptest.NewRequest("POST", "/deploy/job", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		handler.DeployJob(rr, req)
+		return rr
+	}
+
+	t.Run("violations are refused with 422", func(t *testing.T) {
+		cfg.Policy.Rules = []config.PolicyRule{{Type: config.PolicyMaxCount, Max: 2}}
+		handler = handlers.NewHandler(db, cfg, nomad.NewClient(server.URL, true, "test-token"))
+
+		rr := deployJob("policy-1")
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("Expected 422, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var response models.PolicyErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if len(response.Violations) != 1 || response.Violations[0].Group != "api" || response.JobID != "api" {
+			t.Errorf("Unexpected violations: %+v", response)
+		}
+		if submitted {
+			t.Error("A job violating policy was submitted")
+		}
+	})
+
+	t.Run("warn-only rules are reported and deployed", func(t *testing.T) {
+		cfg.Policy.Rules = []config.PolicyRule{{Type: config.PolicyNoLatestTag, WarnOnly: true}}
+		handler = handlers.NewHandler(db, cfg, nomad.NewClient(server.URL, true, "test-token"))
+
+		rr := deployJob("policy-2")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var response models.DeploymentResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if !submitted || len(response.PolicyWarnings) != 1 || response.PolicyWarnings[0].Task != "api" {
+			t.Errorf("Unexpected response: %+v", response)
+		}
+	})
+}
+
+func TestDeployJobRequiredResources(t *testing.T) {
+	// Only the app task sets its resources, the parser fills in the defaults of the worker
+	const jobFile = `
+job "api" {
+  datacenters = ["dc1"]
+
+  group "web" {
+    task "app" {
+      driver = "docker"
+      config {
+        image = "api:1.0.0"
+      }
+      resources {
+        cpu    = 200
+        memory = 256
+      }
+    }
+
+    task "worker" {
+      driver = "docker"
+      config {
+        image = "worker:1.0.0"
+      }
+    }
+  }
+}
+`
+	server := newFakeNomad(t, map[string]interface{}{
+		"/v1/jobs/parse": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"ID": "api", "Datacenters": ["dc1"], "TaskGroups": [{"Name": "web", "Count": 1, "Tasks": [
+				{"Name": "app", "Driver": "docker", "Config": {"image": "api:1.0.0"}, "Resources": {"CPU": 200, "MemoryMB": 256}},
+				{"Name": "worker", "Driver": "docker", "Config": {"image": "worker:1.0.0"}, "Resources": {"CPU": 100, "MemoryMB": 300}}]}]}`))
+		}),
+	})
+
+	for _, parser := range []string{config.JobParserNomad, config.JobParserLocal} {
+		t.Run(parser, func(t *testing.T) {
+			cfg := &config.Config{NomadURL: server.URL, JobParser: parser, Policy: &config.PolicyConfig{
+				Rules: []config.PolicyRule{{Type: config.PolicyRequiredResources}},
+			}}
+			handler := handlers.NewHandler(setupTestDB(t), cfg, nomad.NewClient(server.URL, true, "test-token"))
+
+			body, _ := json.Marshal(models.JobDeploymentRequest{TagID: "resources-" + parser, JobFile: jobFile})
+			req := httptest.NewRequest("POST", "/deploy/job", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			handler.DeployJob(rr, req)
+			if rr.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("Expected 422, got %d: %s", rr.Code, rr.Body.String())
+			}
+
+			var response models.PolicyErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if len(response.Violations) != 2 {
+				t.Fatalf("Expected cpu and memory violations, got %+v", response.Violations)
+			}
+			for _, violation := range response.Violations {
+				if violation.Group != "web" || violation.Task != "worker" {
+					t.Errorf("Unexpected violation: %+v", violation)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write policy: %v", err)
+		}
+	}
+
+	for name, content := range map[string]string{
+		"unknown type":     `{"rules": [{"type": "no_root"}]}`,
+		"drivers missing":  `{"rules": [{"type": "denied_drivers"}]}`,
+		"max count":        `{"rules": [{"type": "max_count"}]}`,
+		"malformed config": `{"rules": {}}`,
+	} {
+		write(content)
+		if _, err := config.LoadPolicy(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	write(`{"rules": [{"type": "denied_drivers", "values": ["raw_exec"]}, {"type": "no_latest_tag", "warn_only": true}]}`)
+	cfg, err := config.LoadPolicy(path)
+	if err != nil {
+		t.Fatalf("LoadPolicy failed: %v", err)
+	}
+	if len(cfg.Rules) != 2 || !cfg.Rules[1].WarnOnly {
+		t.Errorf("Unexpected rules: %+v", cfg.Rules)
+	}
+}
@@ -0,0 +1,89 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+	"shipper-deployment/internal/reconciler"
+	"shipper-deployment/internal/tracker"
+)
+
+func TestReconcileOnce(t *testing.T) {
+	server := newFakeNomad(t, map[string]interface{}{
+		"/v1/evaluation/eval-ok": map[string]interface{}{
+			"ID": "eval-ok", "Status": "complete", "JobID": "api", "DeploymentID": "dep-ok",
+		},
+		"/v1/deployment/dep-ok": map[string]interface{}{
+			"ID": "dep-ok", "JobID": "api", "Status": "successful",
+		},
+		"/v1/evaluation/eval-bad": map[string]interface{}{
+			"ID": "eval-bad", "Status": "complete", "JobID": "worker", "DeploymentID": "dep-bad",
+		},
+		"/v1/deployment/dep-bad": map[string]interface{}{
+			"ID": "dep-bad", "JobID": "worker", "Status": "failed",
+		},
+	})
+	db := setupTestDB(t)
+
+	seed := []struct {
+		tagID, service, jobID, status string
+	}{
+		{"rec-ok", "api", "eval-ok", models.StatusRunning},
+		{"rec-bad", "worker", "eval-bad", models.StatusRunning},
+		{"rec-pending", "api", "", models.StatusPending},
+		{"rec-done", "api", "eval-old", models.StatusSuccessful},
+	}
+	for _, d := range seed {
+		if err := database.InsertDeployment(db, d.tagID, d.service, d.jobID, d.status); err != nil {
+			t.Fatalf("InsertDeployment failed: %v", err)
+		}
+	}
+
+	active, err := database.ListActiveDeployments(db)
+	if err != nil {
+		t.Fatalf("ListActiveDeployments failed: %v", err)
+	}
+	if len(active) != 2 {
+		t.Fatalf("Expected 2 active deployments, got %d", len(active))
+	}
+
+	client := nomad.NewClient(server.URL, true, "test-token")
+	r := reconciler.New(db, tracker.New(db, nomad.NewClusters(client), nil, nil), nil, nil, 0, 2)
+	r.ReconcileOnce(context.Background())
+
+	expected := map[string]string{
+		"rec-ok":      models.StatusSuccessful,
+		"rec-bad":     models.StatusFailed,
+		"rec-pending": models.StatusPending,
+		"rec-done":    models.StatusSuccessful,
+	}
+	for tagID, want := range expected {
+		deployment, err := database.GetDeploymentRecord(db, tagID)
+		if err != nil {
+			t.Fatalf("GetDeploymentRecord(%s) failed: %v", tagID, err)
+		}
+		if deployment.Status != want {
+			t.Errorf("%s status = %v, want %v", tagID, deployment.Status, want)
+		}
+	}
+
+	deployment, _ := database.GetDeploymentRecord(db, "rec-ok")
+	if deployment.DeploymentID != "dep-ok" {
+		t.Errorf("DeploymentID = %v, want dep-ok", deployment.DeploymentID)
+	}
+}
+
+func TestReconcilerStartStop(t *testing.T) {
+	db := setupTestDB(t)
+	client := nomad.NewClient("http://test-nomad:4646", true, "test-token")
+	r := reconciler.New(db, tracker.New(db, nomad.NewClusters(client), nil, nil), nil, nil, 10*time.Millisecond, 1)
+
+	r.Start()
+	r.Stop()
+	// Stopping twice must be safe
+	r.Stop()
+}
@@ -0,0 +1,289 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+	"shipper-deployment/internal/tracker"
+)
+
+// fakeJobVersions returns a versions response for the api job, newest first
+func fakeJobVersions() map[string]interface{} {
+	return map[string]interface{}{
+		"Versions": []map[string]interface{}{
+			{"ID": "api", "Version": 3, "Stable": false, "Meta": map[string]string{"tag_id": "sha-3"}},
+			{"ID": "api", "Version": 2, "Stable": false, "Meta": map[string]string{"tag_id": "sha-2"}},
+			{"ID": "api", "Version": 1, "Stable": true, "Meta": map[string]string{"tag_id": "sha-1"}},
+		},
+	}
+}
+
+func TestRollbackHandler(t *testing.T) {
+	var revertedTo float64
+	server := newFakeNomad(t, map[string]interface{}{
+		"/v1/job/api/versions": fakeJobVersions(),
+		"/v1/job/api/revert": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("Failed to decode revert body: %v", err)
+			}
+			revertedTo = body["JobVersion"].(float64)
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"EvalID":"eval-revert"}`))
+		}),
+	})
+
+	tests := []struct {
+		name            string
+		request         models.RollbackRequest
+		expectedStatus  int
+		expectedVersion float64
+	}{
+		{
+			name:            "by service restores last stable version",
+			request:         models.RollbackRequest{ServiceName: "api"},
+			expectedStatus:  http.StatusOK,
+			expectedVersion: 1,
+		},
+		{
+			name:            "by tag restores the version deployed with it",
+			request:         models.RollbackRequest{TagID: "sha-2"},
+			expectedStatus:  http.StatusOK,
+			expectedVersion: 2,
+		},
+		{
+			name:           "tag that is currently running",
+			request:        models.RollbackRequest{TagID: "sha-3"},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "unknown tag",
+			request:        models.RollbackRequest{TagID: "sha-unknown"},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "empty request",
+			request:        models.RollbackRequest{},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	handler, db := setupTestHandlerWithNomad(t, server.URL)
+	for _, tag := range []string{"sha-2", "sha-3"} {
+		if err := database.InsertDeployment(db, tag, "api", "eval-"+tag, models.StatusSuccessful); err != nil {
+			t.Fatalf("InsertDeployment failed: %v", err)
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(tt.request)
+			req := httptest.NewRequest("POST", "/rollback", bytes.NewBuffer(body))
+			rr := httptest.NewRecorder()
+			handler.Rollback(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Rollback returned %d, want %d: %s", rr.Code, tt.expectedStatus, rr.Body.String())
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			if revertedTo != tt.expectedVersion {
+				t.Errorf("Reverted to version %v, want %v", revertedTo, tt.expectedVersion)
+			}
+
+			var response models.RollbackResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+
+			if response.RollbackOf != "sha-3" {
+				t.Errorf("RollbackOf = %v, want sha-3", response.RollbackOf)
+			}
+
+			deployment, err := database.GetDeploymentRecord(db, response.TagID)
+			if err != nil {
+				t.Fatalf("Rollback row not recorded: %v", err)
+			}
+			if deployment.Action != models.ActionRollback || deployment.JobID != "eval-revert" {
+				t.Errorf("Unexpected rollback row: %+v", deployment)
+			}
+			if deployment.RestoredVersion == nil || float64(*deployment.RestoredVersion) != tt.expectedVersion {
+				t.Errorf("RestoredVersion = %v, want %v", deployment.RestoredVersion, tt.expectedVersion)
+			}
+		})
+	}
+
+	t.Run("database errors are not taken for unknown tags", func(t *testing.T) {
+		handler, db := setupTestHandlerWithNomad(t, server.URL)
+		_ = db.Close()
+
+		body, _ := json.Marshal(models.RollbackRequest{TagID: "sha-2"})
+		rr := httptest.NewRecorder()
+		handler.Rollback(rr, httptest.NewRequest("POST", "/rollback", bytes.NewBuffer(body)))
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("Expected 500, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+}
+
+func TestAutoRollback(t *testing.T) {
+	tests := []struct {
+		name             string
+		deploymentStatus string
+		description      string
+		createdAgo       string
+		policy           config.AutoRollbackPolicy
+		// revertFailures is how many revert requests Nomad refuses before accepting one
+		revertFailures int
+		expectedStatus string
+	}{
+		{
+			name:             "failed deployment is rolled back",
+			deploymentStatus: "failed",
+			policy:           config.AutoRollbackPolicy{Enabled: true},
+			expectedStatus:   models.StatusRolledBack,
+		},
+		{
+			name:             "deployment past its deadline below minimum health is rolled back",
+			deploymentStatus: "running",
+			createdAgo:       "-20 minutes",
+			policy: config.AutoRollbackPolicy{
+				Enabled:           true,
+				HealthDeadline:    config.Duration{Duration: 10 * time.Minute},
+				MinHealthyPercent: 50,
+			},
+			expectedStatus: models.StatusRolledBack,
+		},
+		{
+			name:             "deployment within its deadline is left running",
+			deploymentStatus: "running",
+			policy: config.AutoRollbackPolicy{
+				Enabled:        true,
+				HealthDeadline: config.Duration{Duration: 10 * time.Minute},
+			},
+			expectedStatus: models.StatusRunning,
+		},
+		{
+			name:             "failed revert keeps the deployment for a retry",
+			deploymentStatus: "failed",
+			policy:           config.AutoRollbackPolicy{Enabled: true},
+			revertFailures:   1,
+			expectedStatus:   models.StatusRolledBack,
+		},
+		{
+			name:             "job reverted by Nomad's auto_revert is not reverted again",
+			deploymentStatus: "failed",
+			description:      "Failed due to unhealthy allocations - rolling back to job version 1",
+			policy:           config.AutoRollbackPolicy{Enabled: true},
+			expectedStatus:   models.StatusFailed,
+		},
+		{
+			name:             "disabled policy keeps the failure",
+			deploymentStatus: "failed",
+			policy:           config.AutoRollbackPolicy{Enabled: false},
+			expectedStatus:   models.StatusFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reverted bool
+			failures := tt.revertFailures
+			server := newFakeNomad(t, map[string]interface{}{
+				"/v1/evaluation/eval-2": map[string]interface{}{
+					"ID": "eval-2", "Status": "complete", "JobID": "api", "DeploymentID": "dep-2",
+				},
+				"/v1/deployment/dep-2": map[string]interface{}{
+					"ID": "dep-2", "JobID": "api", "JobVersion": 2, "Status": tt.deploymentStatus, "StatusDescription": tt.description,
+					"TaskGroups": map[string]interface{}{
+						"web": map[string]interface{}{"DesiredTotal": 4, "PlacedAllocs": 4, "HealthyAllocs": 1},
+					},
+				},
+				"/v1/job/api/versions": fakeJobVersions(),
+				"/v1/job/api/revert": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if failures > 0 {
+						failures--
+						http.Error(w, "no cluster leader", http.StatusInternalServerError)
+						return
+					}
+					reverted = true
+					_, _ = w.Write([]byte(`{"EvalID":"eval-auto"}`))
+				}),
+			})
+
+			db := setupTestDB(t)
+			cfg := &config.Config{Services: map[string]config.ServiceConfig{
+				"api": {AutoRollback: &tt.policy},
+			}}
+			client := nomad.NewClient(server.URL, true, "test-token")
+			deploymentTracker := tracker.New(db, nomad.NewClusters(client), cfg, nil)
+
+			good := &models.Deployment{
+				TagID: "sha-1", ServiceName: "api", JobID: "eval-1", Status: models.StatusSuccessful,
+				DeploymentID: "dep-1", NomadJobID: "api", JobVersion: 1,
+			}
+			if err := database.InsertDeploymentRecord(db, good); err != nil {
+				t.Fatalf("InsertDeploymentRecord failed: %v", err)
+			}
+			if err := database.InsertDeployment(db, "sha-2", "api", "eval-2", models.StatusRunning); err != nil {
+				t.Fatalf("InsertDeployment failed: %v", err)
+			}
+			if tt.createdAgo != "" {
+				if _, err := db.Exec("UPDATE deployments SET created_at = datetime('now', ?) WHERE tag_id = 'sha-2'", tt.createdAgo); err != nil {
+					t.Fatalf("Failed to age deployment: %v", err)
+				}
+			}
+
+			deployment, err := database.GetDeploymentRecord(db, "sha-2")
+			if err != nil {
+				t.Fatalf("GetDeploymentRecord failed: %v", err)
+			}
+			if _, err := deploymentTracker.Refresh(*deployment); err != nil {
+				t.Fatalf("Refresh failed: %v", err)
+			}
+
+			deployment, _ = database.GetDeploymentRecord(db, "sha-2")
+			if tt.revertFailures > 0 {
+				if deployment.Status != models.StatusRunning {
+					t.Fatalf("Status after a failed revert = %v, want running", deployment.Status)
+				}
+				if _, err := deploymentTracker.Refresh(*deployment); err != nil {
+					t.Fatalf("Refresh failed: %v", err)
+				}
+				deployment, _ = database.GetDeploymentRecord(db, "sha-2")
+			}
+			if deployment.Status != tt.expectedStatus {
+				t.Errorf("Status = %v, want %v", deployment.Status, tt.expectedStatus)
+			}
+
+			if tt.expectedStatus != models.StatusRolledBack {
+				if reverted {
+					t.Error("Expected no revert to be submitted")
+				}
+				return
+			}
+
+			if deployment.RestoredVersion == nil || *deployment.RestoredVersion != 1 {
+				t.Errorf("RestoredVersion = %v, want 1", deployment.RestoredVersion)
+			}
+
+			var rollbackOf string
+			if err := db.QueryRow("SELECT rollback_of FROM deployments WHERE action = ?", models.ActionRollback).Scan(&rollbackOf); err != nil {
+				t.Fatalf("Rollback row not recorded: %v", err)
+			}
+			if rollbackOf != "sha-2" {
+				t.Errorf("RollbackOf = %v, want sha-2", rollbackOf)
+			}
+		})
+	}
+}
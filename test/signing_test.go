@@ -0,0 +1,159 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"shipper-deployment/internal/auth"
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/pkg/signing"
+)
+
+func TestSignedRequests(t *testing.T) {
+	const sharedSecret = "test-secret-key-64-characters-long-for-testing-purposes"
+	nomadServer := newFakeNomad(t, map[string]interface{}{
+		"/v1/evaluation/eval-1": map[string]interface{}{"ID": "eval-1", "Status": "pending", "JobID": "payments"},
+		"/v1/job/payments":      map[string]interface{}{"ID": "payments"},
+		"/v1/jobs":              map[string]interface{}{"EvalID": "eval-signed", "JobID": "payments"},
+	})
+	db := setupTestDB(t)
+	if err := database.InsertDeployment(db, "pay-1", "payments", "eval-1", models.StatusRunning); err != nil {
+		t.Fatalf("InsertDeployment failed: %v", err)
+	}
+	router := newServerRouter(t, &config.Config{
+		NomadURL:              nomadServer.URL,
+		ValidSecret:           sharedSecret,
+		SharedSecretEnabled:   true,
+		RequireSignedRequests: true,
+		SignatureMaxSkew:      time.Minute,
+		SkipTLSVerify:         true,
+	}, db)
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("signed request is accepted once", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/status/pay-1", nil)
+		if err := signing.Sign(req, sharedSecret); err != nil {
+			t.Fatalf("Sign failed: %v", err)
+		}
+		if rr := serve(req); rr.Code != http.StatusOK {
+			t.Fatalf("Signed request returned %d: %s", rr.Code, rr.Body.String())
+		}
+
+		replay := httptest.NewRequest("GET", "/status/pay-1", nil)
+		replay.Header = req.Header.Clone()
+		if rr := serve(replay); rr.Code != http.StatusUnauthorized {
+			t.Errorf("Replayed request returned %d", rr.Code)
+		}
+	})
+
+	t.Run("tampered body is rejected", func(t *testing.T) {
+		body, _ := json.Marshal(models.DeploymentRequest{ServiceName: "payments", TagID: "signed-1"})
+		req := httptest.NewRequest("POST", "/deploy", bytes.NewReader(body))
+		if err := signing.Sign(req, sharedSecret); err != nil {
+			t.Fatalf("Sign failed: %v", err)
+		}
+
+		tampered, _ := json.Marshal(models.DeploymentRequest{ServiceName: "payments", TagID: "signed-2"})
+		forged := httptest.NewRequest("POST", "/deploy", bytes.NewReader(tampered))
+		forged.Header = req.Header.Clone()
+		if rr := serve(forged); rr.Code != http.StatusUnauthorized {
+			t.Errorf("Tampered request returned %d", rr.Code)
+		}
+	})
+
+	t.Run("tampered query is rejected", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/deploy", nil)
+		if err := signing.Sign(req, sharedSecret); err != nil {
+			t.Fatalf("Sign failed: %v", err)
+		}
+		forged := httptest.NewRequest("POST", "/deploy?dry_run=true", nil)
+		forged.Header = req.Header.Clone()
+		if rr := serve(forged); rr.Code != http.StatusUnauthorized {
+			t.Errorf("Tampered request returned %d", rr.Code)
+		}
+	})
+
+	t.Run("stale timestamp is rejected", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/status/pay-1", nil)
+		if err := signing.SignAt(req, sharedSecret, time.Now().Add(-2*time.Minute), "stale-nonce"); err != nil {
+			t.Fatalf("SignAt failed: %v", err)
+		}
+		if rr := serve(req); rr.Code != http.StatusUnauthorized {
+			t.Errorf("Stale request returned %d", rr.Code)
+		}
+	})
+
+	t.Run("wrong secret is rejected", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/status/pay-1", nil)
+		if err := signing.Sign(req, "not-the-secret"); err != nil {
+			t.Fatalf("Sign failed: %v", err)
+		}
+		if rr := serve(req); rr.Code != http.StatusUnauthorized {
+			t.Errorf("Request signed with the wrong secret returned %d", rr.Code)
+		}
+	})
+
+	t.Run("plain secret is refused when signing is required", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/status/pay-1", nil)
+		req.Header.Set("X-Secret-Key", sharedSecret)
+		if rr := serve(req); rr.Code != http.StatusUnauthorized {
+			t.Errorf("Unsigned request returned %d", rr.Code)
+		}
+	})
+
+	t.Run("client transport signs requests", func(t *testing.T) {
+		server := httptest.NewServer(router)
+		defer server.Close()
+
+		client := &http.Client{Transport: &signing.Transport{Secret: sharedSecret}}
+		body, _ := json.Marshal(models.DeploymentRequest{ServiceName: "payments", TagID: "signed-3"})
+		resp, err := client.Post(server.URL+"/deploy", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Post failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Signed deploy returned %d", resp.StatusCode)
+		}
+
+		deployment, err := database.GetDeploymentRecord(db, "signed-3")
+		if err != nil {
+			t.Fatalf("GetDeploymentRecord failed: %v", err)
+		}
+		if deployment.TriggeredBy != "signed_request:rpc_secret" {
+			t.Errorf("TriggeredBy = %q", deployment.TriggeredBy)
+		}
+	})
+}
+
+func TestNonceCache(t *testing.T) {
+	cache := auth.NewNonceCache(time.Minute)
+	now := time.Now()
+
+	if !cache.Add("a", now) {
+		t.Fatal("First use of a nonce was rejected")
+	}
+	if cache.Add("a", now.Add(30*time.Second)) {
+		t.Error("Nonce reused within its ttl was accepted")
+	}
+	if !cache.Add("a", now.Add(2*time.Minute)) {
+		t.Error("Nonce reused after its ttl was rejected")
+	}
+
+	cache.Add("b", now.Add(2*time.Minute))
+	cache.Add("c", now.Add(5*time.Minute))
+	if cache.Len() != 1 {
+		t.Errorf("Expired nonces were not pruned, %d remain", cache.Len())
+	}
+}
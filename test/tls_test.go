@@ -0,0 +1,240 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"shipper-deployment/internal/config"
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/server"
+)
+
+// testCA issues certificates for TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "shipper test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key signed by the CA
+func (ca *testCA) issue(t *testing.T, serial int64, template *x509.Certificate) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template.SerialNumber = big.NewInt(serial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Failed to issue certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *testCA) serverCert(t *testing.T, serial int64, commonName string) ([]byte, []byte) {
+	return ca.issue(t, serial, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+}
+
+func (ca *testCA) clientCert(t *testing.T, serial int64, commonName string, uri string) tls.Certificate {
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if uri != "" {
+		parsed, _ := url.Parse(uri)
+		template.URIs = []*url.URL{parsed}
+	}
+	certPEM, keyPEM := ca.issue(t, serial, template)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("Failed to load client certificate: %v", err)
+	}
+	return cert
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt")
+	certPEM, keyPEM := ca.serverCert(t, 2, "shipper-1")
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+	writeFile(t, caFile, ca.pem)
+
+	db := setupTestDB(t)
+	for tagID, service := range map[string]string{"pay-1": "payments", "bill-1": "billing"} {
+		if err := database.InsertDeployment(db, tagID, service, "", models.StatusSuccessful); err != nil {
+			t.Fatalf("InsertDeployment failed: %v", err)
+		}
+	}
+
+	cfg := &config.Config{
+		NomadURL:      "http://127.0.0.1:0",
+		SkipTLSVerify: true,
+		TLS: &config.TLSConfig{
+			CertFile:       certFile,
+			KeyFile:        keyFile,
+			ClientCAFile:   caFile,
+			ReloadInterval: time.Millisecond,
+			ClientIdentities: []config.ClientCertRule{
+				{URI: "spiffe://example.org/ci/*", Scopes: []string{models.ScopeStatus}, Services: []string{"payments"}},
+			},
+		},
+	}
+	srv := server.NewServer(cfg, db, nil)
+	if srv.TLSConfig() == nil {
+		t.Fatal("Expected a TLS config")
+	}
+
+	ts := httptest.NewUnstartedServer(srv.Handler())
+	ts.TLS = srv.TLSConfig()
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(path string, certs ...tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certs, MinVersion: tls.VersionTLS12},
+			DisableKeepAlives: true,
+		}}
+		return client.Get(ts.URL + path)
+	}
+	status := func(t *testing.T, path string, certs ...tls.Certificate) int {
+		t.Helper()
+		resp, err := get(path, certs...)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	mapped := ca.clientCert(t, 3, "ci-payments", "spiffe://example.org/ci/payments")
+
+	t.Run("mapped client certificate is authenticated", func(t *testing.T) {
+		if code := status(t, "/status/pay-1", mapped); code != http.StatusOK {
+			t.Errorf("Expected 200, got %d", code)
+		}
+		if code := status(t, "/status/bill-1", mapped); code != http.StatusForbidden {
+			t.Errorf("Expected 403 for another service, got %d", code)
+		}
+	})
+
+	t.Run("unmapped or missing certificate needs a credential", func(t *testing.T) {
+		unmapped := ca.clientCert(t, 4, "someone", "spiffe://example.org/staff/alice")
+		if code := status(t, "/status/pay-1", unmapped); code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for an unmapped certificate, got %d", code)
+		}
+		if code := status(t, "/status/pay-1"); code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 without a certificate, got %d", code)
+		}
+	})
+
+	t.Run("certificate from another CA is refused", func(t *testing.T) {
+		foreign := newTestCA(t).clientCert(t, 5, "ci-payments", "spiffe://example.org/ci/payments")
+		if resp, err := get("/status/pay-1", foreign); err == nil {
+			resp.Body.Close()
+			t.Error("Expected the handshake to fail")
+		}
+	})
+
+	t.Run("certificates reload on change", func(t *testing.T) {
+		certPEM, keyPEM := ca.serverCert(t, 6, "shipper-2")
+		writeFile(t, certFile, certPEM)
+		writeFile(t, keyFile, keyPEM)
+		// Make the change visible even on filesystems with coarse modification times
+		future := time.Now().Add(time.Minute)
+		for _, file := range []string{certFile, keyFile} {
+			if err := os.Chtimes(file, future, future); err != nil {
+				t.Fatalf("Chtimes failed: %v", err)
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		resp, err := get("/health")
+		if err != nil {
+			t.Fatalf("GET /health failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if cn := resp.TLS.PeerCertificates[0].Subject.CommonName; cn != "shipper-2" {
+			t.Errorf("Server presented %s after reload, want shipper-2", cn)
+		}
+	})
+}
+
+func TestLoadClientIdentities(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identities.json")
+
+	writeFile(t, path, []byte(`{"identities":[{"scopes":["deploy"],"services":["*"]}]}`))
+	if _, err := config.LoadClientIdentities(path); err == nil {
+		t.Error("Expected an error for a rule without a certificate field")
+	}
+
+	writeFile(t, path, []byte(`{"identities":[{"common_name":"ci-*","scopes":["deploy"],"services":["api"]}]}`))
+	rules, err := config.LoadClientIdentities(path)
+	if err != nil {
+		t.Fatalf("LoadClientIdentities failed: %v", err)
+	}
+	if len(rules) != 1 || rules[0].CommonName != "ci-*" {
+		t.Errorf("Unexpected rules: %+v", rules)
+	}
+}
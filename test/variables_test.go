@@ -0,0 +1,184 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"shipper-deployment/internal/jobspec"
+	"shipper-deployment/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+const variablesJobHCL = `
+variable "region" {
+  type = string
+}
+
+variable "replicas" {
+  type    = number
+  default = 1
+}
+
+variable "db_password" {
+  type      = string
+  sensitive = true
+}
+
+job "api" {
+  group "web" {
+    count = var.replicas
+  }
+}
+`
+
+func TestJobVariablesMerge(t *testing.T) {
+	variables := jobspec.NewVariables(variablesJobHCL)
+
+	if err := variables.AddVarFile(jobspec.VarFile{Name: "prod.hcl", Content: []byte("region = \"eu-west\"\nreplicas = 2\n")}); err != nil {
+		t.Fatalf("AddVarFile failed: %v", err)
+	}
+	if err := variables.AddVarFile(jobspec.VarFile{Name: "extra.json", Content: []byte(`{"tags": ["a", "b"]}`)}); err != nil {
+		t.Fatalf("AddVarFile (json) failed: %v", err)
+	}
+	for _, assignment := range []string{"replicas=3", "db_password=s3cret=x", "region=us-east"} {
+		if err := variables.AddAssignment(assignment); err != nil {
+			t.Fatalf("AddAssignment(%q) failed: %v", assignment, err)
+		}
+	}
+
+	rendered := variables.HCL()
+	for _, want := range []string{`region      = "us-east"`, `replicas    = 3`, `db_password = "s3cret=x"`, `tags        = ["a", "b"]`} {
+		if !strings.Contains(rendered, want) {
+			t.Errorf("Rendered variables missing %q:\n%s", want, rendered)
+		}
+	}
+
+	redacted := variables.Redacted()
+	if string(redacted["db_password"]) != `"[sensitive]"` {
+		t.Errorf("Sensitive value not redacted: %s", redacted["db_password"])
+	}
+	if string(redacted["replicas"]) != "3" || string(redacted["region"]) != `"us-east"` {
+		t.Errorf("Unexpected recorded values: %v", redacted)
+	}
+
+	if err := variables.AddAssignment("replicas=three"); err == nil {
+		t.Error("Expected an error for a non-number value of a number variable")
+	}
+	if err := variables.AddAssignment("novalue"); err == nil {
+		t.Error("Expected an error for an assignment without a value")
+	}
+	if err := variables.AddVarFile(jobspec.VarFile{Name: "bad.hcl", Content: []byte("region = ")}); err == nil {
+		t.Error("Expected an error for a malformed var file")
+	}
+}
+
+func TestDeployJobWithVariables(t *testing.T) {
+	var parsed map[string]interface{}
+	server := newFakeNomad(t, map[string]interface{}{
+		"/v1/jobs/parse": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			parsed = nil
+			if err := json.NewDecoder(r.Body).Decode(&parsed); err != nil {
+				t.Errorf("Failed to decode parse request: %v", err)
+			}
+			_, _ = w.Write([]byte(`{"ID": "api", "Name": "api"}`))
+		}),
+		"/v1/jobs": map[string]interface{}{"EvalID": "eval-vars", "JobID": "api"},
+		"/v1/evaluation/eval-vars": map[string]interface{}{
+			"ID": "eval-vars", "Status": "pending", "JobID": "api",
+		},
+	})
+	handler, _ := setupTestHandlerWithNomad(t, server.URL)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/deploy/job", handler.DeployJob).Methods("POST")
+	router.HandleFunc("/status/{tag_id}", handler.Status).Methods("GET")
+
+	checkRecorded := func(t *testing.T, tagID string) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/status/"+tagID, nil))
+
+		var status models.StatusResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+			t.Fatalf("Failed to unmarshal status: %v", err)
+		}
+		if string(status.Variables["db_password"]) != `"[sensitive]"` || string(status.Variables["region"]) != `"eu-west"` {
+			t.Errorf("Unexpected recorded variables: %v", status.Variables)
+		}
+	}
+
+	t.Run("multipart", func(t *testing.T) {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		_ = writer.WriteField("tag_id", "vars-1")
+		_ = writer.WriteField("var", "db_password=hunter2")
+		_ = writer.WriteField("var", "replicas=4")
+		part, _ := writer.CreateFormFile("job_file", "api.nomad.hcl")
+		_, _ = part.Write([]byte(variablesJobHCL))
+		part, _ = writer.CreateFormFile("var_file", "prod.hcl")
+		_, _ = part.Write([]byte("region = \"eu-west\"\nreplicas = 2\n"))
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/deploy/job", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("DeployJob returned %d: %s", rr.Code, rr.Body.String())
+		}
+
+		sent, _ := parsed["Variables"].(string)
+		for _, want := range []string{`db_password = "hunter2"`, `replicas    = 4`, `region      = "eu-west"`} {
+			if !strings.Contains(sent, want) {
+				t.Errorf("Parse request variables missing %q:\n%s", want, sent)
+			}
+		}
+		checkRecorded(t, "vars-1")
+	})
+
+	t.Run("json", func(t *testing.T) {
+		body, _ := json.Marshal(models.JobDeploymentRequest{
+			TagID:     "vars-2",
+			JobFile:   variablesJobHCL,
+			VarFiles:  []string{"region = \"eu-west\"\n"},
+			Variables: map[string]json.RawMessage{"db_password": json.RawMessage(`"hunter2"`), "replicas": json.RawMessage(`5`)},
+		})
+		req := httptest.NewRequest("POST", "/deploy/job", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("DeployJob returned %d: %s", rr.Code, rr.Body.String())
+		}
+
+		sent, _ := parsed["Variables"].(string)
+		if !strings.Contains(sent, `replicas    = 5`) {
+			t.Errorf("Parse request variables missing replicas:\n%s", sent)
+		}
+		checkRecorded(t, "vars-2")
+	})
+
+	t.Run("invalid var", func(t *testing.T) {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		_ = writer.WriteField("tag_id", "vars-3")
+		_ = writer.WriteField("var", "replicas")
+		part, _ := writer.CreateFormFile("job_file", "api.nomad.hcl")
+		_, _ = part.Write([]byte(variablesJobHCL))
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/deploy/job", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for invalid var, got %d", rr.Code)
+		}
+	})
+}
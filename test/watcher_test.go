@@ -0,0 +1,129 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"shipper-deployment/internal/database"
+	"shipper-deployment/internal/models"
+	"shipper-deployment/internal/nomad"
+	"shipper-deployment/internal/tracker"
+	"shipper-deployment/internal/watcher"
+)
+
+func TestStreamEventsResumesFromIndex(t *testing.T) {
+	var requestedIndex string
+	server := newFakeNomad(t, map[string]interface{}{
+		"/v1/event/stream": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestedIndex = r.URL.Query().Get("index")
+			topics := r.URL.Query()["topic"]
+			if len(topics) != len(nomad.DeploymentEventTopics) {
+				t.Errorf("Expected %d topics, got %v", len(nomad.DeploymentEventTopics), topics)
+			}
+			fmt.Fprintln(w, `{"Index":11,"Events":[{"Topic":"Evaluation","Key":"eval-1","FilterKeys":["api"]}]}`)
+			fmt.Fprintln(w, `{}`)
+			fmt.Fprintln(w, `{"Index":12,"Events":[{"Topic":"Deployment","Key":"dep-1","FilterKeys":["api"]}]}`)
+		}),
+	})
+	client := nomad.NewClient(server.URL, true, "test-token")
+
+	var batches []models.NomadEventBatch
+	lastIndex, err := client.StreamEvents(context.Background(), 10, nomad.DeploymentEventTopics, func(batch models.NomadEventBatch) {
+		batches = append(batches, batch)
+	})
+	if err != nil {
+		t.Fatalf("StreamEvents failed: %v", err)
+	}
+
+	if requestedIndex != "10" {
+		t.Errorf("Requested index = %v, want 10", requestedIndex)
+	}
+	if lastIndex != 12 {
+		t.Errorf("LastIndex = %d, want 12", lastIndex)
+	}
+	if len(batches) != 2 {
+		t.Errorf("Expected 2 batches (heartbeat skipped), got %d", len(batches))
+	}
+}
+
+func TestWatcherHandleBatchRefreshesMatchingDeployments(t *testing.T) {
+	server := newFakeNomad(t, map[string]interface{}{
+		"/v1/evaluation/eval-1": map[string]interface{}{
+			"ID": "eval-1", "Status": "complete", "JobID": "api", "DeploymentID": "dep-1",
+		},
+		"/v1/deployment/dep-1": map[string]interface{}{
+			"ID": "dep-1", "JobID": "api", "Status": "successful",
+		},
+	})
+	db := setupTestDB(t)
+	client := nomad.NewClient(server.URL, true, "test-token")
+	w := watcher.New(db, "", client, tracker.New(db, nomad.NewClusters(client), nil, nil))
+
+	if err := database.InsertDeployment(db, "watch-api", "api", "eval-1", models.StatusRunning); err != nil {
+		t.Fatalf("InsertDeployment failed: %v", err)
+	}
+	if err := database.InsertDeployment(db, "watch-other", "other", "eval-2", models.StatusRunning); err != nil {
+		t.Fatalf("InsertDeployment failed: %v", err)
+	}
+
+	w.HandleBatch(models.NomadEventBatch{
+		Index: 42,
+		Events: []models.NomadEvent{
+			{Topic: "Allocation", Key: "alloc-1", FilterKeys: []string{"api", "dep-1"}},
+		},
+	})
+
+	deployment, err := database.GetDeploymentRecord(db, "watch-api")
+	if err != nil {
+		t.Fatalf("GetDeploymentRecord failed: %v", err)
+	}
+	if deployment.Status != models.StatusSuccessful {
+		t.Errorf("Status = %v, want %v", deployment.Status, models.StatusSuccessful)
+	}
+
+	other, _ := database.GetDeploymentRecord(db, "watch-other")
+	if other.Status != models.StatusRunning {
+		t.Errorf("Unrelated deployment status = %v, want %v", other.Status, models.StatusRunning)
+	}
+
+	if w.LastIndex() != 42 {
+		t.Errorf("LastIndex = %d, want 42", w.LastIndex())
+	}
+}
+
+func TestBlockingDeploymentHealthQuery(t *testing.T) {
+	var index, wait string
+	server := newFakeNomad(t, map[string]interface{}{
+		"/v1/evaluation/eval-1": map[string]interface{}{
+			"ID": "eval-1", "Status": "complete", "JobID": "api", "DeploymentID": "dep-1",
+		},
+		"/v1/deployment/dep-1": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			index = r.URL.Query().Get("index")
+			wait = r.URL.Query().Get("wait")
+			w.Header().Set("X-Nomad-Index", "101")
+			fmt.Fprint(w, `{"ID":"dep-1","JobID":"api","Status":"running"}`)
+		}),
+	})
+	client := nomad.NewClient(server.URL, true, "test-token")
+
+	health, meta, err := client.GetDeploymentHealthWithOptions("eval-1", nomad.JobScope{}, &nomad.QueryOptions{
+		WaitIndex: 100,
+		WaitTime:  5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("GetDeploymentHealthWithOptions failed: %v", err)
+	}
+
+	if index != "100" || wait != "5s" {
+		t.Errorf("Blocking parameters = index %q wait %q, want 100 and 5s", index, wait)
+	}
+	if meta.LastIndex != 101 {
+		t.Errorf("LastIndex = %d, want 101", meta.LastIndex)
+	}
+	if health.Status != models.StatusRunning {
+		t.Errorf("Status = %v, want %v", health.Status, models.StatusRunning)
+	}
+}